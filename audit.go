@@ -0,0 +1,45 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"time"
+
+	pb "github.com/adammck/collector/proto/gen"
+	"google.golang.org/protobuf/proto"
+)
+
+// hashAnnotation returns the hex sha256 of an answer: the deterministic wire
+// encodings of the request and response (without its meta), the annotator,
+// and the time it was answered in unix nanoseconds, each preceded by its
+// length as a big-endian uint64. See ResponseMeta.audit_hash.
+func hashAnnotation(req *pb.Request, res *pb.Response, annotator string, at time.Time) (string, error) {
+	if res.GetMeta() != nil {
+		res = proto.Clone(res).(*pb.Response)
+		res.Meta = nil
+	}
+
+	opts := proto.MarshalOptions{Deterministic: true}
+	reqBytes, err := opts.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	resBytes, err := opts.Marshal(res)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	for _, part := range [][]byte{
+		reqBytes,
+		resBytes,
+		[]byte(annotator),
+		binary.BigEndian.AppendUint64(nil, uint64(at.UnixNano())),
+	} {
+		h.Write(binary.BigEndian.AppendUint64(nil, uint64(len(part))))
+		h.Write(part)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -4,9 +4,11 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"sync"
 	"time"
 
 	pb "github.com/adammck/collector/proto/gen"
+	"github.com/adammck/collector/validation"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
@@ -17,6 +19,54 @@ type RetryConfig struct {
 	MaxBackoff        time.Duration
 	BackoffMultiplier float64
 	RetryableCodes    []codes.Code
+
+	// Budget, if set, is shared by every call using this config, so that a
+	// client making many calls to a struggling server doesn't multiply its
+	// load by MaxAttempts. Nil means every call may retry.
+	Budget *RetryBudget
+}
+
+// RetryBudget is a token bucket which throttles retries across calls, like
+// gRPC's retry throttling: each failed attempt takes a token, each success
+// returns tokenRatio of one, and retries are only made while more than half
+// of maxTokens remain.
+type RetryBudget struct {
+	mu         sync.Mutex
+	tokens     float64
+	maxTokens  float64
+	tokenRatio float64
+}
+
+func NewRetryBudget(maxTokens, tokenRatio float64) *RetryBudget {
+	return &RetryBudget{
+		tokens:     maxTokens,
+		maxTokens:  maxTokens,
+		tokenRatio: tokenRatio,
+	}
+}
+
+// failed records a failed attempt, and returns true if a retry is allowed.
+func (b *RetryBudget) failed() bool {
+	if b == nil {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.tokens = max(b.tokens-1, 0)
+	return b.tokens > b.maxTokens/2
+}
+
+func (b *RetryBudget) succeeded() {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.tokens = min(b.tokens+b.tokenRatio, b.maxTokens)
 }
 
 var DefaultRetryConfig = RetryConfig{
@@ -34,6 +84,12 @@ var DefaultRetryConfig = RetryConfig{
 func CollectWithRetry(ctx context.Context, client pb.CollectorClient, 
 	req *pb.Request, cfg RetryConfig) (*pb.Response, error) {
 	
+	// an invalid request would only fail every attempt. lenient, because only
+	// the server knows whether it checks value ranges.
+	if err := validation.RequestWithMode(req, validation.Lenient); err != nil {
+		return nil, fmt.Errorf("invalid request: %w", err)
+	}
+
 	var lastErr error
 	backoff := cfg.InitialBackoff
 	
@@ -53,6 +109,7 @@ func CollectWithRetry(ctx context.Context, client pb.CollectorClient,
 		
 		resp, err := client.Collect(ctx, req)
 		if err == nil {
+			cfg.Budget.succeeded()
 			return resp, nil
 		}
 		
@@ -75,6 +132,11 @@ func CollectWithRetry(ctx context.Context, client pb.CollectorClient,
 		if !retryable {
 			return nil, err
 		}
+
+		if !cfg.Budget.failed() {
+			log.Printf("attempt %d failed with %v, retry budget exhausted", attempt+1, st.Code())
+			return nil, err
+		}
 		
 		log.Printf("attempt %d failed with %v, retrying...", attempt+1, st.Code())
 	}
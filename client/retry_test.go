@@ -0,0 +1,76 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pb "github.com/adammck/collector/proto/gen"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// failingClient fails every Collect with Unavailable, counting the attempts.
+type failingClient struct {
+	pb.CollectorClient
+	calls int
+}
+
+func (c *failingClient) Collect(ctx context.Context, req *pb.Request, opts ...grpc.CallOption) (*pb.Response, error) {
+	c.calls++
+	return nil, status.Error(codes.Unavailable, "server is struggling")
+}
+
+func testRequest() *pb.Request {
+	return &pb.Request{
+		Inputs: []*pb.Input{
+			{
+				Visualization: &pb.Input_Scalar{Scalar: &pb.Scalar{Label: "x", Max: 10}},
+				Data:          &pb.Data{Data: &pb.Data_Floats{Floats: &pb.Floats{Values: []float64{1}}}},
+			},
+		},
+		Output: &pb.OutputSchema{
+			Output: &pb.OutputSchema_OptionList{
+				OptionList: &pb.OptionListSchema{
+					Options: []*pb.Option{{Label: "a", Hotkey: "a"}, {Label: "b", Hotkey: "b"}},
+				},
+			},
+		},
+	}
+}
+
+func TestCollectWithRetryBudget(t *testing.T) {
+	client := &failingClient{}
+	cfg := DefaultRetryConfig
+	cfg.MaxAttempts = 2
+	cfg.InitialBackoff = time.Millisecond
+
+	// two calls' worth of failures spends half the budget, so the third
+	// isn't retried
+	cfg.Budget = NewRetryBudget(10, 0.1)
+
+	for i, want := range []int{2, 4, 5} {
+		_, err := CollectWithRetry(context.Background(), client, testRequest(), cfg)
+		if status.Code(err) != codes.Unavailable {
+			t.Fatalf("call %d: expected Unavailable, got %v", i+1, err)
+		}
+		if client.calls != want {
+			t.Errorf("call %d: expected %d attempts so far, got %d", i+1, want, client.calls)
+		}
+	}
+}
+
+func TestCollectWithRetryNoBudget(t *testing.T) {
+	client := &failingClient{}
+	cfg := DefaultRetryConfig
+	cfg.MaxAttempts = 2
+	cfg.InitialBackoff = time.Millisecond
+
+	for i := 0; i < 3; i++ {
+		CollectWithRetry(context.Background(), client, testRequest(), cfg)
+	}
+	if client.calls != 6 {
+		t.Errorf("expected every call to retry, got %d attempts", client.calls)
+	}
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	pb "github.com/adammck/collector/proto/gen"
+	"github.com/adammck/collector/validation"
+)
+
+// expandCompressed replaces each input's CompressedFloats with the Floats it
+// decodes to. Inputs which fail to decode are left alone.
+func expandCompressed(req *pb.Request) {
+	for _, input := range req.GetInputs() {
+		c, ok := input.GetData().GetData().(*pb.Data_CompressedFloats)
+		if !ok || c.CompressedFloats == nil {
+			continue
+		}
+		values, err := validation.DecompressFloats(c.CompressedFloats)
+		if err != nil {
+			continue
+		}
+		input.Data.Data = &pb.Data_Floats{Floats: &pb.Floats{Values: values}}
+	}
+}
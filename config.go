@@ -1,26 +1,96 @@
 package main
 
 import (
+	"log/slog"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
 type Config struct {
-	HTTPPort           int
-	GRPCPort           int
-	MaxPendingRequests int
-	HTTPTimeout        time.Duration
-	SubmitTimeout      time.Duration
+	HTTPPort              int
+	GRPCPort              int
+	MaxPendingRequests    int
+	MaxInputsPerRequest   int
+	MaxVectorMagnitude    float64
+	MaxTimeSeriesPoints   int
+	HTTPTimeout           time.Duration
+	MaxDataTimeout        time.Duration
+	MaxPrefetch           int
+	MaxDataWaiters        int
+	SubmitTimeout         time.Duration
+	SubmitAckTimeout      time.Duration
+	MaxCollectWait        time.Duration
+	AutoAnswerFraction    float64
+	MaxSubmitBytes        int64
+	CurrentItemTimeout    time.Duration
+	QueueMode             string
+	QueueLimits           map[string]int
+	AgingThreshold        time.Duration
+	MaxDefers             int
+	WebhookURL            string
+	WebhookThreshold      float64
+	EventLogSize          int
+	HistorySize           int
+	EchoRequest           bool
+	EchoRequestMaxBytes   int
+	MetricsSampleInterval time.Duration
+	MetricsStatePath      string
+	MetricsStateInterval  time.Duration
+	WarmupDuration        time.Duration
+	EnableReflection      bool
+	KeepaliveTime         time.Duration
+	KeepaliveTimeout      time.Duration
+	MaxConnectionIdle     time.Duration
+	KeepaliveMinTime      time.Duration
+	MaxRecvMsgSize        int
+	MaxSendMsgSize        int
+	AdminAPIKey           string
+	AllowedOrigins        []string
+	FrontendDir           string
+	BasePath              string
+	StrictValidation      bool
+	LogFormat             string
+	LogLevel              slog.Level
 }
 
 func loadConfig() *Config {
 	cfg := &Config{
-		HTTPPort:           8000,
-		GRPCPort:           50051,
-		MaxPendingRequests: 1000,
-		HTTPTimeout:        30 * time.Second,
-		SubmitTimeout:      5 * time.Second,
+		HTTPPort:              8000,
+		GRPCPort:              50051,
+		MaxPendingRequests:    1000,
+		MaxInputsPerRequest:   20,
+		MaxTimeSeriesPoints:   1000,
+		HTTPTimeout:           30 * time.Second,
+		MaxDataTimeout:        2 * time.Minute,
+		MaxPrefetch:           10,
+		MaxDataWaiters:        1000,
+		SubmitTimeout:         5 * time.Second,
+		SubmitAckTimeout:      5 * time.Second,
+		MaxCollectWait:        time.Hour,
+		AutoAnswerFraction:    0.9,
+		MaxSubmitBytes:        1 << 20,
+		CurrentItemTimeout:    10 * time.Minute,
+		QueueMode:             QueueModeFIFO,
+		AgingThreshold:        time.Minute,
+		EventLogSize:          1000,
+		HistorySize:           1000,
+		EchoRequestMaxBytes:   64 << 10,
+		MetricsSampleInterval: time.Minute,
+		MetricsStateInterval:  time.Minute,
+		WebhookThreshold:      0.9,
+		EnableReflection:      false,
+		KeepaliveTime:         time.Minute,
+		KeepaliveTimeout:      20 * time.Second,
+		MaxConnectionIdle:     15 * time.Minute,
+		KeepaliveMinTime:      30 * time.Second,
+		MaxRecvMsgSize:        16 << 20,
+		MaxSendMsgSize:        16 << 20,
+		FrontendDir:           "./frontend/dist",
+		StrictValidation:      true,
+		LogFormat:             logFormatText,
+		LogLevel:              slog.LevelInfo,
 	}
 
 	if port := os.Getenv("HTTP_PORT"); port != "" {
@@ -41,17 +111,261 @@ func loadConfig() *Config {
 		}
 	}
 
+	if limit := os.Getenv("MAX_INPUTS_PER_REQUEST"); limit != "" {
+		if l, err := strconv.Atoi(limit); err == nil {
+			cfg.MaxInputsPerRequest = l
+		}
+	}
+
+	// upper bound on Vector2D.max_magnitude; 0 leaves it unbounded
+	if limit := os.Getenv("MAX_VECTOR_MAGNITUDE"); limit != "" {
+		if f, err := strconv.ParseFloat(limit, 64); err == nil {
+			cfg.MaxVectorMagnitude = f
+		}
+	}
+
+	if limit := os.Getenv("MAX_TIME_SERIES_POINTS"); limit != "" {
+		if l, err := strconv.Atoi(limit); err == nil {
+			cfg.MaxTimeSeriesPoints = l
+		}
+	}
+
 	if timeout := os.Getenv("HTTP_TIMEOUT"); timeout != "" {
 		if t, err := time.ParseDuration(timeout); err == nil {
 			cfg.HTTPTimeout = t
 		}
 	}
 
+	if timeout := os.Getenv("MAX_DATA_TIMEOUT"); timeout != "" {
+		if t, err := time.ParseDuration(timeout); err == nil {
+			cfg.MaxDataTimeout = t
+		}
+	}
+
 	if timeout := os.Getenv("SUBMIT_TIMEOUT"); timeout != "" {
 		if t, err := time.ParseDuration(timeout); err == nil {
 			cfg.SubmitTimeout = t
 		}
 	}
 
+	if n := os.Getenv("MAX_PREFETCH"); n != "" {
+		if p, err := strconv.Atoi(n); err == nil {
+			cfg.MaxPrefetch = p
+		}
+	}
+
+	// how many /data.json requests may wait for an item at once
+	if n := os.Getenv("MAX_DATA_WAITERS"); n != "" {
+		if w, err := strconv.Atoi(n); err == nil {
+			cfg.MaxDataWaiters = w
+		}
+	}
+
+	if size := os.Getenv("MAX_SUBMIT_BYTES"); size != "" {
+		if n, err := strconv.ParseInt(size, 10, 64); err == nil {
+			cfg.MaxSubmitBytes = n
+		}
+	}
+
+	if timeout := os.Getenv("CURRENT_ITEM_TIMEOUT"); timeout != "" {
+		if t, err := time.ParseDuration(timeout); err == nil {
+			cfg.CurrentItemTimeout = t
+		}
+	}
+
+	if mode := os.Getenv("QUEUE_MODE"); mode == QueueModeFIFO || mode == QueueModeTagRoundRobin {
+		cfg.QueueMode = mode
+	}
+
+	if threshold := os.Getenv("AGING_THRESHOLD"); threshold != "" {
+		if t, err := time.ParseDuration(threshold); err == nil {
+			cfg.AgingThreshold = t
+		}
+	}
+
+	if defers := os.Getenv("MAX_DEFERS"); defers != "" {
+		if n, err := strconv.Atoi(defers); err == nil {
+			cfg.MaxDefers = n
+		}
+	}
+
+	cfg.WebhookURL = os.Getenv("WEBHOOK_URL")
+
+	if threshold := os.Getenv("WEBHOOK_THRESHOLD"); threshold != "" {
+		if f, err := strconv.ParseFloat(threshold, 64); err == nil {
+			cfg.WebhookThreshold = f
+		}
+	}
+
+	if size := os.Getenv("EVENT_LOG_SIZE"); size != "" {
+		if n, err := strconv.Atoi(size); err == nil {
+			cfg.EventLogSize = n
+		}
+	}
+
+	if size := os.Getenv("HISTORY_SIZE"); size != "" {
+		if n, err := strconv.Atoi(size); err == nil {
+			cfg.HistorySize = n
+		}
+	}
+
+	if interval := os.Getenv("METRICS_SAMPLE_INTERVAL"); interval != "" {
+		if t, err := time.ParseDuration(interval); err == nil {
+			cfg.MetricsSampleInterval = t
+		}
+	}
+
+	// if set, the counters are saved here periodically and restored at
+	// startup, so that they survive restarts
+	cfg.MetricsStatePath = os.Getenv("METRICS_STATE_PATH")
+
+	if interval := os.Getenv("METRICS_STATE_INTERVAL"); interval != "" {
+		if t, err := time.ParseDuration(interval); err == nil {
+			cfg.MetricsStateInterval = t
+		}
+	}
+
+	// how long after startup /readyz keeps answering 503
+	if warmup := os.Getenv("WARMUP_DURATION"); warmup != "" {
+		if t, err := time.ParseDuration(warmup); err == nil {
+			cfg.WarmupDuration = t
+		}
+	}
+
+	if echo := os.Getenv("ECHO_REQUEST"); echo != "" {
+		if b, err := strconv.ParseBool(echo); err == nil {
+			cfg.EchoRequest = b
+		}
+	}
+
+	if size := os.Getenv("ECHO_REQUEST_MAX_BYTES"); size != "" {
+		if n, err := strconv.Atoi(size); err == nil {
+			cfg.EchoRequestMaxBytes = n
+		}
+	}
+
+	// reflection is handy for grpcurl in dev, but off unless asked for, so that
+	// production doesn't expose it by default
+	if enable := os.Getenv("ENABLE_REFLECTION"); enable != "" {
+		if b, err := strconv.ParseBool(enable); err == nil {
+			cfg.EnableReflection = b
+		}
+	}
+
+	// the server pings a connection which has been quiet for KeepaliveTime,
+	// and closes it (cancelling its Collect calls) if there's no ack within
+	// KeepaliveTimeout
+	if d := os.Getenv("GRPC_KEEPALIVE_TIME"); d != "" {
+		if t, err := time.ParseDuration(d); err == nil {
+			cfg.KeepaliveTime = t
+		}
+	}
+
+	if d := os.Getenv("GRPC_KEEPALIVE_TIMEOUT"); d != "" {
+		if t, err := time.ParseDuration(d); err == nil {
+			cfg.KeepaliveTimeout = t
+		}
+	}
+
+	if d := os.Getenv("GRPC_MAX_CONNECTION_IDLE"); d != "" {
+		if t, err := time.ParseDuration(d); err == nil {
+			cfg.MaxConnectionIdle = t
+		}
+	}
+
+	if d := os.Getenv("GRPC_KEEPALIVE_MIN_TIME"); d != "" {
+		if t, err := time.ParseDuration(d); err == nil {
+			cfg.KeepaliveMinTime = t
+		}
+	}
+
+	// largest gRPC messages the server accepts and sends. grpc's own default
+	// receive limit of 4MB is too small for big images.
+	if size := os.Getenv("GRPC_MAX_RECV_MSG_SIZE"); size != "" {
+		if n, err := strconv.Atoi(size); err == nil {
+			cfg.MaxRecvMsgSize = n
+		}
+	}
+
+	if size := os.Getenv("GRPC_MAX_SEND_MSG_SIZE"); size != "" {
+		if n, err := strconv.Atoi(size); err == nil {
+			cfg.MaxSendMsgSize = n
+		}
+	}
+
+	// lenient validation skips value range checks, for pre-validated data
+	if strict := os.Getenv("STRICT_VALIDATION"); strict != "" {
+		if b, err := strconv.ParseBool(strict); err == nil {
+			cfg.StrictValidation = b
+		}
+	}
+
+	// how long Collect has to pick up a submitted answer before it's logged
+	if timeout := os.Getenv("SUBMIT_ACK_TIMEOUT"); timeout != "" {
+		if t, err := time.ParseDuration(timeout); err == nil {
+			cfg.SubmitAckTimeout = t
+		}
+	}
+
+	// longest a Collect may wait for an answer, whatever its deadline
+	if wait := os.Getenv("MAX_COLLECT_WAIT"); wait != "" {
+		if t, err := time.ParseDuration(wait); err == nil {
+			cfg.MaxCollectWait = t
+		}
+	}
+
+	// fraction of the Collect deadline after which default_response is used
+	if frac := os.Getenv("AUTO_ANSWER_FRACTION"); frac != "" {
+		if f, err := strconv.ParseFloat(frac, 64); err == nil {
+			cfg.AutoAnswerFraction = f
+		}
+	}
+
+	if format := os.Getenv("LOG_FORMAT"); format == logFormatText || format == logFormatJSON {
+		cfg.LogFormat = format
+	}
+
+	// debug, info, warn, or error
+	if level := os.Getenv("LOG_LEVEL"); level != "" {
+		var l slog.Level
+		if err := l.UnmarshalText([]byte(level)); err == nil {
+			cfg.LogLevel = l
+		}
+	}
+
+	cfg.AdminAPIKey = os.Getenv("ADMIN_API_KEY")
+
+	if dir := os.Getenv("FRONTEND_DIR"); dir != "" {
+		cfg.FrontendDir = dir
+	}
+
+	// normalized to a leading slash and no trailing slash, so "/" is the same
+	// as unset
+	if path := strings.Trim(os.Getenv("BASE_PATH"), "/"); path != "" {
+		cfg.BasePath = "/" + path
+	}
+
+	// comma-separated name=limit pairs, overriding MaxPendingRequests for the
+	// named sub-queues
+	for _, pair := range strings.Split(os.Getenv("QUEUE_LIMITS"), ",") {
+		name, limit, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || name == "" {
+			continue
+		}
+		if l, err := strconv.Atoi(limit); err == nil {
+			if cfg.QueueLimits == nil {
+				cfg.QueueLimits = make(map[string]int)
+			}
+			cfg.QueueLimits[name] = l
+		}
+	}
+
+	// comma-separated; empty means same-origin only
+	for _, origin := range strings.Split(os.Getenv("ALLOWED_ORIGINS"), ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			cfg.AllowedOrigins = append(cfg.AllowedOrigins, origin)
+		}
+	}
+
 	return cfg
 }
\ No newline at end of file
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+
+	pb "github.com/adammck/collector/proto/gen"
+	"google.golang.org/protobuf/proto"
+)
+
+// downsampleMinMax reduces values to at most n points by splitting the
+// interior into buckets and keeping the min and max of each, in their original
+// order. The first and last values are always kept, so spikes and the extent
+// of the series survive. n is treated as at least 2.
+func downsampleMinMax(values []float64, n int) []float64 {
+	if n < 2 {
+		n = 2
+	}
+	if len(values) <= n {
+		return values
+	}
+
+	last := len(values) - 1
+	interior := values[1:last]
+	buckets := (n - 2) / 2
+
+	out := make([]float64, 0, n)
+	out = append(out, values[0])
+
+	for b := 0; b < buckets; b++ {
+		start := b * len(interior) / buckets
+		end := (b + 1) * len(interior) / buckets
+		if start == end {
+			continue
+		}
+
+		lo, hi := start, start
+		for i := start + 1; i < end; i++ {
+			if interior[i] < interior[lo] {
+				lo = i
+			}
+			if interior[i] > interior[hi] {
+				hi = i
+			}
+		}
+
+		switch {
+		case lo == hi:
+			out = append(out, interior[lo])
+		case lo < hi:
+			out = append(out, interior[lo], interior[hi])
+		default:
+			out = append(out, interior[hi], interior[lo])
+		}
+	}
+
+	return append(out, values[last])
+}
+
+// forDisplay returns the request as it should be served to the frontend, with
+// any time series longer than maxPoints downsampled and column-major grids
+// transposed to row-major. The original request is never modified; if nothing
+// needs changing, it's returned as-is.
+func forDisplay(req *pb.Request, maxPoints int) *pb.Request {
+	long := maxPoints > 0 && hasLongTimeSeries(req, maxPoints)
+	if !long && !hasColMajorGrid(req) {
+		return req
+	}
+
+	out := proto.Clone(req).(*pb.Request)
+	for _, input := range out.Inputs {
+		toRowMajor(input)
+
+		ts := input.GetTimeSeries()
+		floats := input.GetData().GetFloats()
+		if !long || ts == nil || floats == nil || len(floats.Values) <= maxPoints {
+			continue
+		}
+
+		floats.Values = downsampleMinMax(floats.Values, maxPoints)
+		ts.Points = int32(len(floats.Values))
+	}
+
+	return out
+}
+
+func hasLongTimeSeries(req *pb.Request, maxPoints int) bool {
+	for _, input := range req.GetInputs() {
+		if input.GetTimeSeries() != nil && len(input.GetData().GetFloats().GetValues()) > maxPoints {
+			return true
+		}
+	}
+	return false
+}
+
+// downsampledEdit returns an error if req asks for an edit of a time series
+// which forDisplay would downsample, since the annotator couldn't see, or
+// answer with, every point. req must already have passed validate.
+func downsampledEdit(req *pb.Request, maxPoints int) error {
+	if maxPoints <= 0 {
+		return nil
+	}
+	for _, schema := range append([]*pb.OutputSchema{req.GetOutput()}, req.GetOutputs()...) {
+		edit := schema.GetEdit()
+		if edit == nil {
+			continue
+		}
+		input := req.Inputs[edit.Input]
+		if input.GetTimeSeries() != nil && len(input.GetData().GetFloats().GetValues()) > maxPoints {
+			return fmt.Errorf("edit input %d is a time series of more than %d points, which is displayed downsampled and so can't be edited", edit.Input, maxPoints)
+		}
+	}
+	return nil
+}
@@ -1,36 +1,84 @@
 package main
 
 import (
+	"fmt"
+	"strings"
+
+	pb "github.com/adammck/collector/proto/gen"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
+// newError records the error in the metrics and the recent errors log, and
+// returns it as a grpc status.
+func newError(code codes.Code, msg string) error {
+	recordError(code)
+	err := status.Error(code, msg)
+	recentErrors.Append(code, msg, err)
+	return err
+}
+
+// forRequest attaches a summary of req to the recent errors log entry for err,
+// which must have come from one of the constructors below.
+func forRequest(req *pb.Request, err error) error {
+	recentErrors.Annotate(err, summarizeRequest(req))
+	return err
+}
+
+// maxSummaryLength bounds request summaries in the recent errors log.
+const maxSummaryLength = 200
+
+// summarizeRequest describes req briefly enough to keep in the recent errors
+// log, without its (potentially large) data.
+func summarizeRequest(req *pb.Request) string {
+	if req == nil {
+		return ""
+	}
+
+	kinds := make([]string, len(req.Inputs))
+	for i, input := range req.Inputs {
+		if kinds[i] = "none"; input != nil {
+			if name := visualizationName(input); name != "" {
+				kinds[i] = name
+			}
+		}
+	}
+
+	s := fmt.Sprintf("inputs=[%s] title=%q tag=%q", strings.Join(kinds, " "), req.Title, req.Tag)
+	if len(s) > maxSummaryLength {
+		s = s[:maxSummaryLength] + "..."
+	}
+	return s
+}
+
 // validation errors -> InvalidArgument
 func validationError(msg string, args ...any) error {
-	recordError(codes.InvalidArgument)
-	return status.Errorf(codes.InvalidArgument, msg, args...)
+	return newError(codes.InvalidArgument, fmt.Sprintf(msg, args...))
 }
 
 // not found errors -> NotFound
 func notFoundError(resource string, id string) error {
-	recordError(codes.NotFound)
-	return status.Errorf(codes.NotFound, "%s not found: %s", resource, id)
+	return newError(codes.NotFound, fmt.Sprintf("%s not found: %s", resource, id))
 }
 
 // timeout errors -> DeadlineExceeded
 func timeoutError(operation string) error {
-	recordError(codes.DeadlineExceeded)
-	return status.Errorf(codes.DeadlineExceeded, "%s timed out", operation)
+	return newError(codes.DeadlineExceeded, fmt.Sprintf("%s timed out", operation))
+}
+
+// precondition failures -> FailedPrecondition
+func failedPreconditionError(msg string) error {
+	return newError(codes.FailedPrecondition, msg)
 }
 
 // server errors -> Internal
 func internalError(err error) error {
-	recordError(codes.Internal)
-	return status.Errorf(codes.Internal, "internal error: %v", err)
+	return newError(codes.Internal, fmt.Sprintf("internal error: %v", err))
 }
 
-// resource exhaustion -> ResourceExhausted
-func resourceExhaustedError(resource string) error {
-	recordError(codes.ResourceExhausted)
-	return status.Errorf(codes.ResourceExhausted, "%s limit exceeded", resource)
+// resource exhaustion -> ResourceExhausted. reason is one of the reject*
+// constants, and is counted separately in the metrics.
+func resourceExhaustedError(reason string, resource string) error {
+	recordRejectReason(reason)
+	return newError(codes.ResourceExhausted, fmt.Sprintf("%s limit exceeded", resource))
 }
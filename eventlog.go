@@ -0,0 +1,85 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	EventEnqueue = "enqueue"
+	EventDequeue = "dequeue"
+	EventDefer   = "defer"
+	EventSubmit  = "submit"
+	EventRemove  = "remove"
+	EventExpire  = "expire"
+	EventRequeue = "requeue"
+	EventSkip    = "skip"
+	EventPin     = "pin"
+)
+
+type Event struct {
+	Type string    `json:"type"`
+	UUID string    `json:"uuid"`
+	Time time.Time `json:"time"`
+}
+
+// EventLog is a bounded, append-only log of queue operations, used to
+// reconstruct the lifecycle of stuck items. Once full, the oldest events are
+// overwritten. A nil *EventLog discards everything.
+type EventLog struct {
+	mu     sync.Mutex
+	events []Event
+	next   int
+	full   bool
+}
+
+func NewEventLog(size int) *EventLog {
+	if size <= 0 {
+		return nil
+	}
+
+	return &EventLog{
+		events: make([]Event, size),
+	}
+}
+
+func (l *EventLog) Append(typ, uuid string) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.events[l.next] = Event{Type: typ, UUID: uuid, Time: time.Now()}
+	l.next = (l.next + 1) % len(l.events)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// Events returns the retained events in the order they were appended. If uuid
+// is non-empty, only events for that item are returned.
+func (l *EventLog) Events(uuid string) []Event {
+	out := []Event{}
+	if l == nil {
+		return out
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	start, n := 0, l.next
+	if l.full {
+		start, n = l.next, len(l.events)
+	}
+
+	for i := 0; i < n; i++ {
+		e := l.events[(start+i)%len(l.events)]
+		if uuid == "" || e.UUID == uuid {
+			out = append(out, e)
+		}
+	}
+
+	return out
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	pb "github.com/adammck/collector/proto/gen"
+	"github.com/adammck/collector/validation"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 )
@@ -65,6 +66,9 @@ func main() {
 		},
 	}
 
+	if err := validation.Request(req); err != nil {
+		log.Fatalf("invalid request: %v", err)
+	}
 	r, err := c.Collect(ctx, req)
 	if err != nil {
 		log.Fatalf("could not collect: %v", err)
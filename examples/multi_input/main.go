@@ -9,6 +9,7 @@ import (
 	"time"
 
 	pb "github.com/adammck/collector/proto/gen"
+	"github.com/adammck/collector/validation"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 )
@@ -105,6 +106,9 @@ func main() {
 
 	log.Printf("Sending multi-input request: depth grid + velocity (%.1f,%.1f) + temp %.1f°C", 
 		vx, vy, temperature)
+	if err := validation.Request(req); err != nil {
+		log.Fatalf("invalid request: %v", err)
+	}
 	r, err := c.Collect(ctx, req)
 	if err != nil {
 		log.Fatalf("could not collect: %v", err)
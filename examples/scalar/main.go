@@ -8,6 +8,7 @@ import (
 	"time"
 
 	pb "github.com/adammck/collector/proto/gen"
+	"github.com/adammck/collector/validation"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 )
@@ -61,6 +62,9 @@ func main() {
 	}
 
 	log.Printf("Sending temperature reading: %.1f°C", temperature)
+	if err := validation.Request(req); err != nil {
+		log.Fatalf("invalid request: %v", err)
+	}
 	r, err := c.Collect(ctx, req)
 	if err != nil {
 		log.Fatalf("could not collect: %v", err)
@@ -9,6 +9,7 @@ import (
 	"time"
 
 	pb "github.com/adammck/collector/proto/gen"
+	"github.com/adammck/collector/validation"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 )
@@ -70,6 +71,9 @@ func main() {
 	}
 
 	log.Printf("Sending time series with %d points", points)
+	if err := validation.Request(req); err != nil {
+		log.Fatalf("invalid request: %v", err)
+	}
 	r, err := c.Collect(ctx, req)
 	if err != nil {
 		log.Fatalf("could not collect: %v", err)
@@ -116,7 +116,9 @@ export function CollectorApp() {
         {/* Left Panel - Visualizations */}
         <div className="flex-1 bg-white rounded-xl shadow-lg border border-gray-200 overflow-hidden">
           <div className="bg-gray-50 px-6 py-4 border-b border-gray-200">
-            <h2 className="text-lg font-semibold text-gray-800">Input Data</h2>
+            <h2 className="text-lg font-semibold text-gray-800">
+              {data?.proto.title || 'Input Data'}
+            </h2>
             <p className="text-sm text-gray-600">
               {inputs.length === 0 
                 ? 'No data available'
@@ -124,7 +126,11 @@ export function CollectorApp() {
                   ? 'Single visualization'
                   : `${inputs.length} visualizations`
               }
+              {!!data?.proto.estimated_seconds && ` · about ${data.proto.estimated_seconds}s`}
             </p>
+            {data?.proto.prompt && (
+              <p className="mt-2 text-sm text-gray-800 whitespace-pre-wrap">{data.proto.prompt}</p>
+            )}
           </div>
           <div className="p-6 h-full">
             <VisualizationLayout inputs={inputs} />
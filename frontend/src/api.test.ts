@@ -1,5 +1,5 @@
 import { describe, it, expect, beforeEach, vi } from 'vitest'
-import { fetchData, submitResponse, deferItem, APIError } from './api'
+import { fetchData, submitResponse, deferItem, loadConfig, APIError } from './api'
 import { mockDataResponse } from './test/mocks'
 
 // mock fetch globally
@@ -36,6 +36,22 @@ describe('fetchData', () => {
     expect(mockFetch).toHaveBeenCalledWith('/data.json')
   })
 
+  it('passes on the page queue parameter', async () => {
+    window.history.pushState({}, '', '/?queue=teamA')
+    mockFetch.mockResolvedValueOnce({
+      ok: true,
+      status: 200,
+      headers: {
+        get: (name: string) => name === 'content-type' ? 'application/json' : null,
+      },
+      json: () => Promise.resolve(mockDataResponse),
+    })
+
+    await fetchData()
+    window.history.pushState({}, '', '/')
+    expect(mockFetch).toHaveBeenCalledWith('/data.json?queue=teamA')
+  })
+
   it('throws APIError on 408 timeout', async () => {
     mockFetch.mockResolvedValueOnce({
       ok: false,
@@ -169,4 +185,37 @@ describe('deferItem', () => {
 
     await expect(deferItem('invalid-uuid')).rejects.toThrow(new APIError('defer failed: 404', 404))
   })
-})
\ No newline at end of file
+})
+describe('loadConfig', () => {
+  beforeEach(() => {
+    mockFetch.mockClear()
+  })
+
+  it('prefixes requests with the base path', async () => {
+    mockFetch.mockResolvedValueOnce({
+      ok: true,
+      status: 200,
+      json: () => Promise.resolve({ base_path: '/collector' }),
+    })
+    await loadConfig()
+    expect(mockFetch).toHaveBeenCalledWith('config.json')
+
+    mockFetch.mockResolvedValueOnce({
+      ok: true,
+      status: 200,
+      json: () => Promise.resolve(mockDataResponse),
+    })
+    await deferItem('test-uuid')
+    expect(mockFetch).toHaveBeenLastCalledWith('/collector/defer/test-uuid', {
+      method: 'POST',
+    })
+
+    // back to the root for any later tests
+    mockFetch.mockResolvedValueOnce({
+      ok: true,
+      status: 200,
+      json: () => Promise.resolve({ base_path: '' }),
+    })
+    await loadConfig()
+  })
+})
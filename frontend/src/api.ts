@@ -12,8 +12,33 @@ export class APIError extends Error {
   }
 }
 
+// basePath is where the server mounts its API, from /config.json. It's empty
+// unless the server was started with BASE_PATH.
+let basePath = '';
+
+// loadConfig fetches the server's settings. config.json is requested relative
+// to the page, since the page itself is served under the base path.
+export async function loadConfig(): Promise<void> {
+  try {
+    const response = await fetch('config.json');
+    if (response.ok) {
+      const config: { base_path?: string } = await response.json();
+      basePath = config.base_path ?? '';
+    }
+  } catch {
+    // assume the root, which is what older servers use
+  }
+}
+
+// queueQuery passes on the page's ?queue= (if any), so that a team's
+// annotators only see their own sub-queue.
+function queueQuery(): string {
+  const queue = new URLSearchParams(window.location.search).get('queue');
+  return queue ? `?queue=${encodeURIComponent(queue)}` : '';
+}
+
 export async function fetchData(): Promise<DataResponse> {
-  const response = await fetch('/data.json');
+  const response = await fetch(`${basePath}/data.json${queueQuery()}`);
   
   if (response.status === 408) {
     throw new APIError('timeout', 408);
@@ -42,7 +67,7 @@ export async function fetchData(): Promise<DataResponse> {
   return response.json();
 }
 
-export async function submitResponse(uuid: string, index: number): Promise<void> {
+export async function submitResponse(uuid: string, index: number, comment?: string): Promise<void> {
   const data: SubmitRequest = {
     output: {
       optionList: {
@@ -50,8 +75,11 @@ export async function submitResponse(uuid: string, index: number): Promise<void>
       },
     },
   };
+  if (comment) {
+    data.output.comment = comment;
+  }
   
-  const response = await fetch(`/submit/${uuid}`, {
+  const response = await fetch(`${basePath}/submit/${uuid}`, {
     method: 'POST',
     headers: {
       'Content-Type': 'application/json',
@@ -72,7 +100,7 @@ export async function submitResponse(uuid: string, index: number): Promise<void>
 }
 
 export async function deferItem(uuid: string): Promise<DataResponse> {
-  const response = await fetch(`/defer/${uuid}`, {
+  const response = await fetch(`${basePath}/defer/${uuid}${queueQuery()}`, {
     method: 'POST',
   });
   
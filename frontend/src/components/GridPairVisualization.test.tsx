@@ -0,0 +1,51 @@
+import { describe, it, expect } from 'vitest'
+import { render, screen } from '../test/utils'
+import { GridPairVisualization } from './GridPairVisualization'
+import type { Input } from '../types'
+
+describe('GridPairVisualization', () => {
+  const mockPairInput: Input = {
+    Visualization: {
+      GridPair: {
+        rows: 2,
+        cols: 2,
+        left_label: 'yesterday',
+        right_label: 'today',
+      },
+    },
+    data: {
+      Data: {
+        Ints: {
+          values: [1, 0, 0, 1, 1, 1, 0, 1],
+        },
+      },
+    },
+  }
+
+  it('renders both grids with their labels', () => {
+    render(<GridPairVisualization input={mockPairInput} />)
+
+    expect(screen.getByText('yesterday')).toBeInTheDocument()
+    expect(screen.getByText('today')).toBeInTheDocument()
+    expect(document.querySelectorAll('table')).toHaveLength(2)
+    expect(document.querySelectorAll('td')).toHaveLength(8)
+  })
+
+  it('marks the cells which changed on both sides', () => {
+    render(<GridPairVisualization input={mockPairInput} />)
+
+    // only (0, 1) differs
+    expect(document.querySelectorAll('td[data-changed]')).toHaveLength(2)
+  })
+
+  it('defaults the labels to before and after', () => {
+    const input: Input = {
+      ...mockPairInput,
+      Visualization: { GridPair: { rows: 2, cols: 2 } },
+    }
+    render(<GridPairVisualization input={input} />)
+
+    expect(screen.getByText('Before')).toBeInTheDocument()
+    expect(screen.getByText('After')).toBeInTheDocument()
+  })
+})
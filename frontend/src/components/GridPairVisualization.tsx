@@ -0,0 +1,59 @@
+import type { Input } from '../types';
+import { decodeBytes } from '../data';
+
+interface Props {
+  input: Input;
+}
+
+// GridPairVisualization shows two grids side by side, with the cells which
+// differ between them outlined, for "what changed" tasks.
+export function GridPairVisualization({ input }: Props) {
+  const pair = input.Visualization.GridPair;
+  const data = input.data.Data;
+  const values = data.Ints?.values || data.Floats?.values || decodeBytes(data.Bytes);
+  
+  if (!pair || !values) return null;
+  
+  const { rows, cols } = pair;
+  const size = rows * cols;
+  const sides = [
+    { label: pair.left_label || 'Before', offset: 0 },
+    { label: pair.right_label || 'After', offset: size },
+  ];
+  
+  return (
+    <div className="flex items-center justify-center gap-6 h-full">
+      {sides.map(({ label, offset }) => (
+        <div key={offset} className="bg-gray-50 p-4 rounded-lg border-2 border-gray-200 shadow-inner">
+          <div className="text-center mb-3 text-sm font-semibold text-gray-700">{label}</div>
+          <table className="border-collapse font-mono text-lg">
+            <tbody>
+              {Array.from({ length: rows }, (_, r) => (
+                <tr key={r}>
+                  {Array.from({ length: cols }, (_, c) => {
+                    const i = r * cols + c;
+                    const value = values[offset + i];
+                    const changed = values[i] !== values[size + i];
+                    return (
+                      <td
+                        key={c}
+                        data-changed={changed || undefined}
+                        className={`border border-gray-300 text-center w-12 h-12 font-bold ${
+                          value
+                            ? 'bg-gradient-to-br from-gray-800 to-gray-900 text-white shadow-sm'
+                            : 'bg-white text-gray-300'
+                        } ${changed ? 'ring-2 ring-inset ring-amber-400' : ''}`}
+                      >
+                        {value || '0'}
+                      </td>
+                    );
+                  })}
+                </tr>
+              ))}
+            </tbody>
+          </table>
+        </div>
+      ))}
+    </div>
+  );
+}
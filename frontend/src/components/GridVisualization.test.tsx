@@ -49,6 +49,25 @@ describe('GridVisualization', () => {
     expect(cells[1]).toHaveClass('bg-white', 'text-gray-300')
   })
 
+  it('renders masked cells blank', () => {
+    const masked: Input = {
+      ...mockGridInput,
+      Visualization: {
+        Grid: {
+          rows: 3,
+          cols: 3,
+          mask: [true, false, true, true, true, true, true, true, true],
+        },
+      },
+    }
+    render(<GridVisualization input={masked} />)
+
+    const cells = document.querySelectorAll('td')
+    expect(cells[1]).toHaveTextContent('')
+    expect(cells[1]).toHaveAttribute('title', 'no data')
+    expect(cells[3]).toHaveTextContent('0')
+  })
+
   it('handles missing grid data gracefully', () => {
     const invalidInput: Input = {
       Visualization: {},
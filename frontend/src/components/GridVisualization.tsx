@@ -1,4 +1,5 @@
 import type { Input } from '../types';
+import { decodeBytes } from '../data';
 
 interface Props {
   input: Input;
@@ -6,11 +7,11 @@ interface Props {
 
 export function GridVisualization({ input }: Props) {
   const grid = input.Visualization.Grid;
-  const values = input.data.Data.Ints?.values;
+  const values = input.data.Data.Ints?.values || decodeBytes(input.data.Data.Bytes);
   
   if (!grid || !values) return null;
   
-  const { rows, cols } = grid;
+  const { rows, cols, mask } = grid;
   
   return (
     <div className="flex items-center justify-center h-full">
@@ -21,6 +22,15 @@ export function GridVisualization({ input }: Props) {
               <tr key={r}>
                 {Array.from({ length: cols }, (_, c) => {
                   const value = values[r * cols + c];
+                  if (mask && !mask[r * cols + c]) {
+                    return (
+                      <td
+                        key={c}
+                        title="no data"
+                        className="border border-gray-300 w-12 h-12 bg-gray-100"
+                      />
+                    );
+                  }
                   return (
                     <td
                       key={c}
@@ -0,0 +1,90 @@
+import type { ReactNode } from 'react';
+import type { Input } from '../types';
+
+interface Props {
+  input: Input;
+}
+
+// A deliberately small subset of markdown: headings, paragraphs, bullet lists,
+// **bold**, *italic*, `code`, and [links](https://...). Everything is rendered
+// as React text, never as HTML, so content can't inject markup.
+export function MarkdownVisualization({ input }: Props) {
+  const markdown = input.Visualization.Markdown;
+  if (!markdown) return null;
+
+  return (
+    <div className="prose max-w-none text-gray-800 space-y-3">
+      {parseBlocks(markdown.content)}
+    </div>
+  );
+}
+
+function parseBlocks(content: string): ReactNode[] {
+  const blocks: ReactNode[] = [];
+  const paragraphs = content.split(/\n\s*\n/);
+
+  paragraphs.forEach((para, i) => {
+    const lines = para.split('\n').filter((l) => l.trim() !== '');
+    if (lines.length === 0) return;
+
+    const heading = lines[0].match(/^(#{1,3})\s+(.*)$/);
+    if (heading && lines.length === 1) {
+      const sizes = ['text-xl', 'text-lg', 'text-base'];
+      blocks.push(
+        <div key={i} className={`${sizes[heading[1].length - 1]} font-semibold`}>
+          {parseInline(heading[2])}
+        </div>
+      );
+      return;
+    }
+
+    if (lines.every((l) => /^\s*[-*]\s+/.test(l))) {
+      blocks.push(
+        <ul key={i} className="list-disc pl-5">
+          {lines.map((l, j) => (
+            <li key={j}>{parseInline(l.replace(/^\s*[-*]\s+/, ''))}</li>
+          ))}
+        </ul>
+      );
+      return;
+    }
+
+    blocks.push(<p key={i}>{parseInline(lines.join(' '))}</p>);
+  });
+
+  return blocks;
+}
+
+function parseInline(text: string): ReactNode[] {
+  const nodes: ReactNode[] = [];
+  const pattern = /\*\*([^*]+)\*\*|\*([^*]+)\*|`([^`]+)`|\[([^\]]+)\]\(([^)\s]+)\)/g;
+  let last = 0;
+  let match: RegExpExecArray | null;
+
+  while ((match = pattern.exec(text)) !== null) {
+    if (match.index > last) nodes.push(text.slice(last, match.index));
+    const key = match.index;
+
+    if (match[1] !== undefined) {
+      nodes.push(<strong key={key}>{match[1]}</strong>);
+    } else if (match[2] !== undefined) {
+      nodes.push(<em key={key}>{match[2]}</em>);
+    } else if (match[3] !== undefined) {
+      nodes.push(<code key={key} className="bg-gray-100 px-1 rounded">{match[3]}</code>);
+    } else if (/^https?:\/\//.test(match[5])) {
+      nodes.push(
+        <a key={key} href={match[5]} target="_blank" rel="noopener noreferrer" className="text-blue-600 underline">
+          {match[4]}
+        </a>
+      );
+    } else {
+      // only http(s) links, so nothing like javascript: gets through
+      nodes.push(match[4]);
+    }
+
+    last = pattern.lastIndex;
+  }
+
+  if (last < text.length) nodes.push(text.slice(last));
+  return nodes;
+}
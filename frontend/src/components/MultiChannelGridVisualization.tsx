@@ -1,5 +1,6 @@
 import { useEffect, useRef } from 'react';
 import type { Input } from '../types';
+import { decodeBytes } from '../data';
 
 interface Props {
   input: Input;
@@ -8,7 +9,7 @@ interface Props {
 export function MultiChannelGridVisualization({ input }: Props) {
   const canvasRef = useRef<HTMLCanvasElement>(null);
   const multiGrid = input.Visualization.MultiGrid;
-  const values = input.data.Data.Ints?.values || input.data.Data.Floats?.values;
+  const values = input.data.Data.Ints?.values || input.data.Data.Floats?.values || decodeBytes(input.data.Data.Bytes);
   
   useEffect(() => {
     if (!multiGrid || !values || !canvasRef.current) return;
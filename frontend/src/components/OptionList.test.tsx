@@ -148,4 +148,36 @@ describe('OptionList', () => {
 
     expect(mockOnSubmit).toHaveBeenCalledWith(0)
   })
+
+  it('submits -1 for none of these when allowed', async () => {
+    const user = userEvent.setup()
+    const output: Output = {
+      OptionList: { ...mockOutput.OptionList!, allow_none: true }
+    }
+    render(<OptionList output={output} onSubmit={mockOnSubmit} />)
+
+    await user.click(screen.getByText('None of these'))
+    expect(mockOnSubmit).toHaveBeenCalledWith(-1)
+  })
+
+  it('shows descriptions as tooltips', () => {
+    const output: Output = {
+      OptionList: {
+        options: [
+          { label: 'go', hotkey: 'g' },
+          { label: 'stop', hotkey: 's', description: 'halts all motors immediately' },
+        ]
+      }
+    }
+    render(<OptionList output={output} onSubmit={mockOnSubmit} />)
+
+    expect(screen.getByText('stop').closest('button')).toHaveAttribute('title', 'halts all motors immediately')
+    expect(screen.getByText('go').closest('button')).not.toHaveAttribute('title')
+  })
+
+  it('hides none of these unless allowed', () => {
+    render(<OptionList output={mockOutput} onSubmit={mockOnSubmit} />)
+
+    expect(screen.queryByText('None of these')).not.toBeInTheDocument()
+  })
 })
\ No newline at end of file
@@ -9,6 +9,7 @@ interface Props {
 
 export function OptionList({ output, disabled = false, onSubmit }: Props) {
   const options = output.OptionList?.options;
+  const allowNone = output.OptionList?.allow_none ?? false;
   
   useEffect(() => {
     if (!options) return;
@@ -34,30 +35,59 @@ export function OptionList({ output, disabled = false, onSubmit }: Props) {
   
   if (!options) return null;
   
+  // ungrouped options come first, then each group in order of first use. the
+  // original indices are kept, since that's what gets submitted.
+  const sections = new Map<string, number[]>([['', []]]);
+  options.forEach((opt, i) => {
+    const group = opt.group ?? '';
+    if (!sections.has(group)) sections.set(group, []);
+    sections.get(group)!.push(i);
+  });
+  
   return (
     <div className={`space-y-3 ${disabled ? 'opacity-50' : ''}`}>
-      {options.map((option, index) => {
-        const hotkey = option.hotkey;
-        return (
-          <button
-            key={index}
-            className="w-full px-6 py-4 text-left bg-gradient-to-r from-blue-50 to-indigo-50 hover:from-blue-100 hover:to-indigo-100 border border-blue-200 rounded-xl cursor-pointer disabled:cursor-not-allowed transition-all duration-200 hover:shadow-md transform hover:scale-[1.02] active:scale-[0.98]"
-            onClick={() => onSubmit(index)}
-            disabled={disabled}
-          >
-            <div className="flex items-center justify-between">
-              <span className="text-lg font-medium text-gray-800">
-                {option.label}
-              </span>
-              {hotkey && (
-                <span className="px-2 py-1 bg-white border border-gray-300 rounded text-sm font-mono text-gray-600 shadow-sm">
-                  {hotkey}
-                </span>
-              )}
-            </div>
-          </button>
-        );
-      })}
+      {Array.from(sections, ([group, indices]) => indices.length > 0 && (
+        <div key={group} className="space-y-3">
+          {group && (
+            <h3 className="pt-2 text-sm font-semibold uppercase tracking-wide text-gray-500">
+              {group}
+            </h3>
+          )}
+          {indices.map((index) => {
+            const option = options[index];
+            const hotkey = option.hotkey;
+            return (
+              <button
+                key={index}
+                className="w-full px-6 py-4 text-left bg-gradient-to-r from-blue-50 to-indigo-50 hover:from-blue-100 hover:to-indigo-100 border border-blue-200 rounded-xl cursor-pointer disabled:cursor-not-allowed transition-all duration-200 hover:shadow-md transform hover:scale-[1.02] active:scale-[0.98]"
+                onClick={() => onSubmit(index)}
+                disabled={disabled}
+                title={option.description || undefined}
+              >
+                <div className="flex items-center justify-between">
+                  <span className="text-lg font-medium text-gray-800">
+                    {option.label}
+                  </span>
+                  {hotkey && (
+                    <span className="px-2 py-1 bg-white border border-gray-300 rounded text-sm font-mono text-gray-600 shadow-sm">
+                      {hotkey}
+                    </span>
+                  )}
+                </div>
+              </button>
+            );
+          })}
+        </div>
+      ))}
+      {allowNone && (
+        <button
+          className="w-full px-6 py-3 text-left bg-white hover:bg-gray-50 border border-dashed border-gray-300 rounded-xl cursor-pointer disabled:cursor-not-allowed text-gray-600 transition-all duration-200"
+          onClick={() => onSubmit(-1)}
+          disabled={disabled}
+        >
+          None of these
+        </button>
+      )}
     </div>
   );
 }
\ No newline at end of file
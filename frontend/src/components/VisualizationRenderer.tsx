@@ -1,9 +1,11 @@
 import type { Input } from '../types';
 import { GridVisualization } from './GridVisualization';
+import { GridPairVisualization } from './GridPairVisualization';
 import { MultiChannelGridVisualization } from './MultiChannelGridVisualization';
 import { ScalarVisualization } from './ScalarVisualization';
 import { Vector2DVisualization } from './Vector2DVisualization';
 import { TimeSeriesVisualization } from './TimeSeriesVisualization';
+import { MarkdownVisualization } from './MarkdownVisualization';
 
 interface Props {
   input: Input;
@@ -21,6 +23,14 @@ export function VisualizationRenderer({ input, className = '' }: Props) {
     );
   }
   
+  if (viz.GridPair) {
+    return (
+      <div className={className}>
+        <GridPairVisualization input={input} />
+      </div>
+    );
+  }
+  
   if (viz.MultiGrid) {
     return (
       <div className={className}>
@@ -53,6 +63,14 @@ export function VisualizationRenderer({ input, className = '' }: Props) {
     );
   }
   
+  if (viz.Markdown) {
+    return (
+      <div className={className}>
+        <MarkdownVisualization input={input} />
+      </div>
+    );
+  }
+  
   return (
     <div className={`${className} flex items-center justify-center text-gray-500`}>
       <div className="text-center">
@@ -0,0 +1,9 @@
+import type { BytesData } from './types';
+
+// Decodes base64 byte data into plain numbers, so visualizations can treat it
+// like Ints.
+export function decodeBytes(bytes?: BytesData): number[] | undefined {
+  if (!bytes) return undefined;
+  const raw = atob(bytes.values ?? '');
+  return Array.from(raw, (c) => c.charCodeAt(0));
+}
@@ -1,6 +1,16 @@
 export interface GridVisualization {
   rows: number;
   cols: number;
+  // false for cells with no reading; absent if every cell is valid
+  mask?: boolean[];
+}
+
+// the data holds the left grid then the right, each rows*cols long
+export interface GridPairVisualization {
+  rows: number;
+  cols: number;
+  left_label?: string;
+  right_label?: string;
 }
 
 export interface MultiChannelGridVisualization {
@@ -29,12 +39,19 @@ export interface TimeSeriesVisualization {
   maxValue: number;
 }
 
+// display-only, so the input has no data
+export interface MarkdownVisualization {
+  content: string;
+}
+
 export interface Visualization {
   Grid?: GridVisualization;
+  GridPair?: GridPairVisualization;
   MultiGrid?: MultiChannelGridVisualization;
   Scalar?: ScalarVisualization;
   Vector?: Vector2DVisualization;
   TimeSeries?: TimeSeriesVisualization;
+  Markdown?: MarkdownVisualization;
 }
 
 export interface IntData {
@@ -45,57 +62,119 @@ export interface FloatData {
   values: number[];
 }
 
+// base64, as encoding/json serializes []byte
+export interface BytesData {
+  values: string;
+}
+
 export interface Data {
   Ints?: IntData;
   Floats?: FloatData;
+  Bytes?: BytesData;
 }
 
 export interface InputData {
   Data: Data;
 }
 
+export type LayoutHint = 'LAYOUT_HINT_UNSPECIFIED' | 'FULL_WIDTH' | 'HALF' | 'SIDEBAR';
+
 export interface Input {
   Visualization: Visualization;
+  // absent for display-only inputs, i.e. Markdown
   data: InputData;
+  layoutHint?: LayoutHint;
+  name?: string;
+  // base64 png, jpeg, or gif; also served at /data/{uuid}/preview/{i}.png
+  preview?: string;
 }
 
 export interface Option {
   label: string;
   hotkey?: string;
+  group?: string;
+  description?: string;
 }
 
 export interface OptionListOutput {
   options: Option[];
+  // if set, the annotator may abstain, which is submitted as index -1.
+  allow_none?: boolean;
+}
+
+export interface RangeSchema {
+  min: number;
+  max: number;
+  label?: string;
+}
+
+export interface ClassifySchema {
+  class_labels: string[];
+  ask_confidence?: boolean;
+}
+
+export interface EditSchema {
+  editable: boolean;
+  // index into inputs of the input whose data is corrected
+  input?: number;
 }
 
 export interface Output {
   OptionList?: OptionListOutput;
+  Range?: RangeSchema;
+  Classify?: ClassifySchema;
+  Edit?: EditSchema;
 }
 
 export interface Proto {
+  title?: string;
+  prompt?: string;
+  // hint of how long the task should take; zero if unset
+  estimated_seconds?: number;
   inputs?: Input[];
   output?: {
     Output: Output;
   };
+  // set instead of output when several answers are needed at once
+  outputs?: {
+    Output: Output;
+  }[];
 }
 
 export interface Queue {
   total: number;
   active: number;
   deferred: number;
+  // only from /queue/status: counts by tag ("" if untagged) and input kind
+  by_tag?: Record<string, number>;
+  by_type?: Record<string, number>;
+  tags_truncated?: boolean;
 }
 
 export interface DataResponse {
   uuid: string;
   proto: Proto;
   queue: Queue;
+  // ordinal of this item among all served since the server started
+  queue_position?: number;
+  // active items still waiting behind this one
+  queue_remaining?: number;
 }
 
 export interface SubmitRequest {
   output: {
-    optionList: {
+    optionList?: {
       index: number;
     };
+    range?: {
+      start: number;
+      end: number;
+    };
+    classify?: {
+      classIndex: number;
+      confidence?: number;
+    };
+    comment?: string;
   };
 }
 
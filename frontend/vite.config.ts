@@ -4,6 +4,8 @@ import react from '@vitejs/plugin-react'
 // https://vite.dev/config/
 export default defineConfig({
   plugins: [react()],
+  // relative asset paths, so the build works under the server's BASE_PATH
+  base: './',
   server: {
     proxy: {
       '/data.json': 'http://localhost:8000',
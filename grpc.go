@@ -2,16 +2,179 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	pb "github.com/adammck/collector/proto/gen"
 	"github.com/google/uuid"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/reflection"
+	grpcstats "google.golang.org/grpc/stats"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
 )
 
+// newGRPCServer returns a grpc server with the Collector service (and, if
+// enabled, server reflection) registered. Keepalive pings detect dead clients,
+// whose connections are closed so that their blocked Collect calls return.
+// Messages over the configured sizes (0 leaves grpc's defaults) are rejected
+// by grpc itself, before Collect sees them; sizeLimitHandler makes those
+// rejections visible.
+func newGRPCServer(cfg *Config, s *server) *grpc.Server {
+	opts := []grpc.ServerOption{
+		grpc.StatsHandler(sizeLimitHandler{}),
+		grpc.UnaryInterceptor(s.validationInterceptor),
+	}
+	if cfg.MaxRecvMsgSize > 0 {
+		opts = append(opts, grpc.MaxRecvMsgSize(cfg.MaxRecvMsgSize))
+	}
+	if cfg.MaxSendMsgSize > 0 {
+		opts = append(opts, grpc.MaxSendMsgSize(cfg.MaxSendMsgSize))
+	}
+
+	srv := grpc.NewServer(append(opts,
+		grpc.KeepaliveParams(keepalive.ServerParameters{
+			MaxConnectionIdle: cfg.MaxConnectionIdle,
+			Time:              cfg.KeepaliveTime,
+			Timeout:           cfg.KeepaliveTimeout,
+		}),
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             cfg.KeepaliveMinTime,
+			PermitWithoutStream: true,
+		}),
+	)...)
+	pb.RegisterCollectorServer(srv, &collectorServer{s: s})
+
+	if cfg.EnableReflection {
+		reflection.Register(srv)
+	}
+
+	return srv
+}
+
+// validatedMethods are the rpcs whose Request is checked by
+// validationInterceptor before their handler runs.
+var validatedMethods = map[string]bool{
+	pb.Collector_Collect_FullMethodName: true,
+}
+
+// validationInterceptor rejects an invalid Request to any of validatedMethods
+// with InvalidArgument, so that the handlers only ever see valid requests and
+// share one place where they're checked. As with validate, compressed data is
+// expanded in place.
+func (s *server) validationInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	r, ok := req.(*pb.Request)
+	if !ok || !validatedMethods[info.FullMethod] {
+		return handler(ctx, req)
+	}
+
+	err := validate(r, s.validation)
+	if err == nil {
+		err = downsampledEdit(r, s.maxTimeSeriesPoints)
+	}
+	if err != nil {
+		attrs := []any{"method", info.FullMethod, "field", fieldPath(err), "error", err}
+		if u := incomingMetadata(ctx, uuidMetadataKey); u != "" {
+			attrs = append(attrs, "uuid", u)
+		}
+		if p, ok := peer.FromContext(ctx); ok {
+			attrs = append(attrs, "peer", p.Addr.String())
+		}
+		slog.Warn("collect request invalid", attrs...)
+
+		if incomingMetadata(ctx, debugMetadataKey) == "true" {
+			debugLog.Debug("collect request debug",
+				"request", protojson.Format(r))
+		}
+		return nil, forRequest(r, validationError("invalid request: %v", err))
+	}
+
+	return handler(ctx, req)
+}
+
+// incomingMetadata returns the first value of key in the request metadata, or
+// "" if there is none.
+func incomingMetadata(ctx context.Context, key string) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if vals := md.Get(key); len(vals) > 0 {
+			return vals[0]
+		}
+	}
+	return ""
+}
+
+// sizeLimitHandler logs and counts the rpcs which grpc rejects for exceeding
+// MaxRecvMsgSize or MaxSendMsgSize. grpc writes the status (ResourceExhausted,
+// with both sizes) before any of our code runs, so it wouldn't otherwise show
+// up in the logs, /metrics, or /debug/errors.
+type sizeLimitHandler struct{}
+
+type rpcMethodKey struct{}
+
+func (sizeLimitHandler) TagRPC(ctx context.Context, info *grpcstats.RPCTagInfo) context.Context {
+	return context.WithValue(ctx, rpcMethodKey{}, info.FullMethodName)
+}
+
+func (sizeLimitHandler) HandleRPC(ctx context.Context, rs grpcstats.RPCStats) {
+	end, ok := rs.(*grpcstats.End)
+	if !ok || end.Error == nil {
+		return
+	}
+	st := status.Convert(end.Error)
+	if st.Code() != codes.ResourceExhausted || !strings.Contains(st.Message(), "larger than max") {
+		return
+	}
+
+	method, _ := ctx.Value(rpcMethodKey{}).(string)
+	slog.Warn("grpc message too large", "method", method, "error", st.Message())
+	recordError(codes.ResourceExhausted)
+	recordRejectReason(rejectTooLarge)
+	recentErrors.Append(st.Code(), st.Message(), end.Error)
+}
+
+func (sizeLimitHandler) TagConn(ctx context.Context, _ *grpcstats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (sizeLimitHandler) HandleConn(context.Context, grpcstats.ConnStats) {}
+
+// uuidMetadataKey carries the uuid of a Collect call: optionally chosen by the
+// client in request metadata, and always sent back in the response header.
+const uuidMetadataKey = "x-collector-uuid"
+
+// debugMetadataKey, when set to "true" in request metadata, enables verbose
+// logging for that one Collect call regardless of the global log level.
+const debugMetadataKey = "x-collect-debug"
+
+// queueMetadataKey names the sub-queue a Session serves. Collect uses
+// Request.queue_name instead.
+const queueMetadataKey = "x-collector-queue"
+
+// debugLog receives the verbose logs of requests marked with debugMetadataKey.
+// main rebuilds it in the configured LOG_FORMAT, still at the Debug level.
+var debugLog = newLogger(os.Stderr, logFormatText, slog.LevelDebug)
+
+// errCancelledByClient is the cause given when a Collect is aborted via the
+// Cancel rpc.
+var errCancelledByClient = errors.New("cancelled by client")
+
+// errSkippedByAnnotator is the cause given when an annotator skips an item.
+var errSkippedByAnnotator = errors.New("skipped by annotator")
+
+// errNoLongerValid is wrapped by the cause given when an item fails validation
+// as it's served, having passed when its Collect was accepted.
+var errNoLongerValid = errors.New("request no longer valid")
+
 type collectorServer struct {
 	pb.UnsafeCollectorServer
 	s *server
@@ -20,48 +183,181 @@ type collectorServer struct {
 func (cs *collectorServer) Collect(ctx context.Context, req *pb.Request) (*pb.Response, error) {
 	slog.Info("collect request received",
 		"input_count", len(req.Inputs),
-		"has_output_schema", req.Output != nil)
+		"has_output_schema", req.Output != nil || len(req.Outputs) > 0)
+
+	u := uuid.NewString()
+	clientID := false
+	debug := false
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(uuidMetadataKey); len(ids) > 0 && ids[0] != "" {
+			u, clientID = ids[0], true
+		}
+		if vals := md.Get(debugMetadataKey); len(vals) > 0 && vals[0] == "true" {
+			debug = true
+		}
+	}
+
+	if debug {
+		debugLog.Debug("collect request debug",
+			"uuid", u,
+			"request", protojson.Format(req))
+	}
+
+	// req has already passed validationInterceptor
+	q, err := cs.s.queueFor(req.QueueName)
+	if err != nil {
+		return nil, forRequest(req, resourceExhaustedError(rejectQueueFull, "queues"))
+	}
 
-	// validate first
-	if err := validate(req); err != nil {
-		return nil, validationError("invalid request: %v", err)
+	// check resource limits, which are per sub-queue
+	queueStatus := q.Status()
+	if queueStatus.Total >= cs.s.maxPendingFor(req.QueueName) {
+		return nil, forRequest(req, resourceExhaustedError(rejectQueueFull, "pending requests"))
 	}
 
-	// check resource limits
-	queueStatus := cs.s.queue.Status()
-	if queueStatus.Total >= config.MaxPendingRequests {
-		return nil, resourceExhaustedError("pending requests")
+	// a client which forgot its deadline would otherwise wait forever
+	if max := cs.s.maxCollectWait; max > 0 {
+		if deadline, ok := ctx.Deadline(); !ok || time.Until(deadline) > max {
+			var cancelWait context.CancelFunc
+			ctx, cancelWait = context.WithTimeout(ctx, max)
+			defer cancelWait()
+		}
 	}
 
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
 	resCh := make(chan *pb.Response, 1)
-	u := uuid.NewString()
 	item := &QueueItem{
 		ID:       u,
 		Request:  req,
 		Response: resCh,
 		AddedAt:  time.Now(),
 		Context:  ctx,
+		Cancel:   cancel,
+		Acked:    make(chan struct{}),
+
+		Permutations: shufflePermutations(req, u),
 	}
 
-	if err := cs.s.queue.Enqueue(item); err != nil {
-		return nil, internalError(err)
+	if err := cs.s.enqueueUnique(q, item); err != nil {
+		if clientID {
+			return nil, status.Errorf(codes.AlreadyExists, "uuid already in use: %s", u)
+		}
+		return nil, forRequest(req, internalError(err))
 	}
 
+	grpc.SendHeader(ctx, metadata.Pairs(uuidMetadataKey, u))
+	slog.Info("collect request enqueued", "uuid", u, "client_uuid", clientID)
+	warnShortDeadline(ctx, req, u)
+
 	// cleanup on all exit paths
 	defer func() {
-		cs.s.queue.Remove(u)
+		q.Remove(u)
 	}()
 
-	select {
-	case res, ok := <-resCh:
-		if !ok {
-			return nil, internalError(fmt.Errorf("response channel closed"))
-		}
-		return res, nil
-	case <-ctx.Done():
-		if ctx.Err() == context.DeadlineExceeded {
-			return nil, timeoutError("collect")
+	atomic.AddInt64(&stats.InFlight, 1)
+	defer atomic.AddInt64(&stats.InFlight, -1)
+
+	// a default answer stands in for a human once most of the deadline is gone
+	var autoAnswer <-chan time.Time
+	if d := cs.s.autoAnswerAfter(ctx, req); d > 0 {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		autoAnswer = timer.C
+	}
+
+	for {
+		select {
+		case res, ok := <-resCh:
+			if !ok {
+				return nil, internalError(fmt.Errorf("response channel closed"))
+			}
+			close(item.Acked)
+			if debug {
+				debugLog.Debug("collect request answered",
+					"uuid", u,
+					"response", protojson.Format(res))
+			}
+			return res, nil
+		case <-autoAnswer:
+			// take the item back so nobody else can answer it. If that fails,
+			// an annotator is submitting right now, so wait for theirs.
+			autoAnswer = nil
+			if _, ok := cs.s.take(u); !ok {
+				continue
+			}
+			res := proto.Clone(req.DefaultResponse).(*pb.Response)
+			res.Meta = &pb.ResponseMeta{Uuid: u, Auto: true}
+			slog.Info("collect request auto-answered", "uuid", u)
+			return res, nil
+		case <-ctx.Done():
+			return nil, cs.collectDone(ctx, req, u)
 		}
-		return nil, status.Error(codes.Canceled, "request cancelled")
 	}
+}
+
+// warnShortDeadline logs and counts a request whose deadline leaves less time
+// than its estimated_seconds, since it's likely to time out.
+func warnShortDeadline(ctx context.Context, req *pb.Request, u string) {
+	est := time.Duration(req.EstimatedSeconds) * time.Second
+	deadline, ok := ctx.Deadline()
+	if est <= 0 || !ok {
+		return
+	}
+	if left := time.Until(deadline); left < est {
+		atomic.AddInt64(&stats.ShortDeadlines, 1)
+		slog.Warn("collect deadline shorter than estimated duration",
+			"uuid", u, "estimated", est, "deadline", left.Round(time.Millisecond))
+	}
+}
+
+// autoAnswerAfter returns how long to wait before returning the request's
+// default_response, or zero if it shouldn't be.
+func (s *server) autoAnswerAfter(ctx context.Context, req *pb.Request) time.Duration {
+	deadline, ok := ctx.Deadline()
+	if !ok || req.DefaultResponse == nil || s.autoAnswerFraction <= 0 {
+		return 0
+	}
+	return time.Duration(s.autoAnswerFraction * float64(time.Until(deadline)))
+}
+
+// collectDone returns the error for a Collect whose context ended before it
+// was answered.
+func (cs *collectorServer) collectDone(ctx context.Context, req *pb.Request, u string) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		cs.s.events.Append(EventExpire, u)
+		return forRequest(req, timeoutError("collect"))
+	}
+	if errors.Is(context.Cause(ctx), ErrDefersExhausted) {
+		return forRequest(req, failedPreconditionError("exhausted defers"))
+	}
+	if cause := context.Cause(ctx); errors.Is(cause, errNoLongerValid) {
+		return forRequest(req, validationError("invalid request: %v", cause))
+	}
+	if errors.Is(context.Cause(ctx), errSkippedByAnnotator) {
+		return forRequest(req, failedPreconditionError("request skipped by annotator"))
+	}
+	if errors.Is(context.Cause(ctx), errCancelledByClient) {
+		return status.Error(codes.Canceled, "request cancelled via Cancel")
+	}
+	return status.Error(codes.Canceled, "request cancelled")
+}
+
+func (cs *collectorServer) Cancel(ctx context.Context, req *pb.CancelRequest) (*pb.CancelResponse, error) {
+	if req.Id == "" {
+		return nil, validationError("id is required")
+	}
+
+	item, ok := cs.s.take(req.Id)
+	if !ok {
+		return &pb.CancelResponse{Found: false}, nil
+	}
+
+	slog.Info("collect request cancelled", "uuid", req.Id)
+	if item.Cancel != nil {
+		item.Cancel(errCancelledByClient)
+	}
+
+	return &pb.CancelResponse{Found: true}, nil
 }
\ No newline at end of file
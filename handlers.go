@@ -1,61 +1,228 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"embed"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"log/slog"
+	"mime"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	pb "github.com/adammck/collector/proto/gen"
+	"github.com/google/uuid"
 	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
 )
 
+const contentTypeProtobuf = "application/x-protobuf"
+
+// maxReplayLineSize is the longest line accepted by /admin/replay.
+const maxReplayLineSize = 16 << 20
+
+// isProtobuf returns true if the given Content-Type or Accept header value
+// names the binary protobuf media type.
+func isProtobuf(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		mt, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err == nil && mt == contentTypeProtobuf {
+			return true
+		}
+	}
+	return false
+}
+
 type webRequest struct {
 	UUID  string      `json:"uuid"`
 	Proto *pb.Request `json:"proto"`
 	Queue QueueStatus `json:"queue"`
+
+	// QueuePosition is the 1-based ordinal of this item among all items
+	// served since the server started. Since the item has already been
+	// dequeued, its position in the queue itself would always be 1.
+	QueuePosition int64 `json:"queue_position"`
+
+	// QueueRemaining is the number of active (non-deferred) items still
+	// waiting behind this one.
+	QueueRemaining int `json:"queue_remaining"`
 }
 
+// webMarshalOptions emits zero-valued fields, so the frontend can tell a
+// legitimate zero (e.g. Scalar.Min) from an unset field.
+var webMarshalOptions = protojson.MarshalOptions{EmitUnpopulated: true}
+
 func (w *webRequest) MarshalJSON() ([]byte, error) {
-	pj, err := protojson.Marshal(w.Proto)
+	pj, err := webMarshalOptions.Marshal(w.Proto)
 	if err != nil {
 		return nil, err
 	}
 
 	return json.Marshal(map[string]interface{}{
-		"uuid":  w.UUID,
-		"proto": json.RawMessage(pj),
-		"queue": w.Queue,
+		"uuid":            w.UUID,
+		"proto":           json.RawMessage(pj),
+		"queue":           w.Queue,
+		"queue_position":  w.QueuePosition,
+		"queue_remaining": w.QueueRemaining,
 	})
 }
 
-func (s *server) handleData(w http.ResponseWriter, r *http.Request) {
-	item, err := s.queue.GetNext(s.timeout)
-	if err != nil {
-		writeJSONError(w, http.StatusRequestTimeout,
-			"no pending requests available",
-			"wait and retry")
-		return
+// displayed returns the item's request as it's served to annotators.
+func (s *server) displayed(item *QueueItem) *pb.Request {
+	return withHotkeys(shuffled(forDisplay(item.Request, s.maxTimeSeriesPoints), item.Permutations))
+}
+
+// rejectInvalid fails the Collect of a dequeued item which no longer passes
+// validate, e.g. because the limits were lowered by a reload or a validator
+// was registered since it was accepted. It has already left the queue, so its
+// Collect would otherwise wait for an answer until its deadline.
+func (s *server) rejectInvalid(item *QueueItem, err error) {
+	if item.Cancel != nil {
+		item.Cancel(fmt.Errorf("%w: %v", errNoLongerValid, err))
+	}
+}
+
+// dataTimeout returns how long handleData should wait for an item: the
+// ?timeout= query param if present and valid (clamped to maxTimeout),
+// otherwise the server default.
+func (s *server) dataTimeout(r *http.Request) time.Duration {
+	t, err := time.ParseDuration(r.URL.Query().Get("timeout"))
+	if err != nil || t <= 0 {
+		return time.Duration(s.timeout.Load())
 	}
 
-	if err := validate(item.Request); err != nil {
+	if s.maxTimeout > 0 && t > s.maxTimeout {
+		return s.maxTimeout
+	}
+
+	return t
+}
+
+// requestQueue returns the sub-queue named by ?queue=, or the default queue.
+// If the name is invalid, it writes the error and returns false.
+func (s *server) requestQueue(w http.ResponseWriter, r *http.Request) (*Queue, bool) {
+	name := r.URL.Query().Get("queue")
+	if err := validateQueueName(name); err != nil {
 		writeJSONError(w, http.StatusBadRequest,
-			"invalid request data",
+			"invalid queue parameter",
 			err.Error())
+		return nil, false
+	}
+
+	q, err := s.queueFor(name)
+	if err != nil {
+		writeJSONError(w, http.StatusServiceUnavailable,
+			err.Error(),
+			fmt.Sprintf("at most %d queues", maxQueues))
+		return nil, false
+	}
+	return q, true
+}
+
+// errTooManyWaiters is returned by getNext when maxDataWaiters requests are
+// already waiting.
+var errTooManyWaiters = errors.New("too many waiting requests")
+
+// getNext is q.GetNext for data requests, which fails with errTooManyWaiters
+// rather than wait alongside maxDataWaiters others.
+func (s *server) getNext(q *Queue, timeout time.Duration) (*QueueItem, error) {
+	n := s.dataWaiters.Add(1)
+	defer s.dataWaiters.Add(-1)
+
+	if s.maxDataWaiters > 0 && n > int64(s.maxDataWaiters) {
+		recordRejectReason(rejectRateLimited)
+		return nil, errTooManyWaiters
+	}
+	return q.GetNext(timeout)
+}
+
+func (s *server) handleData(w http.ResponseWriter, r *http.Request) {
+	q, ok := s.requestQueue(w, r)
+	if !ok {
 		return
 	}
 
-	s.cmu.Lock()
-	s.current[item.ID] = item
-	s.cmu.Unlock()
+	// serve refuses a race replica which was answered after it was dequeued,
+	// so wait for another item instead
+	var item *QueueItem
+	var err error
+	for {
+		item, err = s.getNext(q, s.dataTimeout(r))
+		if err == errTooManyWaiters {
+			writeJSONError(w, http.StatusTooManyRequests,
+				err.Error(),
+				fmt.Sprintf("at most %d requests may wait for an item at once; retry later", s.maxDataWaiters))
+			return
+		}
+		if err == ErrQueuePaused {
+			writeJSONError(w, http.StatusServiceUnavailable,
+				"paused",
+				"serving is paused by an operator")
+			return
+		}
+		if err == ErrAllDeferred {
+			writeJSONError(w, http.StatusRequestTimeout,
+				"all pending requests are deferred",
+				"deferred requests aren't served again; wait for new ones")
+			return
+		}
+		if err != nil {
+			writeJSONError(w, http.StatusRequestTimeout,
+				"no pending requests available",
+				"wait and retry")
+			return
+		}
 
-	status := s.queue.Status()
+		if err := validate(item.Request, s.validation); err != nil {
+			s.rejectInvalid(item, err)
+			writeJSONError(w, http.StatusBadRequest,
+				"invalid request data",
+				err.Error())
+			return
+		}
+
+		if s.serve(item) {
+			break
+		}
+	}
+
+	position := atomic.AddInt64(&s.served, 1)
+
+	status := q.Status()
+	req := s.displayed(item)
+
+	// binary clients get the bare request, with the uuid in a header since
+	// there's nowhere else to put it.
+	if r.URL.Path == "/data.pb" || isProtobuf(r.Header.Get("Accept")) {
+		b, err := proto.Marshal(req)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError,
+				"failed to marshal request",
+				err.Error())
+			return
+		}
+
+		w.Header().Set("Content-Type", contentTypeProtobuf)
+		w.Header().Set("X-Collector-UUID", item.ID)
+		w.Write(b)
+		return
+	}
 
 	b, err := json.Marshal(webRequest{
-		UUID:  item.ID,
-		Proto: item.Request,
-		Queue: status,
+		UUID:           item.ID,
+		Proto:          req,
+		Queue:          status,
+		QueuePosition:  position,
+		QueueRemaining: status.Active,
 	})
 	if err != nil {
 		writeJSONError(w, http.StatusInternalServerError,
@@ -68,6 +235,104 @@ func (s *server) handleData(w http.ResponseWriter, r *http.Request) {
 	w.Write(b)
 }
 
+// handleDataBatch serves up to ?n= items at once, so that a UI can prefetch
+// the next few and not wait between submits. It waits for the first item like
+// handleData, then takes whatever else is immediately available. Each item is
+// served individually, so is submitted or deferred by its own uuid.
+func (s *server) handleDataBatch(w http.ResponseWriter, r *http.Request) {
+	n := 1
+	if v := r.URL.Query().Get("n"); v != "" {
+		var err error
+		if n, err = strconv.Atoi(v); err != nil || n < 1 {
+			writeJSONError(w, http.StatusBadRequest,
+				"invalid n parameter",
+				fmt.Sprintf("n must be a positive integer (got %q)", v))
+			return
+		}
+	}
+	if n > s.maxPrefetch {
+		writeJSONError(w, http.StatusBadRequest,
+			"too many items requested",
+			fmt.Sprintf("n must be at most %d (got %d)", s.maxPrefetch, n))
+		return
+	}
+
+	q, ok := s.requestQueue(w, r)
+	if !ok {
+		return
+	}
+
+	item, err := s.getNext(q, s.dataTimeout(r))
+	if err == errTooManyWaiters {
+		writeJSONError(w, http.StatusTooManyRequests,
+			err.Error(),
+			fmt.Sprintf("at most %d requests may wait for an item at once; retry later", s.maxDataWaiters))
+		return
+	}
+	if err == ErrQueuePaused {
+		writeJSONError(w, http.StatusServiceUnavailable,
+			"paused",
+			"serving is paused by an operator")
+		return
+	}
+	if err == ErrAllDeferred {
+		writeJSONError(w, http.StatusRequestTimeout,
+			"all pending requests are deferred",
+			"deferred requests aren't served again; wait for new ones")
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusRequestTimeout,
+			"no pending requests available",
+			"wait and retry")
+		return
+	}
+
+	items := []*QueueItem{item}
+	for len(items) < n {
+		item, err := q.Dequeue()
+		if err != nil {
+			break
+		}
+		items = append(items, item)
+	}
+
+	// each element is marshalled as a value, like handleData, so they all
+	// have the same shape.
+	out := []json.RawMessage{}
+	for _, item := range items {
+		if err := validate(item.Request, s.validation); err != nil {
+			slog.Warn("dropping invalid item from batch", "uuid", item.ID, "error", err)
+			s.rejectInvalid(item, err)
+			continue
+		}
+
+		if !s.serve(item) {
+			continue
+		}
+		position := atomic.AddInt64(&s.served, 1)
+		status := q.Status()
+
+		b, err := json.Marshal(webRequest{
+			UUID:           item.ID,
+			Proto:          s.displayed(item),
+			Queue:          status,
+			QueuePosition:  position,
+			QueueRemaining: status.Active,
+		})
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError,
+				"failed to marshal request",
+				err.Error())
+			return
+		}
+		out = append(out, b)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
 func (s *server) handleSubmit(w http.ResponseWriter, r *http.Request) {
 	u := r.PathValue("uuid")
 	if u == "" {
@@ -76,22 +341,49 @@ func (s *server) handleSubmit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	s.cmu.Lock()
-	item, ok := s.current[u]
-	if ok {
-		delete(s.current, u)
-	}
-	s.cmu.Unlock()
-
+	item, ok := s.claim(u)
 	if !ok {
+		if s.wasResolved(u) {
+			writeJSONError(w, http.StatusGone,
+				"already answered by another annotator",
+				fmt.Sprintf("uuid: %s", u))
+			return
+		}
 		writeJSONError(w, http.StatusNotFound,
 			"pending request not found",
 			fmt.Sprintf("uuid: %s", u))
 		return
 	}
 
-	b, err := io.ReadAll(r.Body)
+	// the gRPC client has gone away, so there's nobody to resubmit for
+	if item.Context != nil && item.Context.Err() != nil {
+		writeJSONError(w, http.StatusGone,
+			"client is no longer waiting for a response",
+			fmt.Sprintf("uuid: %s", u))
+		return
+	}
+
+	// until the response is accepted, failures must put the item back in
+	// current, or the client is stranded with no way to receive an answer.
+	restore := func() {
+		s.restore(item)
+	}
+
+	body := r.Body
+	if s.maxSubmitBytes > 0 {
+		body = http.MaxBytesReader(w, r.Body, s.maxSubmitBytes)
+	}
+
+	b, err := io.ReadAll(body)
 	if err != nil {
+		restore()
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeJSONError(w, http.StatusRequestEntityTooLarge,
+				"request body too large",
+				fmt.Sprintf("limit is %d bytes", tooLarge.Limit))
+			return
+		}
 		writeJSONError(w, http.StatusInternalServerError,
 			"failed to read request body",
 			err.Error())
@@ -99,15 +391,44 @@ func (s *server) handleSubmit(w http.ResponseWriter, r *http.Request) {
 	}
 
 	res := &pb.Response{}
-	if err := protojson.Unmarshal(b, res); err != nil {
+	if isProtobuf(r.Header.Get("Content-Type")) {
+		err = proto.Unmarshal(b, res)
+	} else {
+		err = protojson.Unmarshal(b, res)
+	}
+	if err != nil {
+		restore()
 		writeJSONError(w, http.StatusBadRequest,
 			"invalid response format",
 			err.Error())
 		return
 	}
 
-	item.Response <- res
-	close(item.Response)
+	// the annotator chose from the options as displayed
+	unshuffle(res, item.Permutations)
+
+	if err := validateAnswer(item.Request, res); err != nil {
+		restore()
+		writeJSONError(w, http.StatusBadRequest,
+			"invalid response",
+			fmt.Sprintf("%v; correct the response and resubmit to the same uuid", err))
+		return
+	}
+
+	// and edited any grid as displayed, i.e. row-major
+	editsToColMajor(item.Request, res)
+
+	annotator := r.Header.Get(annotatorHeader)
+	if !s.complete(item, res, annotator) {
+		writeJSONError(w, http.StatusServiceUnavailable,
+			"client cannot receive the response",
+			fmt.Sprintf("uuid: %s", u))
+		return
+	}
+
+	if annotator != "" {
+		s.annotators.record(annotator)
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.Write([]byte(`{"status":"ok"}`))
@@ -121,68 +442,704 @@ func (s *server) handleDefer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Remove from current before deferring
-	s.cmu.Lock()
-	delete(s.current, u)
-	s.cmu.Unlock()
+	// a served item has left the queue, so put it back before deferring it.
+	// If other annotators are still racing on it, this one just lets go.
+	if item, last, ok := s.release(u); ok {
+		if !last {
+			atomic.AddInt64(&stats.DeferCount, 1)
+			s.handleData(w, r)
+			return
+		}
+		if err := s.queueOf(item).Enqueue(item); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
 
-	if err := s.queue.Defer(u); err != nil {
+	// an item which has exhausted its defers is gone, which is as good as
+	// deferred from the annotator's point of view
+	already, err := s.findQueue(u).Defer(u)
+	if err != nil && !errors.Is(err, ErrDefersExhausted) {
 		writeJSONError(w, http.StatusNotFound, err.Error())
 		return
 	}
 
+	// a repeated defer (e.g. a double-click) is acknowledged without serving
+	// another item, since the first one already did
+	if already {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"already_deferred"}`))
+		return
+	}
+	atomic.AddInt64(&stats.DeferCount, 1)
+
 	// immediately serve next item
 	s.handleData(w, r)
 }
 
+// handleDeferBatch defers many queued items at once, for an operator triaging
+// the queue. The body is either a JSON array of uuids, or {"tag":"x"} to defer
+// every active item with that tag. Items currently shown to an annotator
+// aren't in the queue, so they're not_found.
+func (s *server) handleDeferBatch(w http.ResponseWriter, r *http.Request) {
+	var body json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid defer format", err.Error())
+		return
+	}
+
+	byQueue := map[*Queue][]string{}
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '{' {
+		var sel struct {
+			Tag string `json:"tag"`
+		}
+		if err := json.Unmarshal(body, &sel); err != nil || sel.Tag == "" {
+			writeJSONError(w, http.StatusBadRequest, "invalid defer format",
+				`expected an array of uuids or {"tag":"..."}`)
+			return
+		}
+		for _, q := range s.allQueues() {
+			for _, item := range q.Active() {
+				if item.Request.GetTag() == sel.Tag {
+					byQueue[q] = append(byQueue[q], item.ID)
+				}
+			}
+		}
+	} else {
+		var ids []string
+		if err := json.Unmarshal(body, &ids); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid defer format",
+				`expected an array of uuids or {"tag":"..."}`)
+			return
+		}
+		for _, id := range ids {
+			q := s.findQueue(id)
+			byQueue[q] = append(byQueue[q], id)
+		}
+	}
+
+	results := map[string]string{}
+	for q, ids := range byQueue {
+		for i, res := range q.DeferMany(ids) {
+			switch {
+			case errors.Is(res.Err, ErrDefersExhausted):
+				atomic.AddInt64(&stats.DeferCount, 1)
+				results[ids[i]] = "removed"
+			case res.Err != nil:
+				results[ids[i]] = "not_found"
+			case res.AlreadyDeferred:
+				results[ids[i]] = "already_deferred"
+			default:
+				atomic.AddInt64(&stats.DeferCount, 1)
+				results[ids[i]] = "deferred"
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"results": results,
+	})
+}
+
+// handleSkip gives up on a served item which the annotator can't answer. The
+// Collect waiting on it fails with FailedPrecondition, and the next item is
+// served.
+func (s *server) handleSkip(w http.ResponseWriter, r *http.Request) {
+	u := r.PathValue("uuid")
+	if u == "" {
+		writeJSONError(w, http.StatusBadRequest,
+			"missing uuid parameter")
+		return
+	}
+
+	item, last, ok := s.release(u)
+	if !ok {
+		writeJSONError(w, http.StatusNotFound,
+			"pending request not found",
+			fmt.Sprintf("uuid: %s", u))
+		return
+	}
+
+	// a race item is only given up on once every annotator has skipped it
+	if last && item.Cancel != nil {
+		item.Cancel(errSkippedByAnnotator)
+	}
+	s.events.Append(EventSkip, u)
+	atomic.AddInt64(&stats.SkipCount, 1)
+
+	s.handleData(w, r)
+}
+
+// handlePin moves a queued item ahead of everything not already pinned, so that
+// it's labeled next. Items which have already been served can't be pinned.
+func (s *server) handlePin(w http.ResponseWriter, r *http.Request) {
+	u := r.PathValue("uuid")
+	if u == "" {
+		writeJSONError(w, http.StatusBadRequest,
+			"missing uuid parameter")
+		return
+	}
+
+	if err := s.findQueue(u).Pin(u); err != nil {
+		writeJSONError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"status":"pinned"}`))
+}
+
 func (s *server) handleQueueStatus(w http.ResponseWriter, r *http.Request) {
-	status := s.queue.Status()
+	q, ok := s.requestQueue(w, r)
+	if !ok {
+		return
+	}
+	status := q.DetailedStatus()
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(status)
 }
 
+// handleQueueSummaries returns a compact summary of each active item, so list
+// views don't need to fetch every item's data.
+func (s *server) handleQueueSummaries(w http.ResponseWriter, r *http.Request) {
+	q, ok := s.requestQueue(w, r)
+	if !ok {
+		return
+	}
+
+	summaries := []itemSummary{}
+	for _, item := range q.Active() {
+		summaries = append(summaries, summarizeItem(item))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summaries)
+}
+
+// handlePreview serves the preview image of one input of an item, so that
+// list and history views can show a thumbnail without rendering its data. The
+// path ends in {i}.png, though the content type follows the image itself.
+func (s *server) handlePreview(w http.ResponseWriter, r *http.Request) {
+	u := r.PathValue("uuid")
+	name, ok := strings.CutSuffix(r.PathValue("file"), ".png")
+	i, err := strconv.Atoi(name)
+	if !ok || err != nil || i < 0 {
+		writeJSONError(w, http.StatusBadRequest,
+			"invalid preview path",
+			fmt.Sprintf("expected /data/%s/preview/{i}.png", u))
+		return
+	}
+
+	req, ok := s.findRequest(u)
+	if !ok {
+		writeJSONError(w, http.StatusNotFound,
+			"request not found",
+			fmt.Sprintf("uuid: %s", u))
+		return
+	}
+
+	inputs := req.GetInputs()
+	if i >= len(inputs) || len(inputs[i].GetPreview()) == 0 {
+		writeJSONError(w, http.StatusNotFound,
+			"no preview for input",
+			fmt.Sprintf("uuid: %s, input: %d", u, i))
+		return
+	}
+
+	b := inputs[i].GetPreview()
+	w.Header().Set("Content-Type", http.DetectContentType(b))
+	w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+	w.Write(b)
+}
+
+func (s *server) handleQueueLog(w http.ResponseWriter, r *http.Request) {
+	events := s.events.Events(r.URL.Query().Get("uuid"))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events)
+}
+
 func (s *server) handleMetrics(w http.ResponseWriter, r *http.Request) {
 	stats := getStats()
-	queueStatus := s.queue.Status()
+	queueStatus := s.queue.DetailedStatus()
+	unique, submits, capped := s.annotators.snapshot()
+
+	repeated := []string{}
+	for _, q := range s.allQueues() {
+		repeated = append(repeated, q.RepeatedlyDeferred(2)...)
+	}
+	queues := map[string]QueueStatus{}
+	for name, q := range s.namedQueues() {
+		queues[name] = q.DetailedStatus()
+	}
 	
 	metrics := map[string]interface{}{
 		"queue": queueStatus,
+		"queues": queues,
 		"errors": map[string]int64{
 			"validation": stats.ValidationErrors,
 			"timeout": stats.TimeoutErrors,
 			"internal": stats.InternalErrors,
 			"resource_exhausted": stats.ResourceExhausted,
 		},
-		"total_requests": stats.TotalRequests,
+		"rejections": map[string]int64{
+			rejectQueueFull:   stats.QueueFull,
+			rejectRateLimited: stats.RateLimited,
+			rejectTooLarge:    stats.TooLarge,
+		},
+		"actions": map[string]interface{}{
+			"defer":               stats.DeferCount,
+			"skip":                stats.SkipCount,
+			"abstain":             stats.AbstainCount,
+			"repeatedly_deferred": repeated,
+		},
+		"annotators": map[string]interface{}{
+			"unique":  unique,
+			"submits": submits,
+			"capped":  capped,
+		},
+		"http":                s.httpStats.snapshot(),
+		"in_flight":           stats.InFlight,
+		"short_deadlines":     stats.ShortDeadlines,
+		"total_requests":      stats.TotalRequests,
+		"answered_per_minute": answered.perMinute(time.Now()),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(metrics)
 }
 
+// handleMetricsTimeseries returns per-interval deltas of the request, error,
+// defer, and skip counters over the ?window= (default 1h).
+func (s *server) handleMetricsTimeseries(w http.ResponseWriter, r *http.Request) {
+	window := time.Hour
+	if v := r.URL.Query().Get("window"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			writeJSONError(w, http.StatusBadRequest,
+				"invalid window parameter",
+				fmt.Sprintf("window must be a positive duration like 1h (got %q)", v))
+			return
+		}
+		window = d
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.metrics.deltas(time.Now(), window))
+}
+
 func (s *server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	health := map[string]interface{}{
 		"status": "healthy",
 		"timestamp": time.Now().UTC().Format(time.RFC3339),
 		"queue_total": s.queue.Status().Total,
+		"paused": s.queue.Paused(),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(health)
 }
 
+// handleConfig tells the frontend where the API is mounted, so that it works
+// behind a reverse proxy which serves it under BasePath.
+func (s *server) handleConfig(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"base_path": config.BasePath,
+	})
+}
+
+// handleHealthz is the liveness check: if it answers at all, the process is
+// up.
+func (s *server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"status":"ok"}`))
+}
+
+// handleReadyz is the readiness check. It returns 503 unless the warmup has
+// passed, the gRPC listener is bound, the queue has room, and serving isn't
+// paused, so that an orchestrator stops routing traffic to this instance until
+// it can take it.
+func (s *server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]bool{
+		"warmed_up":      time.Since(s.startedAt) >= s.warmup,
+		"grpc_listening": s.listening.Load(),
+		"queue_has_room": s.queue.Status().Total < config.MaxPendingRequests,
+		"not_paused":     !s.queue.Paused(),
+	}
+
+	ready := true
+	for _, ok := range checks {
+		ready = ready && ok
+	}
+
+	status, code := "ready", http.StatusOK
+	if !ready {
+		status, code = "not_ready", http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": status,
+		"checks": checks,
+	})
+}
+
+func (s *server) handlePause(w http.ResponseWriter, r *http.Request) {
+	for _, q := range s.allQueues() {
+		q.Pause()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"status":"paused"}`))
+}
+
+func (s *server) handleDebugErrors(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(recentErrors.Events())
+}
+
+// handleReload re-reads the config from the environment and applies the
+// validation limits to new requests. Other settings still need a restart.
+func (s *server) handleReload(w http.ResponseWriter, r *http.Request) {
+	cfg := loadConfig()
+	reloadLimits(cfg)
+	slog.Info("reloaded validation limits",
+		"max_inputs_per_request", cfg.MaxInputsPerRequest,
+		"max_vector_magnitude", cfg.MaxVectorMagnitude)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":                 "reloaded",
+		"max_inputs_per_request": cfg.MaxInputsPerRequest,
+		"max_vector_magnitude":   cfg.MaxVectorMagnitude,
+	})
+}
+
+// handleAdminConfig changes settings of the running server. Currently that's only
+// data_timeout, the default /data.json long-poll, which must be positive and no
+// longer than MAX_DATA_TIMEOUT. It's not persisted, so a restart reverts it.
+func (s *server) handleAdminConfig(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		DataTimeout *string `json:"data_timeout"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid config format", err.Error())
+		return
+	}
+
+	if body.DataTimeout != nil {
+		t, err := time.ParseDuration(*body.DataTimeout)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid data_timeout", err.Error())
+			return
+		}
+		if t <= 0 {
+			writeJSONError(w, http.StatusBadRequest, "invalid data_timeout",
+				fmt.Sprintf("must be positive, got %s", t))
+			return
+		}
+		if s.maxTimeout > 0 && t > s.maxTimeout {
+			writeJSONError(w, http.StatusBadRequest, "invalid data_timeout",
+				fmt.Sprintf("must be at most %s, got %s", s.maxTimeout, t))
+			return
+		}
+
+		s.timeout.Store(int64(t))
+		slog.Info("updated data timeout", "data_timeout", t)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":       "updated",
+		"data_timeout": time.Duration(s.timeout.Load()).String(),
+	})
+}
+
+func (s *server) handleResume(w http.ResponseWriter, r *http.Request) {
+	for _, q := range s.allQueues() {
+		q.Resume()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"status":"resumed"}`))
+}
+
+// handleClear removes every queued item. The replicas of a race item which
+// annotators already hold are dropped from current too, under cmu so that
+// nobody can claim one in between, since their response channel is closed.
+func (s *server) handleClear(w http.ResponseWriter, r *http.Request) {
+	n := 0
+	s.cmu.Lock()
+	for _, q := range s.allQueues() {
+		ids := q.Clear()
+		for _, id := range ids {
+			if item, ok := s.current[id]; ok {
+				item.holders = 0
+				delete(s.current, id)
+			}
+		}
+		n += len(ids)
+	}
+	s.cmu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  "cleared",
+		"removed": n,
+	})
+}
+
+// handleReplay enqueues JSONL requests to be answered again, e.g. to train
+// annotators or measure agreement. Each line is either a Request or a history
+// entry (as served by /admin/history), in which case its request is used.
+// Nobody is waiting for the answers, which are only recorded in the history.
+func (s *server) handleReplay(w http.ResponseWriter, r *http.Request) {
+	var reqs []*pb.Request
+
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxReplayLineSize)
+	for line := 1; scanner.Scan(); line++ {
+		b := bytes.TrimSpace(scanner.Bytes())
+		if len(b) == 0 {
+			continue
+		}
+
+		var entry struct {
+			Request json.RawMessage `json:"request"`
+		}
+		if json.Unmarshal(b, &entry) == nil && entry.Request != nil {
+			b = entry.Request
+		}
+
+		req := &pb.Request{}
+		if err := protojson.Unmarshal(b, req); err != nil {
+			writeJSONError(w, http.StatusBadRequest,
+				"invalid request format",
+				fmt.Sprintf("line %d: %v", line, err))
+			return
+		}
+		if err := validate(req, s.validation); err != nil {
+			writeJSONError(w, http.StatusBadRequest,
+				"invalid request",
+				fmt.Sprintf("line %d: %v", line, err))
+			return
+		}
+		reqs = append(reqs, req)
+	}
+	if err := scanner.Err(); err != nil {
+		writeJSONError(w, http.StatusBadRequest,
+			"failed to read request body",
+			err.Error())
+		return
+	}
+
+	// each sub-queue must have room for everything replayed into it
+	counts := map[string]int{}
+	for _, req := range reqs {
+		counts[req.QueueName]++
+	}
+	queues := map[string]*Queue{}
+	for name, n := range counts {
+		q, err := s.queueFor(name)
+		if err != nil || q.Status().Total+n > s.maxPendingFor(name) {
+			writeJSONError(w, http.StatusServiceUnavailable,
+				"queue full",
+				fmt.Sprintf("cannot replay %d requests", len(reqs)))
+			return
+		}
+		queues[name] = q
+	}
+
+	ids := []string{}
+	batches := map[string][]*QueueItem{}
+	for _, req := range reqs {
+		id := uuid.NewString()
+		batches[req.QueueName] = append(batches[req.QueueName], &QueueItem{
+			ID:           id,
+			Request:      req,
+			AddedAt:      time.Now(),
+			Replay:       true,
+			Permutations: shufflePermutations(req, id),
+		})
+		ids = append(ids, id)
+	}
+	for name, items := range batches {
+		if err := queues[name].EnqueueBatch(items); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "enqueued",
+		"uuids":  ids,
+	})
+}
+
+// historyFlushEvery is how many entries handleHistory writes between flushes.
+const historyFlushEvery = 100
+
+// handleHistory streams the history, oldest first, as a JSON array (the
+// default), JSONL (?format=jsonl), or CSV (?format=csv). Entries are written
+// and flushed as they're read, so memory stays bounded however big the
+// history is, and clients can start consuming straight away.
+func (s *server) handleHistory(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	format := r.URL.Query().Get("format")
+
+	var write func(HistoryEntry) error
+	flush := func() error {
+		rc.Flush()
+		return nil
+	}
+	done := func() error { return nil }
+
+	switch format {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		sep := "["
+		write = func(e HistoryEntry) error {
+			if _, err := io.WriteString(w, sep); err != nil {
+				return err
+			}
+			sep = ","
+			return enc.Encode(e)
+		}
+		done = func() error {
+			if sep == "[" {
+				_, err := io.WriteString(w, "[]\n")
+				return err
+			}
+			_, err := io.WriteString(w, "]\n")
+			return err
+		}
+
+	case "jsonl":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		enc := json.NewEncoder(w)
+		write = func(e HistoryEntry) error { return enc.Encode(e) }
+
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"uuid", "time", "queue", "replay", "title", "prompt", "request", "response"})
+		write = func(e HistoryEntry) error {
+			req, err := protojson.Marshal(e.Request)
+			if err != nil {
+				return err
+			}
+			res, err := protojson.Marshal(e.Response)
+			if err != nil {
+				return err
+			}
+			return cw.Write([]string{
+				e.UUID,
+				e.Time.Format(time.RFC3339Nano),
+				e.Request.GetQueueName(),
+				strconv.FormatBool(e.Replay),
+				e.Request.GetTitle(),
+				e.Request.GetPrompt(),
+				string(req),
+				string(res),
+			})
+		}
+		flush = func() error {
+			cw.Flush()
+			rc.Flush()
+			return cw.Error()
+		}
+		done = flush
+
+	default:
+		writeJSONError(w, http.StatusBadRequest,
+			"invalid format",
+			fmt.Sprintf("expected json, jsonl, or csv; got %q", format))
+		return
+	}
+
+	n := 0
+	err := s.history.Each(func(e HistoryEntry) error {
+		if err := write(e); err != nil {
+			return err
+		}
+		if n++; n%historyFlushEvery == 0 {
+			return flush()
+		}
+		return nil
+	})
+	if err == nil {
+		err = done()
+	}
+	if err != nil {
+		// the status has already been sent, so all we can do is stop
+		slog.Warn("failed to stream history", "format", format, "error", err)
+		return
+	}
+	rc.Flush()
+}
+
+//go:embed static
+var staticFS embed.FS
+
+// frontendHandler serves the built frontend from dir. If dir doesn't exist, it
+// serves a placeholder page instead, so the API remains usable.
+func frontendHandler(dir string) http.Handler {
+	if info, err := os.Stat(dir); err == nil && info.IsDir() {
+		return http.FileServer(http.Dir(dir))
+	}
+
+	slog.Warn("frontend directory not found, serving placeholder", "dir", dir)
+	sub, err := fs.Sub(staticFS, "static")
+	if err != nil {
+		panic(err)
+	}
+	return http.FileServer(http.FS(sub))
+}
+
 func (s *server) ServeHTTP() http.Handler {
 	mux := http.NewServeMux()
-	fs := http.FileServer(http.Dir("./frontend/dist"))
 
-	mux.Handle("/", fs)
+	mux.Handle("/", frontendHandler(config.FrontendDir))
+	mux.HandleFunc("GET /config.json", s.handleConfig)
 	mux.HandleFunc("/data.json", s.handleData)
+	mux.HandleFunc("/data.pb", s.handleData)
+	mux.HandleFunc("GET /data/batch", s.handleDataBatch)
+	mux.HandleFunc("GET /data/{uuid}/preview/{file}", s.handlePreview)
 	mux.HandleFunc("POST /submit/{uuid}", s.handleSubmit)
+	mux.HandleFunc("POST /defer/batch", requireAPIKey(s.handleDeferBatch))
 	mux.HandleFunc("POST /defer/{uuid}", s.handleDefer)
+	mux.HandleFunc("POST /skip/{uuid}", s.handleSkip)
+	mux.HandleFunc("POST /pin/{uuid}", requireAPIKey(s.handlePin))
 	mux.HandleFunc("GET /queue/status", s.handleQueueStatus)
+	mux.HandleFunc("GET /queue/summaries", s.handleQueueSummaries)
+	mux.HandleFunc("GET /queue/log", s.handleQueueLog)
 	mux.HandleFunc("GET /metrics", s.handleMetrics)
+	mux.HandleFunc("GET /metrics/timeseries", s.handleMetricsTimeseries)
 	mux.HandleFunc("GET /health", s.handleHealth)
+	mux.HandleFunc("GET /healthz", s.handleHealthz)
+	mux.HandleFunc("GET /readyz", s.handleReadyz)
+	mux.HandleFunc("POST /admin/pause", requireAPIKey(s.handlePause))
+	mux.HandleFunc("POST /admin/resume", requireAPIKey(s.handleResume))
+	mux.HandleFunc("POST /admin/clear", requireAPIKey(s.handleClear))
+	mux.HandleFunc("POST /admin/reload", requireAPIKey(s.handleReload))
+	mux.HandleFunc("POST /admin/config", requireAPIKey(s.handleAdminConfig))
+	mux.HandleFunc("POST /admin/replay", requireAPIKey(s.handleReplay))
+	mux.HandleFunc("GET /admin/history", requireAPIKey(s.handleHistory))
+	mux.HandleFunc("GET /debug/errors", requireAPIKey(s.handleDebugErrors))
+
+	// everything is under BasePath, and nothing is served outside of it
+	h := withHTTPMetrics(s.httpStats, mux)
+	if config.BasePath != "" {
+		root := http.NewServeMux()
+		root.Handle(config.BasePath+"/", http.StripPrefix(config.BasePath, h))
+		h = root
+	}
 
-	return mux
+	return withCORS(config.AllowedOrigins, withGzip(h))
 }
\ No newline at end of file
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	pb "github.com/adammck/collector/proto/gen"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// HistoryEntry is a completed item: the request and the answer it was given.
+type HistoryEntry struct {
+	UUID      string
+	Request   *pb.Request
+	Response  *pb.Response
+	Replay    bool
+	Annotator string
+	Time      time.Time
+}
+
+// MarshalJSON uses protojson for the request and response, so that entries
+// can be fed back to /admin/replay.
+func (e HistoryEntry) MarshalJSON() ([]byte, error) {
+	req, err := protojson.Marshal(e.Request)
+	if err != nil {
+		return nil, err
+	}
+	res, err := protojson.Marshal(e.Response)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(struct {
+		UUID      string          `json:"uuid"`
+		Request   json.RawMessage `json:"request"`
+		Response  json.RawMessage `json:"response"`
+		Replay    bool            `json:"replay,omitempty"`
+		Annotator string          `json:"annotator,omitempty"`
+		Time      time.Time       `json:"time"`
+	}{e.UUID, req, res, e.Replay, e.Annotator, e.Time})
+}
+
+// History is a bounded log of completed items, oldest first. Once full, the
+// oldest entries are overwritten. A nil *History discards everything.
+type History struct {
+	mu      sync.Mutex
+	entries []HistoryEntry
+	next    int
+	full    bool
+
+	// appended counts every entry ever appended, so that Each can tell which
+	// entries have been overwritten since it started.
+	appended int
+}
+
+func NewHistory(size int) *History {
+	if size <= 0 {
+		return nil
+	}
+
+	return &History{
+		entries: make([]HistoryEntry, size),
+	}
+}
+
+func (h *History) Append(e HistoryEntry) {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.entries[h.next] = e
+	h.appended++
+	h.next = (h.next + 1) % len(h.entries)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// Entries returns the retained entries in the order they were appended.
+func (h *History) Entries() []HistoryEntry {
+	out := []HistoryEntry{}
+	if h == nil {
+		return out
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	start, n := 0, h.next
+	if h.full {
+		start, n = h.next, len(h.entries)
+	}
+
+	for i := 0; i < n; i++ {
+		out = append(out, h.entries[(start+i)%len(h.entries)])
+	}
+
+	return out
+}
+
+// Each calls fn with each entry retained when it's called, oldest first,
+// stopping at the first error. The lock is only held to read each entry, not
+// while fn runs, so a slow fn doesn't block Append. Entries appended after Each
+// starts are not included, and any overwritten before fn gets to them are
+// skipped.
+func (h *History) Each(fn func(HistoryEntry) error) error {
+	if h == nil {
+		return nil
+	}
+
+	h.mu.Lock()
+	from, to := h.appended-len(h.entries), h.appended
+	if from < 0 {
+		from = 0
+	}
+	h.mu.Unlock()
+
+	for seq := from; seq < to; seq++ {
+		h.mu.Lock()
+		overwritten := seq < h.appended-len(h.entries)
+		e := h.entries[seq%len(h.entries)]
+		h.mu.Unlock()
+
+		if overwritten {
+			continue
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	pb "github.com/adammck/collector/proto/gen"
+	"google.golang.org/protobuf/proto"
+)
+
+// autoHotkeys are assigned in order to the options of a list with
+// auto_hotkeys. Any options past the last one get no hotkey.
+const autoHotkeys = "123456789abcdefghijklmnopqrstuvwxyz"
+
+// needsHotkeys returns true if the option list asked for its hotkeys to be
+// assigned, and left them all empty.
+func needsHotkeys(ol *pb.OptionListSchema) bool {
+	if !ol.GetAutoHotkeys() {
+		return false
+	}
+	for _, opt := range ol.GetOptions() {
+		if opt.GetHotkey() != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// withHotkeys returns a copy of the request with hotkeys assigned to the
+// options of any list which needs them, in the order they're served. That
+// depends only on the request and the item's shuffle, so the same item always
+// gets the same hotkeys. The original is never modified; if nothing needs
+// hotkeys, it's returned as-is.
+func withHotkeys(req *pb.Request) *pb.Request {
+	var out *pb.Request
+	for i, schema := range outputSchemas(req) {
+		if !needsHotkeys(schema.GetOptionList()) {
+			continue
+		}
+		if out == nil {
+			out = proto.Clone(req).(*pb.Request)
+		}
+
+		for j, opt := range outputSchemas(out)[i].GetOptionList().GetOptions() {
+			if j < len(autoHotkeys) {
+				opt.Hotkey = autoHotkeys[j : j+1]
+			}
+		}
+	}
+
+	if out == nil {
+		return req
+	}
+	return out
+}
@@ -2,20 +2,24 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	pb "github.com/adammck/collector/proto/gen"
-	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
 )
 
 var (
@@ -30,23 +34,576 @@ type server struct {
 	current map[string]*QueueItem
 	cmu     sync.RWMutex
 
-	timeout time.Duration
+	// queues holds the named sub-queues, created on demand by queueFor, so
+	// that one team's flood doesn't hold up another's. queue is the default,
+	// unnamed one. newQueue makes a queue configured like the default.
+	queues      map[string]*Queue
+	qmu         sync.Mutex
+	newQueue    func() *Queue
+	queueLimits map[string]int
+
+	// resolved holds the uuids of race items which have been answered, and
+	// when, so that the losing annotators get a 410 rather than a 404.
+	// Guarded by cmu.
+	resolved map[string]time.Time
+	events  *EventLog
+	history *History
+	metrics *metricsSeries
+
+	// annotators counts submits by X-Annotator-ID.
+	annotators *annotatorCounts
+
+	// httpStats counts requests per route, for /metrics.
+	httpStats *httpMetrics
+
+	// timeout is the default /data.json long-poll, as a time.Duration. It's
+	// atomic because /admin/config can change it while requests are waiting.
+	timeout    atomic.Int64
+	maxTimeout time.Duration
+
+	// currentTimeout is how long an annotator may hold an item before it's
+	// put back in the queue for someone else. Zero disables requeueing.
+	currentTimeout time.Duration
+
+	// maxTimeSeriesPoints is the longest time series served to the frontend;
+	// longer ones are downsampled.
+	maxTimeSeriesPoints int
+
+	// maxSubmitBytes caps the size of a submit body. Zero means no limit.
+	maxSubmitBytes int64
+
+	// maxPrefetch is the most items /data/batch will serve at once.
+	maxPrefetch int
+
+	// dataWaiters counts the data requests currently blocked waiting for an
+	// item, each holding a goroutine, which is capped at maxDataWaiters.
+	// Zero means no limit.
+	dataWaiters    atomic.Int64
+	maxDataWaiters int
+
+	// echoRequest attaches the request to each response's meta, unless it's
+	// larger than echoRequestMaxBytes (if that's positive). The hash is
+	// attached either way.
+	echoRequest         bool
+	echoRequestMaxBytes int
+
+	// submitAckTimeout is how long the Collect waiting on an item has to take
+	// a submitted answer before a warning is logged. Zero disables it.
+	submitAckTimeout time.Duration
+
+	// maxCollectWait bounds every Collect, including those whose client set
+	// no deadline, so that none hold a queue slot forever. Zero disables it.
+	maxCollectWait time.Duration
+
+	// autoAnswerFraction is the fraction of a Collect's deadline after which
+	// its default_response, if any, is returned. Zero disables it.
+	autoAnswerFraction float64
+
+	validation validationMode
+
+	// served counts items handed out by handleData, for queue_position.
+	served int64
+
+	// listening is true while the gRPC listener is bound, and no longer
+	// once shutdown has begun. It's part of readiness.
+	listening atomic.Bool
+
+	// readiness also waits until warmup has passed since startedAt, so that
+	// orchestrators don't route to a process which is still starting up.
+	startedAt time.Time
+	warmup    time.Duration
 }
 
 func newServer(cfg *Config) *server {
-	return &server{
-		queue:   NewQueue(),
-		current: make(map[string]*QueueItem),
-		timeout: cfg.HTTPTimeout,
+	events := NewEventLog(cfg.EventLogSize)
+	newQueue := func() *Queue {
+		q := NewQueue()
+		if cfg.QueueMode != "" {
+			if err := q.SetMode(cfg.QueueMode); err != nil {
+				log.Printf("ignoring queue mode: %v", err)
+			}
+		}
+		q.events = events
+		q.agingThreshold = cfg.AgingThreshold
+		q.maxDefers = cfg.MaxDefers
+		return q
+	}
+
+	// the webhook is about the default queue; sub-queues have their own
+	// limits, so their totals aren't comparable
+	q := newQueue()
+	q.notifier = newNotifier(cfg.WebhookURL, cfg.MaxPendingRequests, cfg.WebhookThreshold)
+
+	mode := validationStrict
+	if !cfg.StrictValidation {
+		mode = validationLenient
+	}
+
+	s := &server{
+		queue:       q,
+		queues:      make(map[string]*Queue),
+		newQueue:    newQueue,
+		queueLimits: cfg.QueueLimits,
+		current:     make(map[string]*QueueItem),
+		resolved:   make(map[string]time.Time),
+		events:     events,
+		history:    NewHistory(cfg.HistorySize),
+		metrics:    newMetricsSeries(metricsSeriesSize(cfg.MetricsSampleInterval)),
+		annotators: newAnnotatorCounts(maxTrackedAnnotators),
+		httpStats:  newHTTPMetrics(),
+		maxTimeout: cfg.MaxDataTimeout,
+
+		currentTimeout:      cfg.CurrentItemTimeout,
+		maxTimeSeriesPoints: cfg.MaxTimeSeriesPoints,
+		maxSubmitBytes:      cfg.MaxSubmitBytes,
+		maxPrefetch:         cfg.MaxPrefetch,
+		maxDataWaiters:      cfg.MaxDataWaiters,
+		echoRequest:         cfg.EchoRequest,
+		echoRequestMaxBytes: cfg.EchoRequestMaxBytes,
+		maxCollectWait:      cfg.MaxCollectWait,
+		submitAckTimeout:    cfg.SubmitAckTimeout,
+		autoAnswerFraction:  cfg.AutoAnswerFraction,
+		validation:          mode,
+		startedAt:           time.Now(),
+		warmup:              cfg.WarmupDuration,
+	}
+	s.timeout.Store(int64(cfg.HTTPTimeout))
+
+	return s
+}
+
+// maxQueues bounds the number of named sub-queues, since their names come
+// from clients.
+const maxQueues = 100
+
+var errTooManyQueues = errors.New("too many queues")
+
+// queueFor returns the sub-queue with the given name, creating it if need be.
+// The empty name is the default queue.
+func (s *server) queueFor(name string) (*Queue, error) {
+	if name == "" {
+		return s.queue, nil
+	}
+
+	s.qmu.Lock()
+	defer s.qmu.Unlock()
+
+	if q, ok := s.queues[name]; ok {
+		return q, nil
+	}
+	if len(s.queues) >= maxQueues {
+		return nil, errTooManyQueues
+	}
+
+	q := s.newQueue()
+	s.queues[name] = q
+	return q, nil
+}
+
+// queueOf returns the sub-queue which item belongs to. It was created when the
+// item was first enqueued, so this never fails.
+func (s *server) queueOf(item *QueueItem) *Queue {
+	if q, err := s.queueFor(item.Request.GetQueueName()); err == nil {
+		return q
 	}
+	return s.queue
 }
 
+// namedQueues returns a copy of the named sub-queues.
+func (s *server) namedQueues() map[string]*Queue {
+	s.qmu.Lock()
+	defer s.qmu.Unlock()
 
+	out := make(map[string]*Queue, len(s.queues))
+	for name, q := range s.queues {
+		out[name] = q
+	}
+	return out
+}
 
+// allQueues returns the default queue followed by the named sub-queues.
+func (s *server) allQueues() []*Queue {
+	out := []*Queue{s.queue}
+	for _, q := range s.namedQueues() {
+		out = append(out, q)
+	}
+	return out
+}
 
+// findQueue returns the queue holding the item with the given id, or the
+// default queue if none does.
+func (s *server) findQueue(id string) *Queue {
+	for _, q := range s.namedQueues() {
+		if q.Contains(id) {
+			return q
+		}
+	}
+	return s.queue
+}
 
+// errUUIDInUse is returned by enqueueUnique when another item has the id.
+var errUUIDInUse = errors.New("uuid already in use")
+
+// enqueueUnique adds item to q, unless an item with the same id is being
+// answered, waiting in any queue, or a race which was answered recently (whose
+// replicas serve refuses). Queues only check their own items, so a uuid
+// chosen by the client could otherwise be pending twice, and the two Collects
+// would get each other's answers. Everything is done under cmu, so that two
+// Collects choosing the same uuid for different sub-queues can't both pass.
+func (s *server) enqueueUnique(q *Queue, item *QueueItem) error {
+	s.cmu.Lock()
+	defer s.cmu.Unlock()
+
+	if _, ok := s.current[item.ID]; ok {
+		return errUUIDInUse
+	}
+	if _, ok := s.resolved[item.ID]; ok {
+		return errUUIDInUse
+	}
+	for _, other := range s.allQueues() {
+		if other.Contains(item.ID) {
+			return errUUIDInUse
+		}
+	}
 
+	return q.Enqueue(item)
+}
 
+// maxPendingFor returns the most items the named queue may hold.
+func (s *server) maxPendingFor(name string) int {
+	if max, ok := s.queueLimits[name]; ok && name != "" {
+		return max
+	}
+	return config.MaxPendingRequests
+}
+
+// currentSweepInterval is how often sweepCurrent runs.
+const currentSweepInterval = 10 * time.Second
+
+// resolvedRetention is how long the uuid of an answered race item is kept, to
+// reject the answers which lost.
+const resolvedRetention = 10 * time.Minute
+
+// sweepCurrent removes items from current whose client has gone away, and
+// puts items held by an annotator for longer than currentTimeout back in the
+// queue. Without this, an item whose page was closed would sit in current
+// forever.
+func (s *server) sweepCurrent(now time.Time) (expired, requeued int) {
+	var abandoned []*QueueItem
+
+	s.cmu.Lock()
+	for id, item := range s.current {
+		if item.Context != nil && item.Context.Err() != nil {
+			delete(s.current, id)
+			item.holders = 0
+			expired++
+			continue
+		}
+		if s.currentTimeout > 0 && now.Sub(item.ServedAt) > s.currentTimeout {
+			delete(s.current, id)
+			item.holders = 0
+			abandoned = append(abandoned, item)
+		}
+	}
+	for id, t := range s.resolved {
+		if now.Sub(t) > resolvedRetention {
+			delete(s.resolved, id)
+		}
+	}
+	s.cmu.Unlock()
+
+	for _, item := range abandoned {
+		if err := s.queueOf(item).Requeue(item); err != nil {
+			log.Printf("failed to requeue abandoned item: %v", err)
+			continue
+		}
+		requeued++
+	}
+
+	return expired, requeued
+}
+
+// runMetricsSampler snapshots the counters into s.metrics every interval until
+// ctx is done.
+func (s *server) runMetricsSampler(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	s.metrics.sample(time.Now(), getStats())
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			s.metrics.sample(now, getStats())
+		}
+	}
+}
+
+// runSweeper calls sweepCurrent every interval until ctx is done.
+func (s *server) runSweeper(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			if expired, requeued := s.sweepCurrent(now); expired > 0 || requeued > 0 {
+				log.Printf("swept current items: %d expired, %d requeued", expired, requeued)
+			}
+		}
+	}
+}
+
+
+
+
+
+
+
+
+// metricsSeriesSize is the number of samples taken at interval which covers
+// metricsRetention, or zero if sampling is disabled.
+func metricsSeriesSize(interval time.Duration) int {
+	if interval <= 0 {
+		return 0
+	}
+	return int(metricsRetention/interval) + 1
+}
+
+// serve records that the item has been handed to an annotator, so that it can
+// be answered via its uuid. A race item goes back to the front of the queue
+// until RaceReplicas annotators hold it at once. It returns false, and does
+// nothing, if the item is a race replica which was answered after it was
+// dequeued; the caller must not show it.
+func (s *server) serve(item *QueueItem) bool {
+	s.cmu.Lock()
+	if _, ok := s.resolved[item.ID]; ok {
+		s.cmu.Unlock()
+		return false
+	}
+	item.ServedAt = time.Now()
+	s.current[item.ID] = item
+	item.holders++
+	holders := item.holders
+	s.cmu.Unlock()
+
+	slog.Info("item served", "uuid", item.ID, "holders", holders)
+
+	if holders < int(item.Request.GetRaceReplicas()) {
+		if err := s.queueOf(item).Requeue(item); err != nil {
+			slog.Warn("failed to requeue race item", "uuid", item.ID, "error", err)
+		}
+	}
+	return true
+}
+
+// restore puts a claimed item back in current after its answer was rejected,
+// so that it can be answered again, undoing everything claim did to end a
+// race.
+func (s *server) restore(item *QueueItem) {
+	s.cmu.Lock()
+	defer s.cmu.Unlock()
+
+	item.ServedAt = time.Now()
+	s.current[item.ID] = item
+	delete(s.resolved, item.ID)
+
+	if item.replicaQueued {
+		item.replicaQueued = false
+		if err := s.queueOf(item).Requeue(item); err != nil {
+			slog.Warn("failed to requeue race item", "uuid", item.ID, "error", err)
+		}
+	}
+}
+
+// claim removes the item with the given uuid from current, so that only one
+// caller can answer it, even if several annotators hold it. A race is over as
+// soon as it's claimed: the id is marked resolved and any replica still
+// queued is taken out, before anything is delivered, so that no replica can
+// be served (and answered) again. If the answer is rejected, the caller must
+// restore it.
+func (s *server) claim(id string) (*QueueItem, bool) {
+	s.cmu.Lock()
+	defer s.cmu.Unlock()
+
+	item, ok := s.current[id]
+	if !ok {
+		return nil, false
+	}
+
+	delete(s.current, id)
+	if item.Request.GetRaceReplicas() > 1 {
+		s.resolved[id] = time.Now()
+		_, item.replicaQueued = s.queueOf(item).Take(id)
+	}
+	return item, true
+}
+
+// release drops one annotator's hold on a served item, when they defer or skip
+// it or go away. The item stays in current while other annotators of a race
+// still hold it; last is true once it has been removed.
+func (s *server) release(id string) (item *QueueItem, last, ok bool) {
+	s.cmu.Lock()
+	defer s.cmu.Unlock()
+
+	item, ok = s.current[id]
+	if !ok {
+		return nil, false, false
+	}
+
+	if item.holders > 1 {
+		item.holders--
+		return item, false, true
+	}
+
+	item.holders = 0
+	delete(s.current, id)
+	return item, true, true
+}
+
+// findRequest returns the request of the item with the given id, wherever it
+// is: being answered, waiting in a queue, or already in the history.
+func (s *server) findRequest(id string) (*pb.Request, bool) {
+	s.cmu.RLock()
+	item, ok := s.current[id]
+	s.cmu.RUnlock()
+	if ok {
+		return item.Request, true
+	}
+
+	for _, q := range s.allQueues() {
+		if item, ok := q.Get(id); ok {
+			return item.Request, true
+		}
+	}
+
+	var req *pb.Request
+	s.history.Each(func(e HistoryEntry) error {
+		if e.UUID == id {
+			req = e.Request
+		}
+		return nil
+	})
+	return req, req != nil
+}
+
+// wasResolved returns true if id is a race item which another annotator has
+// already answered.
+func (s *server) wasResolved(id string) bool {
+	s.cmu.RLock()
+	defer s.cmu.RUnlock()
+
+	_, ok := s.resolved[id]
+	return ok
+}
+
+// complete delivers the response to the Collect waiting on a claimed item, and
+// records it in the history, with an audit hash over the answer and who gave
+// it (annotator, which may be empty) and when. It never blocks: if the channel can't take the
+// response (nobody is left to read it), it returns false and the caller should
+// report the failure. Replayed items have no channel, so only go to history.
+func (s *server) complete(item *QueueItem, res *pb.Response, annotator string) bool {
+	now := time.Now()
+	meta := s.responseMeta(item)
+	meta.Annotator = annotator
+	meta.AnsweredAt = now.UnixNano()
+	if hash, err := hashAnnotation(item.Request, res, annotator, now); err == nil {
+		meta.AuditHash = hash
+	} else {
+		slog.Warn("failed to hash answer", "uuid", item.ID, "error", err)
+	}
+	res.Meta = meta
+
+	if !item.Replay {
+		select {
+		case item.Response <- res:
+		default:
+			return false
+		}
+		close(item.Response)
+		if item.Acked != nil && s.submitAckTimeout > 0 {
+			go s.watchAck(item)
+		}
+	}
+
+	s.history.Append(HistoryEntry{
+		UUID:      item.ID,
+		Request:   item.Request,
+		Response:  res,
+		Replay:    item.Replay,
+		Annotator: annotator,
+		Time:      now,
+	})
+	s.events.Append(EventSubmit, item.ID)
+	answered.record(time.Now())
+	if abstained(res) {
+		atomic.AddInt64(&stats.AbstainCount, 1)
+	}
+	slog.Info("item answered", "uuid", item.ID, "replay", item.Replay)
+	return true
+}
+
+// watchAck warns if the Collect waiting on item doesn't take its answer within
+// submitAckTimeout, e.g. because the client is gone or stuck, since the
+// annotator's work is then lost.
+func (s *server) watchAck(item *QueueItem) {
+	timer := time.NewTimer(s.submitAckTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-item.Acked:
+	case <-timer.C:
+		slog.Warn("answer not picked up by client", "uuid", item.ID, "timeout", s.submitAckTimeout)
+	}
+}
+
+// abstained returns true if any option list answer in res is -1, meaning none
+// of the options applied.
+func abstained(res *pb.Response) bool {
+	outputs := append([]*pb.Output{res.GetOutput()}, res.GetOutputs()...)
+	for _, out := range outputs {
+		if ol := out.GetOptionList(); ol != nil && ol.Index == -1 {
+			return true
+		}
+	}
+	return false
+}
+
+// responseMeta returns the meta attached to the answer for item.
+func (s *server) responseMeta(item *QueueItem) *pb.ResponseMeta {
+	meta := &pb.ResponseMeta{Uuid: item.ID}
+	if !s.echoRequest {
+		return meta
+	}
+
+	b, err := proto.MarshalOptions{Deterministic: true}.Marshal(item.Request)
+	if err != nil {
+		slog.Warn("failed to marshal request to echo", "uuid", item.ID, "error", err)
+		return meta
+	}
+
+	sum := sha256.Sum256(b)
+	meta.RequestHash = hex.EncodeToString(sum[:])
+	if s.echoRequestMaxBytes <= 0 || len(b) <= s.echoRequestMaxBytes {
+		meta.Request = item.Request
+	}
+	return meta
+}
+
+// take removes the item with the given uuid from wherever it is, either
+// waiting in the queue or being shown to an annotator, and returns it.
+func (s *server) take(id string) (*QueueItem, bool) {
+	for _, q := range s.allQueues() {
+		if item, ok := q.Take(id); ok {
+			return item, true
+		}
+	}
+
+	return s.claim(id)
+}
 
 func main() {
 	// support command line flags for backwards compatibility
@@ -65,8 +622,32 @@ func main() {
 		config.GRPCPort = *gp
 	}
 
+	// this also routes the log package through slog, so everything is in the
+	// same format
+	slog.SetDefault(newLogger(os.Stderr, config.LogFormat, config.LogLevel))
+	debugLog = newLogger(os.Stderr, config.LogFormat, slog.LevelDebug)
+	reloadLimits(config)
+
+	// before anything is counted, so the restored counts aren't overwritten
+	if config.MetricsStatePath != "" {
+		if err := loadStats(config.MetricsStatePath, stats); err != nil {
+			slog.Warn("failed to restore metrics state, starting from zero", "path", config.MetricsStatePath, "error", err)
+		}
+	}
+
 	s := newServer(config)
 
+	sweepCtx, stopSweep := context.WithCancel(context.Background())
+	defer stopSweep()
+	go s.runSweeper(sweepCtx, currentSweepInterval)
+	if config.MetricsSampleInterval > 0 {
+		go s.runMetricsSampler(sweepCtx, config.MetricsSampleInterval)
+	}
+
+	if config.MetricsStatePath != "" && config.MetricsStateInterval > 0 {
+		go runStatsSaver(sweepCtx, config.MetricsStatePath, config.MetricsStateInterval)
+	}
+
 	// Create HTTP server
 	httpAddr := fmt.Sprintf(":%d", config.HTTPPort)
 	httpSrv := &http.Server{
@@ -80,8 +661,8 @@ func main() {
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
-	grpcSrv := grpc.NewServer()
-	pb.RegisterCollectorServer(grpcSrv, &collectorServer{s: s})
+	grpcSrv := newGRPCServer(config, s)
+	s.listening.Store(true)
 
 	// Start servers
 	go func() {
@@ -98,12 +679,24 @@ func main() {
 		}
 	}()
 
+	// SIGHUP re-reads the validation limits from the environment
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			cfg := loadConfig()
+			reloadLimits(cfg)
+			log.Printf("reloaded validation limits (max inputs per request: %d, max vector magnitude: %g)", cfg.MaxInputsPerRequest, cfg.MaxVectorMagnitude)
+		}
+	}()
+
 	// Setup signal handling for graceful shutdown
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 
 	<-quit
 	log.Println("shutting down servers...")
+	s.listening.Store(false)
 
 	// Create shutdown context with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
@@ -117,6 +710,12 @@ func main() {
 	// Shutdown gRPC server gracefully
 	grpcSrv.GracefulStop()
 
+	if config.MetricsStatePath != "" {
+		if err := saveStats(config.MetricsStatePath, stats); err != nil {
+			log.Printf("failed to save metrics state: %v", err)
+		}
+	}
+
 	log.Println("servers stopped")
 }
 
@@ -2,35 +2,59 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/binary"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"math"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	pb "github.com/adammck/collector/proto/gen"
+	"github.com/adammck/collector/validation"
 	"github.com/google/uuid"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
 )
 
 func TestMain(m *testing.M) {
 	// initialize global config for tests
 	config = &Config{
-		HTTPPort:           8000,
-		GRPCPort:           50051,
-		MaxPendingRequests: 1000,
-		HTTPTimeout:        30 * time.Second,
-		SubmitTimeout:      5 * time.Second,
+		HTTPPort:            8000,
+		GRPCPort:            50051,
+		MaxPendingRequests:  1000,
+		MaxInputsPerRequest: 20,
+		MaxTimeSeriesPoints: 1000,
+		HTTPTimeout:         30 * time.Second,
+		MaxDataTimeout:      2 * time.Minute,
+		MaxPrefetch:         10,
+		SubmitTimeout:       5 * time.Second,
+		MaxSubmitBytes:      1 << 20,
+		EventLogSize:        1000,
+		HistorySize:         1000,
+		EnableReflection:    true,
+		StrictValidation:    true,
 	}
+	reloadLimits(config)
 	m.Run()
 }
 
@@ -38,11 +62,19 @@ func TestMain(m *testing.M) {
 
 func newTestServer() *server {
 	testConfig := &Config{
-		HTTPPort:           8000,
-		GRPCPort:           50051,
-		MaxPendingRequests: 1000,
-		HTTPTimeout:        30 * time.Second,
-		SubmitTimeout:      5 * time.Second,
+		HTTPPort:            8000,
+		GRPCPort:            50051,
+		MaxPendingRequests:  1000,
+		MaxInputsPerRequest: 20,
+		MaxTimeSeriesPoints: 1000,
+		HTTPTimeout:         30 * time.Second,
+		MaxDataTimeout:      2 * time.Minute,
+		MaxPrefetch:         10,
+		SubmitTimeout:       5 * time.Second,
+		MaxSubmitBytes:      1 << 20,
+		EventLogSize:        1000,
+		HistorySize:         1000,
+		StrictValidation:    true,
 	}
 	return newServer(testConfig)
 }
@@ -91,8 +123,8 @@ func startTestGRPCServer(t *testing.T, s *server) (pb.CollectorClient, func()) {
 		t.Fatalf("failed to listen: %v", err)
 	}
 
-	srv := grpc.NewServer()
-	pb.RegisterCollectorServer(srv, &collectorServer{s: s})
+	srv := newGRPCServer(config, s)
+	s.listening.Store(true)
 
 	go func() {
 		if err := srv.Serve(lis); err != nil {
@@ -108,6 +140,7 @@ func startTestGRPCServer(t *testing.T, s *server) (pb.CollectorClient, func()) {
 	client := pb.NewCollectorClient(conn)
 
 	cleanup := func() {
+		s.listening.Store(false)
 		conn.Close()
 		srv.Stop()
 		lis.Close()
@@ -116,6 +149,65 @@ func startTestGRPCServer(t *testing.T, s *server) (pb.CollectorClient, func()) {
 	return client, cleanup
 }
 
+// collectAsync starts a Collect call in the background, and waits for it to
+// be enqueued before returning.
+func collectAsync(t *testing.T, s *server, client pb.CollectorClient, ctx context.Context, req *pb.Request) (<-chan *pb.Response, <-chan error) {
+	t.Helper()
+	resultCh := make(chan *pb.Response, 1)
+	errCh := make(chan error, 1)
+	before := s.queue.Status().Total
+
+	go func() {
+		res, err := client.Collect(ctx, req)
+		if err != nil {
+			errCh <- err
+			return
+		}
+		resultCh <- res
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for s.queue.Status().Total == before {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for collect to be enqueued")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	return resultCh, errCh
+}
+
+// fetchItem serves the next item via handleData, and returns its uuid.
+func fetchItem(t *testing.T, s *server) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	s.handleData(w, httptest.NewRequest("GET", "/data.json", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("data request failed: %d: %s", w.Code, w.Body.String())
+	}
+
+	var jsonResp map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &jsonResp); err != nil {
+		t.Fatalf("failed to unmarshal web request: %v", err)
+	}
+	return jsonResp["uuid"].(string)
+}
+
+// submitItem posts the given response via handleSubmit.
+func submitItem(t *testing.T, s *server, u string, res *pb.Response) *httptest.ResponseRecorder {
+	t.Helper()
+	b, err := protojson.Marshal(res)
+	if err != nil {
+		t.Fatalf("failed to marshal response: %v", err)
+	}
+
+	req := httptest.NewRequest("POST", "/submit/"+u, bytes.NewReader(b))
+	req.SetPathValue("uuid", u)
+	w := httptest.NewRecorder()
+	s.handleSubmit(w, req)
+	return w
+}
+
 // queue integration tests
 
 func TestServerQueueIntegration(t *testing.T) {
@@ -230,11 +322,136 @@ func TestConcurrentCurrentAccess(t *testing.T) {
 	wg.Wait()
 }
 
+func TestGRPCReflection(t *testing.T) {
+	s := newTestServer()
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	srv := newGRPCServer(config, s)
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := rpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	if err != nil {
+		t.Fatalf("failed to open reflection stream: %v", err)
+	}
+
+	err = stream.Send(&rpb.ServerReflectionRequest{
+		MessageRequest: &rpb.ServerReflectionRequest_ListServices{},
+	})
+	if err != nil {
+		t.Fatalf("failed to send reflection request: %v", err)
+	}
+
+	res, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("failed to receive reflection response: %v", err)
+	}
+
+	found := false
+	for _, svc := range res.GetListServicesResponse().GetService() {
+		if svc.Name == "collector.Collector" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("expected collector.Collector in services, got %v", res.GetListServicesResponse())
+	}
+}
+
+func TestGRPCReflectionDisabled(t *testing.T) {
+	t.Setenv("ENABLE_REFLECTION", "")
+	if loadConfig().EnableReflection {
+		t.Fatal("expected reflection to be off by default")
+	}
+
+	cfg := *config
+	cfg.EnableReflection = false
+	srv := newGRPCServer(&cfg, newTestServer())
+
+	if _, ok := srv.GetServiceInfo()["grpc.reflection.v1.ServerReflection"]; ok {
+		t.Fatal("expected reflection not to be registered")
+	}
+	if _, ok := srv.GetServiceInfo()["collector.Collector"]; !ok {
+		t.Fatal("expected collector service to be registered")
+	}
+}
+
+func TestGRPCMaxRecvMsgSize(t *testing.T) {
+	s := newTestServer()
+	cfg := *config
+	cfg.MaxRecvMsgSize = 16 << 10
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	srv := newGRPCServer(&cfg, s)
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+	client := pb.NewCollectorClient(conn)
+
+	// a 32x32 grid of floats is 8KB of data, and a 48x48 one is 18KB
+	gridRequest := func(size int32) *pb.Request {
+		req := newTestRequest()
+		req.Inputs[0].Visualization = &pb.Input_Grid{Grid: &pb.Grid{Rows: size, Cols: size}}
+		req.Inputs[0].Data = &pb.Data{Data: &pb.Data_Floats{Floats: &pb.Floats{Values: make([]float64, size*size)}}}
+		return req
+	}
+
+	before := getStats().TooLarge
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err = client.Collect(ctx, gridRequest(48))
+	if st := status.Convert(err); st.Code() != codes.ResourceExhausted || !strings.Contains(st.Message(), "larger than max") {
+		t.Fatalf("expected ResourceExhausted for an oversized request, got %v", err)
+	}
+
+	// the handler sees the rejection after the client does
+	deadline := time.Now().Add(time.Second)
+	for getStats().TooLarge == before && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := getStats().TooLarge - before; n != 1 {
+		t.Errorf("expected the rejection to be counted as too large, got %d", n)
+	}
+
+	resCh, errCh := collectAsync(t, s, client, ctx, gridRequest(32))
+	u := fetchItem(t, s)
+	if w := submitItem(t, s, u, newTestResponse()); w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	select {
+	case <-resCh:
+	case err := <-errCh:
+		t.Fatalf("collect failed: %v", err)
+	}
+}
+
 // http handler tests
 
 func TestHandleDataNoPending(t *testing.T) {
 	s := newTestServer()
-	s.timeout = 100 * time.Millisecond // short timeout for test
+	s.timeout.Store(int64(100 * time.Millisecond)) // short timeout for test
 	req := httptest.NewRequest("GET", "/data.json", nil)
 	w := httptest.NewRecorder()
 
@@ -257,6 +474,163 @@ func TestHandleDataNoPending(t *testing.T) {
 	}
 }
 
+func TestHandleDataMaxWaiters(t *testing.T) {
+	s := newTestServer()
+	s.maxDataWaiters = 2
+	s.timeout.Store(int64(5 * time.Second))
+
+	codes := make(chan int, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			w := httptest.NewRecorder()
+			s.handleData(w, httptest.NewRequest("GET", "/data.json", nil))
+			codes <- w.Code
+		}()
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for s.dataWaiters.Load() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the data requests to wait")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	w := httptest.NewRecorder()
+	s.handleData(w, httptest.NewRequest("GET", "/data.json", nil))
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected status 429 past the cap, got %d: %s", w.Code, w.Body.String())
+	}
+
+	select {
+	case code := <-codes:
+		t.Fatalf("expected the earlier requests to still be waiting, got %d", code)
+	default:
+	}
+
+	for i := 0; i < 2; i++ {
+		u := uuid.NewString()
+		s.queue.Enqueue(&QueueItem{ID: u, Request: newTestRequest(), AddedAt: time.Now()})
+	}
+	for i := 0; i < 2; i++ {
+		if code := <-codes; code != http.StatusOK {
+			t.Errorf("expected waiting requests to be served, got %d", code)
+		}
+	}
+	if n := s.dataWaiters.Load(); n != 0 {
+		t.Errorf("expected no waiters left, got %d", n)
+	}
+}
+
+func TestHandleDataAllDeferred(t *testing.T) {
+	s := newTestServer()
+	s.timeout.Store(int64(50 * time.Millisecond))
+	s.queue.Enqueue(&QueueItem{ID: "deferred", Request: newTestRequest(), AddedAt: time.Now()})
+	if _, err := s.queue.Defer("deferred"); err != nil {
+		t.Fatalf("defer failed: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	s.handleData(w, httptest.NewRequest("GET", "/data.json", nil))
+	if w.Code != http.StatusRequestTimeout {
+		t.Fatalf("expected status 408, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "all pending requests are deferred") {
+		t.Fatalf("expected all-deferred message, got: %s", w.Body.String())
+	}
+}
+
+func TestHandleDataTimeoutOverride(t *testing.T) {
+	s := newTestServer()
+	s.timeout.Store(int64(5 * time.Second))
+
+	req := httptest.NewRequest("GET", "/data.json?timeout=50ms", nil)
+	w := httptest.NewRecorder()
+
+	start := time.Now()
+	s.handleData(w, req)
+	duration := time.Since(start)
+
+	if w.Code != http.StatusRequestTimeout {
+		t.Fatalf("expected status 408, got %d", w.Code)
+	}
+	if duration < 50*time.Millisecond || duration >= time.Duration(s.timeout.Load()) {
+		t.Fatalf("expected to wait about 50ms, waited %v", duration)
+	}
+}
+
+func TestDataTimeout(t *testing.T) {
+	s := newTestServer()
+	s.timeout.Store(int64(30 * time.Second))
+	s.maxTimeout = time.Minute
+
+	tests := []struct {
+		query    string
+		expected time.Duration
+	}{
+		{"", 30 * time.Second},
+		{"?timeout=5s", 5 * time.Second},
+		{"?timeout=1h", time.Minute},
+		{"?timeout=bogus", 30 * time.Second},
+		{"?timeout=-5s", 30 * time.Second},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/data.json"+tt.query, nil)
+		if got := s.dataTimeout(req); got != tt.expected {
+			t.Errorf("%q: expected %v, got %v", tt.query, tt.expected, got)
+		}
+	}
+}
+
+func TestAdminConfig(t *testing.T) {
+	s := newTestServer()
+	s.timeout.Store(int64(5 * time.Second))
+	s.maxTimeout = time.Minute
+	handler := s.ServeHTTP()
+
+	post := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("POST", "/admin/config", strings.NewReader(body)))
+		return w
+	}
+
+	for _, body := range []string{
+		`{"data_timeout":"bogus"}`,
+		`{"data_timeout":"-1s"}`,
+		`{"data_timeout":"0s"}`,
+		`{"data_timeout":"2m"}`,
+		`not json`,
+	} {
+		if w := post(body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d: %s", body, w.Code, w.Body.String())
+		}
+	}
+	if got := time.Duration(s.timeout.Load()); got != 5*time.Second {
+		t.Fatalf("expected rejected updates to leave the timeout alone, got %v", got)
+	}
+
+	w := post(`{"data_timeout":"100ms"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), `"data_timeout":"100ms"`) {
+		t.Errorf("expected new timeout in response, got: %s", w.Body.String())
+	}
+
+	// the next long-poll on the empty queue uses the new timeout
+	w = httptest.NewRecorder()
+	start := time.Now()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/data.json", nil))
+	duration := time.Since(start)
+	if w.Code != http.StatusRequestTimeout {
+		t.Fatalf("expected status 408, got %d", w.Code)
+	}
+	if duration < 100*time.Millisecond || duration >= time.Second {
+		t.Fatalf("expected to wait about 100ms, waited %v", duration)
+	}
+}
+
 func TestHandleDataWithPending(t *testing.T) {
 	s := newTestServer()
 
@@ -327,441 +701,501 @@ func TestHandleDataWithPending(t *testing.T) {
 	}
 }
 
-func TestHandleSubmitValid(t *testing.T) {
+func TestHandlePauseResume(t *testing.T) {
 	s := newTestServer()
+	s.timeout.Store(int64(100 * time.Millisecond))
+	handler := s.ServeHTTP()
 
-	// create queue item and add to current (simulate handleData)
-	testReq := newTestRequest()
-	resCh := make(chan *pb.Response, 1)
-	testUUID := uuid.NewString()
-	item := &QueueItem{
-		ID:       testUUID,
-		Request:  testReq,
-		Response: resCh,
+	s.queue.Enqueue(&QueueItem{
+		ID:       "paused-uuid",
+		Request:  newTestRequest(),
+		Response: make(chan *pb.Response, 1),
 		AddedAt:  time.Now(),
 		Context:  context.Background(),
-	}
-
-	// add to current (simulate what handleData would do)
-	s.cmu.Lock()
-	s.current[testUUID] = item
-	s.cmu.Unlock()
+	})
 
-	// prepare response
-	testRes := newTestResponse()
-	resJSON, err := protojson.Marshal(testRes)
-	if err != nil {
-		t.Fatalf("failed to marshal response: %v", err)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/admin/pause", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200 from pause, got %d", w.Code)
 	}
 
-	req := httptest.NewRequest("POST", "/submit/"+testUUID, bytes.NewReader(resJSON))
-	w := httptest.NewRecorder()
+	// paused: fail immediately rather than long-polling
+	start := time.Now()
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/data.json", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected status 503 while paused, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "paused") {
+		t.Fatalf("expected paused message, got: %s", w.Body.String())
+	}
+	if time.Since(start) >= time.Duration(s.timeout.Load()) {
+		t.Fatal("expected paused response without waiting for timeout")
+	}
 
-	// simulate the path value extraction (normally done by http.ServeMux)
-	req.SetPathValue("uuid", testUUID)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/health", nil))
+	var health map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &health)
+	if health["paused"] != true {
+		t.Fatalf("expected health to report paused, got: %v", health)
+	}
 
-	s.handleSubmit(w, req)
+	// item is still queued
+	if status := s.queue.Status(); status.Active != 1 {
+		t.Fatalf("expected 1 active item while paused, got %+v", status)
+	}
 
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/admin/resume", nil))
 	if w.Code != http.StatusOK {
-		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		t.Fatalf("expected status 200 from resume, got %d", w.Code)
 	}
 
-	// verify response channel received the data
-	select {
-	case res := <-resCh:
-		if res == nil {
-			t.Fatal("expected response to be non-nil")
-		}
-	case <-time.After(100 * time.Millisecond):
-		t.Fatal("expected response on channel")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/data.json", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200 after resume, got %d: %s", w.Code, w.Body.String())
 	}
-
-	// verify current request was removed
-	s.cmu.RLock()
-	_, exists := s.current[testUUID]
-	s.cmu.RUnlock()
-
-	if exists {
-		t.Fatal("expected current request to be removed")
+	if !strings.Contains(w.Body.String(), "paused-uuid") {
+		t.Fatalf("expected queued item after resume, got: %s", w.Body.String())
 	}
 }
 
-func TestHandleSubmitInvalidUUID(t *testing.T) {
+func TestHandleDataQueuePosition(t *testing.T) {
 	s := newTestServer()
 
-	req := httptest.NewRequest("POST", "/submit/invalid-uuid", nil)
-	w := httptest.NewRecorder()
+	for i := 0; i < 3; i++ {
+		s.queue.Enqueue(&QueueItem{
+			ID:       fmt.Sprintf("item-%d", i),
+			Request:  newTestRequest(),
+			Response: make(chan *pb.Response, 1),
+			AddedAt:  time.Now(),
+			Context:  context.Background(),
+		})
+	}
 
-	req.SetPathValue("uuid", "invalid-uuid")
+	for i := 1; i <= 2; i++ {
+		w := httptest.NewRecorder()
+		s.handleData(w, httptest.NewRequest("GET", "/data.json", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
 
-	s.handleSubmit(w, req)
+		var resp struct {
+			QueuePosition  int64 `json:"queue_position"`
+			QueueRemaining int   `json:"queue_remaining"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to unmarshal response: %v", err)
+		}
 
-	if w.Code != http.StatusNotFound {
-		t.Fatalf("expected status 404, got %d", w.Code)
+		if resp.QueuePosition != int64(i) {
+			t.Errorf("expected queue_position %d, got %d", i, resp.QueuePosition)
+		}
+		if want := s.queue.Status().Active; resp.QueueRemaining != want {
+			t.Errorf("expected queue_remaining %d to match active count, got %d", want, resp.QueueRemaining)
+		}
+		if resp.QueueRemaining != 3-i {
+			t.Errorf("expected %d remaining, got %d", 3-i, resp.QueueRemaining)
+		}
 	}
+}
 
-	expectedMsg := "pending request not found"
-	if !strings.Contains(w.Body.String(), expectedMsg) {
-		t.Fatalf("expected 'pending request not found' message, got: %s", w.Body.String())
-	}
-}
-
-func TestHandleSubmitMalformedJSON(t *testing.T) {
-	s := newTestServer()
-
-	// create queue item and add to current
-	testReq := newTestRequest()
-	resCh := make(chan *pb.Response, 1)
-	testUUID := uuid.NewString()
-	item := &QueueItem{
-		ID:       testUUID,
-		Request:  testReq,
-		Response: resCh,
-		AddedAt:  time.Now(),
-		Context:  context.Background(),
+func TestFrontendDir(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "index.html"), []byte("<p>built frontend</p>"), 0o644); err != nil {
+		t.Fatalf("failed to write index: %v", err)
 	}
 
-	s.cmu.Lock()
-	s.current[testUUID] = item
-	s.cmu.Unlock()
+	orig := config.FrontendDir
+	t.Cleanup(func() { config.FrontendDir = orig })
 
-	malformedJSON := []byte(`{"invalid": json`)
-	req := httptest.NewRequest("POST", "/submit/"+testUUID, bytes.NewReader(malformedJSON))
+	config.FrontendDir = dir
 	w := httptest.NewRecorder()
+	newTestServer().ServeHTTP().ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "built frontend") {
+		t.Errorf("expected configured index to be served, got: %s", w.Body.String())
+	}
 
-	req.SetPathValue("uuid", testUUID)
-
-	s.handleSubmit(w, req)
-
-	if w.Code != http.StatusBadRequest {
-		t.Fatalf("expected status 400, got %d: %s", w.Code, w.Body.String())
+	config.FrontendDir = filepath.Join(dir, "missing")
+	w = httptest.NewRecorder()
+	newTestServer().ServeHTTP().ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "frontend hasn't been built") {
+		t.Errorf("expected embedded placeholder to be served, got: %s", w.Body.String())
 	}
 }
 
-// grpc service tests
-
-func TestCollectorServiceSuccess(t *testing.T) {
-	s := newTestServer()
-	client, cleanup := startTestGRPCServer(t, s)
-	defer cleanup()
+func TestBasePath(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "index.html"), []byte("<p>built frontend</p>"), 0o644); err != nil {
+		t.Fatalf("failed to write index: %v", err)
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	origDir, origPath := config.FrontendDir, config.BasePath
+	t.Cleanup(func() { config.FrontendDir, config.BasePath = origDir, origPath })
+	config.FrontendDir = dir
+	config.BasePath = "/collector"
 
-	testReq := newTestRequest()
+	handler := newTestServer().ServeHTTP()
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
 
-	// make grpc call in background
-	resultCh := make(chan *pb.Response, 1)
-	errCh := make(chan error, 1)
+	tests := []struct {
+		path     string
+		wantCode int
+		wantBody string
+	}{
+		{"/collector/", http.StatusOK, "built frontend"},
+		{"/collector/queue/status", http.StatusOK, `"total":0`},
+		{"/collector/config.json", http.StatusOK, `"base_path":"/collector"`},
+		{"/collector", http.StatusTemporaryRedirect, "/collector/"},
+		{"/", http.StatusNotFound, ""},
+		{"/queue/status", http.StatusNotFound, ""},
+		{"/config.json", http.StatusNotFound, ""},
+	}
 
-	go func() {
-		res, err := client.Collect(ctx, testReq)
-		if err != nil {
-			errCh <- err
-			return
-		}
-		resultCh <- res
-	}()
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			w := get(tt.path)
+			if w.Code != tt.wantCode {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantCode, w.Code, w.Body.String())
+			}
+			if !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("expected body to contain %q, got: %s", tt.wantBody, w.Body.String())
+			}
+		})
+	}
+}
 
-	// wait a bit for request to be registered
-	time.Sleep(10 * time.Millisecond)
+func TestQueueEventLog(t *testing.T) {
+	s := newTestServer()
+	handler := s.ServeHTTP()
 
-	// check that queue has item
-	status := s.queue.Status()
-	if status.Total != 1 {
-		t.Fatalf("expected 1 item in queue, got %d", status.Total)
+	for _, id := range []string{"logged-uuid", "other-uuid"} {
+		s.queue.Enqueue(&QueueItem{
+			ID:       id,
+			Request:  newTestRequest(),
+			Response: make(chan *pb.Response, 1),
+			AddedAt:  time.Now(),
+			Context:  context.Background(),
+		})
 	}
 
-	// get item from queue (simulating web client)
-	item, err := s.queue.GetNext(100 * time.Millisecond)
-	if err != nil {
-		t.Fatalf("failed to get item from queue: %v", err)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/data.json", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
 	}
 
-	// add to current (simulating handleData)
-	s.cmu.Lock()
-	s.current[item.ID] = item
-	s.cmu.Unlock()
+	resJSON, _ := protojson.Marshal(newTestResponse())
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/submit/logged-uuid", bytes.NewReader(resJSON)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
 
-	// simulate web client submission
-	testRes := newTestResponse()
-	resJSON, err := protojson.Marshal(testRes)
-	if err != nil {
-		t.Fatalf("failed to marshal response: %v", err)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/queue/log?uuid=logged-uuid", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
 	}
 
-	// simulate http submission
-	req := httptest.NewRequest("POST", "/submit/"+item.ID, bytes.NewReader(resJSON))
-	w := httptest.NewRecorder()
-	req.SetPathValue("uuid", item.ID)
+	var events []Event
+	if err := json.Unmarshal(w.Body.Bytes(), &events); err != nil {
+		t.Fatalf("failed to unmarshal events: %v", err)
+	}
 
-	s.handleSubmit(w, req)
+	expected := []string{EventEnqueue, EventDequeue, EventSubmit}
+	if len(events) != len(expected) {
+		t.Fatalf("expected %d events, got %d: %+v", len(expected), len(events), events)
+	}
+	for i, e := range events {
+		if e.Type != expected[i] || e.UUID != "logged-uuid" {
+			t.Fatalf("event %d: expected %s for logged-uuid, got %+v", i, expected[i], e)
+		}
+		if i > 0 && e.Time.Before(events[i-1].Time) {
+			t.Fatalf("event %d is out of order", i)
+		}
+	}
+}
 
-	if w.Code != http.StatusOK {
-		t.Fatalf("submit failed: %d: %s", w.Code, w.Body.String())
+func TestEventLogBounded(t *testing.T) {
+	l := NewEventLog(3)
+	for i := 0; i < 5; i++ {
+		l.Append(EventEnqueue, fmt.Sprintf("uuid-%d", i))
 	}
 
-	// verify grpc call completed successfully
-	select {
-	case res := <-resultCh:
-		if res == nil {
-			t.Fatal("expected non-nil response")
+	events := l.Events("")
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events, got %d", len(events))
+	}
+	for i, e := range events {
+		if want := fmt.Sprintf("uuid-%d", i+2); e.UUID != want {
+			t.Fatalf("event %d: expected %s, got %s", i, want, e.UUID)
 		}
-	case err := <-errCh:
-		t.Fatalf("grpc call failed: %v", err)
-	case <-time.After(1 * time.Second):
-		t.Fatal("grpc call timed out")
+	}
+
+	// nil log is a no-op
+	var nilLog *EventLog
+	nilLog.Append(EventEnqueue, "x")
+	if len(nilLog.Events("")) != 0 {
+		t.Fatal("expected nil log to have no events")
 	}
 }
 
-func TestCollectorServiceCancellation(t *testing.T) {
+func TestDebugErrors(t *testing.T) {
 	s := newTestServer()
 	client, cleanup := startTestGRPCServer(t, s)
 	defer cleanup()
+	handler := s.ServeHTTP()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
-
-	testReq := newTestRequest()
+	config.AdminAPIKey = "secret"
+	t.Cleanup(func() { config.AdminAPIKey = "" })
 
-	// make grpc call in background
-	errCh := make(chan error, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
 
-	go func() {
-		_, err := client.Collect(ctx, testReq)
-		errCh <- err
-	}()
+	req := newTestRequest()
+	req.Title = "debug-errors-test"
+	req.Inputs[0].Data = &pb.Data{}
+	if _, err := client.Collect(ctx, req); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument, got: %v", err)
+	}
 
-	// wait for request to be registered
-	time.Sleep(10 * time.Millisecond)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/debug/errors", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status 401 without api key, got %d", w.Code)
+	}
 
-	// verify queue has item
-	status := s.queue.Status()
-	if status.Total != 1 {
-		t.Fatalf("expected 1 item in queue, got %d", status.Total)
+	r := httptest.NewRequest("GET", "/debug/errors", nil)
+	r.Header.Set("X-API-Key", "secret")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
 	}
 
-	// cancel context
-	cancel()
+	var events []ErrorEvent
+	if err := json.Unmarshal(w.Body.Bytes(), &events); err != nil {
+		t.Fatalf("failed to unmarshal errors: %v", err)
+	}
+	if len(events) == 0 {
+		t.Fatal("expected at least one recorded error")
+	}
 
-	// verify grpc call was cancelled
-	select {
-	case err := <-errCh:
-		if err == nil {
-			t.Fatal("expected cancellation error")
-		}
-		// should be a grpc cancellation error
-	case <-time.After(1 * time.Second):
-		t.Fatal("expected cancellation error")
+	last := events[len(events)-1]
+	if last.Code != codes.InvalidArgument.String() {
+		t.Errorf("expected code %s, got %s", codes.InvalidArgument, last.Code)
+	}
+	if !strings.Contains(last.Message, "invalid request: input 0") {
+		t.Errorf("expected validation message, got %q", last.Message)
+	}
+	if !strings.Contains(last.Request, "inputs=[grid]") || !strings.Contains(last.Request, "debug-errors-test") {
+		t.Errorf("expected request summary, got %q", last.Request)
 	}
+	if last.Time.IsZero() {
+		t.Error("expected timestamp")
+	}
+}
 
-	// wait a bit for cleanup
-	time.Sleep(10 * time.Millisecond)
+func TestErrorLogWraps(t *testing.T) {
+	l := NewErrorLog(2)
+	for i := 0; i < 3; i++ {
+		l.Append(codes.Internal, fmt.Sprintf("error %d", i), nil)
+	}
 
-	// verify queue was cleaned up (context cancellation removes items)
-	status = s.queue.Status()
-	if status.Total != 0 {
-		t.Fatalf("expected 0 items in queue after cancellation, got %d", status.Total)
+	events := l.Events()
+	if len(events) != 2 || events[0].Message != "error 1" || events[1].Message != "error 2" {
+		t.Fatalf("expected the last two errors oldest first, got %+v", events)
 	}
 }
 
-// integration tests
-
-func TestEndToEndFlow(t *testing.T) {
+func TestHandleClear(t *testing.T) {
 	s := newTestServer()
 	client, cleanup := startTestGRPCServer(t, s)
 	defer cleanup()
+	handler := s.ServeHTTP()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	testReq := newTestRequest()
-
-	// start grpc call
-	resultCh := make(chan *pb.Response, 1)
-	errCh := make(chan error, 1)
+	config.AdminAPIKey = "secret"
+	t.Cleanup(func() { config.AdminAPIKey = "" })
 
-	go func() {
-		res, err := client.Collect(ctx, testReq)
-		if err != nil {
-			errCh <- err
-			return
-		}
-		resultCh <- res
-	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 
-	// wait for request registration
-	time.Sleep(10 * time.Millisecond)
+	var errChs []<-chan error
+	for i := 0; i < 3; i++ {
+		_, errCh := collectAsync(t, s, client, ctx, newTestRequest())
+		errChs = append(errChs, errCh)
+	}
 
-	// simulate web client getting data
-	httpReq := httptest.NewRequest("GET", "/data.json", nil)
+	// rejected without the key
 	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/admin/clear", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status 401 without api key, got %d", w.Code)
+	}
+	if status := s.queue.Status(); status.Total != 3 {
+		t.Fatalf("expected queue untouched, got %+v", status)
+	}
 
-	s.handleData(w, httpReq)
-
+	req := httptest.NewRequest("POST", "/admin/clear", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
 	if w.Code != http.StatusOK {
-		t.Fatalf("data request failed: %d: %s", w.Code, w.Body.String())
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
 	}
-
-	// parse the json response manually since webRequest has custom marshaling
-	var jsonResp map[string]interface{}
-	if err := json.Unmarshal(w.Body.Bytes(), &jsonResp); err != nil {
-		t.Fatalf("failed to unmarshal web request: %v", err)
+	if !strings.Contains(w.Body.String(), `"removed":3`) {
+		t.Fatalf("expected 3 removed, got: %s", w.Body.String())
 	}
 
-	webReqUUID, ok := jsonResp["uuid"].(string)
-	if !ok {
-		t.Fatal("expected uuid to be a string")
+	for i, errCh := range errChs {
+		select {
+		case err := <-errCh:
+			if err == nil {
+				t.Fatalf("collect %d: expected error", i)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("collect %d: expected prompt error after clear", i)
+		}
 	}
 
-	protoData, ok := jsonResp["proto"].(map[string]interface{})
-	if !ok {
-		t.Fatal("expected proto to be an object")
+	if status := s.queue.Status(); status.Total != 0 {
+		t.Fatalf("expected empty queue, got %+v", status)
 	}
+}
 
-	// verify the request has expected structure
-	inputs, ok := protoData["inputs"].([]interface{})
-	if !ok || len(inputs) != len(testReq.Inputs) {
-		t.Fatalf("expected %d proto inputs, got %v", len(testReq.Inputs), len(inputs))
-	}
+func TestHandleClearHeldRaceItem(t *testing.T) {
+	s := newTestServer()
 
-	// simulate web client submission
-	testRes := newTestResponse()
-	resJSON, err := protojson.Marshal(testRes)
-	if err != nil {
-		t.Fatalf("failed to marshal response: %v", err)
-	}
+	req := newTestRequest()
+	req.RaceReplicas = 2
+	s.queue.Enqueue(&QueueItem{
+		ID:       "race",
+		Request:  req,
+		Response: make(chan *pb.Response, 1),
+		AddedAt:  time.Now(),
+		Context:  context.Background(),
+	})
 
-	submitReq := httptest.NewRequest("POST", "/submit/"+webReqUUID, bytes.NewReader(resJSON))
-	submitW := httptest.NewRecorder()
-	submitReq.SetPathValue("uuid", webReqUUID)
+	// one annotator holds it, and its replica waits in the queue
+	if u := fetchItem(t, s); u != "race" {
+		t.Fatalf("expected race item, got %q", u)
+	}
+	if !s.queue.Contains("race") {
+		t.Fatal("expected the replica to be queued")
+	}
 
-	s.handleSubmit(submitW, submitReq)
+	w := httptest.NewRecorder()
+	s.handleClear(w, httptest.NewRequest("POST", "/admin/clear", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
 
-	if submitW.Code != http.StatusOK {
-		t.Fatalf("submit failed: %d: %s", submitW.Code, submitW.Body.String())
+	// the response channel is closed, so a submit must not reach complete
+	if w := submitItem(t, s, "race", newTestResponse()); w.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404 after clear, got %d: %s", w.Code, w.Body.String())
 	}
+}
 
-	// verify grpc call completed
-	select {
-	case res := <-resultCh:
-		if res == nil {
-			t.Fatal("expected non-nil response")
-		}
-		// verify response content matches
-		if res.Output == nil {
-			t.Fatal("expected output to be non-nil")
-		}
-	case err := <-errCh:
-		t.Fatalf("grpc call failed: %v", err)
-	case <-time.After(1 * time.Second):
-		t.Fatal("end-to-end flow timed out")
-	}
-}
-
-// additional coverage tests
-
-func TestServeHTTP(t *testing.T) {
+func TestHandleSubmitValid(t *testing.T) {
 	s := newTestServer()
-	handler := s.ServeHTTP()
 
-	if handler == nil {
-		t.Fatal("expected non-nil handler")
+	// create queue item and add to current (simulate handleData)
+	testReq := newTestRequest()
+	resCh := make(chan *pb.Response, 1)
+	testUUID := uuid.NewString()
+	item := &QueueItem{
+		ID:       testUUID,
+		Request:  testReq,
+		Response: resCh,
+		AddedAt:  time.Now(),
+		Context:  context.Background(),
 	}
 
-	// test that static files are served
-	req := httptest.NewRequest("GET", "/", nil)
-	w := httptest.NewRecorder()
-	handler.ServeHTTP(w, req)
+	// add to current (simulate what handleData would do)
+	s.cmu.Lock()
+	s.current[testUUID] = item
+	s.cmu.Unlock()
 
-	// should get some response (likely 404 since no static files exist)
-	if w.Code == 0 {
-		t.Fatal("expected some response code")
+	// prepare response
+	testRes := newTestResponse()
+	resJSON, err := protojson.Marshal(testRes)
+	if err != nil {
+		t.Fatalf("failed to marshal response: %v", err)
 	}
-}
 
-func TestWebRequestMarshalJSON(t *testing.T) {
-	testReq := newTestRequest()
-	webReq := webRequest{
-		UUID:  "test-uuid",
-		Proto: testReq,
-		Queue: QueueStatus{Total: 1, Active: 1, Deferred: 0},
-	}
+	req := httptest.NewRequest("POST", "/submit/"+testUUID, bytes.NewReader(resJSON))
+	w := httptest.NewRecorder()
 
-	data, err := json.Marshal(webReq)
-	if err != nil {
-		t.Fatalf("failed to marshal webRequest: %v", err)
-	}
+	// simulate the path value extraction (normally done by http.ServeMux)
+	req.SetPathValue("uuid", testUUID)
 
-	// verify it produces json with uuid, proto, and queue fields
-	var result map[string]interface{}
-	if err := json.Unmarshal(data, &result); err != nil {
-		t.Fatalf("failed to unmarshal result: %v", err)
-	}
+	s.handleSubmit(w, req)
 
-	if result["uuid"] != "test-uuid" {
-		t.Fatalf("expected uuid field, got: %v", result["uuid"])
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
 	}
 
-	if result["proto"] == nil {
-		t.Fatal("expected proto field")
+	// verify response channel received the data
+	select {
+	case res := <-resCh:
+		if res == nil {
+			t.Fatal("expected response to be non-nil")
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("expected response on channel")
 	}
 
-	if result["queue"] == nil {
-		t.Fatal("expected queue field")
+	// verify current request was removed
+	s.cmu.RLock()
+	_, exists := s.current[testUUID]
+	s.cmu.RUnlock()
+
+	if exists {
+		t.Fatal("expected current request to be removed")
 	}
 }
 
-func TestHandleQueueStatus(t *testing.T) {
+func TestHandleSubmitInvalidUUID(t *testing.T) {
 	s := newTestServer()
 
-	// add some items to queue
-	for i := 0; i < 3; i++ {
-		item := &QueueItem{
-			ID:       fmt.Sprintf("test-%d", i),
-			Request:  newTestRequest(),
-			Response: make(chan *pb.Response, 1),
-			AddedAt:  time.Now(),
-			Context:  context.Background(),
-		}
-		s.queue.Enqueue(item)
-	}
-
-	req := httptest.NewRequest("GET", "/queue/status", nil)
+	req := httptest.NewRequest("POST", "/submit/invalid-uuid", nil)
 	w := httptest.NewRecorder()
 
-	s.handleQueueStatus(w, req)
+	req.SetPathValue("uuid", "invalid-uuid")
 
-	if w.Code != http.StatusOK {
-		t.Fatalf("expected status 200, got %d", w.Code)
-	}
+	s.handleSubmit(w, req)
 
-	var status QueueStatus
-	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
-		t.Fatalf("failed to unmarshal status: %v", err)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404, got %d", w.Code)
 	}
 
-	if status.Total != 3 {
-		t.Fatalf("expected total 3, got %d", status.Total)
-	}
-	if status.Active != 3 {
-		t.Fatalf("expected active 3, got %d", status.Active)
+	expectedMsg := "pending request not found"
+	if !strings.Contains(w.Body.String(), expectedMsg) {
+		t.Fatalf("expected 'pending request not found' message, got: %s", w.Body.String())
 	}
 }
 
-// errorReader always returns an error when read
-type errorReader struct{}
-
-func (er *errorReader) Read(p []byte) (n int, err error) {
-	return 0, fmt.Errorf("simulated read error")
-}
-
-func TestHandleSubmitReadError(t *testing.T) {
+func TestHandleSubmitMalformedJSON(t *testing.T) {
 	s := newTestServer()
 
 	// create queue item and add to current
 	testReq := newTestRequest()
 	resCh := make(chan *pb.Response, 1)
-	testUUID := "test-uuid"
+	testUUID := uuid.NewString()
 	item := &QueueItem{
 		ID:       testUUID,
 		Request:  testReq,
@@ -774,1099 +1208,4652 @@ func TestHandleSubmitReadError(t *testing.T) {
 	s.current[testUUID] = item
 	s.cmu.Unlock()
 
-	// create a request with an error reader
-	errorReader := &errorReader{}
-	req := httptest.NewRequest("POST", "/submit/"+testUUID, errorReader)
+	malformedJSON := []byte(`{"invalid": json`)
+	req := httptest.NewRequest("POST", "/submit/"+testUUID, bytes.NewReader(malformedJSON))
 	w := httptest.NewRecorder()
 
 	req.SetPathValue("uuid", testUUID)
 
 	s.handleSubmit(w, req)
 
-	// should get an internal server error due to read failure
-	if w.Code != http.StatusInternalServerError {
-		t.Fatalf("expected status 500 for read error, got %d", w.Code)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d: %s", w.Code, w.Body.String())
 	}
 }
 
-// validation tests
+func TestHandleSubmitInvalidThenValid(t *testing.T) {
+	s := newTestServer()
+	client, cleanup := startTestGRPCServer(t, s)
+	defer cleanup()
 
-func TestValidateRequest(t *testing.T) {
-	tests := []struct {
-		name    string
-		req     *pb.Request
-		wantErr bool
-		errMsg  string
-	}{
-		{
-			name:    "nil request",
-			req:     nil,
-			wantErr: true,
-			errMsg:  "request cannot be nil",
-		},
-		{
-			name: "empty inputs",
-			req: &pb.Request{
-				Inputs: []*pb.Input{},
-				Output: &pb.OutputSchema{
-					Output: &pb.OutputSchema_OptionList{
-						OptionList: &pb.OptionListSchema{
-							Options: []*pb.Option{
-								{Label: "Option 1", Hotkey: "1"},
-								{Label: "Option 2", Hotkey: "2"},
-							},
-						},
-					},
-				},
-			},
-			wantErr: true,
-			errMsg:  "request must have at least one input",
-		},
-		{
-			name:    "valid request",
-			req:     newTestRequest(),
-			wantErr: false,
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resultCh, errCh := collectAsync(t, s, client, ctx, newTestRequest())
+	u := fetchItem(t, s)
+
+	// index 5 doesn't exist in the two-option schema
+	bad := &pb.Response{
+		Output: &pb.Output{
+			Output: &pb.Output_OptionList{OptionList: &pb.OptionListOutput{Index: 5}},
 		},
 	}
+	w := submitItem(t, s, u, bad)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d: %s", w.Code, w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), "resubmit") {
+		t.Fatalf("expected resubmit guidance, got: %s", w.Body.String())
+	}
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			err := validate(tt.req)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
-				return
-			}
-			if tt.wantErr && !strings.Contains(err.Error(), tt.errMsg) {
-				t.Errorf("expected error containing %q, got %v", tt.errMsg, err)
-			}
-		})
+	// malformed bodies are recoverable too
+	req := httptest.NewRequest("POST", "/submit/"+u, strings.NewReader(`{"invalid": json`))
+	req.SetPathValue("uuid", u)
+	w = httptest.NewRecorder()
+	s.handleSubmit(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d", w.Code)
+	}
+
+	if w := submitItem(t, s, u, newTestResponse()); w.Code != http.StatusOK {
+		t.Fatalf("expected corrected submit to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+
+	select {
+	case res := <-resultCh:
+		if res.Output.GetOptionList().Index != 0 {
+			t.Fatalf("expected index 0, got %v", res.Output)
+		}
+	case err := <-errCh:
+		t.Fatalf("grpc call failed: %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for response")
 	}
 }
 
-func TestValidateInput(t *testing.T) {
-	validData := &pb.Data{
-		Data: &pb.Data_Ints{
-			Ints: &pb.Ints{Values: make([]int64, 100)}, // 10x10 = 100
-		},
+func TestHandleSubmitClientGone(t *testing.T) {
+	s := newTestServer()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	s.current["gone-uuid"] = &QueueItem{
+		ID:       "gone-uuid",
+		Request:  newTestRequest(),
+		Response: make(chan *pb.Response, 1),
+		AddedAt:  time.Now(),
+		Context:  ctx,
 	}
 
+	if w := submitItem(t, s, "gone-uuid", newTestResponse()); w.Code != http.StatusGone {
+		t.Fatalf("expected status 410, got %d", w.Code)
+	}
+	if _, ok := s.current["gone-uuid"]; ok {
+		t.Fatal("expected item for departed client to be dropped")
+	}
+}
+
+func TestHandleSubmitDoesNotBlock(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
 	tests := []struct {
-		name    string
-		input   *pb.Input
-		wantErr bool
-		errMsg  string
+		name     string
+		ctx      context.Context
+		response chan *pb.Response
+		wantCode int
 	}{
-		{
-			name:    "nil input",
-			input:   nil,
-			wantErr: true,
-			errMsg:  "input cannot be nil",
-		},
-		{
-			name: "nil visualization",
-			input: &pb.Input{
-				Visualization: nil,
-				Data:          validData,
-			},
-			wantErr: true,
-			errMsg:  "visualization is required",
-		},
-		{
-			name: "valid input",
-			input: &pb.Input{
-				Visualization: &pb.Input_Grid{
-					Grid: &pb.Grid{Rows: 10, Cols: 10},
-				},
-				Data: validData,
-			},
-			wantErr: false,
-		},
+		// the collect call has been cancelled, but nothing has cleaned up yet
+		{"client cancelled", cancelled, make(chan *pb.Response), http.StatusGone},
+		// the client's context is live, but nothing is reading and the
+		// channel has no room
+		{"no reader", context.Background(), make(chan *pb.Response), http.StatusServiceUnavailable},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := validateInput(tt.input, 0)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("validateInput() error = %v, wantErr %v", err, tt.wantErr)
-				return
+			s := newTestServer()
+			u := uuid.NewString()
+			s.current[u] = &QueueItem{
+				ID:       u,
+				Request:  newTestRequest(),
+				Response: tt.response,
+				AddedAt:  time.Now(),
+				Context:  tt.ctx,
 			}
-			if tt.wantErr && !strings.Contains(err.Error(), tt.errMsg) {
-				t.Errorf("expected error containing %q, got %v", tt.errMsg, err)
+
+			done := make(chan *httptest.ResponseRecorder, 1)
+			go func() { done <- submitItem(t, s, u, newTestResponse()) }()
+
+			select {
+			case w := <-done:
+				if w.Code != tt.wantCode {
+					t.Errorf("expected status %d, got %d: %s", tt.wantCode, w.Code, w.Body.String())
+				}
+			case <-time.After(time.Second):
+				t.Fatal("handleSubmit blocked")
 			}
 		})
 	}
 }
 
-func TestValidateGrid(t *testing.T) {
-	tests := []struct {
-		name    string
-		grid    *pb.Grid
-		data    *pb.Data
+func TestHandleSubmitContentNegotiation(t *testing.T) {
+	testRes := &pb.Response{
+		Output: &pb.Output{
+			Output: &pb.Output_OptionList{
+				OptionList: &pb.OptionListOutput{Index: 1},
+			},
+		},
+	}
+
+	jsonBody, err := protojson.Marshal(testRes)
+	if err != nil {
+		t.Fatalf("failed to marshal json: %v", err)
+	}
+	binBody, err := proto.Marshal(testRes)
+	if err != nil {
+		t.Fatalf("failed to marshal binary: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        []byte
+	}{
+		{"json", "application/json", jsonBody},
+		{"binary", "application/x-protobuf", binBody},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer()
+			resCh := make(chan *pb.Response, 1)
+			testUUID := uuid.NewString()
+			s.current[testUUID] = &QueueItem{
+				ID:       testUUID,
+				Request:  newTestRequest(),
+				Response: resCh,
+				AddedAt:  time.Now(),
+				Context:  context.Background(),
+			}
+
+			req := httptest.NewRequest("POST", "/submit/"+testUUID, bytes.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			req.SetPathValue("uuid", testUUID)
+			w := httptest.NewRecorder()
+
+			s.handleSubmit(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+			}
+
+			// the server adds meta, so only compare what was submitted
+			res := <-resCh
+			if !proto.Equal(res.Output, testRes.Output) {
+				t.Fatalf("expected %v, got %v", testRes.Output, res.Output)
+			}
+		})
+	}
+}
+
+func TestHandleDataProtobuf(t *testing.T) {
+	s := newTestServer()
+	handler := s.ServeHTTP()
+
+	testReq := newTestRequest()
+	for _, id := range []string{"pb-1", "pb-2"} {
+		s.queue.Enqueue(&QueueItem{
+			ID:       id,
+			Request:  testReq,
+			Response: make(chan *pb.Response, 1),
+			AddedAt:  time.Now(),
+			Context:  context.Background(),
+		})
+	}
+
+	// via the accept header, and via the alias
+	reqs := []*http.Request{
+		httptest.NewRequest("GET", "/data.json", nil),
+		httptest.NewRequest("GET", "/data.pb", nil),
+	}
+	reqs[0].Header.Set("Accept", "application/x-protobuf")
+
+	for i, req := range reqs {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "application/x-protobuf" {
+			t.Fatalf("expected protobuf content type, got %q", ct)
+		}
+		if got := w.Header().Get("X-Collector-UUID"); got != fmt.Sprintf("pb-%d", i+1) {
+			t.Fatalf("expected uuid header pb-%d, got %q", i+1, got)
+		}
+
+		got := &pb.Request{}
+		if err := proto.Unmarshal(w.Body.Bytes(), got); err != nil {
+			t.Fatalf("failed to unmarshal binary request: %v", err)
+		}
+		if !proto.Equal(got, testReq) {
+			t.Fatal("expected served request to match enqueued request")
+		}
+	}
+}
+
+// grpc service tests
+
+func TestCollectorServiceSuccess(t *testing.T) {
+	s := newTestServer()
+	client, cleanup := startTestGRPCServer(t, s)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	testReq := newTestRequest()
+
+	// make grpc call in background
+	resultCh := make(chan *pb.Response, 1)
+	errCh := make(chan error, 1)
+
+	go func() {
+		res, err := client.Collect(ctx, testReq)
+		if err != nil {
+			errCh <- err
+			return
+		}
+		resultCh <- res
+	}()
+
+	// wait a bit for request to be registered
+	time.Sleep(10 * time.Millisecond)
+
+	// check that queue has item
+	status := s.queue.Status()
+	if status.Total != 1 {
+		t.Fatalf("expected 1 item in queue, got %d", status.Total)
+	}
+
+	// get item from queue (simulating web client)
+	item, err := s.queue.GetNext(100 * time.Millisecond)
+	if err != nil {
+		t.Fatalf("failed to get item from queue: %v", err)
+	}
+
+	// add to current (simulating handleData)
+	s.cmu.Lock()
+	s.current[item.ID] = item
+	s.cmu.Unlock()
+
+	// simulate web client submission
+	testRes := newTestResponse()
+	resJSON, err := protojson.Marshal(testRes)
+	if err != nil {
+		t.Fatalf("failed to marshal response: %v", err)
+	}
+
+	// simulate http submission
+	req := httptest.NewRequest("POST", "/submit/"+item.ID, bytes.NewReader(resJSON))
+	w := httptest.NewRecorder()
+	req.SetPathValue("uuid", item.ID)
+
+	s.handleSubmit(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("submit failed: %d: %s", w.Code, w.Body.String())
+	}
+
+	// verify grpc call completed successfully
+	select {
+	case res := <-resultCh:
+		if res == nil {
+			t.Fatal("expected non-nil response")
+		}
+	case err := <-errCh:
+		t.Fatalf("grpc call failed: %v", err)
+	case <-time.After(1 * time.Second):
+		t.Fatal("grpc call timed out")
+	}
+}
+
+func TestCollectorServiceCancellation(t *testing.T) {
+	s := newTestServer()
+	client, cleanup := startTestGRPCServer(t, s)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+
+	testReq := newTestRequest()
+
+	// make grpc call in background
+	errCh := make(chan error, 1)
+
+	go func() {
+		_, err := client.Collect(ctx, testReq)
+		errCh <- err
+	}()
+
+	// wait for request to be registered
+	time.Sleep(10 * time.Millisecond)
+
+	// verify queue has item
+	status := s.queue.Status()
+	if status.Total != 1 {
+		t.Fatalf("expected 1 item in queue, got %d", status.Total)
+	}
+
+	// cancel context
+	cancel()
+
+	// verify grpc call was cancelled
+	select {
+	case err := <-errCh:
+		if err == nil {
+			t.Fatal("expected cancellation error")
+		}
+		// should be a grpc cancellation error
+	case <-time.After(1 * time.Second):
+		t.Fatal("expected cancellation error")
+	}
+
+	// wait a bit for cleanup
+	time.Sleep(10 * time.Millisecond)
+
+	// verify queue was cleaned up (context cancellation removes items)
+	status = s.queue.Status()
+	if status.Total != 0 {
+		t.Fatalf("expected 0 items in queue after cancellation, got %d", status.Total)
+	}
+}
+
+// blackholeProxy forwards tcp between a client and addr until kill is called,
+// after which it silently drops everything in both directions, like a client
+// which has gone away without closing its connection.
+type blackholeProxy struct {
+	lis  net.Listener
+	dead chan struct{}
+}
+
+func newBlackholeProxy(t *testing.T, addr string) *blackholeProxy {
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	p := &blackholeProxy{lis: lis, dead: make(chan struct{})}
+	t.Cleanup(func() { lis.Close() })
+
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			upstream, err := net.Dial("tcp", addr)
+			if err != nil {
+				conn.Close()
+				return
+			}
+			t.Cleanup(func() { conn.Close(); upstream.Close() })
+			go p.pipe(upstream, conn)
+			go p.pipe(conn, upstream)
+		}
+	}()
+
+	return p
+}
+
+func (p *blackholeProxy) pipe(dst io.Writer, src io.Reader) {
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := src.Read(buf)
+		if err != nil {
+			return
+		}
+		select {
+		case <-p.dead:
+			continue
+		default:
+		}
+		if _, err := dst.Write(buf[:n]); err != nil {
+			return
+		}
+	}
+}
+
+func (p *blackholeProxy) kill() { close(p.dead) }
+
+func TestKeepaliveFreesDeadClient(t *testing.T) {
+	s := newTestServer()
+
+	// the shortest ping interval which grpc allows
+	cfg := *config
+	cfg.KeepaliveTime = time.Second
+	cfg.KeepaliveTimeout = 500 * time.Millisecond
+
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	srv := newGRPCServer(&cfg, s)
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	proxy := newBlackholeProxy(t, lis.Addr().String())
+	conn, err := grpc.NewClient(proxy.lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	collectAsync(t, s, pb.NewCollectorClient(conn), ctx, newTestRequest())
+
+	// the client's context is still live, so only the server noticing the
+	// dead connection can free the item
+	proxy.kill()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for s.queue.Status().Total != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected pending item to be removed once the connection died")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// integration tests
+
+func TestCollectorMaxCollectWait(t *testing.T) {
+	s := newTestServer()
+	s.maxCollectWait = 200 * time.Millisecond
+	client, cleanup := startTestGRPCServer(t, s)
+	defer cleanup()
+
+	// no deadline, so only the server's cap can end it
+	errCh := make(chan error, 1)
+	start := time.Now()
+	go func() {
+		_, err := client.Collect(context.Background(), newTestRequest())
+		errCh <- err
+	}()
+
+	select {
+	case err := <-errCh:
+		if status.Code(err) != codes.DeadlineExceeded {
+			t.Fatalf("expected DeadlineExceeded, got %v", err)
+		}
+		if d := time.Since(start); d < s.maxCollectWait {
+			t.Errorf("expected collect to wait at least %v, returned after %v", s.maxCollectWait, d)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected collect to return after the server cap")
+	}
+
+	if got := s.queue.Status().Total; got != 0 {
+		t.Errorf("expected queue slot to be released, got %d items", got)
+	}
+}
+
+func TestSubmitAckWatchdog(t *testing.T) {
+	buf := &lockedBuffer{}
+	orig := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(orig) })
+
+	s := newTestServer()
+	s.submitAckTimeout = 50 * time.Millisecond
+	client, cleanup := startTestGRPCServer(t, s)
+	defer cleanup()
+
+	const warning = "answer not picked up by client"
+
+	// a client which is still waiting takes its answer
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	resCh, errCh := collectAsync(t, s, client, ctx, newTestRequest())
+	u := fetchItem(t, s)
+	if w := submitItem(t, s, u, newTestResponse()); w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	select {
+	case <-resCh:
+	case err := <-errCh:
+		t.Fatalf("collect failed: %v", err)
+	}
+
+	// one which has stopped reading never does
+	s.queue.Enqueue(&QueueItem{
+		ID:       "abandoned",
+		Request:  newTestRequest(),
+		Response: make(chan *pb.Response, 1),
+		AddedAt:  time.Now(),
+		Context:  context.Background(),
+		Acked:    make(chan struct{}),
+	})
+	fetchItem(t, s)
+	if w := submitItem(t, s, "abandoned", newTestResponse()); w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for !strings.Contains(buf.String(), warning) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected a warning about the abandoned answer, got: %s", buf.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	var warned []string
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var rec map[string]any
+		if err := json.Unmarshal([]byte(line), &rec); err == nil && rec["msg"] == warning {
+			warned = append(warned, fmt.Sprint(rec["uuid"]))
+		}
+	}
+	if len(warned) != 1 || warned[0] != "abandoned" {
+		t.Errorf("expected a warning for only the abandoned item, got %v", warned)
+	}
+}
+
+func TestCollectorCancel(t *testing.T) {
+	s := newTestServer()
+	client, cleanup := startTestGRPCServer(t, s)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// the client chooses the uuid, so it knows what to cancel
+	collectCtx := metadata.AppendToOutgoingContext(ctx, "x-collector-uuid", "client-chosen")
+	_, errCh := collectAsync(t, s, client, collectCtx, newTestRequest())
+
+	res, err := client.Cancel(ctx, &pb.CancelRequest{Id: "client-chosen"})
+	if err != nil {
+		t.Fatalf("cancel failed: %v", err)
+	}
+	if !res.Found {
+		t.Fatal("expected pending item to be found")
+	}
+
+	select {
+	case err := <-errCh:
+		if status.Code(err) != codes.Canceled {
+			t.Fatalf("expected Canceled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected collect to return after cancel")
+	}
+
+	if st := s.queue.Status(); st.Total != 0 {
+		t.Fatalf("expected empty queue, got %+v", st)
+	}
+
+	// unknown ids are reported as not found
+	res, err = client.Cancel(ctx, &pb.CancelRequest{Id: "no-such-uuid"})
+	if err != nil {
+		t.Fatalf("cancel failed: %v", err)
+	}
+	if res.Found {
+		t.Fatal("expected unknown item not to be found")
+	}
+}
+
+func TestCollectorCancelServedItem(t *testing.T) {
+	s := newTestServer()
+	client, cleanup := startTestGRPCServer(t, s)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, errCh := collectAsync(t, s, client, ctx, newTestRequest())
+	u := fetchItem(t, s)
+
+	res, err := client.Cancel(ctx, &pb.CancelRequest{Id: u})
+	if err != nil || !res.Found {
+		t.Fatalf("expected served item to be cancelled, got %v, %v", res, err)
+	}
+
+	select {
+	case err := <-errCh:
+		if status.Code(err) != codes.Canceled {
+			t.Fatalf("expected Canceled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected collect to return after cancel")
+	}
+
+	// a late submit finds nothing
+	if w := submitItem(t, s, u, newTestResponse()); w.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404 for cancelled item, got %d", w.Code)
+	}
+}
+
+func TestCollectorUUIDHeader(t *testing.T) {
+	s := newTestServer()
+	client, cleanup := startTestGRPCServer(t, s)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var header metadata.MD
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.Collect(ctx, newTestRequest(), grpc.Header(&header))
+		done <- err
+	}()
+
+	for s.queue.Status().Total == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	u := fetchItem(t, s)
+	submitItem(t, s, u, newTestResponse())
+
+	if err := <-done; err != nil {
+		t.Fatalf("collect failed: %v", err)
+	}
+	if got := header.Get("x-collector-uuid"); len(got) != 1 || got[0] != u {
+		t.Fatalf("expected uuid header %q, got %v", u, got)
+	}
+
+	// reusing a client-chosen uuid is rejected
+	dupCtx := metadata.AppendToOutgoingContext(ctx, "x-collector-uuid", "dup")
+	collectAsync(t, s, client, dupCtx, newTestRequest())
+	_, err := client.Collect(dupCtx, newTestRequest())
+	if status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("expected AlreadyExists, got %v", err)
+	}
+}
+
+func TestCollectClientUUIDInUseElsewhere(t *testing.T) {
+	s := newTestServer()
+	client, cleanup := startTestGRPCServer(t, s)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// queued in a sub-queue, which the default queue knows nothing about
+	queued := metadata.AppendToOutgoingContext(ctx, "x-collector-uuid", "queued")
+	req := newTestRequest()
+	req.QueueName = "teamA"
+	go client.Collect(queued, req)
+
+	deadline := time.Now().Add(time.Second)
+	for !s.findQueue("queued").Contains("queued") {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for collect to be enqueued")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := client.Collect(queued, newTestRequest()); status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("expected AlreadyExists for a uuid queued in another sub-queue, got %v", err)
+	}
+
+	// served, so in no queue at all
+	served := metadata.AppendToOutgoingContext(ctx, "x-collector-uuid", "served")
+	collectAsync(t, s, client, served, newTestRequest())
+	if u := fetchItem(t, s); u != "served" {
+		t.Fatalf("expected served item, got %q", u)
+	}
+	if _, err := client.Collect(served, newTestRequest()); status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("expected AlreadyExists for a served uuid, got %v", err)
+	}
+}
+
+func TestEndToEndFlow(t *testing.T) {
+	s := newTestServer()
+	client, cleanup := startTestGRPCServer(t, s)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	testReq := newTestRequest()
+
+	// start grpc call
+	resultCh := make(chan *pb.Response, 1)
+	errCh := make(chan error, 1)
+
+	go func() {
+		res, err := client.Collect(ctx, testReq)
+		if err != nil {
+			errCh <- err
+			return
+		}
+		resultCh <- res
+	}()
+
+	// wait for request registration
+	time.Sleep(10 * time.Millisecond)
+
+	// simulate web client getting data
+	httpReq := httptest.NewRequest("GET", "/data.json", nil)
+	w := httptest.NewRecorder()
+
+	s.handleData(w, httpReq)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("data request failed: %d: %s", w.Code, w.Body.String())
+	}
+
+	// parse the json response manually since webRequest has custom marshaling
+	var jsonResp map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &jsonResp); err != nil {
+		t.Fatalf("failed to unmarshal web request: %v", err)
+	}
+
+	webReqUUID, ok := jsonResp["uuid"].(string)
+	if !ok {
+		t.Fatal("expected uuid to be a string")
+	}
+
+	protoData, ok := jsonResp["proto"].(map[string]interface{})
+	if !ok {
+		t.Fatal("expected proto to be an object")
+	}
+
+	// verify the request has expected structure
+	inputs, ok := protoData["inputs"].([]interface{})
+	if !ok || len(inputs) != len(testReq.Inputs) {
+		t.Fatalf("expected %d proto inputs, got %v", len(testReq.Inputs), len(inputs))
+	}
+
+	// simulate web client submission
+	testRes := newTestResponse()
+	resJSON, err := protojson.Marshal(testRes)
+	if err != nil {
+		t.Fatalf("failed to marshal response: %v", err)
+	}
+
+	submitReq := httptest.NewRequest("POST", "/submit/"+webReqUUID, bytes.NewReader(resJSON))
+	submitW := httptest.NewRecorder()
+	submitReq.SetPathValue("uuid", webReqUUID)
+
+	s.handleSubmit(submitW, submitReq)
+
+	if submitW.Code != http.StatusOK {
+		t.Fatalf("submit failed: %d: %s", submitW.Code, submitW.Body.String())
+	}
+
+	// verify grpc call completed
+	select {
+	case res := <-resultCh:
+		if res == nil {
+			t.Fatal("expected non-nil response")
+		}
+		// verify response content matches
+		if res.Output == nil {
+			t.Fatal("expected output to be non-nil")
+		}
+	case err := <-errCh:
+		t.Fatalf("grpc call failed: %v", err)
+	case <-time.After(1 * time.Second):
+		t.Fatal("end-to-end flow timed out")
+	}
+}
+
+// additional coverage tests
+
+func TestSubmitComment(t *testing.T) {
+	s := newTestServer()
+	client, cleanup := startTestGRPCServer(t, s)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resultCh, errCh := collectAsync(t, s, client, ctx, newTestRequest())
+	u := fetchItem(t, s)
+
+	res := newTestResponse()
+	res.Output.Comment = "image was blurry"
+	if w := submitItem(t, s, u, res); w.Code != http.StatusOK {
+		t.Fatalf("submit failed: %d: %s", w.Code, w.Body.String())
+	}
+
+	select {
+	case got := <-resultCh:
+		if got.Output.Comment != "image was blurry" {
+			t.Fatalf("expected comment to reach client, got %q", got.Output.Comment)
+		}
+		if got.Output.GetOptionList() == nil {
+			t.Fatal("expected option list output alongside comment")
+		}
+	case err := <-errCh:
+		t.Fatalf("grpc call failed: %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for response")
+	}
+}
+
+func TestSubmitCommentTooLong(t *testing.T) {
+	s := newTestServer()
+	resCh := make(chan *pb.Response, 1)
+	s.current["long-comment"] = &QueueItem{
+		ID:       "long-comment",
+		Request:  newTestRequest(),
+		Response: resCh,
+		AddedAt:  time.Now(),
+		Context:  context.Background(),
+	}
+
+	res := newTestResponse()
+	res.Output.Comment = strings.Repeat("a", 1001)
+	w := submitItem(t, s, "long-comment", res)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d: %s", w.Code, w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), "comment too long") {
+		t.Fatalf("expected comment error, got: %s", w.Body.String())
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	s := newTestServer()
+	handler := s.ServeHTTP()
+
+	if handler == nil {
+		t.Fatal("expected non-nil handler")
+	}
+
+	// test that static files are served
+	req := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	// should get some response (likely 404 since no static files exist)
+	if w.Code == 0 {
+		t.Fatal("expected some response code")
+	}
+}
+
+func TestServeHTTPGzip(t *testing.T) {
+	s := newTestServer()
+	handler := s.ServeHTTP()
+
+	item := &QueueItem{
+		ID:       "gzip-uuid",
+		Request:  newTestRequest(),
+		Response: make(chan *pb.Response, 1),
+		AddedAt:  time.Now(),
+		Context:  context.Background(),
+	}
+	s.queue.Enqueue(item)
+
+	req := httptest.NewRequest("GET", "/data.json", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	if enc := w.Header().Get("Content-Encoding"); enc != "gzip" {
+		t.Fatalf("expected gzip content encoding, got %q", enc)
+	}
+
+	gr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatalf("failed to create gzip reader: %v", err)
+	}
+	b, err := io.ReadAll(gr)
+	if err != nil {
+		t.Fatalf("failed to decompress body: %v", err)
+	}
+
+	var jsonResp map[string]interface{}
+	if err := json.Unmarshal(b, &jsonResp); err != nil {
+		t.Fatalf("failed to unmarshal decompressed body: %v", err)
+	}
+	if jsonResp["uuid"] != "gzip-uuid" {
+		t.Fatalf("expected uuid gzip-uuid, got %v", jsonResp["uuid"])
+	}
+
+	// clients which don't ask for gzip get plain json
+	req = httptest.NewRequest("GET", "/queue/status", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if enc := w.Header().Get("Content-Encoding"); enc != "" {
+		t.Fatalf("expected no content encoding, got %q", enc)
+	}
+	if !json.Valid(w.Body.Bytes()) {
+		t.Fatalf("expected plain json body, got: %s", w.Body.String())
+	}
+}
+
+func TestWithGzipSkips(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n" + strings.Repeat("\x00", 64))
+
+	tests := []struct {
+		name     string
+		method   string
+		handler  http.HandlerFunc
+		wantCode int
+		wantGzip bool
+		wantBody []byte
+	}{
+		{
+			name:   "json",
+			method: "GET",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`{"ok":true}`))
+			},
+			wantCode: http.StatusOK,
+			wantGzip: true,
+		},
+		{
+			name:   "no content",
+			method: "GET",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			},
+			wantCode: http.StatusNoContent,
+		},
+		{
+			name:   "not modified",
+			method: "GET",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNotModified)
+			},
+			wantCode: http.StatusNotModified,
+		},
+		{
+			name:   "status without a body",
+			method: "GET",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusAccepted)
+			},
+			wantCode: http.StatusAccepted,
+		},
+		{
+			name:   "sniffed image",
+			method: "GET",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write(png)
+			},
+			wantCode: http.StatusOK,
+			wantBody: png,
+		},
+		{
+			name:   "head",
+			method: "HEAD",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+			},
+			wantCode: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/", nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			w := httptest.NewRecorder()
+			withGzip(tt.handler).ServeHTTP(w, req)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("expected status %d, got %d", tt.wantCode, w.Code)
+			}
+			if got := w.Header().Get("Content-Encoding") == "gzip"; got != tt.wantGzip {
+				t.Fatalf("expected gzip %v, got %v", tt.wantGzip, got)
+			}
+			if tt.wantBody != nil && !bytes.Equal(w.Body.Bytes(), tt.wantBody) {
+				t.Errorf("expected the body to be sent as it is, got %q", w.Body.Bytes())
+			}
+		})
+	}
+}
+
+func TestServeHTTPCORS(t *testing.T) {
+	config.AllowedOrigins = []string{"http://localhost:5173"}
+	t.Cleanup(func() { config.AllowedOrigins = nil })
+
+	s := newTestServer()
+	handler := s.ServeHTTP()
+
+	// allowed origin gets the header
+	req := httptest.NewRequest("GET", "/queue/status", nil)
+	req.Header.Set("Origin", "http://localhost:5173")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "http://localhost:5173" {
+		t.Fatalf("expected allow-origin header, got %q", got)
+	}
+
+	// disallowed origin doesn't
+	req = httptest.NewRequest("GET", "/queue/status", nil)
+	req.Header.Set("Origin", "http://evil.example")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Fatalf("expected no allow-origin header, got %q", got)
+	}
+
+	// preflight for a mutating route is answered directly
+	req = httptest.NewRequest("OPTIONS", "/submit/some-uuid", nil)
+	req.Header.Set("Origin", "http://localhost:5173")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	req.Header.Set("Access-Control-Request-Headers", "content-type, x-annotator-id")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected status 204 for preflight, got %d", w.Code)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Methods"); !strings.Contains(got, "POST") {
+		t.Fatalf("expected POST in allowed methods, got %q", got)
+	}
+	allowed := strings.ToLower(w.Header().Get("Access-Control-Allow-Headers"))
+	for _, h := range []string{"content-type", "x-annotator-id"} {
+		if !strings.Contains(allowed, h) {
+			t.Errorf("expected %s in allowed headers, got %q", h, allowed)
+		}
+	}
+}
+
+func TestWebRequestMarshalJSON(t *testing.T) {
+	testReq := newTestRequest()
+	webReq := webRequest{
+		UUID:  "test-uuid",
+		Proto: testReq,
+		Queue: QueueStatus{Total: 1, Active: 1, Deferred: 0},
+	}
+
+	data, err := json.Marshal(webReq)
+	if err != nil {
+		t.Fatalf("failed to marshal webRequest: %v", err)
+	}
+
+	// verify it produces json with uuid, proto, and queue fields
+	var result map[string]interface{}
+	if err := json.Unmarshal(data, &result); err != nil {
+		t.Fatalf("failed to unmarshal result: %v", err)
+	}
+
+	if result["uuid"] != "test-uuid" {
+		t.Fatalf("expected uuid field, got: %v", result["uuid"])
+	}
+
+	if result["proto"] == nil {
+		t.Fatal("expected proto field")
+	}
+
+	if result["queue"] == nil {
+		t.Fatal("expected queue field")
+	}
+}
+
+func TestWebRequestMarshalJSONPrompt(t *testing.T) {
+	testReq := newTestRequest()
+	testReq.Title = "Weld inspection"
+	testReq.Prompt = "These are weld seams; mark defects."
+
+	data, err := json.Marshal(&webRequest{
+		UUID:  "test-uuid",
+		Proto: testReq,
+	})
+	if err != nil {
+		t.Fatalf("failed to marshal webRequest: %v", err)
+	}
+
+	var result map[string]interface{}
+	if err := json.Unmarshal(data, &result); err != nil {
+		t.Fatalf("failed to unmarshal result: %v", err)
+	}
+
+	protoData := result["proto"].(map[string]interface{})
+	if protoData["title"] != testReq.Title {
+		t.Fatalf("expected title %q, got %v", testReq.Title, protoData["title"])
+	}
+	if protoData["prompt"] != testReq.Prompt {
+		t.Fatalf("expected prompt %q, got %v", testReq.Prompt, protoData["prompt"])
+	}
+}
+
+func TestWebRequestMarshalJSONLayoutHint(t *testing.T) {
+	testReq := newTestRequest()
+	testReq.Inputs[0].LayoutHint = pb.LayoutHint_FULL_WIDTH
+
+	data, err := json.Marshal(&webRequest{UUID: "test-uuid", Proto: testReq})
+	if err != nil {
+		t.Fatalf("failed to marshal webRequest: %v", err)
+	}
+
+	if !strings.Contains(string(data), `"layoutHint":"FULL_WIDTH"`) {
+		t.Fatalf("expected layout hint in json, got: %s", data)
+	}
+}
+
+func TestEstimatedSeconds(t *testing.T) {
+	buf := &lockedBuffer{}
+	orig := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(orig) })
+
+	s := newTestServer()
+	client, cleanup := startTestGRPCServer(t, s)
+	defer cleanup()
+
+	before := getStats().ShortDeadlines
+
+	// a 30s task with a 5s deadline is likely to time out
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req := newTestRequest()
+	req.EstimatedSeconds = 30
+	collectAsync(t, s, client, ctx, req)
+
+	w := httptest.NewRecorder()
+	s.handleData(w, httptest.NewRequest("GET", "/data.json", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("data request failed: %d: %s", w.Code, w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), `"estimated_seconds":30`) {
+		t.Errorf("expected estimated seconds in json, got: %s", w.Body.String())
+	}
+
+	if !strings.Contains(buf.String(), "collect deadline shorter than estimated duration") {
+		t.Errorf("expected short deadline warning, got: %s", buf.String())
+	}
+	if got := getStats().ShortDeadlines - before; got != 1 {
+		t.Errorf("expected short deadline count to increase by 1, got %d", got)
+	}
+}
+
+func TestOptionDescription(t *testing.T) {
+	s := newTestServer()
+
+	req := newTestRequest()
+	req.Output.GetOptionList().Options[1].Description = "halts all motors immediately"
+	s.queue.Enqueue(&QueueItem{
+		ID:       "desc",
+		Request:  req,
+		Response: make(chan *pb.Response, 1),
+		AddedAt:  time.Now(),
+		Context:  context.Background(),
+	})
+
+	w := httptest.NewRecorder()
+	s.handleData(w, httptest.NewRequest("GET", "/data.json", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("data request failed: %d: %s", w.Code, w.Body.String())
+	}
+
+	if !strings.Contains(w.Body.String(), `"description":"halts all motors immediately"`) {
+		t.Errorf("expected description in json, got: %s", w.Body.String())
+	}
+}
+
+func TestWebRequestMarshalJSONEmitsDefaults(t *testing.T) {
+	testReq := newTestRequest()
+	testReq.Inputs = []*pb.Input{
+		{
+			Visualization: &pb.Input_Scalar{
+				Scalar: &pb.Scalar{Label: "speed", Min: 0, Max: 10},
+			},
+			Data: &pb.Data{
+				Data: &pb.Data_Floats{Floats: &pb.Floats{Values: []float64{0}}},
+			},
+		},
+	}
+
+	data, err := json.Marshal(&webRequest{UUID: "test-uuid", Proto: testReq})
+	if err != nil {
+		t.Fatalf("failed to marshal webRequest: %v", err)
+	}
+
+	var result map[string]interface{}
+	if err := json.Unmarshal(data, &result); err != nil {
+		t.Fatalf("failed to unmarshal result: %v", err)
+	}
+
+	input := result["proto"].(map[string]interface{})["inputs"].([]interface{})[0].(map[string]interface{})
+	scalar := input["scalar"].(map[string]interface{})
+	min, ok := scalar["min"]
+	if !ok {
+		t.Fatalf("expected min key to be present, got: %v", scalar)
+	}
+	if min != 0.0 {
+		t.Fatalf("expected min 0, got %v", min)
+	}
+	if _, ok := scalar["unit"]; !ok {
+		t.Fatalf("expected empty unit key to be present, got: %v", scalar)
+	}
+}
+
+func TestHandleQueueStatus(t *testing.T) {
+	s := newTestServer()
+
+	// add some items to queue
+	for i := 0; i < 3; i++ {
+		item := &QueueItem{
+			ID:       fmt.Sprintf("test-%d", i),
+			Request:  newTestRequest(),
+			Response: make(chan *pb.Response, 1),
+			AddedAt:  time.Now(),
+			Context:  context.Background(),
+		}
+		s.queue.Enqueue(item)
+	}
+
+	req := httptest.NewRequest("GET", "/queue/status", nil)
+	w := httptest.NewRecorder()
+
+	s.handleQueueStatus(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	var status QueueStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatalf("failed to unmarshal status: %v", err)
+	}
+
+	if status.Total != 3 {
+		t.Fatalf("expected total 3, got %d", status.Total)
+	}
+	if status.Active != 3 {
+		t.Fatalf("expected active 3, got %d", status.Active)
+	}
+}
+
+// errorReader always returns an error when read
+type errorReader struct{}
+
+func (er *errorReader) Read(p []byte) (n int, err error) {
+	return 0, fmt.Errorf("simulated read error")
+}
+
+func TestHandleSubmitReadError(t *testing.T) {
+	s := newTestServer()
+
+	// create queue item and add to current
+	testReq := newTestRequest()
+	resCh := make(chan *pb.Response, 1)
+	testUUID := "test-uuid"
+	item := &QueueItem{
+		ID:       testUUID,
+		Request:  testReq,
+		Response: resCh,
+		AddedAt:  time.Now(),
+		Context:  context.Background(),
+	}
+
+	s.cmu.Lock()
+	s.current[testUUID] = item
+	s.cmu.Unlock()
+
+	// create a request with an error reader
+	errorReader := &errorReader{}
+	req := httptest.NewRequest("POST", "/submit/"+testUUID, errorReader)
+	w := httptest.NewRecorder()
+
+	req.SetPathValue("uuid", testUUID)
+
+	s.handleSubmit(w, req)
+
+	// should get an internal server error due to read failure
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected status 500 for read error, got %d", w.Code)
+	}
+}
+
+func TestHandleSubmitTooLarge(t *testing.T) {
+	s := newTestServer()
+	s.maxSubmitBytes = 1024
+
+	testUUID := "test-uuid"
+	s.cmu.Lock()
+	s.current[testUUID] = &QueueItem{
+		ID:       testUUID,
+		Request:  newTestRequest(),
+		Response: make(chan *pb.Response, 1),
+		AddedAt:  time.Now(),
+		Context:  context.Background(),
+	}
+	s.cmu.Unlock()
+
+	// a valid response padded past the limit with a long comment
+	res := newTestResponse()
+	res.Output.Comment = strings.Repeat("x", 2048)
+	b, err := protojson.Marshal(res)
+	if err != nil {
+		t.Fatalf("failed to marshal response: %v", err)
+	}
+
+	req := httptest.NewRequest("POST", "/submit/"+testUUID, bytes.NewReader(b))
+	req.SetPathValue("uuid", testUUID)
+	w := httptest.NewRecorder()
+	s.handleSubmit(w, req)
+
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected status 413, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// the item stays pending, so a smaller resubmit can succeed
+	if w := submitItem(t, s, testUUID, newTestResponse()); w.Code != http.StatusOK {
+		t.Fatalf("expected status 200 for resubmit, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+// validation tests
+
+func TestValidateRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     *pb.Request
 		wantErr bool
 		errMsg  string
 	}{
 		{
-			name:    "nil grid",
-			grid:    nil,
-			data:    &pb.Data{},
-			wantErr: true,
-			errMsg:  "grid cannot be nil",
-		},
-		{
-			name:    "zero rows",
-			grid:    &pb.Grid{Rows: 0, Cols: 5},
-			data:    &pb.Data{},
-			wantErr: true,
-			errMsg:  "grid dimensions must be positive",
-		},
-		{
-			name:    "zero cols",
-			grid:    &pb.Grid{Rows: 5, Cols: 0},
-			data:    &pb.Data{},
-			wantErr: true,
-			errMsg:  "grid dimensions must be positive",
-		},
-		{
-			name:    "negative rows",
-			grid:    &pb.Grid{Rows: -1, Cols: 5},
-			data:    &pb.Data{},
-			wantErr: true,
-			errMsg:  "grid dimensions must be positive",
-		},
-		{
-			name:    "too large grid",
-			grid:    &pb.Grid{Rows: 101, Cols: 50},
-			data:    &pb.Data{},
-			wantErr: true,
-			errMsg:  "grid too large",
-		},
-		{
-			name:    "nil data",
-			grid:    &pb.Grid{Rows: 2, Cols: 2},
-			data:    nil,
-			wantErr: true,
-			errMsg:  "data is required",
-		},
-		{
-			name:    "nil data type",
-			grid:    &pb.Grid{Rows: 2, Cols: 2},
-			data:    &pb.Data{Data: nil},
-			wantErr: true,
-			errMsg:  "data type is required",
-		},
-		{
-			name: "nil ints data",
-			grid: &pb.Grid{Rows: 2, Cols: 2},
-			data: &pb.Data{
-				Data: &pb.Data_Ints{Ints: nil},
-			},
-			wantErr: true,
-			errMsg:  "ints data cannot be nil",
-		},
-		{
-			name: "wrong ints size",
-			grid: &pb.Grid{Rows: 2, Cols: 2},
-			data: &pb.Data{
-				Data: &pb.Data_Ints{
-					Ints: &pb.Ints{Values: []int64{1, 2, 3}}, // should be 4
-				},
-			},
-			wantErr: true,
-			errMsg:  "data size 3 doesn't match grid size 4",
-		},
-		{
-			name: "nil floats data",
-			grid: &pb.Grid{Rows: 2, Cols: 2},
-			data: &pb.Data{
-				Data: &pb.Data_Floats{Floats: nil},
-			},
-			wantErr: true,
-			errMsg:  "floats data cannot be nil",
-		},
-		{
-			name: "wrong floats size",
-			grid: &pb.Grid{Rows: 2, Cols: 2},
-			data: &pb.Data{
-				Data: &pb.Data_Floats{
-					Floats: &pb.Floats{Values: []float64{1.0, 2.0, 3.0}}, // should be 4
-				},
-			},
-			wantErr: true,
-			errMsg:  "data size 3 doesn't match grid size 4",
-		},
-		{
-			name: "valid ints",
-			grid: &pb.Grid{Rows: 2, Cols: 2},
-			data: &pb.Data{
-				Data: &pb.Data_Ints{
-					Ints: &pb.Ints{Values: []int64{1, 2, 3, 4}},
-				},
-			},
-			wantErr: false,
-		},
-		{
-			name: "valid floats",
-			grid: &pb.Grid{Rows: 2, Cols: 2},
-			data: &pb.Data{
-				Data: &pb.Data_Floats{
-					Floats: &pb.Floats{Values: []float64{1.0, 2.0, 3.0, 4.0}},
-				},
+			name:    "nil request",
+			req:     nil,
+			wantErr: true,
+			errMsg:  "request cannot be nil",
+		},
+		{
+			name: "empty inputs",
+			req: &pb.Request{
+				Inputs: []*pb.Input{},
+				Output: &pb.OutputSchema{
+					Output: &pb.OutputSchema_OptionList{
+						OptionList: &pb.OptionListSchema{
+							Options: []*pb.Option{
+								{Label: "Option 1", Hotkey: "1"},
+								{Label: "Option 2", Hotkey: "2"},
+							},
+						},
+					},
+				},
+			},
+			wantErr: true,
+			errMsg:  "request must have at least one input",
+		},
+		{
+			name: "prompt too long",
+			req: func() *pb.Request {
+				r := newTestRequest()
+				r.Prompt = strings.Repeat("a", 2001)
+				return r
+			}(),
+			wantErr: true,
+			errMsg:  "prompt too long (max 2000 characters, got 2001)",
+		},
+		{
+			name: "title too long",
+			req: func() *pb.Request {
+				r := newTestRequest()
+				r.Title = strings.Repeat("a", 201)
+				return r
+			}(),
+			wantErr: true,
+			errMsg:  "title too long (max 200 characters, got 201)",
+		},
+		{
+			name: "prompt at limit",
+			req: func() *pb.Request {
+				r := newTestRequest()
+				r.Title = "Weld inspection"
+				r.Prompt = strings.Repeat("é", 2000)
+				return r
+			}(),
+			wantErr: false,
+		},
+		{
+			name:    "valid request",
+			req:     newTestRequest(),
+			wantErr: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validate(tt.req, validationStrict)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr && !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("expected error containing %q, got %v", tt.errMsg, err)
+			}
+		})
+	}
+}
+
+func TestValidateMaxInputs(t *testing.T) {
+	withInputs := func(n int) *pb.Request {
+		r := newTestRequest()
+		for len(r.Inputs) < n {
+			r.Inputs = append(r.Inputs, r.Inputs[0])
+		}
+		return r
+	}
+
+	if err := validate(withInputs(config.MaxInputsPerRequest), validationStrict); err != nil {
+		t.Errorf("expected %d inputs to pass, got: %v", config.MaxInputsPerRequest, err)
+	}
+
+	err := validate(withInputs(config.MaxInputsPerRequest+1), validationStrict)
+	if err == nil || !strings.Contains(err.Error(), "request has too many inputs (max 20, got 21)") {
+		t.Errorf("expected too many inputs error, got: %v", err)
+	}
+
+	cfg := *config
+	cfg.MaxInputsPerRequest = 2
+	reloadLimits(&cfg)
+	t.Cleanup(func() { reloadLimits(config) })
+
+	if err := validate(withInputs(2), validationStrict); err != nil {
+		t.Errorf("expected 2 inputs to pass, got: %v", err)
+	}
+	if err := validate(withInputs(3), validationStrict); err == nil {
+		t.Error("expected 3 inputs to fail with a limit of 2")
+	}
+}
+
+func TestReloadLimits(t *testing.T) {
+	s := newTestServer()
+	client, cleanup := startTestGRPCServer(t, s)
+	defer cleanup()
+	t.Cleanup(func() { reloadLimits(config) })
+
+	req := newTestRequest()
+	req.Inputs = append(req.Inputs, req.Inputs[0], req.Inputs[0])
+	if err := validate(req, validationStrict); err != nil {
+		t.Fatalf("expected 3 inputs to pass before reload, got: %v", err)
+	}
+
+	t.Setenv("MAX_INPUTS_PER_REQUEST", "2")
+	w := httptest.NewRecorder()
+	s.ServeHTTP().ServeHTTP(w, httptest.NewRequest("POST", "/admin/reload", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), `"max_inputs_per_request":2`) {
+		t.Errorf("expected reloaded limit in response, got: %s", w.Body.String())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	_, err := client.Collect(ctx, req)
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument after reload, got: %v", err)
+	}
+	if !strings.Contains(err.Error(), "too many inputs (max 2, got 3)") {
+		t.Errorf("expected new limit in error, got: %v", err)
+	}
+}
+
+func TestReloadLimitsFailsAcceptedCollect(t *testing.T) {
+	s := newTestServer()
+	client, cleanup := startTestGRPCServer(t, s)
+	defer cleanup()
+	t.Cleanup(func() { reloadLimits(config) })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// accepted under the old limit, then the limit is lowered before it's served
+	req := newTestRequest()
+	req.Inputs = append(req.Inputs, req.Inputs[0], req.Inputs[0])
+	_, errCh := collectAsync(t, s, client, ctx, req)
+
+	t.Setenv("MAX_INPUTS_PER_REQUEST", "2")
+	s.ServeHTTP().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/admin/reload", nil))
+
+	w := httptest.NewRecorder()
+	s.handleData(w, httptest.NewRequest("GET", "/data.json", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// the Collect fails now, rather than waiting for its deadline
+	select {
+	case err := <-errCh:
+		if status.Code(err) != codes.InvalidArgument {
+			t.Fatalf("expected InvalidArgument, got %v", err)
+		}
+		if !strings.Contains(err.Error(), "too many inputs (max 2, got 3)") {
+			t.Errorf("expected new limit in error, got: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected collect to fail promptly")
+	}
+}
+
+func TestReloadLimitsFailsSessionItem(t *testing.T) {
+	s := newTestServer()
+	client, cleanup := startTestGRPCServer(t, s)
+	defer cleanup()
+	t.Cleanup(func() { reloadLimits(config) })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req := newTestRequest()
+	req.Inputs = append(req.Inputs, req.Inputs[0], req.Inputs[0])
+	_, badCh := collectAsync(t, s, client, ctx, req)
+	collectAsync(t, s, client, ctx, newTestRequest())
+
+	t.Setenv("MAX_INPUTS_PER_REQUEST", "2")
+	s.ServeHTTP().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/admin/reload", nil))
+
+	stream, err := client.Session(ctx)
+	if err != nil {
+		t.Fatalf("failed to open session: %v", err)
+	}
+	defer stream.CloseSend()
+
+	select {
+	case err := <-badCh:
+		if status.Code(err) != codes.InvalidArgument {
+			t.Fatalf("expected InvalidArgument, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected collect to fail promptly")
+	}
+
+	// and the session serves the next, valid, item instead
+	item, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("failed to receive item: %v", err)
+	}
+	if len(item.Request.GetInputs()) != 1 {
+		t.Errorf("expected the valid item to be sent, got %v", item)
+	}
+}
+
+func TestRegisterValidator(t *testing.T) {
+	var calls []string
+	t.Cleanup(RegisterValidator(func(req *pb.Request) error {
+		calls = append(calls, "square")
+		for i, input := range req.Inputs {
+			if g := input.GetGrid(); g != nil && g.Rows != g.Cols {
+				return fmt.Errorf("input %d: grid must be square (got %dx%d)", i, g.Rows, g.Cols)
+			}
+		}
+		return nil
+	}))
+	t.Cleanup(RegisterValidator(func(req *pb.Request) error {
+		calls = append(calls, "second")
+		return nil
+	}))
+
+	if err := validate(newTestRequest(), validationStrict); err != nil {
+		t.Fatalf("expected 10x10 grid to pass, got: %v", err)
+	}
+	if strings.Join(calls, ",") != "square,second" {
+		t.Errorf("expected hooks to run in registration order, got %v", calls)
+	}
+
+	calls = nil
+	req := newTestRequest()
+	req.Inputs[0].Visualization = &pb.Input_Grid{Grid: &pb.Grid{Rows: 10, Cols: 8}}
+	req.Inputs[0].Data = &pb.Data{Data: &pb.Data_Ints{Ints: &pb.Ints{Values: make([]int64, 80)}}}
+
+	err := validate(req, validationStrict)
+	if err == nil || !strings.Contains(err.Error(), "grid must be square (got 10x8)") {
+		t.Fatalf("expected square grid hook to reject 10x8 grid, got: %v", err)
+	}
+	if strings.Join(calls, ",") != "square" {
+		t.Errorf("expected the first error to short-circuit, got %v", calls)
+	}
+
+	// hooks apply to grpc requests too
+	s := newTestServer()
+	client, cleanup := startTestGRPCServer(t, s)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := client.Collect(ctx, req); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument from Collect, got: %v", err)
+	}
+}
+
+func TestCollectorValidationFailure(t *testing.T) {
+	s := newTestServer()
+	client, cleanup := startTestGRPCServer(t, s)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	// create invalid request
+	badReq := &pb.Request{
+		Inputs: []*pb.Input{}, // empty inputs should fail validation
+		Output: &pb.OutputSchema{
+			Output: &pb.OutputSchema_OptionList{
+				OptionList: &pb.OptionListSchema{
+					Options: []*pb.Option{
+						{Label: "Option 1", Hotkey: "1"},
+						{Label: "Option 2", Hotkey: "2"},
+					},
+				},
+			},
+		},
+	}
+
+	_, err := client.Collect(ctx, badReq)
+	if err == nil {
+		t.Fatal("expected validation error")
+	}
+
+	// should be grpc invalid argument error
+	if !strings.Contains(err.Error(), "request must have at least one input") {
+		t.Fatalf("expected validation error message, got: %v", err)
+	}
+}
+
+// lockedBuffer is a bytes.Buffer which is safe to write from a grpc handler
+// while the test reads it.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestCollectorDebugMetadata(t *testing.T) {
+	buf := &lockedBuffer{}
+	orig := debugLog
+	debugLog = newLogger(buf, logFormatText, slog.LevelDebug)
+	t.Cleanup(func() { debugLog = orig })
+
+	s := newTestServer()
+	client, cleanup := startTestGRPCServer(t, s)
+	defer cleanup()
+
+	// empty inputs fail validation, so the call returns without a submission.
+	req := &pb.Request{
+		Title: "debug me",
+		Output: &pb.OutputSchema{
+			Output: &pb.OutputSchema_OptionList{
+				OptionList: &pb.OptionListSchema{
+					Options: []*pb.Option{
+						{Label: "Option 1", Hotkey: "1"},
+						{Label: "Option 2", Hotkey: "2"},
+					},
+				},
+			},
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	if _, err := client.Collect(ctx, req); err == nil {
+		t.Fatal("expected validation error")
+	}
+	if got := buf.String(); got != "" {
+		t.Fatalf("expected no debug logs without header, got: %s", got)
+	}
+
+	ctx = metadata.NewOutgoingContext(ctx, metadata.Pairs(debugMetadataKey, "true"))
+	if _, err := client.Collect(ctx, req); err == nil {
+		t.Fatal("expected validation error")
+	}
+
+	got := buf.String()
+	if !strings.Contains(got, "level=DEBUG") || !strings.Contains(got, "collect request debug") {
+		t.Errorf("expected debug log line, got: %s", got)
+	}
+	if !strings.Contains(got, "debug me") {
+		t.Errorf("expected request dump in debug log, got: %s", got)
+	}
+}
+
+func TestValidationInterceptor(t *testing.T) {
+	s := newTestServer()
+
+	var calls int
+	spy := func(ctx context.Context, req any) (any, error) {
+		calls++
+		return &pb.Response{}, nil
+	}
+
+	bad := newTestRequest()
+	bad.Inputs = nil
+	collect := &grpc.UnaryServerInfo{FullMethod: pb.Collector_Collect_FullMethodName}
+
+	_, err := s.validationInterceptor(context.Background(), bad, collect, spy)
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument, got %v", err)
+	}
+	if calls != 0 {
+		t.Fatalf("expected handler not to run for an invalid request, ran %d times", calls)
+	}
+
+	if _, err := s.validationInterceptor(context.Background(), newTestRequest(), collect, spy); err != nil {
+		t.Fatalf("expected valid request to pass, got %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("expected handler to run once, ran %d times", calls)
+	}
+
+	// other methods are left to their handlers
+	other := &grpc.UnaryServerInfo{FullMethod: pb.Collector_Cancel_FullMethodName}
+	if _, err := s.validationInterceptor(context.Background(), &pb.CancelRequest{}, other, spy); err != nil {
+		t.Fatalf("expected other method to pass, got %v", err)
+	}
+	if calls != 2 {
+		t.Fatalf("expected handler to run twice, ran %d times", calls)
+	}
+}
+
+func TestCollectorLogsValidationFieldPath(t *testing.T) {
+	var buf bytes.Buffer
+	orig := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(orig) })
+
+	req := newTestRequest()
+	req.Inputs = append(req.Inputs, &pb.Input{
+		Visualization: &pb.Input_Grid{Grid: &pb.Grid{Rows: 0, Cols: 10}},
+		Data:          &pb.Data{},
+	})
+
+	s := newTestServer()
+	cs := &collectorServer{s: s}
+	info := &grpc.UnaryServerInfo{FullMethod: pb.Collector_Collect_FullMethodName}
+	handler := func(ctx context.Context, req any) (any, error) {
+		return cs.Collect(ctx, req.(*pb.Request))
+	}
+	if _, err := s.validationInterceptor(context.Background(), req, info, handler); err == nil {
+		t.Fatal("expected validation error")
+	}
+
+	var found bool
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var rec map[string]any
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("failed to parse log line %q: %v", line, err)
+		}
+		if rec["msg"] != "collect request invalid" {
+			continue
+		}
+
+		found = true
+		if rec["level"] != "WARN" {
+			t.Errorf("expected WARN level, got %v", rec["level"])
+		}
+		if rec["field"] != "input 1 / grid" {
+			t.Errorf("expected field path %q, got %v", "input 1 / grid", rec["field"])
+		}
+		if msg, _ := rec["error"].(string); !strings.Contains(msg, "grid dimensions must be positive") {
+			t.Errorf("expected error attribute, got %v", rec["error"])
+		}
+	}
+
+	if !found {
+		t.Fatalf("expected validation failure log record, got: %s", buf.String())
+	}
+}
+
+// error helper tests
+
+func TestErrorHelpers(t *testing.T) {
+	t.Run("notFoundError", func(t *testing.T) {
+		err := notFoundError("request", "test-uuid")
+		if err == nil {
+			t.Fatal("expected error, got nil")
+		}
+
+		st := status.Convert(err)
+		if st.Code().String() != "NotFound" {
+			t.Errorf("expected status NotFound, got %s", st.Code().String())
+		}
+
+		if !strings.Contains(st.Message(), "request not found: test-uuid") {
+			t.Errorf("expected message to contain 'request not found: test-uuid', got: %s", st.Message())
+		}
+	})
+
+	t.Run("timeoutError", func(t *testing.T) {
+		err := timeoutError("collect")
+		if err == nil {
+			t.Fatal("expected error, got nil")
+		}
+
+		st := status.Convert(err)
+		if st.Code().String() != "DeadlineExceeded" {
+			t.Errorf("expected status DeadlineExceeded, got %s", st.Code().String())
+		}
+
+		if !strings.Contains(st.Message(), "collect timed out") {
+			t.Errorf("expected message to contain 'collect timed out', got: %s", st.Message())
+		}
+	})
+
+	t.Run("internalError", func(t *testing.T) {
+		testErr := fmt.Errorf("something went wrong")
+		err := internalError(testErr)
+		if err == nil {
+			t.Fatal("expected error, got nil")
+		}
+
+		st := status.Convert(err)
+		if st.Code().String() != "Internal" {
+			t.Errorf("expected status Internal, got %s", st.Code().String())
+		}
+
+		if !strings.Contains(st.Message(), "internal error: something went wrong") {
+			t.Errorf("expected message to contain 'internal error: something went wrong', got: %s", st.Message())
+		}
+	})
+
+	t.Run("resourceExhaustedError", func(t *testing.T) {
+		err := resourceExhaustedError(rejectQueueFull, "queue")
+		if err == nil {
+			t.Fatal("expected error, got nil")
+		}
+
+		st := status.Convert(err)
+		if st.Code().String() != "ResourceExhausted" {
+			t.Errorf("expected status ResourceExhausted, got %s", st.Code().String())
+		}
+
+		if !strings.Contains(st.Message(), "queue limit exceeded") {
+			t.Errorf("expected message to contain 'queue limit exceeded', got: %s", st.Message())
+		}
+	})
+}
+
+func TestMetricsQueueFull(t *testing.T) {
+	s := newTestServer()
+	client, cleanup := startTestGRPCServer(t, s)
+	defer cleanup()
+
+	orig := config.MaxPendingRequests
+	config.MaxPendingRequests = 1
+	t.Cleanup(func() { config.MaxPendingRequests = orig })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	before := getStats()
+	collectAsync(t, s, client, ctx, newTestRequest())
+
+	_, err := client.Collect(ctx, newTestRequest())
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("expected ResourceExhausted, got %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	s.handleMetrics(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	var metrics struct {
+		Rejections map[string]int64 `json:"rejections"`
+		InFlight   int64            `json:"in_flight"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &metrics); err != nil {
+		t.Fatalf("failed to unmarshal metrics: %v", err)
+	}
+
+	if got := metrics.Rejections["queue_full"]; got != before.QueueFull+1 {
+		t.Fatalf("expected queue_full %d, got %d", before.QueueFull+1, got)
+	}
+	if got := metrics.Rejections["rate_limited"]; got != before.RateLimited {
+		t.Fatalf("expected rate_limited unchanged at %d, got %d", before.RateLimited, got)
+	}
+	if metrics.InFlight != before.InFlight+1 {
+		t.Fatalf("expected in_flight %d, got %d", before.InFlight+1, metrics.InFlight)
+	}
+}
+
+func TestSweepCurrent(t *testing.T) {
+	s := newTestServer()
+	s.currentTimeout = time.Minute
+
+	goneCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	now := time.Now()
+	s.current["gone"] = &QueueItem{ID: "gone", Request: newTestRequest(), Context: goneCtx, ServedAt: now}
+	s.current["abandoned"] = &QueueItem{ID: "abandoned", Request: newTestRequest(), Context: context.Background(), ServedAt: now.Add(-2 * time.Minute)}
+	s.current["active"] = &QueueItem{ID: "active", Request: newTestRequest(), Context: context.Background(), ServedAt: now.Add(-30 * time.Second)}
+
+	expired, requeued := s.sweepCurrent(now)
+	if expired != 1 || requeued != 1 {
+		t.Fatalf("expected 1 expired and 1 requeued, got %d and %d", expired, requeued)
+	}
+
+	if _, ok := s.current["active"]; !ok {
+		t.Error("expected active item to remain in current")
+	}
+	if len(s.current) != 1 {
+		t.Errorf("expected only the active item in current, got %d items", len(s.current))
+	}
+
+	// the abandoned item is claimable again
+	if u := fetchItem(t, s); u != "abandoned" {
+		t.Errorf("expected abandoned item to be served again, got %q", u)
+	}
+}
+
+func TestSweepCurrentDisabled(t *testing.T) {
+	s := newTestServer()
+	s.currentTimeout = 0
+
+	s.current["old"] = &QueueItem{ID: "old", Request: newTestRequest(), Context: context.Background(), ServedAt: time.Now().Add(-24 * time.Hour)}
+
+	if expired, requeued := s.sweepCurrent(time.Now()); expired != 0 || requeued != 0 {
+		t.Fatalf("expected nothing swept, got %d expired and %d requeued", expired, requeued)
+	}
+	if _, ok := s.current["old"]; !ok {
+		t.Error("expected item to remain in current")
+	}
+}
+
+func TestSweepCurrentCollectStillAnswered(t *testing.T) {
+	s := newTestServer()
+	s.currentTimeout = time.Minute
+	client, cleanup := startTestGRPCServer(t, s)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resCh, errCh := collectAsync(t, s, client, ctx, newTestRequest())
+
+	// served to a labeller who never submits
+	u := fetchItem(t, s)
+	if _, requeued := s.sweepCurrent(time.Now().Add(2 * time.Minute)); requeued != 1 {
+		t.Fatalf("expected 1 requeued, got %d", requeued)
+	}
+
+	// the next labeller gets it, and their answer reaches the blocked client
+	if got := fetchItem(t, s); got != u {
+		t.Fatalf("expected %q to be served again, got %q", u, got)
+	}
+	if w := submitItem(t, s, u, newTestResponse()); w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	select {
+	case <-resCh:
+	case err := <-errCh:
+		t.Fatalf("collect failed: %v", err)
+	}
+}
+
+func TestHandlePin(t *testing.T) {
+	s := newTestServer()
+	handler := s.ServeHTTP()
+
+	for _, id := range []string{"a", "b", "c"} {
+		s.queue.Enqueue(&QueueItem{
+			ID:       id,
+			Request:  newTestRequest(),
+			Response: make(chan *pb.Response, 1),
+			AddedAt:  time.Now(),
+			Context:  context.Background(),
+		})
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/pin/c", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if u := fetchItem(t, s); u != "c" {
+		t.Errorf("expected pinned item c to be served first, got %q", u)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/pin/missing", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", w.Code)
+	}
+}
+
+func TestHandleDefer(t *testing.T) {
+	s := newTestServer()
+	
+	// create and enqueue test request
+	testReq := newTestRequest()
+	resCh := make(chan *pb.Response, 1)
+	testUUID := "test-uuid"
+
+	item := &QueueItem{
+		ID:       testUUID,
+		Request:  testReq,
+		Response: resCh,
+		AddedAt:  time.Now(),
+		Context:  context.Background(),
+	}
+
+	s.queue.Enqueue(item)
+
+	// add a second item so defer has something to serve
+	item2 := &QueueItem{
+		ID:       "test-uuid-2",
+		Request:  newTestRequest(),
+		Response: make(chan *pb.Response, 1),
+		AddedAt:  time.Now(),
+		Context:  context.Background(),
+	}
+	s.queue.Enqueue(item2)
+
+	// defer the item
+	req := httptest.NewRequest("POST", "/defer/"+testUUID, nil)
+	w := httptest.NewRecorder()
+	req.SetPathValue("uuid", testUUID)
+
+	s.handleDefer(w, req)
+
+	// handleDefer should succeed and serve the next available item
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	// verify that a response was served (should contain uuid field)
+	var response map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+
+	if _, hasUUID := response["uuid"]; !hasUUID {
+		t.Error("expected response to contain uuid field")
+	}
+}
+
+func TestHandleDeferInvalidUUID(t *testing.T) {
+	s := newTestServer()
+
+	req := httptest.NewRequest("POST", "/defer/nonexistent", nil)
+	w := httptest.NewRecorder()
+	req.SetPathValue("uuid", "nonexistent")
+
+	s.handleDefer(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404, got %d", w.Code)
+	}
+
+	var response map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+
+	// check that we got an error response (exact code depends on error format)
+	if response["code"] == nil {
+		t.Error("expected error code field to exist")
+	}
+}
+
+func TestHandleDeferServedItem(t *testing.T) {
+	s := newTestServer()
+	for _, id := range []string{"a", "b"} {
+		s.queue.Enqueue(&QueueItem{
+			ID:       id,
+			Request:  newTestRequest(),
+			Response: make(chan *pb.Response, 1),
+			AddedAt:  time.Now(),
+			Context:  context.Background(),
+		})
+	}
+
+	before := getStats().DeferCount
+
+	u := fetchItem(t, s)
+	req := httptest.NewRequest("POST", "/defer/"+u, nil)
+	req.SetPathValue("uuid", u)
+	w := httptest.NewRecorder()
+	s.handleDefer(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if got := getStats().DeferCount - before; got != 1 {
+		t.Errorf("expected defer count to increase by 1, got %d", got)
+	}
+
+	// the served item went back in the queue rather than being lost
+	if s.queue.Status().Deferred != 1 {
+		t.Fatalf("expected deferred item in queue, got %+v", s.queue.Status())
+	}
+
+	if got := s.queue.RepeatedlyDeferred(2); len(got) != 0 {
+		t.Errorf("expected nothing flagged after one defer, got %v", got)
+	}
+	item, _ := s.queue.Take(u)
+	item.Deferred = false
+	s.queue.Enqueue(item)
+	s.queue.Defer(u)
+	if got := s.queue.RepeatedlyDeferred(2); len(got) != 1 || got[0] != u {
+		t.Errorf("expected %s to be flagged, got %v", u, got)
+	}
+}
+
+func TestHandleDeferExhausted(t *testing.T) {
+	s := newTestServer()
+	s.queue.maxDefers = 1
+	client, cleanup := startTestGRPCServer(t, s)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	collectCtx := metadata.AppendToOutgoingContext(ctx, "x-collector-uuid", "bouncy")
+	_, errCh := collectAsync(t, s, client, collectCtx, newTestRequest())
+
+	// something for the final defer to serve next
+	s.queue.Enqueue(&QueueItem{
+		ID:       "next",
+		Request:  newTestRequest(),
+		Response: make(chan *pb.Response, 1),
+		AddedAt:  time.Now(),
+		Context:  context.Background(),
+	})
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("POST", "/defer/bouncy", nil)
+		req.SetPathValue("uuid", "bouncy")
+		w := httptest.NewRecorder()
+		s.handleDefer(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("defer %d: expected status 200, got %d: %s", i, w.Code, w.Body.String())
+		}
+		var served struct {
+			UUID string `json:"uuid"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &served); err != nil || served.UUID != "next" {
+			t.Fatalf("defer %d: expected next to be served, got %q (%v)", i, served.UUID, err)
+		}
+
+		// put next back, so there's something to serve after the next defer
+		item, _ := s.take("next")
+		s.queue.Enqueue(item)
+
+		// and show bouncy to an annotator again
+		if item, ok := s.queue.Take("bouncy"); ok {
+			item.Deferred = false
+			s.serve(item)
+		}
+	}
+
+	select {
+	case err := <-errCh:
+		if status.Code(err) != codes.FailedPrecondition || !strings.Contains(err.Error(), "exhausted defers") {
+			t.Fatalf("expected FailedPrecondition, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected collect to return after exhausting defers")
+	}
+
+	if _, ok := s.queue.Take("bouncy"); ok {
+		t.Error("expected exhausted item to be removed from the queue")
+	}
+}
+
+func TestHandleSkip(t *testing.T) {
+	s := newTestServer()
+	client, cleanup := startTestGRPCServer(t, s)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	before := getStats().SkipCount
+
+	_, errCh := collectAsync(t, s, client, ctx, newTestRequest())
+	u := fetchItem(t, s)
+
+	// something for the skip to serve next
+	s.queue.Enqueue(&QueueItem{
+		ID:       "next",
+		Request:  newTestRequest(),
+		Response: make(chan *pb.Response, 1),
+		AddedAt:  time.Now(),
+		Context:  context.Background(),
+	})
+
+	req := httptest.NewRequest("POST", "/skip/"+u, nil)
+	req.SetPathValue("uuid", u)
+	w := httptest.NewRecorder()
+	s.handleSkip(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	select {
+	case err := <-errCh:
+		if status.Code(err) != codes.FailedPrecondition {
+			t.Fatalf("expected FailedPrecondition, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected collect to return after skip")
+	}
+
+	if got := getStats().SkipCount - before; got != 1 {
+		t.Errorf("expected skip count to increase by 1, got %d", got)
+	}
+
+	// skipping again finds nothing
+	w = httptest.NewRecorder()
+	s.handleSkip(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404 for skipped item, got %d", w.Code)
+	}
+	if got := getStats().SkipCount - before; got != 1 {
+		t.Errorf("expected skip count unchanged, got %d", got)
+	}
+}
+
+func TestDeferBatch(t *testing.T) {
+	s := newTestServer()
+	handler := s.ServeHTTP()
+
+	for i, tag := range []string{"x", "y", "x", "y", "x"} {
+		req := newTestRequest()
+		req.Tag = tag
+		s.queue.Enqueue(&QueueItem{
+			ID:       fmt.Sprintf("item%d", i),
+			Request:  req,
+			Response: make(chan *pb.Response, 1),
+			AddedAt:  time.Now(),
+			Context:  context.Background(),
+		})
+	}
+
+	deferBatch := func(body string) map[string]string {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("POST", "/defer/batch", strings.NewReader(body)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var got struct {
+			Results map[string]string `json:"results"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatalf("failed to unmarshal results: %v", err)
+		}
+		return got.Results
+	}
+
+	before := getStats().DeferCount
+	results := deferBatch(`["item0", "item1", "nope"]`)
+	want := map[string]string{"item0": "deferred", "item1": "deferred", "nope": "not_found"}
+	if fmt.Sprint(results) != fmt.Sprint(want) {
+		t.Errorf("expected %v, got %v", want, results)
+	}
+
+	// item0 is tagged x but already deferred, so it's not selected
+	results = deferBatch(`{"tag": "x"}`)
+	want = map[string]string{"item2": "deferred", "item4": "deferred"}
+	if fmt.Sprint(results) != fmt.Sprint(want) {
+		t.Errorf("expected %v, got %v", want, results)
+	}
+
+	if status := s.queue.Status(); status.Active != 1 || status.Deferred != 4 {
+		t.Errorf("expected 1 active and 4 deferred, got %+v", status)
+	}
+	if got := getStats().DeferCount - before; got != 4 {
+		t.Errorf("expected defer count to increase by 4, got %d", got)
+	}
+
+	for _, body := range []string{`{}`, `"item3"`, `not json`} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("POST", "/defer/batch", strings.NewReader(body)))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", body, w.Code)
+		}
+	}
+}
+
+func TestWebRequestMarshalJSONExtended(t *testing.T) {
+	testReq := newTestRequest()
+	
+	wr := &webRequest{
+		UUID:  "test-uuid-123",
+		Proto: testReq,
+		Queue: QueueStatus{
+			Total:    5,
+			Active:   3,
+			Deferred: 2,
+		},
+	}
+
+	data, err := wr.MarshalJSON()
+	if err != nil {
+		t.Fatalf("MarshalJSON failed: %v", err)
+	}
+
+	var result map[string]interface{}
+	if err := json.Unmarshal(data, &result); err != nil {
+		t.Fatalf("failed to unmarshal result: %v", err)
+	}
+
+	// check uuid
+	if result["uuid"] != "test-uuid-123" {
+		t.Errorf("expected uuid 'test-uuid-123', got %v", result["uuid"])
+	}
+
+	// check queue status
+	queue, ok := result["queue"].(map[string]interface{})
+	if !ok {
+		t.Fatal("expected queue to be a map")
+	}
+
+	if queue["total"] != float64(5) { // JSON numbers are float64
+		t.Errorf("expected total 5, got %v", queue["total"])
+	}
+	if queue["active"] != float64(3) {
+		t.Errorf("expected active 3, got %v", queue["active"])
+	}
+	if queue["deferred"] != float64(2) {
+		t.Errorf("expected deferred 2, got %v", queue["deferred"])
+	}
+
+	// check proto field exists (it's complex so just verify it's there)
+	if _, exists := result["proto"]; !exists {
+		t.Error("expected proto field to exist")
+	}
+}
+
+func TestDownsampleMinMax(t *testing.T) {
+	values := make([]float64, 5000)
+	for i := range values {
+		values[i] = math.Sin(float64(i) / 50)
+	}
+	values[0], values[4999] = 0.25, -0.25
+	values[1234] = 5  // spike
+	values[3210] = -5 // dip
+
+	got := downsampleMinMax(values, 1000)
+	if len(got) > 1000 {
+		t.Fatalf("expected at most 1000 points, got %d", len(got))
+	}
+	if got[0] != 0.25 || got[len(got)-1] != -0.25 {
+		t.Errorf("expected endpoints to be preserved, got %f and %f", got[0], got[len(got)-1])
+	}
+
+	lo, hi := got[0], got[0]
+	for _, v := range got {
+		lo, hi = math.Min(lo, v), math.Max(hi, v)
+	}
+	if lo != -5 || hi != 5 {
+		t.Errorf("expected extremes to be preserved, got min=%f max=%f", lo, hi)
+	}
+
+	short := []float64{1, 2, 3}
+	if got := downsampleMinMax(short, 1000); len(got) != 3 {
+		t.Errorf("expected short series to be unchanged, got %v", got)
+	}
+}
+
+func TestTranspose(t *testing.T) {
+	// the 2x3 grid
+	//   1 2 3
+	//   4 5 6
+	// in column-major order
+	colMajor := []int64{1, 4, 2, 5, 3, 6}
+	got := transpose(colMajor, 2, 3, 1)
+	want := []int64{1, 2, 3, 4, 5, 6}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	// with two channels per cell, which stay together
+	colMajor2 := []int64{1, 10, 4, 40, 2, 20, 5, 50, 3, 30, 6, 60}
+	got = transpose(colMajor2, 2, 3, 2)
+	want = []int64{1, 10, 2, 20, 3, 30, 4, 40, 5, 50, 6, 60}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestForDisplayColMajor(t *testing.T) {
+	req := &pb.Request{
+		Inputs: []*pb.Input{{
+			Visualization: &pb.Input_Grid{
+				Grid: &pb.Grid{
+					Rows:     2,
+					Cols:     3,
+					Ordering: pb.Ordering_COL_MAJOR,
+					Mask:     []bool{true, true, false, true, true, true},
+				},
+			},
+			Data: &pb.Data{
+				Data: &pb.Data_Floats{Floats: &pb.Floats{Values: []float64{1, 4, 2, 5, 3, 6}}},
+			},
+		}},
+		Output: newTestRequest().Output,
+	}
+
+	out := forDisplay(req, 0)
+	if got := out.Inputs[0].GetGrid().Ordering; got != pb.Ordering_ROW_MAJOR {
+		t.Errorf("expected served grid to be row-major, got %v", got)
+	}
+	if got := out.Inputs[0].GetData().GetFloats().Values; fmt.Sprint(got) != "[1 2 3 4 5 6]" {
+		t.Errorf("expected transposed data, got %v", got)
+	}
+	if got := out.Inputs[0].GetGrid().Mask; fmt.Sprint(got) != "[true false true true true true]" {
+		t.Errorf("expected mask to be transposed with the data, got %v", got)
+	}
+
+	// the original is untouched
+	if got := req.Inputs[0].GetGrid().Ordering; got != pb.Ordering_COL_MAJOR {
+		t.Errorf("expected original grid to stay col-major, got %v", got)
+	}
+	if got := req.Inputs[0].GetData().GetFloats().Values; fmt.Sprint(got) != "[1 4 2 5 3 6]" {
+		t.Errorf("expected original data to be unchanged, got %v", got)
+	}
+}
+
+func TestHandleDataDownsamplesTimeSeries(t *testing.T) {
+	s := newTestServer()
+	s.maxTimeSeriesPoints = 100
+
+	values := make([]float64, 5000)
+	for i := range values {
+		values[i] = float64(i) / 5000
+	}
+
+	req := &pb.Request{
+		Inputs: []*pb.Input{{
+			Visualization: &pb.Input_TimeSeries{
+				TimeSeries: &pb.TimeSeries{Label: "signal", Points: 5000, MinValue: 0, MaxValue: 1},
+			},
+			Data: &pb.Data{Data: &pb.Data_Floats{Floats: &pb.Floats{Values: values}}},
+		}},
+		Output: newTestRequest().Output,
+	}
+
+	s.queue.Enqueue(&QueueItem{
+		ID:       "long-series",
+		Request:  req,
+		Response: make(chan *pb.Response, 1),
+		AddedAt:  time.Now(),
+		Context:  context.Background(),
+	})
+
+	w := httptest.NewRecorder()
+	s.handleData(w, httptest.NewRequest("GET", "/data.pb", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	served := &pb.Request{}
+	if err := proto.Unmarshal(w.Body.Bytes(), served); err != nil {
+		t.Fatalf("failed to unmarshal served request: %v", err)
+	}
+
+	got := served.Inputs[0].GetData().GetFloats().GetValues()
+	if len(got) > 100 {
+		t.Errorf("expected at most 100 points served, got %d", len(got))
+	}
+	if pts := served.Inputs[0].GetTimeSeries().Points; int(pts) != len(got) {
+		t.Errorf("expected points %d to match served data length %d", pts, len(got))
+	}
+	if got[0] != values[0] || got[len(got)-1] != values[4999] {
+		t.Errorf("expected endpoints to be preserved, got %f and %f", got[0], got[len(got)-1])
+	}
+
+	// the original stays intact for the collecting client
+	if n := len(req.Inputs[0].GetData().GetFloats().GetValues()); n != 5000 {
+		t.Errorf("expected original request to keep 5000 points, got %d", n)
+	}
+}
+
+func TestNewServerValidationMode(t *testing.T) {
+	cfg := *config
+	cfg.StrictValidation = false
+	if s := newServer(&cfg); s.validation != validationLenient {
+		t.Errorf("expected lenient validation, got %v", s.validation)
+	}
+
+	cfg.StrictValidation = true
+	if s := newServer(&cfg); s.validation != validationStrict {
+		t.Errorf("expected strict validation, got %v", s.validation)
+	}
+}
+
+func TestCollectorSession(t *testing.T) {
+	s := newTestServer()
+	client, cleanup := startTestGRPCServer(t, s)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resultCh, errCh := collectAsync(t, s, client, ctx, newTestRequest())
+
+	stream, err := client.Session(ctx)
+	if err != nil {
+		t.Fatalf("failed to open session: %v", err)
+	}
+
+	item, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("failed to receive item: %v", err)
+	}
+	if item.Uuid == "" || len(item.Request.GetInputs()) != 1 {
+		t.Fatalf("unexpected session item: %v", item)
+	}
+
+	if err := stream.Send(&pb.SessionAnswer{Uuid: item.Uuid, Response: newTestResponse()}); err != nil {
+		t.Fatalf("failed to send answer: %v", err)
+	}
+
+	select {
+	case res := <-resultCh:
+		if res.Output.GetOptionList().Index != newTestResponse().Output.GetOptionList().Index {
+			t.Errorf("unexpected response: %v", res)
+		}
+	case err := <-errCh:
+		t.Fatalf("collect failed: %v", err)
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for collect")
+	}
+
+	if err := stream.CloseSend(); err != nil {
+		t.Fatalf("failed to close session: %v", err)
+	}
+	if _, err := stream.Recv(); err != io.EOF {
+		t.Errorf("expected session to end with EOF, got: %v", err)
+	}
+}
+
+func TestCollectorSessionDisplayed(t *testing.T) {
+	s := newTestServer()
+	client, cleanup := startTestGRPCServer(t, s)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	labels := []string{"a", "b", "c", "d", "e", "f"}
+	options := []*pb.Option{}
+	for _, l := range labels {
+		options = append(options, &pb.Option{Label: l})
+	}
+	req := newTestRequest()
+	req.Output = &pb.OutputSchema{
+		Output: &pb.OutputSchema_OptionList{
+			OptionList: &pb.OptionListSchema{Options: options, Shuffle: true, AutoHotkeys: true},
+		},
+	}
+	resCh, errCh := collectAsync(t, s, client, ctx, req)
+
+	stream, err := client.Session(ctx)
+	if err != nil {
+		t.Fatalf("failed to open session: %v", err)
+	}
+	item, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("failed to receive item: %v", err)
+	}
+
+	// the session sees the options as the web frontend would
+	perms := shufflePermutations(req, item.Uuid)
+	displayed := -1
+	for i, o := range item.Request.GetOutput().GetOptionList().GetOptions() {
+		if o.Label != labels[perms[0][i]] {
+			t.Fatalf("expected %q at display index %d, got %q", labels[perms[0][i]], i, o.Label)
+		}
+		if o.Hotkey == "" {
+			t.Errorf("expected option %q to be given a hotkey", o.Label)
+		}
+		if o.Label == "c" {
+			displayed = i
+		}
+	}
+
+	res := &pb.Response{
+		Output: &pb.Output{
+			Output: &pb.Output_OptionList{OptionList: &pb.OptionListOutput{Index: int32(displayed)}},
+		},
+	}
+	if err := stream.Send(&pb.SessionAnswer{Uuid: item.Uuid, Response: res}); err != nil {
+		t.Fatalf("failed to send answer: %v", err)
+	}
+
+	select {
+	case got := <-resCh:
+		if idx := got.GetOutput().GetOptionList().GetIndex(); idx != 2 {
+			t.Errorf("expected display index %d to map back to original index 2, got %d", displayed, idx)
+		}
+	case err := <-errCh:
+		t.Fatalf("collect failed: %v", err)
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for collect")
+	}
+	stream.CloseSend()
+}
+
+func TestCollectorSessionInvalidAnswer(t *testing.T) {
+	s := newTestServer()
+	client, cleanup := startTestGRPCServer(t, s)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resCh, errCh := collectAsync(t, s, client, ctx, newTestRequest())
+
+	stream, err := client.Session(ctx)
+	if err != nil {
+		t.Fatalf("failed to open session: %v", err)
+	}
+	item, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("failed to receive item: %v", err)
+	}
+
+	bad := newTestResponse()
+	bad.Output.GetOptionList().Index = 99
+	if err := stream.Send(&pb.SessionAnswer{Uuid: item.Uuid, Response: bad}); err != nil {
+		t.Fatalf("failed to send answer: %v", err)
+	}
+
+	// the stream stays open, and the item comes back with the error
+	again, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("expected the item to be sent again, got: %v", err)
+	}
+	if again.Uuid != item.Uuid || !strings.Contains(again.Error, "invalid response") {
+		t.Fatalf("expected %s again with an error, got %v", item.Uuid, again)
+	}
+
+	if err := stream.Send(&pb.SessionAnswer{Uuid: item.Uuid, Response: newTestResponse()}); err != nil {
+		t.Fatalf("failed to send answer: %v", err)
+	}
+	select {
+	case <-resCh:
+	case err := <-errCh:
+		t.Fatalf("collect failed: %v", err)
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for collect")
+	}
+
+	stream.CloseSend()
+	if _, err := stream.Recv(); err != io.EOF {
+		t.Errorf("expected session to end with EOF, got: %v", err)
+	}
+}
+
+func TestCollectorSessionRequeuesOnTeardown(t *testing.T) {
+	s := newTestServer()
+	client, cleanup := startTestGRPCServer(t, s)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, errCh := collectAsync(t, s, client, ctx, newTestRequest())
+
+	sessCtx, endSession := context.WithCancel(ctx)
+	stream, err := client.Session(sessCtx)
+	if err != nil {
+		t.Fatalf("failed to open session: %v", err)
+	}
+
+	item, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("failed to receive item: %v", err)
+	}
+
+	// abandon the session without answering
+	endSession()
+
+	deadline := time.Now().Add(time.Second)
+	for s.queue.Status().Active != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected unanswered item to be requeued, got %+v", s.queue.Status())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// and it can still be answered by the web frontend
+	if u := fetchItem(t, s); u != item.Uuid {
+		t.Fatalf("expected requeued item %s, got %s", item.Uuid, u)
+	}
+	if w := submitItem(t, s, item.Uuid, newTestResponse()); w.Code != http.StatusOK {
+		t.Fatalf("submit failed: %d: %s", w.Code, w.Body.String())
+	}
+
+	select {
+	case err := <-errCh:
+		t.Fatalf("collect failed: %v", err)
+	default:
+	}
+}
+
+func TestThroughput(t *testing.T) {
+	tp := newThroughput(5*time.Minute, 30)
+	now := time.Now()
+
+	// 30 answers spread over the last minute
+	for i := 0; i < 30; i++ {
+		tp.record(now.Add(-time.Duration(i) * 2 * time.Second))
+	}
+
+	if got := tp.perMinute(now); got != 6 {
+		t.Errorf("expected 6 per minute (30 over a 5m window), got %f", got)
+	}
+
+	// once the window has passed, the old answers no longer count
+	if got := tp.perMinute(now.Add(6 * time.Minute)); got != 0 {
+		t.Errorf("expected 0 per minute after the window, got %f", got)
+	}
+
+	// a reused slot is reset rather than accumulated
+	tp.record(now.Add(5 * time.Minute))
+	if got := tp.perMinute(now.Add(5 * time.Minute)); got > 6 {
+		t.Errorf("expected stale buckets to be dropped, got %f", got)
+	}
+}
+
+func TestMetricsAnsweredPerMinute(t *testing.T) {
+	s := newTestServer()
+	before := answered.perMinute(time.Now())
+
+	for i := 0; i < 5; i++ {
+		u := uuid.NewString()
+		s.queue.Enqueue(&QueueItem{
+			ID:       u,
+			Request:  newTestRequest(),
+			Response: make(chan *pb.Response, 1),
+			AddedAt:  time.Now(),
+			Context:  context.Background(),
+		})
+		fetchItem(t, s)
+		if w := submitItem(t, s, u, newTestResponse()); w.Code != http.StatusOK {
+			t.Fatalf("submit failed: %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	w := httptest.NewRecorder()
+	s.handleMetrics(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	var metrics struct {
+		AnsweredPerMinute float64 `json:"answered_per_minute"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &metrics); err != nil {
+		t.Fatalf("failed to unmarshal metrics: %v", err)
+	}
+
+	// 5 answers over the 5m window
+	if got := metrics.AnsweredPerMinute - before; got < 0.99 || got > 1.01 {
+		t.Errorf("expected answered_per_minute to rise by 1, got %f", got)
+	}
+}
+
+func TestMetricsAbstain(t *testing.T) {
+	s := newTestServer()
+	before := getStats().AbstainCount
+
+	abstain := &pb.Response{Output: &pb.Output{
+		Output: &pb.Output_OptionList{OptionList: &pb.OptionListOutput{Index: -1}},
+	}}
+
+	for _, allowNone := range []bool{false, true} {
+		req := newTestRequest()
+		req.Output.GetOptionList().AllowNone = allowNone
+
+		u := uuid.NewString()
+		s.queue.Enqueue(&QueueItem{
+			ID:       u,
+			Request:  req,
+			Response: make(chan *pb.Response, 1),
+			AddedAt:  time.Now(),
+			Context:  context.Background(),
+		})
+		fetchItem(t, s)
+
+		w := submitItem(t, s, u, abstain)
+		want := http.StatusBadRequest
+		if allowNone {
+			want = http.StatusOK
+		}
+		if w.Code != want {
+			t.Fatalf("allow_none=%v: expected status %d, got %d: %s", allowNone, want, w.Code, w.Body.String())
+		}
+	}
+
+	if got := getStats().AbstainCount - before; got != 1 {
+		t.Errorf("expected abstain count to increase by 1, got %d", got)
+	}
+}
+
+func TestMetricsHTTP(t *testing.T) {
+	s := newTestServer()
+	s.timeout.Store(int64(10 * time.Millisecond))
+	handler := s.ServeHTTP()
+
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+
+	var statusBytes int
+	for i := 0; i < 2; i++ {
+		statusBytes += serve("GET", "/queue/status").Body.Len()
+	}
+	serve("GET", "/data.json")
+	serve("POST", "/defer/no-such-uuid")
+
+	w := serve("GET", "/metrics")
+	var metrics struct {
+		HTTP map[string]httpRouteSnapshot `json:"http"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &metrics); err != nil {
+		t.Fatalf("failed to unmarshal metrics: %v", err)
+	}
+
+	status := metrics.HTTP["GET /queue/status"]
+	if status.Requests != 2 || status.Statuses["200"] != 2 {
+		t.Errorf("expected 2 successful status requests, got %+v", status)
+	}
+	if status.Bytes != int64(statusBytes) {
+		t.Errorf("expected %d bytes written, got %d", statusBytes, status.Bytes)
+	}
+
+	// an empty queue times out, so the long-poll counts as a 408
+	data := metrics.HTTP["/data.json"]
+	if data.Requests != 1 || data.Statuses["408"] != 1 {
+		t.Errorf("expected one timed out data request, got %+v", data)
+	}
+	if data.P50Ms < 10 || data.P95Ms < data.P50Ms {
+		t.Errorf("expected latency to include the long-poll, got p50=%f p95=%f", data.P50Ms, data.P95Ms)
+	}
+
+	if d := metrics.HTTP["POST /defer/{uuid}"]; d.Requests != 1 || d.Statuses["404"] != 1 {
+		t.Errorf("expected one failed defer, got %+v", d)
+	}
+}
+
+func TestSummarizeInput(t *testing.T) {
+	grid := &pb.Input{
+		Visualization: &pb.Input_Grid{Grid: &pb.Grid{Rows: 2, Cols: 2}},
+		Data:          &pb.Data{Data: &pb.Data_Ints{Ints: &pb.Ints{Values: []int64{1, 2, 3, 10}}}},
+	}
+	sum := summarizeInput(grid)
+	if sum.Visualization != "grid" || sum.Points != 4 {
+		t.Errorf("unexpected grid summary: %+v", sum)
+	}
+	if sum.Min == nil || *sum.Min != 1 || sum.Max == nil || *sum.Max != 10 || sum.Mean == nil || *sum.Mean != 4 {
+		t.Errorf("expected grid min=1 max=10 mean=4, got %v %v %v", sum.Min, sum.Max, sum.Mean)
+	}
+
+	scalar := &pb.Input{
+		Visualization: &pb.Input_Scalar{Scalar: &pb.Scalar{Label: "temp", Min: 0, Max: 100}},
+		Data:          &pb.Data{Data: &pb.Data_Floats{Floats: &pb.Floats{Values: []float64{42.5}}}},
+	}
+	sum = summarizeInput(scalar)
+	if sum.Visualization != "scalar" || sum.Value == nil || *sum.Value != 42.5 {
+		t.Errorf("expected scalar value 42.5, got %+v", sum)
+	}
+	if sum.Min != nil || sum.Magnitude != nil {
+		t.Errorf("expected only value to be set for scalar, got %+v", sum)
+	}
+
+	vector := &pb.Input{
+		Visualization: &pb.Input_Vector{Vector: &pb.Vector2D{Label: "v", MaxMagnitude: 10}},
+		Data:          &pb.Data{Data: &pb.Data_Floats{Floats: &pb.Floats{Values: []float64{3, 4}}}},
+	}
+	sum = summarizeInput(vector)
+	if sum.Magnitude == nil || *sum.Magnitude != 5 {
+		t.Errorf("expected vector magnitude 5, got %+v", sum)
+	}
+}
+
+func TestHandleQueueSummaries(t *testing.T) {
+	s := newTestServer()
+	for _, id := range []string{"a", "b"} {
+		s.queue.Enqueue(&QueueItem{
+			ID:       id,
+			Request:  newTestRequest(),
+			Response: make(chan *pb.Response, 1),
+			AddedAt:  time.Now(),
+			Context:  context.Background(),
+		})
+	}
+	s.queue.Defer("b")
+
+	w := httptest.NewRecorder()
+	s.handleQueueSummaries(w, httptest.NewRequest("GET", "/queue/summaries", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	var got []itemSummary
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("failed to unmarshal summaries: %v", err)
+	}
+	if len(got) != 1 || got[0].UUID != "a" {
+		t.Fatalf("expected only the active item, got %+v", got)
+	}
+	if len(got[0].Inputs) != 1 || got[0].Inputs[0].Visualization != "grid" || got[0].Inputs[0].Points != 100 {
+		t.Errorf("unexpected input summary: %+v", got[0].Inputs)
+	}
+}
+
+func TestHandlePreview(t *testing.T) {
+	s := newTestServer()
+	handler := s.ServeHTTP()
+
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	req := newTestRequest()
+	req.Inputs[0].Preview = png
+	s.queue.Enqueue(&QueueItem{
+		ID:       "a",
+		Request:  req,
+		Response: make(chan *pb.Response, 1),
+		AddedAt:  time.Now(),
+		Context:  context.Background(),
+	})
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	w := get("/data/a/preview/0.png")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "image/png" {
+		t.Errorf("expected content type image/png, got %q", ct)
+	}
+	if !bytes.Equal(w.Body.Bytes(), png) {
+		t.Errorf("expected preview bytes %q, got %q", png, w.Body.Bytes())
+	}
+
+	if sum := summarizeItem(&QueueItem{ID: "a", Request: req}); !sum.Inputs[0].Preview {
+		t.Error("expected summary to note the preview")
+	}
+
+	for path, code := range map[string]int{
+		"/data/a/preview/1.png":  http.StatusNotFound,
+		"/data/b/preview/0.png":  http.StatusNotFound,
+		"/data/a/preview/x.png":  http.StatusBadRequest,
+		"/data/a/preview/0.jpeg": http.StatusBadRequest,
+	} {
+		if w := get(path); w.Code != code {
+			t.Errorf("%s: expected status %d, got %d", path, code, w.Code)
+		}
+	}
+
+	// still served once answered, for history views
+	if u := fetchItem(t, s); u != "a" {
+		t.Fatalf("expected item a, got %q", u)
+	}
+	if w := submitItem(t, s, "a", newTestResponse()); w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := get("/data/a/preview/0.png"); w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), png) {
+		t.Errorf("expected preview from history, got %d: %q", w.Code, w.Body.Bytes())
+	}
+}
+
+func TestWebhookThresholds(t *testing.T) {
+	received := make(chan webhookEvent, 10)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ev webhookEvent
+		if err := json.NewDecoder(r.Body).Decode(&ev); err != nil {
+			t.Errorf("failed to decode webhook event: %v", err)
+		}
+		received <- ev
+	}))
+	defer hook.Close()
+
+	q := NewQueue()
+	q.notifier = newNotifier(hook.URL, 10, 0.9)
+	q.notifier.interval = 50 * time.Millisecond
+
+	expect := func(event string, total int) {
+		t.Helper()
+		select {
+		case ev := <-received:
+			if ev.Event != event || ev.Total != total || ev.Max != 10 {
+				t.Fatalf("expected %s at %d/10, got %+v", event, total, ev)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected %s webhook", event)
+		}
+	}
+
+	for i := 0; i < 10; i++ {
+		q.Enqueue(&QueueItem{ID: fmt.Sprintf("item-%d", i), Request: newTestRequest(), AddedAt: time.Now()})
+	}
+	expect(WebhookNearlyFull, 9)
+
+	// draining straight away is held back by the interval, then delivered
+	for i := 0; i < 10; i++ {
+		q.Take(fmt.Sprintf("item-%d", i))
+	}
+	expect(WebhookDrained, 0)
+
+	select {
+	case ev := <-received:
+		t.Fatalf("expected no more webhooks, got %+v", ev)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestHandleReplay(t *testing.T) {
+	s := newTestServer()
+
+	bare, err := protojson.Marshal(newTestRequest())
+	if err != nil {
+		t.Fatalf("failed to marshal request: %v", err)
+	}
+	entry, err := json.Marshal(HistoryEntry{UUID: "old", Request: newTestRequest(), Response: newTestResponse()})
+	if err != nil {
+		t.Fatalf("failed to marshal history entry: %v", err)
+	}
+
+	body := string(bare) + "\n\n" + string(entry) + "\n"
+	w := httptest.NewRecorder()
+	s.handleReplay(w, httptest.NewRequest("POST", "/admin/replay", strings.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var replayed struct {
+		UUIDs []string `json:"uuids"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &replayed); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if len(replayed.UUIDs) != 2 {
+		t.Fatalf("expected 2 replayed items, got %v", replayed.UUIDs)
+	}
+
+	for range replayed.UUIDs {
+		u := fetchItem(t, s)
+		if w := submitItem(t, s, u, newTestResponse()); w.Code != http.StatusOK {
+			t.Fatalf("expected status 200 for replayed submit, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	entries := s.history.Entries()
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 history entries, got %d", len(entries))
+	}
+	for i, e := range entries {
+		if !e.Replay || e.UUID != replayed.UUIDs[i] || e.Response == nil {
+			t.Errorf("unexpected history entry %d: %+v", i, e)
+		}
+	}
+}
+
+// flushRecorder counts the flushes made while a response is being written,
+// and how much of the body had been written at each.
+type flushRecorder struct {
+	*httptest.ResponseRecorder
+	flushedAt []int
+}
+
+func (w *flushRecorder) Flush() {
+	w.flushedAt = append(w.flushedAt, w.Body.Len())
+	w.ResponseRecorder.Flush()
+}
+
+func TestHandleHistoryStream(t *testing.T) {
+	s := newTestServer()
+	for i := 0; i < 350; i++ {
+		s.history.Append(HistoryEntry{
+			UUID:     fmt.Sprintf("item-%d", i),
+			Request:  newTestRequest(),
+			Response: newTestResponse(),
+			Time:     time.Now(),
+		})
+	}
+
+	tests := []struct {
+		format string
+		count  func(t *testing.T, body []byte) int
+	}{
+		{
+			format: "",
+			count: func(t *testing.T, body []byte) int {
+				var entries []json.RawMessage
+				if err := json.Unmarshal(body, &entries); err != nil {
+					t.Fatalf("failed to unmarshal history: %v", err)
+				}
+				return len(entries)
 			},
-			wantErr: false,
 		},
 		{
-			name: "1x1 grid valid",
-			grid: &pb.Grid{Rows: 1, Cols: 1},
-			data: &pb.Data{
-				Data: &pb.Data_Ints{
-					Ints: &pb.Ints{Values: []int64{42}},
-				},
+			format: "jsonl",
+			count: func(t *testing.T, body []byte) int {
+				n := 0
+				for _, line := range strings.Split(strings.TrimSpace(string(body)), "\n") {
+					var entry struct {
+						UUID string `json:"uuid"`
+					}
+					if err := json.Unmarshal([]byte(line), &entry); err != nil {
+						t.Fatalf("failed to unmarshal line %d: %v", n, err)
+					}
+					if want := fmt.Sprintf("item-%d", n); entry.UUID != want {
+						t.Fatalf("expected line %d to be %s, got %s", n, want, entry.UUID)
+					}
+					n++
+				}
+				return n
 			},
-			wantErr: false,
 		},
 		{
-			name: "max size grid valid",
-			grid: &pb.Grid{Rows: 100, Cols: 100},
-			data: &pb.Data{
-				Data: &pb.Data_Ints{
-					Ints: &pb.Ints{Values: make([]int64, 10000)},
-				},
+			format: "csv",
+			count: func(t *testing.T, body []byte) int {
+				rows, err := csv.NewReader(bytes.NewReader(body)).ReadAll()
+				if err != nil {
+					t.Fatalf("failed to parse csv: %v", err)
+				}
+				if len(rows) == 0 || rows[0][0] != "uuid" {
+					t.Fatalf("expected a header row, got %v", rows)
+				}
+				return len(rows) - 1
 			},
-			wantErr: false,
 		},
 	}
 
 	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			err := validateGrid(tt.grid, tt.data)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("validateGrid() error = %v, wantErr %v", err, tt.wantErr)
-				return
+		t.Run("format="+tt.format, func(t *testing.T) {
+			w := &flushRecorder{ResponseRecorder: httptest.NewRecorder()}
+			s.handleHistory(w, httptest.NewRequest("GET", "/admin/history?format="+tt.format, nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
 			}
-			if tt.wantErr && !strings.Contains(err.Error(), tt.errMsg) {
-				t.Errorf("expected error containing %q, got %v", tt.errMsg, err)
+
+			if n := tt.count(t, w.Body.Bytes()); n != 350 {
+				t.Errorf("expected 350 entries, got %d", n)
+			}
+
+			// every hundred entries, then once at the end
+			if len(w.flushedAt) < 4 {
+				t.Fatalf("expected at least 4 flushes, got %d", len(w.flushedAt))
+			}
+			if f := w.flushedAt; f[0] == 0 || f[0] >= f[1] || f[1] >= f[2] || f[2] >= w.Body.Len() {
+				t.Errorf("expected flushes as the body was written, got %v of %d bytes", f, w.Body.Len())
 			}
 		})
 	}
+
+	w := httptest.NewRecorder()
+	s.handleHistory(w, httptest.NewRequest("GET", "/admin/history?format=xml", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for unknown format, got %d", w.Code)
+	}
 }
 
-func TestValidateData(t *testing.T) {
-	tests := []struct {
-		name    string
-		data    *pb.Data
-		wantErr bool
-		errMsg  string
-	}{
-		{
-			name:    "nil data",
-			data:    nil,
-			wantErr: true,
-			errMsg:  "data cannot be nil",
-		},
-		{
-			name:    "nil data type",
-			data:    &pb.Data{Data: nil},
-			wantErr: true,
-			errMsg:  "data type is required",
-		},
-		{
-			name: "valid ints",
-			data: &pb.Data{
-				Data: &pb.Data_Ints{
-					Ints: &pb.Ints{Values: []int64{1, 2, 3}},
-				},
-			},
-			wantErr: false,
-		},
-		{
-			name: "valid floats",
-			data: &pb.Data{
-				Data: &pb.Data_Floats{
-					Floats: &pb.Floats{Values: []float64{1.0, 2.0, 3.0}},
-				},
-			},
-			wantErr: false,
-		},
+func TestHandleHistoryCSVColumns(t *testing.T) {
+	s := newTestServer()
+	req := newTestRequest()
+	req.Title = "Weld seams"
+	req.Prompt = "Mark any defects"
+	req.GetOutput().GetOptionList().Options[0].Description = "halts all motors"
+	s.history.Append(HistoryEntry{
+		UUID:     "item-0",
+		Request:  req,
+		Response: newTestResponse(),
+		Time:     time.Now(),
+	})
+
+	w := httptest.NewRecorder()
+	s.handleHistory(w, httptest.NewRequest("GET", "/admin/history?format=csv", nil))
+	rows, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("failed to parse csv: %v", err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("expected a header and one row, got %v", rows)
+	}
+
+	row := map[string]string{}
+	for i, col := range rows[0] {
+		row[col] = rows[1][i]
+	}
+	if row["title"] != req.Title || row["prompt"] != req.Prompt {
+		t.Errorf("expected title %q and prompt %q, got %q and %q", req.Title, req.Prompt, row["title"], row["prompt"])
+	}
+
+	got := &pb.Request{}
+	if err := protojson.Unmarshal([]byte(row["request"]), got); err != nil {
+		t.Fatalf("failed to unmarshal request column: %v", err)
+	}
+	if !proto.Equal(got, req) {
+		t.Errorf("expected the request column to round-trip, got %v", got)
+	}
+}
+
+func TestHistoryEach(t *testing.T) {
+	h := NewHistory(3)
+	for i := 0; i < 5; i++ {
+		h.Append(HistoryEntry{UUID: fmt.Sprint(i)})
+	}
+
+	// entries appended while iterating aren't included, and those overwritten
+	// before they're reached are skipped
+	got := []string{}
+	h.Each(func(e HistoryEntry) error {
+		got = append(got, e.UUID)
+		if e.UUID == "2" {
+			h.Append(HistoryEntry{UUID: "5"})
+			h.Append(HistoryEntry{UUID: "6"})
+		}
+		return nil
+	})
+	if fmt.Sprint(got) != "[2 4]" {
+		t.Errorf("expected [2 4], got %v", got)
+	}
+
+	var nilHistory *History
+	if err := nilHistory.Each(func(HistoryEntry) error { return errors.New("unexpected") }); err != nil {
+		t.Errorf("expected nil history to have no entries, got %v", err)
+	}
+}
+
+func TestHandleReplayInvalid(t *testing.T) {
+	s := newTestServer()
+
+	bare, _ := protojson.Marshal(newTestRequest())
+	body := string(bare) + "\n" + `{"inputs": []}` + "\n"
+
+	w := httptest.NewRecorder()
+	s.handleReplay(w, httptest.NewRequest("POST", "/admin/replay", strings.NewReader(body)))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "line 2") {
+		t.Errorf("expected error to name line 2, got %s", w.Body.String())
+	}
+
+	// nothing is enqueued unless every line is valid
+	if st := s.queue.Status(); st.Total != 0 {
+		t.Errorf("expected empty queue, got %+v", st)
+	}
+}
+
+func TestHandleDataPreservesOptionGroups(t *testing.T) {
+	s := newTestServer()
+
+	req := newTestRequest()
+	req.Output.GetOptionList().Options = []*pb.Option{
+		{Label: "Left", Hotkey: "a", Group: "Movement"},
+		{Label: "Stop", Hotkey: "s", Group: "Safety"},
+		{Label: "Unsure", Hotkey: "u"},
+	}
+	if err := validate(req, validationStrict); err != nil {
+		t.Fatalf("expected grouped options to validate: %v", err)
+	}
+
+	s.queue.Enqueue(&QueueItem{
+		ID:       "grouped",
+		Request:  req,
+		Response: make(chan *pb.Response, 1),
+		AddedAt:  time.Now(),
+		Context:  context.Background(),
+	})
+
+	w := httptest.NewRecorder()
+	s.handleData(w, httptest.NewRequest("GET", "/data.json", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	var data struct {
+		Proto struct {
+			Output struct {
+				Output struct {
+					OptionList struct {
+						Options []struct {
+							Label string `json:"label"`
+							Group string `json:"group"`
+						} `json:"options"`
+					}
+				}
+			} `json:"output"`
+		} `json:"proto"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+
+	got := []string{}
+	for _, opt := range data.Proto.Output.Output.OptionList.Options {
+		got = append(got, opt.Group)
+	}
+	if want := "Movement,Safety,"; strings.Join(got, ",") != want {
+		t.Errorf("expected groups %q, got %q", want, strings.Join(got, ","))
+	}
+}
+
+func TestHandleDeferTwice(t *testing.T) {
+	s := newTestServer()
+	for _, id := range []string{"a", "b"} {
+		s.queue.Enqueue(&QueueItem{
+			ID:       id,
+			Request:  newTestRequest(),
+			Response: make(chan *pb.Response, 1),
+			AddedAt:  time.Now(),
+			Context:  context.Background(),
+		})
+	}
+
+	before := getStats().DeferCount
+	deferItem := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/defer/a", nil)
+		req.SetPathValue("uuid", "a")
+		w := httptest.NewRecorder()
+		s.handleDefer(w, req)
+		return w
+	}
+
+	if w := deferItem(); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"uuid":"b"`) {
+		t.Fatalf("expected first defer to serve b, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w := deferItem()
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), `"status":"already_deferred"`) {
+		t.Errorf("expected already_deferred, got %s", w.Body.String())
+	}
+	if got := getStats().DeferCount - before; got != 1 {
+		t.Errorf("expected defer count to increase by 1, got %d", got)
+	}
+}
+
+func TestHandleDataBatch(t *testing.T) {
+	s := newTestServer()
+	client, cleanup := startTestGRPCServer(t, s)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var results []<-chan *pb.Response
+	for i := 0; i < 3; i++ {
+		resCh, _ := collectAsync(t, s, client, ctx, newTestRequest())
+		results = append(results, resCh)
+	}
+
+	w := httptest.NewRecorder()
+	s.handleDataBatch(w, httptest.NewRequest("GET", "/data/batch?n=5", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var batch []struct {
+		UUID string `json:"uuid"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &batch); err != nil {
+		t.Fatalf("failed to unmarshal batch: %v", err)
+	}
+	if len(batch) != 3 {
+		t.Fatalf("expected the 3 available items, got %d", len(batch))
+	}
+
+	for _, item := range batch {
+		if w := submitItem(t, s, item.UUID, newTestResponse()); w.Code != http.StatusOK {
+			t.Fatalf("expected status 200 submitting %s, got %d: %s", item.UUID, w.Code, w.Body.String())
+		}
+	}
+
+	for i, resCh := range results {
+		select {
+		case <-resCh:
+		case <-time.After(time.Second):
+			t.Fatalf("expected collect %d to complete", i)
+		}
+	}
+}
+
+func TestHandleDataBatchLimit(t *testing.T) {
+	s := newTestServer()
+
+	for _, n := range []string{"11", "0", "x"} {
+		w := httptest.NewRecorder()
+		s.handleDataBatch(w, httptest.NewRequest("GET", "/data/batch?n="+n, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("n=%s: expected status 400, got %d", n, w.Code)
+		}
+	}
+}
+
+func TestCorrelationUUID(t *testing.T) {
+	buf := &lockedBuffer{}
+	orig := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(orig) })
+
+	s := newTestServer()
+	client, cleanup := startTestGRPCServer(t, s)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resCh, errCh := collectAsync(t, s, client, ctx, newTestRequest())
+	u := fetchItem(t, s)
+	if w := submitItem(t, s, u, newTestResponse()); w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	select {
+	case res := <-resCh:
+		if got := res.GetMeta().GetUuid(); got != u {
+			t.Errorf("expected response meta uuid %q, got %q", u, got)
+		}
+	case err := <-errCh:
+		t.Fatalf("collect failed: %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("expected collect to complete")
+	}
+
+	uuids := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var rec map[string]any
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("failed to parse log line %q: %v", line, err)
+		}
+		if msg, _ := rec["msg"].(string); msg != "" {
+			if id, ok := rec["uuid"].(string); ok {
+				uuids[msg] = id
+			}
+		}
+	}
+
+	for _, msg := range []string{"collect request enqueued", "item served", "item answered"} {
+		if uuids[msg] != u {
+			t.Errorf("expected %q to be logged with uuid %q, got %q", msg, u, uuids[msg])
+		}
+	}
+}
+
+func newMultiOutputRequest() *pb.Request {
+	req := newTestRequest()
+	req.Outputs = []*pb.OutputSchema{
+		req.Output,
+		{Output: &pb.OutputSchema_Range{Range: &pb.RangeSchema{Min: 0, Max: 10, Label: "quality"}}},
+	}
+	req.Output = nil
+	return req
+}
+
+func TestValidateAnswerMultipleOutputs(t *testing.T) {
+	req := newMultiOutputRequest()
+	option := &pb.Output{Output: &pb.Output_OptionList{OptionList: &pb.OptionListOutput{Index: 1}}}
+	rng := &pb.Output{Output: &pb.Output_Range{Range: &pb.RangeOutput{Start: 2, End: 7}}}
+
+	tests := []struct {
+		name    string
+		res     *pb.Response
+		wantErr bool
+		errMsg  string
+	}{
 		{
-			name: "nil floats data",
-			data: &pb.Data{
-				Data: &pb.Data_Floats{Floats: nil},
-			},
-			wantErr: true,
-			errMsg:  "floats data cannot be nil",
+			name: "one answer per schema",
+			res:  &pb.Response{Outputs: []*pb.Output{option, rng}},
 		},
 		{
-			name: "nan float",
-			data: &pb.Data{
-				Data: &pb.Data_Floats{
-					Floats: &pb.Floats{Values: []float64{1.0, math.NaN(), 3.0}},
-				},
-			},
+			name:    "missing answer",
+			res:     &pb.Response{Outputs: []*pb.Output{option}},
 			wantErr: true,
-			errMsg:  "float value at index 1 is NaN",
+			errMsg:  "expected 2 outputs (got 1)",
 		},
 		{
-			name: "positive inf float",
-			data: &pb.Data{
-				Data: &pb.Data_Floats{
-					Floats: &pb.Floats{Values: []float64{1.0, math.Inf(1), 3.0}},
-				},
-			},
+			name:    "answers out of order",
+			res:     &pb.Response{Outputs: []*pb.Output{rng, option}},
 			wantErr: true,
-			errMsg:  "float value at index 1 is infinite",
+			errMsg:  "output 0: expected option list output",
 		},
 		{
-			name: "negative inf float",
-			data: &pb.Data{
-				Data: &pb.Data_Floats{
-					Floats: &pb.Floats{Values: []float64{1.0, math.Inf(-1), 3.0}},
-				},
-			},
+			name:    "singular output",
+			res:     &pb.Response{Output: option},
 			wantErr: true,
-			errMsg:  "float value at index 1 is infinite",
+			errMsg:  "answer with outputs rather than output",
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := validateData(tt.data)
+			err := validateAnswer(req, tt.res)
 			if (err != nil) != tt.wantErr {
-				t.Errorf("validateData() error = %v, wantErr %v", err, tt.wantErr)
-				return
+				t.Fatalf("validateAnswer() error = %v, wantErr %v", err, tt.wantErr)
 			}
 			if tt.wantErr && !strings.Contains(err.Error(), tt.errMsg) {
-				t.Errorf("expected error containing %q, got %v", tt.errMsg, err)
+				t.Errorf("expected error containing %q, got %q", tt.errMsg, err.Error())
 			}
 		})
 	}
 }
 
-func TestValidateOutputSchema(t *testing.T) {
-	tests := []struct {
-		name    string
-		schema  *pb.OutputSchema
-		wantErr bool
-		errMsg  string
-	}{
-		{
-			name:    "nil schema",
-			schema:  nil,
-			wantErr: true,
-			errMsg:  "output schema is required",
-		},
-		{
-			name:    "nil output type",
-			schema:  &pb.OutputSchema{Output: nil},
-			wantErr: true,
-			errMsg:  "output type is required",
-		},
-		{
-			name: "nil option list",
-			schema: &pb.OutputSchema{
-				Output: &pb.OutputSchema_OptionList{OptionList: nil},
-			},
-			wantErr: true,
-			errMsg:  "option list cannot be nil",
-		},
-		{
-			name: "empty option list",
-			schema: &pb.OutputSchema{
-				Output: &pb.OutputSchema_OptionList{
-					OptionList: &pb.OptionListSchema{Options: []*pb.Option{}},
-				},
-			},
-			wantErr: true,
-			errMsg:  "option list must have at least 2 options",
-		},
-		{
-			name: "one option only",
-			schema: &pb.OutputSchema{
-				Output: &pb.OutputSchema_OptionList{
-					OptionList: &pb.OptionListSchema{
-						Options: []*pb.Option{
-							{Label: "Option 1", Hotkey: "1"},
-						},
-					},
-				},
-			},
-			wantErr: true,
-			errMsg:  "option list must have at least 2 options",
-		},
-		{
-			name: "nil option",
-			schema: &pb.OutputSchema{
-				Output: &pb.OutputSchema_OptionList{
-					OptionList: &pb.OptionListSchema{
-						Options: []*pb.Option{
-							{Label: "Option 1", Hotkey: "1"},
-							nil,
-						},
-					},
-				},
-			},
-			wantErr: true,
-			errMsg:  "option 1 cannot be nil",
-		},
-		{
-			name: "empty label",
-			schema: &pb.OutputSchema{
-				Output: &pb.OutputSchema_OptionList{
-					OptionList: &pb.OptionListSchema{
-						Options: []*pb.Option{
-							{Label: "Option 1", Hotkey: "1"},
-							{Label: "", Hotkey: "2"},
-						},
-					},
-				},
-			},
-			wantErr: true,
-			errMsg:  "option 1 label cannot be empty",
-		},
-		{
-			name: "empty hotkey",
-			schema: &pb.OutputSchema{
-				Output: &pb.OutputSchema_OptionList{
-					OptionList: &pb.OptionListSchema{
-						Options: []*pb.Option{
-							{Label: "Option 1", Hotkey: "1"},
-							{Label: "Option 2", Hotkey: ""},
-						},
-					},
-				},
-			},
-			wantErr: true,
-			errMsg:  "option 1 hotkey must be single character",
-		},
-		{
-			name: "multi-char hotkey",
-			schema: &pb.OutputSchema{
-				Output: &pb.OutputSchema_OptionList{
-					OptionList: &pb.OptionListSchema{
-						Options: []*pb.Option{
-							{Label: "Option 1", Hotkey: "1"},
-							{Label: "Option 2", Hotkey: "ab"},
-						},
-					},
-				},
-			},
-			wantErr: true,
-			errMsg:  "option 1 hotkey must be single character",
-		},
-		{
-			name: "duplicate hotkey",
-			schema: &pb.OutputSchema{
-				Output: &pb.OutputSchema_OptionList{
-					OptionList: &pb.OptionListSchema{
-						Options: []*pb.Option{
-							{Label: "Option 1", Hotkey: "1"},
-							{Label: "Option 2", Hotkey: "1"},
-						},
-					},
-				},
-			},
-			wantErr: true,
-			errMsg:  "duplicate hotkey \"1\" found at option 1",
-		},
-		{
-			name: "valid option list",
-			schema: &pb.OutputSchema{
-				Output: &pb.OutputSchema_OptionList{
-					OptionList: &pb.OptionListSchema{
-						Options: []*pb.Option{
-							{Label: "Option 1", Hotkey: "1"},
-							{Label: "Option 2", Hotkey: "2"},
-						},
-					},
-				},
-			},
-			wantErr: false,
-		},
-		{
-			name: "valid many options",
-			schema: &pb.OutputSchema{
-				Output: &pb.OutputSchema_OptionList{
-					OptionList: &pb.OptionListSchema{
-						Options: []*pb.Option{
-							{Label: "First", Hotkey: "a"},
-							{Label: "Second", Hotkey: "b"},
-							{Label: "Third", Hotkey: "c"},
-							{Label: "Fourth", Hotkey: "d"},
-						},
-					},
-				},
-			},
-			wantErr: false,
-		},
+func TestCollectMultipleOutputs(t *testing.T) {
+	s := newTestServer()
+	client, cleanup := startTestGRPCServer(t, s)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resCh, errCh := collectAsync(t, s, client, ctx, newMultiOutputRequest())
+	u := fetchItem(t, s)
+
+	res := &pb.Response{Outputs: []*pb.Output{
+		{Output: &pb.Output_OptionList{OptionList: &pb.OptionListOutput{Index: 0}}},
+		{Output: &pb.Output_Range{Range: &pb.RangeOutput{Start: 3, End: 4}}},
+	}}
+	if w := submitItem(t, s, u, res); w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	select {
+	case got := <-resCh:
+		if len(got.Outputs) != 2 || got.Outputs[1].GetRange().GetEnd() != 4 {
+			t.Errorf("expected both outputs, got %v", got)
+		}
+	case err := <-errCh:
+		t.Fatalf("collect failed: %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("expected collect to complete")
+	}
+}
+
+func TestSaveLoadStats(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+
+	saved := &ErrorStats{
+		ValidationErrors: 3,
+		TotalRequests:    10,
+		TooLarge:         1,
+		InFlight:         4,
+		DeferCount:       7,
+		AbstainCount:     2,
+	}
+	if err := saveStats(path, saved); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+
+	loaded := &ErrorStats{}
+	if err := loadStats(path, loaded); err != nil {
+		t.Fatalf("failed to load: %v", err)
+	}
+
+	// the in-flight gauge describes the old process, so isn't restored
+	want := *saved
+	want.InFlight = 0
+	if *loaded != want {
+		t.Errorf("expected %+v, got %+v", want, *loaded)
+	}
+
+	fresh := &ErrorStats{}
+	if err := loadStats(filepath.Join(t.TempDir(), "missing.json"), fresh); err != nil {
+		t.Errorf("expected a missing file to be ignored, got %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte("{not json"), 0o644); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	if err := loadStats(path, fresh); err == nil {
+		t.Error("expected an error for a corrupt file")
+	}
+	if *fresh != (ErrorStats{}) {
+		t.Errorf("expected a corrupt file to restore nothing, got %+v", *fresh)
+	}
+}
+
+func TestStatsCountersComplete(t *testing.T) {
+	e := &ErrorStats{}
+	persisted := map[*int64]bool{}
+	for _, c := range e.counters() {
+		persisted[c] = true
+	}
+
+	v := reflect.ValueOf(e).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		name := v.Type().Field(i).Name
+		if name == "InFlight" {
+			continue
+		}
+		if !persisted[v.Field(i).Addr().Interface().(*int64)] {
+			t.Errorf("expected ErrorStats.%s to be persisted", name)
+		}
+	}
+}
+
+func TestMetricsSeriesDeltas(t *testing.T) {
+	m := newMetricsSeries(10)
+	t0 := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	counters := []ErrorStats{
+		{TotalRequests: 10, ValidationErrors: 1},
+		{TotalRequests: 15, ValidationErrors: 1, TimeoutErrors: 2},
+		{TotalRequests: 15, ValidationErrors: 1, TimeoutErrors: 2, DeferCount: 4},
+		{TotalRequests: 22, ValidationErrors: 3, TimeoutErrors: 2, DeferCount: 4, SkipCount: 1},
+	}
+	for i, c := range counters {
+		m.sample(t0.Add(time.Duration(i)*time.Minute), c)
+	}
+
+	now := t0.Add(3 * time.Minute)
+	got := m.deltas(now, time.Hour)
+	want := []metricsBucket{
+		{Start: t0, End: t0.Add(time.Minute), Requests: 5, Errors: 2},
+		{Start: t0.Add(time.Minute), End: t0.Add(2 * time.Minute), Defers: 4},
+		{Start: t0.Add(2 * time.Minute), End: t0.Add(3 * time.Minute), Requests: 7, Errors: 2, Skips: 1},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d buckets, got %d: %+v", len(want), len(got), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("bucket %d: expected %+v, got %+v", i, want[i], got[i])
+		}
+	}
+
+	// a shorter window only includes the buckets which ended within it
+	if got := m.deltas(now, 30*time.Second); len(got) != 1 || got[0].Requests != 7 {
+		t.Errorf("expected only the last bucket, got %+v", got)
+	}
+
+	// once the ring wraps, the oldest samples are gone
+	for i := 4; i < 14; i++ {
+		m.sample(t0.Add(time.Duration(i)*time.Minute), counters[3])
+	}
+	if got := m.deltas(t0.Add(13*time.Minute), time.Hour); len(got) != 9 {
+		t.Errorf("expected 9 buckets from 10 samples, got %d", len(got))
+	}
+}
+
+func TestHandleMetricsTimeseries(t *testing.T) {
+	s := newTestServer()
+	s.metrics = newMetricsSeries(10)
+
+	now := time.Now()
+	s.metrics.sample(now.Add(-2*time.Minute), ErrorStats{TotalRequests: 1})
+	s.metrics.sample(now.Add(-time.Minute), ErrorStats{TotalRequests: 4})
+
+	w := httptest.NewRecorder()
+	s.handleMetricsTimeseries(w, httptest.NewRequest("GET", "/metrics/timeseries?window=1h", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	var buckets []metricsBucket
+	if err := json.Unmarshal(w.Body.Bytes(), &buckets); err != nil {
+		t.Fatalf("failed to unmarshal buckets: %v", err)
+	}
+	if len(buckets) != 1 || buckets[0].Requests != 3 {
+		t.Errorf("expected one bucket of 3 requests, got %+v", buckets)
+	}
+
+	w = httptest.NewRecorder()
+	s.handleMetricsTimeseries(w, httptest.NewRequest("GET", "/metrics/timeseries?window=soon", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for invalid window, got %d", w.Code)
+	}
+}
+
+func TestRaceReplicas(t *testing.T) {
+	s := newTestServer()
+	client, cleanup := startTestGRPCServer(t, s)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req := newTestRequest()
+	req.RaceReplicas = 2
+	resCh, errCh := collectAsync(t, s, client, ctx, req)
+
+	// both annotators are shown the same item, and then it's gone
+	first := fetchItem(t, s)
+	second := fetchItem(t, s)
+	if first != second {
+		t.Fatalf("expected both annotators to get %q, got %q", first, second)
+	}
+	if total := s.queue.Status().Total; total != 0 {
+		t.Errorf("expected empty queue after two replicas, got total %d", total)
+	}
+
+	if w := submitItem(t, s, first, newTestResponse()); w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	select {
+	case res := <-resCh:
+		if res.GetMeta().GetUuid() != first {
+			t.Errorf("expected response for %q, got %q", first, res.GetMeta().GetUuid())
+		}
+	case err := <-errCh:
+		t.Fatalf("collect failed: %v", err)
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for collect")
+	}
+
+	w := submitItem(t, s, second, newTestResponse())
+	if w.Code != http.StatusGone {
+		t.Fatalf("expected status 410 for the losing submit, got %d: %s", w.Code, w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), "another annotator") {
+		t.Errorf("expected error to mention another annotator, got %s", w.Body.String())
+	}
+}
+
+func TestRaceReplicaDequeuedDuringSubmit(t *testing.T) {
+	s := newTestServer()
+
+	req := newTestRequest()
+	req.RaceReplicas = 3
+	item := &QueueItem{
+		ID:       "race",
+		Request:  req,
+		Response: make(chan *pb.Response, 1),
+		AddedAt:  time.Now(),
+		Context:  context.Background(),
+	}
+	s.queue.Enqueue(item)
+
+	if u := fetchItem(t, s); u != "race" {
+		t.Fatalf("expected race item, got %q", u)
+	}
+
+	// another /data.json has dequeued the replica, but not yet served it
+	replica, err := s.queue.Dequeue()
+	if err != nil {
+		t.Fatalf("dequeue failed: %v", err)
+	}
+
+	if w := submitItem(t, s, "race", newTestResponse()); w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if s.serve(replica) {
+		t.Fatal("expected serve to refuse a replica of an answered race item")
+	}
+
+	// without the check above, this would send on the closed channel
+	if w := submitItem(t, s, "race", newTestResponse()); w.Code != http.StatusGone {
+		t.Fatalf("expected status 410, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestRaceClaimRestore(t *testing.T) {
+	s := newTestServer()
+
+	req := newTestRequest()
+	req.RaceReplicas = 2
+	s.queue.Enqueue(&QueueItem{
+		ID:       "race",
+		Request:  req,
+		Response: make(chan *pb.Response, 1),
+		AddedAt:  time.Now(),
+		Context:  context.Background(),
+	})
+	fetchItem(t, s)
+
+	item, ok := s.claim("race")
+	if !ok {
+		t.Fatal("expected to claim the race item")
+	}
+	if !s.wasResolved("race") || s.queue.Contains("race") {
+		t.Fatal("expected claim to resolve the race and take its replica out of the queue")
+	}
+
+	// e.g. the answer was invalid
+	s.restore(item)
+	if s.wasResolved("race") || !s.queue.Contains("race") {
+		t.Fatal("expected restore to reopen the race and requeue its replica")
+	}
+	if u := fetchItem(t, s); u != "race" {
+		t.Errorf("expected the replica to be served again, got %q", u)
+	}
+}
+
+func TestLogFormatJSON(t *testing.T) {
+	t.Setenv("LOG_FORMAT", "json")
+	t.Setenv("LOG_LEVEL", "warn")
+	cfg := loadConfig()
+	if cfg.LogFormat != logFormatJSON || cfg.LogLevel != slog.LevelWarn {
+		t.Fatalf("expected json/warn config, got %q/%v", cfg.LogFormat, cfg.LogLevel)
+	}
+
+	buf := &bytes.Buffer{}
+	logger := newLogger(buf, cfg.LogFormat, cfg.LogLevel)
+	logger.Info("below the level")
+	logger.Warn("item expired", "uuid", "abc")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected one log line, got %d: %q", len(lines), buf.String())
+	}
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("expected log line to be JSON: %v: %s", err, lines[0])
+	}
+	for _, key := range []string{"time", "level", "msg", "uuid"} {
+		if _, ok := entry[key]; !ok {
+			t.Errorf("expected key %q in log line: %s", key, lines[0])
+		}
+	}
+	if entry["msg"] != "item expired" || entry["level"] != "WARN" || entry["uuid"] != "abc" {
+		t.Errorf("unexpected log line: %s", lines[0])
+	}
+}
+
+func TestHandleReadyz(t *testing.T) {
+	s := newTestServer()
+	handler := s.ServeHTTP()
+
+	readyz := func() (int, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+		var body map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("failed to unmarshal readyz: %v: %s", err, w.Body.String())
+		}
+		return w.Code, body
+	}
+
+	// not ready until the grpc listener is bound
+	if code, body := readyz(); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected status 503 before listening, got %d: %v", code, body)
+	}
+
+	_, cleanup := startTestGRPCServer(t, s)
+	defer cleanup()
+
+	if code, body := readyz(); code != http.StatusOK || body["status"] != "ready" {
+		t.Fatalf("expected ready, got %d: %v", code, body)
+	}
+
+	s.queue.Pause()
+	code, body := readyz()
+	if code != http.StatusServiceUnavailable || body["status"] != "not_ready" {
+		t.Fatalf("expected status 503 while paused, got %d: %v", code, body)
+	}
+	if checks := body["checks"].(map[string]interface{}); checks["not_paused"] != false {
+		t.Errorf("expected not_paused check to fail, got %v", checks)
+	}
+
+	s.queue.Resume()
+	if code, body := readyz(); code != http.StatusOK {
+		t.Fatalf("expected status 200 after resume, got %d: %v", code, body)
+	}
+
+	// not ready again until the warmup has passed
+	s.warmup = time.Hour
+	code, body = readyz()
+	if code != http.StatusServiceUnavailable {
+		t.Fatalf("expected status 503 during warmup, got %d: %v", code, body)
+	}
+	if checks := body["checks"].(map[string]interface{}); checks["warmed_up"] != false {
+		t.Errorf("expected warmed_up check to fail, got %v", checks)
+	}
+	s.startedAt = time.Now().Add(-time.Hour)
+	if code, body := readyz(); code != http.StatusOK {
+		t.Fatalf("expected status 200 after warmup, got %d: %v", code, body)
+	}
+
+	// liveness doesn't care
+	s.queue.Pause()
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected healthz 200 while paused, got %d", w.Code)
+	}
+}
+
+func TestEchoRequest(t *testing.T) {
+	tests := []struct {
+		name        string
+		echo        bool
+		maxBytes    int
+		wantRequest bool
+		wantHash    bool
+	}{
+		{name: "disabled", echo: false},
+		{name: "enabled", echo: true, maxBytes: 64 << 10, wantRequest: true, wantHash: true},
+		{name: "too large to echo", echo: true, maxBytes: 1, wantHash: true},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := validateOutputSchema(tt.schema)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("validateOutputSchema() error = %v, wantErr %v", err, tt.wantErr)
-				return
+			s := newTestServer()
+			s.echoRequest = tt.echo
+			s.echoRequestMaxBytes = tt.maxBytes
+			client, cleanup := startTestGRPCServer(t, s)
+			defer cleanup()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			req := newTestRequest()
+			resCh, errCh := collectAsync(t, s, client, ctx, req)
+			u := fetchItem(t, s)
+			if w := submitItem(t, s, u, newTestResponse()); w.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
 			}
-			if tt.wantErr && !strings.Contains(err.Error(), tt.errMsg) {
-				t.Errorf("expected error containing %q, got %v", tt.errMsg, err)
+
+			var res *pb.Response
+			select {
+			case res = <-resCh:
+			case err := <-errCh:
+				t.Fatalf("collect failed: %v", err)
+			}
+
+			meta := res.GetMeta()
+			if got := meta.GetRequest() != nil; got != tt.wantRequest {
+				t.Fatalf("expected echoed request %v, got %v", tt.wantRequest, got)
+			}
+			if tt.wantRequest && !proto.Equal(meta.GetRequest(), req) {
+				t.Errorf("echoed request doesn't match the original")
+			}
+			if got := meta.GetRequestHash() != ""; got != tt.wantHash {
+				t.Errorf("expected request hash %v, got %q", tt.wantHash, meta.GetRequestHash())
 			}
 		})
 	}
 }
 
-func TestCollectorValidationFailure(t *testing.T) {
+func TestSubmitEdit(t *testing.T) {
 	s := newTestServer()
 	client, cleanup := startTestGRPCServer(t, s)
 	defer cleanup()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	// create invalid request
-	badReq := &pb.Request{
-		Inputs: []*pb.Input{}, // empty inputs should fail validation
-		Output: &pb.OutputSchema{
-			Output: &pb.OutputSchema_OptionList{
-				OptionList: &pb.OptionListSchema{
-					Options: []*pb.Option{
-						{Label: "Option 1", Hotkey: "1"},
-						{Label: "Option 2", Hotkey: "2"},
-					},
-				},
-			},
-		},
+	req := newTestRequest()
+	req.Output = &pb.OutputSchema{
+		Output: &pb.OutputSchema_Edit{Edit: &pb.EditSchema{Editable: true}},
 	}
+	resCh, errCh := collectAsync(t, s, client, ctx, req)
+	u := fetchItem(t, s)
 
-	_, err := client.Collect(ctx, badReq)
-	if err == nil {
-		t.Fatal("expected validation error")
+	edit := func(n int) *pb.Response {
+		values := make([]int64, n)
+		for i := range values {
+			values[i] = int64(i)
+		}
+		return &pb.Response{Output: &pb.Output{
+			Output: &pb.Output_Edit{Edit: &pb.EditOutput{
+				Data: &pb.Data{Data: &pb.Data_Ints{Ints: &pb.Ints{Values: values}}},
+			}},
+		}}
 	}
 
-	// should be grpc invalid argument error
-	if !strings.Contains(err.Error(), "request must have at least one input") {
-		t.Fatalf("expected validation error message, got: %v", err)
+	if w := submitItem(t, s, u, edit(10)); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400 for a wrongly-shaped edit, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := submitItem(t, s, u, edit(100)); w.Code != http.StatusOK {
+		t.Fatalf("expected status 200 for a correctly-shaped edit, got %d: %s", w.Code, w.Body.String())
+	}
+
+	select {
+	case res := <-resCh:
+		if got := res.GetOutput().GetEdit().GetData().GetInts().GetValues(); len(got) != 100 || got[99] != 99 {
+			t.Errorf("expected the edited data, got %v", got)
+		}
+	case err := <-errCh:
+		t.Fatalf("collect failed: %v", err)
 	}
 }
 
-// error helper tests
+func TestSubmitEditColMajor(t *testing.T) {
+	s := newTestServer()
+	client, cleanup := startTestGRPCServer(t, s)
+	defer cleanup()
 
-func TestErrorHelpers(t *testing.T) {
-	t.Run("notFoundError", func(t *testing.T) {
-		err := notFoundError("request", "test-uuid")
-		if err == nil {
-			t.Fatal("expected error, got nil")
-		}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
-		st := status.Convert(err)
-		if st.Code().String() != "NotFound" {
-			t.Errorf("expected status NotFound, got %s", st.Code().String())
-		}
+	req := &pb.Request{
+		Inputs: []*pb.Input{{
+			Visualization: &pb.Input_Grid{
+				Grid: &pb.Grid{Rows: 2, Cols: 3, Ordering: pb.Ordering_COL_MAJOR},
+			},
+			Data: &pb.Data{
+				Data: &pb.Data_Floats{Floats: &pb.Floats{Values: []float64{1, 4, 2, 5, 3, 6}}},
+			},
+		}},
+		Output: &pb.OutputSchema{
+			Output: &pb.OutputSchema_Edit{Edit: &pb.EditSchema{Editable: true}},
+		},
+	}
+	resCh, errCh := collectAsync(t, s, client, ctx, req)
+	u := fetchItem(t, s)
+
+	// the annotator edits the grid as displayed, i.e. row-major
+	edit := &pb.Response{Output: &pb.Output{
+		Output: &pb.Output_Edit{Edit: &pb.EditOutput{
+			Data: &pb.Data{Data: &pb.Data_Floats{Floats: &pb.Floats{Values: []float64{1, 2, 3, 4, 5, 60}}}},
+		}},
+	}}
+	if w := submitItem(t, s, u, edit); w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
 
-		if !strings.Contains(st.Message(), "request not found: test-uuid") {
-			t.Errorf("expected message to contain 'request not found: test-uuid', got: %s", st.Message())
+	select {
+	case res := <-resCh:
+		if got := res.GetOutput().GetEdit().GetData().GetFloats().GetValues(); fmt.Sprint(got) != "[1 4 2 5 3 60]" {
+			t.Errorf("expected the edit in the input's col-major order, got %v", got)
 		}
-	})
+	case err := <-errCh:
+		t.Fatalf("collect failed: %v", err)
+	}
+}
 
-	t.Run("timeoutError", func(t *testing.T) {
-		err := timeoutError("collect")
-		if err == nil {
-			t.Fatal("expected error, got nil")
-		}
+func TestCollectRejectsDownsampledEdit(t *testing.T) {
+	s := newTestServer()
+	s.maxTimeSeriesPoints = 100
 
-		st := status.Convert(err)
-		if st.Code().String() != "DeadlineExceeded" {
-			t.Errorf("expected status DeadlineExceeded, got %s", st.Code().String())
+	req := func(points int) *pb.Request {
+		return &pb.Request{
+			Inputs: []*pb.Input{{
+				Visualization: &pb.Input_TimeSeries{
+					TimeSeries: &pb.TimeSeries{Label: "signal", Points: int32(points), MinValue: 0, MaxValue: 1},
+				},
+				Data: &pb.Data{
+					Data: &pb.Data_Floats{Floats: &pb.Floats{Values: make([]float64, points)}},
+				},
+			}},
+			Output: &pb.OutputSchema{
+				Output: &pb.OutputSchema_Edit{Edit: &pb.EditSchema{Editable: true}},
+			},
 		}
+	}
 
-		if !strings.Contains(st.Message(), "collect timed out") {
-			t.Errorf("expected message to contain 'collect timed out', got: %s", st.Message())
-		}
-	})
+	pass := func(ctx context.Context, req any) (any, error) { return &pb.Response{}, nil }
+	info := &grpc.UnaryServerInfo{FullMethod: pb.Collector_Collect_FullMethodName}
 
-	t.Run("internalError", func(t *testing.T) {
-		testErr := fmt.Errorf("something went wrong")
-		err := internalError(testErr)
-		if err == nil {
-			t.Fatal("expected error, got nil")
-		}
+	_, err := s.validationInterceptor(context.Background(), req(5000), info, pass)
+	if status.Code(err) != codes.InvalidArgument || !strings.Contains(err.Error(), "displayed downsampled") {
+		t.Fatalf("expected InvalidArgument for an edit of a downsampled time series, got %v", err)
+	}
 
-		st := status.Convert(err)
-		if st.Code().String() != "Internal" {
-			t.Errorf("expected status Internal, got %s", st.Code().String())
-		}
+	if _, err := s.validationInterceptor(context.Background(), req(100), info, pass); err != nil {
+		t.Fatalf("expected an edit of a short time series to pass, got %v", err)
+	}
+}
 
-		if !strings.Contains(st.Message(), "internal error: something went wrong") {
-			t.Errorf("expected message to contain 'internal error: something went wrong', got: %s", st.Message())
+func TestHashAnnotation(t *testing.T) {
+	at := time.Unix(1700000000, 123)
+	hash := func(res *pb.Response, annotator string, at time.Time) string {
+		t.Helper()
+		h, err := hashAnnotation(newTestRequest(), res, annotator, at)
+		if err != nil {
+			t.Fatalf("failed to hash: %v", err)
 		}
-	})
+		return h
+	}
 
-	t.Run("resourceExhaustedError", func(t *testing.T) {
-		err := resourceExhaustedError("queue")
-		if err == nil {
-			t.Fatal("expected error, got nil")
+	want := hash(newTestResponse(), "alice", at)
+	if got := hash(newTestResponse(), "alice", at); got != want {
+		t.Errorf("expected identical answers to hash the same, got %s and %s", want, got)
+	}
+
+	// the meta, which carries the hash itself, isn't covered
+	withMeta := newTestResponse()
+	withMeta.Meta = &pb.ResponseMeta{Uuid: "x", AuditHash: want}
+	if got := hash(withMeta, "alice", at); got != want {
+		t.Errorf("expected meta to be ignored, got %s and %s", want, got)
+	}
+	if withMeta.Meta.GetUuid() != "x" {
+		t.Error("expected the response to be left alone")
+	}
+
+	changed := newTestResponse()
+	changed.Output.GetOptionList().Index = 1
+	for name, got := range map[string]string{
+		"response":  hash(changed, "alice", at),
+		"annotator": hash(newTestResponse(), "bob", at),
+		"time":      hash(newTestResponse(), "alice", at.Add(time.Nanosecond)),
+	} {
+		if got == want {
+			t.Errorf("expected a different %s to change the hash", name)
 		}
+	}
+}
 
-		st := status.Convert(err)
-		if st.Code().String() != "ResourceExhausted" {
-			t.Errorf("expected status ResourceExhausted, got %s", st.Code().String())
+func TestAuditHash(t *testing.T) {
+	s := newTestServer()
+	client, cleanup := startTestGRPCServer(t, s)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req := newTestRequest()
+	resCh, errCh := collectAsync(t, s, client, ctx, req)
+	u := fetchItem(t, s)
+
+	b, _ := protojson.Marshal(newTestResponse())
+	r := httptest.NewRequest("POST", "/submit/"+u, bytes.NewReader(b))
+	r.SetPathValue("uuid", u)
+	r.Header.Set(annotatorHeader, "alice")
+	w := httptest.NewRecorder()
+	s.handleSubmit(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var res *pb.Response
+	select {
+	case res = <-resCh:
+	case err := <-errCh:
+		t.Fatalf("collect failed: %v", err)
+	}
+
+	meta := res.GetMeta()
+	if meta.GetAnnotator() != "alice" || meta.GetAnsweredAt() == 0 {
+		t.Fatalf("expected the annotator and time in meta, got %+v", meta)
+	}
+	want, err := hashAnnotation(req, res, meta.GetAnnotator(), time.Unix(0, meta.GetAnsweredAt()))
+	if err != nil {
+		t.Fatalf("failed to hash: %v", err)
+	}
+	if meta.GetAuditHash() != want {
+		t.Errorf("expected audit hash %s to be verifiable, got %s", want, meta.GetAuditHash())
+	}
+
+	entries := s.history.Entries()
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 history entry, got %d", len(entries))
+	}
+	if e := entries[0]; e.Annotator != "alice" || e.Response.GetMeta().GetAuditHash() != want {
+		t.Errorf("expected the history entry to carry the annotator and hash, got %+v", e)
+	}
+}
+
+func TestShuffleOptions(t *testing.T) {
+	s := newTestServer()
+	client, cleanup := startTestGRPCServer(t, s)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	labels := []string{"a", "b", "c", "d", "e", "f"}
+	options := []*pb.Option{}
+	for _, l := range labels {
+		options = append(options, &pb.Option{Label: l, Hotkey: l})
+	}
+
+	req := newTestRequest()
+	req.Output = &pb.OutputSchema{
+		Output: &pb.OutputSchema_OptionList{
+			OptionList: &pb.OptionListSchema{Options: options, Shuffle: true},
+		},
+	}
+	resCh, errCh := collectAsync(t, s, client, ctx, req)
+
+	w := httptest.NewRecorder()
+	s.handleData(w, httptest.NewRequest("GET", "/data.json", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("data request failed: %d: %s", w.Code, w.Body.String())
+	}
+
+	var served struct {
+		UUID  string `json:"uuid"`
+		Proto struct {
+			Output struct {
+				Output struct {
+					OptionList struct {
+						Options []struct {
+							Label string `json:"label"`
+						} `json:"options"`
+					}
+				}
+			} `json:"output"`
+		} `json:"proto"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &served); err != nil {
+		t.Fatalf("failed to unmarshal web request: %v", err)
+	}
+
+	// the permutation is seeded by the uuid, so the same item always gets the
+	// same one
+	perms := shufflePermutations(req, served.UUID)
+	if fmt.Sprint(perms) != fmt.Sprint(shufflePermutations(req, served.UUID)) {
+		t.Fatal("expected permutation to be deterministic per item")
+	}
+
+	displayed := -1
+	for i, o := range served.Proto.Output.Output.OptionList.Options {
+		if o.Label != labels[perms[0][i]] {
+			t.Fatalf("expected %q at display index %d, got %q", labels[perms[0][i]], i, o.Label)
+		}
+		if o.Label == "c" {
+			displayed = i
 		}
+	}
+	if displayed < 0 {
+		t.Fatalf("option c not served: %+v", served.Proto.Output.Output.OptionList.Options)
+	}
 
-		if !strings.Contains(st.Message(), "queue limit exceeded") {
-			t.Errorf("expected message to contain 'queue limit exceeded', got: %s", st.Message())
+	res := &pb.Response{
+		Output: &pb.Output{
+			Output: &pb.Output_OptionList{OptionList: &pb.OptionListOutput{Index: int32(displayed)}},
+		},
+	}
+	if w := submitItem(t, s, served.UUID, res); w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	select {
+	case got := <-resCh:
+		if idx := got.GetOutput().GetOptionList().GetIndex(); idx != 2 {
+			t.Errorf("expected display index %d to map back to original index 2, got %d", displayed, idx)
 		}
-	})
+	case err := <-errCh:
+		t.Fatalf("collect failed: %v", err)
+	}
 }
 
-func TestHandleDefer(t *testing.T) {
+func TestAutoHotkeys(t *testing.T) {
 	s := newTestServer()
-	
-	// create and enqueue test request
-	testReq := newTestRequest()
-	resCh := make(chan *pb.Response, 1)
-	testUUID := "test-uuid"
 
-	item := &QueueItem{
-		ID:       testUUID,
-		Request:  testReq,
-		Response: resCh,
-		AddedAt:  time.Now(),
-		Context:  context.Background(),
+	options := []*pb.Option{}
+	for _, l := range []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l"} {
+		options = append(options, &pb.Option{Label: l})
+	}
+	req := newTestRequest()
+	req.Output = &pb.OutputSchema{
+		Output: &pb.OutputSchema_OptionList{
+			OptionList: &pb.OptionListSchema{Options: options, Shuffle: true, AutoHotkeys: true},
+		},
+	}
+	if err := validate(req, validationStrict); err != nil {
+		t.Fatalf("expected auto hotkeys to pass validation: %v", err)
 	}
 
-	s.queue.Enqueue(item)
+	u := uuid.NewString()
+	s.queue.Enqueue(&QueueItem{
+		ID:           u,
+		Request:      req,
+		Response:     make(chan *pb.Response, 1),
+		AddedAt:      time.Now(),
+		Context:      context.Background(),
+		Permutations: shufflePermutations(req, u),
+	})
 
-	// add a second item so defer has something to serve
-	item2 := &QueueItem{
-		ID:       "test-uuid-2",
-		Request:  newTestRequest(),
-		Response: make(chan *pb.Response, 1),
-		AddedAt:  time.Now(),
-		Context:  context.Background(),
+	hotkeysOf := func(w *httptest.ResponseRecorder) map[string]string {
+		if w.Code != http.StatusOK {
+			t.Fatalf("data request failed: %d: %s", w.Code, w.Body.String())
+		}
+
+		var served struct {
+			Proto struct {
+				Output struct {
+					Output struct {
+						OptionList struct {
+							Options []struct {
+								Label  string `json:"label"`
+								Hotkey string `json:"hotkey"`
+							} `json:"options"`
+						}
+					}
+				} `json:"output"`
+			} `json:"proto"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &served); err != nil {
+			t.Fatalf("failed to unmarshal web request: %v", err)
+		}
+
+		hotkeys := map[string]string{}
+		seen := map[string]bool{}
+		for _, o := range served.Proto.Output.Output.OptionList.Options {
+			if o.Hotkey == "" || seen[o.Hotkey] {
+				t.Fatalf("expected unique non-empty hotkeys, got %+v", served.Proto.Output.Output.OptionList.Options)
+			}
+			seen[o.Hotkey] = true
+			hotkeys[o.Label] = o.Hotkey
+		}
+		return hotkeys
 	}
-	s.queue.Enqueue(item2)
 
-	// defer the item
-	req := httptest.NewRequest("POST", "/defer/"+testUUID, nil)
 	w := httptest.NewRecorder()
-	req.SetPathValue("uuid", testUUID)
+	s.handleData(w, httptest.NewRequest("GET", "/data.json", nil))
+	first := hotkeysOf(w)
 
-	s.handleDefer(w, req)
+	// abandon it, so that it's requeued and served again
+	s.currentTimeout = time.Minute
+	if _, requeued := s.sweepCurrent(time.Now().Add(time.Hour)); requeued != 1 {
+		t.Fatalf("expected the item to be requeued, got %d", requeued)
+	}
+	w = httptest.NewRecorder()
+	s.handleData(w, httptest.NewRequest("GET", "/data.json", nil))
+	second := hotkeysOf(w)
 
-	// handleDefer should succeed and serve the next available item
-	if w.Code != http.StatusOK {
-		t.Fatalf("expected status 200, got %d", w.Code)
+	if fmt.Sprint(first) != fmt.Sprint(second) {
+		t.Errorf("expected the same hotkeys on every serve, got %v then %v", first, second)
+	}
+	if len(first) != len(options) {
+		t.Errorf("expected %d hotkeys, got %v", len(options), first)
 	}
 
-	// verify that a response was served (should contain uuid field)
-	var response map[string]interface{}
-	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
-		t.Fatalf("failed to unmarshal response: %v", err)
+	for _, opt := range options {
+		if opt.Hotkey != "" {
+			t.Fatalf("expected the original request to be left alone, got hotkey %q", opt.Hotkey)
+		}
+	}
+}
+
+func TestAutoHotkeysManyOptions(t *testing.T) {
+	options := []*pb.Option{}
+	for i := 0; i < 40; i++ {
+		options = append(options, &pb.Option{Label: fmt.Sprintf("option %d", i)})
+	}
+	req := newTestRequest()
+	req.Output = &pb.OutputSchema{
+		Output: &pb.OutputSchema_OptionList{
+			OptionList: &pb.OptionListSchema{Options: options, AutoHotkeys: true},
+		},
+	}
+	if err := validate(req, validationStrict); err != nil {
+		t.Fatalf("expected 40 options with auto hotkeys to pass validation: %v", err)
 	}
 
-	if _, hasUUID := response["uuid"]; !hasUUID {
-		t.Error("expected response to contain uuid field")
+	served := withHotkeys(req).GetOutput().GetOptionList().GetOptions()
+	for i, opt := range served {
+		if i < len(autoHotkeys) && opt.Hotkey != autoHotkeys[i:i+1] {
+			t.Errorf("expected option %d to get hotkey %q, got %q", i, autoHotkeys[i:i+1], opt.Hotkey)
+		}
+		if i >= len(autoHotkeys) && opt.Hotkey != "" {
+			t.Errorf("expected option %d to get no hotkey, got %q", i, opt.Hotkey)
+		}
 	}
 }
 
-func TestHandleDeferInvalidUUID(t *testing.T) {
+func TestUniqueAnnotators(t *testing.T) {
 	s := newTestServer()
+	client, cleanup := startTestGRPCServer(t, s)
+	defer cleanup()
 
-	req := httptest.NewRequest("POST", "/defer/nonexistent", nil)
-	w := httptest.NewRecorder()
-	req.SetPathValue("uuid", "nonexistent")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
-	s.handleDefer(w, req)
+	for _, id := range []string{"alice", "bob", "carol", "alice"} {
+		resCh, errCh := collectAsync(t, s, client, ctx, newTestRequest())
+		u := fetchItem(t, s)
+
+		b, _ := protojson.Marshal(newTestResponse())
+		req := httptest.NewRequest("POST", "/submit/"+u, bytes.NewReader(b))
+		req.SetPathValue("uuid", u)
+		req.Header.Set("X-Annotator-ID", id)
+		w := httptest.NewRecorder()
+		s.handleSubmit(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
 
-	if w.Code != http.StatusNotFound {
-		t.Fatalf("expected status 404, got %d", w.Code)
+		select {
+		case <-resCh:
+		case err := <-errCh:
+			t.Fatalf("collect failed: %v", err)
+		}
 	}
 
-	var response map[string]interface{}
-	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
-		t.Fatalf("failed to unmarshal response: %v", err)
+	w := httptest.NewRecorder()
+	s.handleMetrics(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	var metrics struct {
+		Annotators struct {
+			Unique  int              `json:"unique"`
+			Submits map[string]int64 `json:"submits"`
+			Capped  bool             `json:"capped"`
+		} `json:"annotators"`
 	}
-
-	// check that we got an error response (exact code depends on error format)
-	if response["code"] == nil {
-		t.Error("expected error code field to exist")
+	if err := json.Unmarshal(w.Body.Bytes(), &metrics); err != nil {
+		t.Fatalf("failed to unmarshal metrics: %v", err)
 	}
-}
-
-func TestWebRequestMarshalJSONExtended(t *testing.T) {
-	testReq := newTestRequest()
-	
-	wr := &webRequest{
-		UUID:  "test-uuid-123",
-		Proto: testReq,
-		Queue: QueueStatus{
-			Total:    5,
-			Active:   3,
-			Deferred: 2,
-		},
+	if metrics.Annotators.Unique != 3 {
+		t.Errorf("expected 3 unique annotators, got %d", metrics.Annotators.Unique)
 	}
-
-	data, err := wr.MarshalJSON()
-	if err != nil {
-		t.Fatalf("MarshalJSON failed: %v", err)
+	if metrics.Annotators.Submits["alice"] != 2 {
+		t.Errorf("expected 2 submits from alice, got %v", metrics.Annotators.Submits)
 	}
-
-	var result map[string]interface{}
-	if err := json.Unmarshal(data, &result); err != nil {
-		t.Fatalf("failed to unmarshal result: %v", err)
+	if metrics.Annotators.Capped {
+		t.Error("expected annotators not to be capped")
 	}
 
-	// check uuid
-	if result["uuid"] != "test-uuid-123" {
-		t.Errorf("expected uuid 'test-uuid-123', got %v", result["uuid"])
+	// past the cap, new annotators are dropped rather than growing the set
+	counts := newAnnotatorCounts(2)
+	for _, id := range []string{"a", "b", "c", "a"} {
+		counts.record(id)
 	}
-
-	// check queue status
-	queue, ok := result["queue"].(map[string]interface{})
-	if !ok {
-		t.Fatal("expected queue to be a map")
+	if unique, _, capped := counts.snapshot(); unique != 2 || !capped {
+		t.Errorf("expected 2 unique and capped, got %d and %v", unique, capped)
 	}
+}
 
-	if queue["total"] != float64(5) { // JSON numbers are float64
-		t.Errorf("expected total 5, got %v", queue["total"])
+// compressFloats packs and gzips values, as a client would.
+func compressFloats(t *testing.T, values []float64) *pb.CompressedFloats {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+
+	b := make([]byte, 8)
+	for _, v := range values {
+		binary.LittleEndian.PutUint64(b, math.Float64bits(v))
+		if _, err := zw.Write(b); err != nil {
+			t.Fatalf("failed to compress: %v", err)
+		}
 	}
-	if queue["active"] != float64(3) {
-		t.Errorf("expected active 3, got %v", queue["active"])
+	if err := zw.Close(); err != nil {
+		t.Fatalf("failed to compress: %v", err)
 	}
-	if queue["deferred"] != float64(2) {
-		t.Errorf("expected deferred 2, got %v", queue["deferred"])
+
+	return &pb.CompressedFloats{Algorithm: pb.Compression_GZIP, Data: buf.Bytes()}
+}
+
+func TestCompressedFloats(t *testing.T) {
+	values := []float64{0.5, 1.5, -2.25, 3, 4, 5}
+	grid := func(data *pb.Data) *pb.Request {
+		req := newTestRequest()
+		req.Inputs = []*pb.Input{{
+			Visualization: &pb.Input_Grid{Grid: &pb.Grid{Rows: 2, Cols: 3}},
+			Data:          data,
+		}}
+		return req
 	}
 
-	// check proto field exists (it's complex so just verify it's there)
-	if _, exists := result["proto"]; !exists {
-		t.Error("expected proto field to exist")
+	// a round trip ends up as the original floats
+	req := grid(&pb.Data{Data: &pb.Data_CompressedFloats{CompressedFloats: compressFloats(t, values)}})
+	if err := validate(req, validationStrict); err != nil {
+		t.Fatalf("expected compressed grid to validate: %v", err)
+	}
+	floats := req.Inputs[0].GetData().GetFloats()
+	if floats == nil || fmt.Sprint(floats.Values) != fmt.Sprint(values) {
+		t.Fatalf("expected data to be expanded to %v, got %v", values, req.Inputs[0].GetData())
 	}
-}
 
-func TestValidateMultiChannelGrid(t *testing.T) {
 	tests := []struct {
-		name    string
-		grid    *pb.MultiChannelGrid
-		data    *pb.Data
-		wantErr bool
-		errMsg  string
+		name   string
+		data   *pb.CompressedFloats
+		errMsg string
 	}{
 		{
-			name:    "nil grid",
-			grid:    nil,
-			data:    &pb.Data{},
-			wantErr: true,
-			errMsg:  "multi-channel grid cannot be nil",
-		},
-		{
-			name:    "zero rows",
-			grid:    &pb.MultiChannelGrid{Rows: 0, Cols: 5, Channels: 3},
-			data:    &pb.Data{},
-			wantErr: true,
-			errMsg:  "grid dimensions must be positive",
-		},
-		{
-			name:    "zero channels",
-			grid:    &pb.MultiChannelGrid{Rows: 2, Cols: 2, Channels: 0},
-			data:    &pb.Data{},
-			wantErr: true,
-			errMsg:  "channel count must be positive",
-		},
-		{
-			name:    "too many channels",
-			grid:    &pb.MultiChannelGrid{Rows: 2, Cols: 2, Channels: 11},
-			data:    &pb.Data{},
-			wantErr: true,
-			errMsg:  "too many channels (max 10, got 11)",
+			name:   "corrupt blob",
+			data:   &pb.CompressedFloats{Algorithm: pb.Compression_GZIP, Data: []byte("not gzip at all")},
+			errMsg: "invalid compressed data",
 		},
 		{
-			name: "wrong channel names length",
-			grid: &pb.MultiChannelGrid{
-				Rows:     2,
-				Cols:     2,
-				Channels: 3,
-				ChannelNames: []string{"R", "G"}, // should be 3
-			},
-			data:    &pb.Data{},
-			wantErr: true,
-			errMsg:  "channel names count 2 doesn't match channel count 3",
+			name:   "unknown algorithm",
+			data:   &pb.CompressedFloats{Data: compressFloats(t, values).Data},
+			errMsg: "unsupported compression algorithm",
 		},
 		{
-			name: "wrong data size",
-			grid: &pb.MultiChannelGrid{Rows: 2, Cols: 2, Channels: 3},
-			data: &pb.Data{
-				Data: &pb.Data_Floats{
-					Floats: &pb.Floats{Values: make([]float64, 10)}, // should be 2*2*3=12
-				},
-			},
-			wantErr: true,
-			errMsg:  "data size 10 doesn't match expected size 12 (rows*cols*channels=2*2*3)",
+			name:   "wrong size for grid",
+			data:   compressFloats(t, values[:4]),
+			errMsg: "data size 4 doesn't match grid size 6",
 		},
 		{
-			name: "valid multi channel grid",
-			grid: &pb.MultiChannelGrid{Rows: 2, Cols: 2, Channels: 3},
-			data: &pb.Data{
-				Data: &pb.Data_Floats{
-					Floats: &pb.Floats{Values: make([]float64, 12)}, // 2*2*3=12
-				},
-			},
-			wantErr: false,
+			name:   "NaN",
+			data:   compressFloats(t, []float64{1, 2, math.NaN(), 4, 5, 6}),
+			errMsg: "float value at index 2 is NaN",
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := validateMultiChannelGrid(tt.grid, tt.data)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("validateMultiChannelGrid() error = %v, wantErr %v", err, tt.wantErr)
-				return
-			}
-			if tt.wantErr && !strings.Contains(err.Error(), tt.errMsg) {
+			err := validate(grid(&pb.Data{Data: &pb.Data_CompressedFloats{CompressedFloats: tt.data}}), validationStrict)
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
 				t.Errorf("expected error containing %q, got %v", tt.errMsg, err)
 			}
 		})
 	}
+
+	// a truncated blob whose length isn't a whole number of floats
+	c := compressFloats(t, nil)
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte{1, 2, 3})
+	zw.Close()
+	c.Data = buf.Bytes()
+	if _, err := validation.DecompressFloats(c); err == nil || !strings.Contains(err.Error(), "not a multiple of 8") {
+		t.Errorf("expected length error, got %v", err)
+	}
 }
 
-func TestValidateScalar(t *testing.T) {
-	tests := []struct {
-		name    string
-		scalar  *pb.Scalar
-		data    *pb.Data
-		wantErr bool
-		errMsg  string
-	}{
-		{
-			name:    "nil scalar",
-			scalar:  nil,
-			data:    &pb.Data{},
-			wantErr: true,
-			errMsg:  "scalar cannot be nil",
-		},
-		{
-			name:    "empty label",
-			scalar:  &pb.Scalar{Label: "", Min: 0.0, Max: 1.0},
-			data:    &pb.Data{},
-			wantErr: true,
-			errMsg:  "scalar label is required",
-		},
-		{
-			name:    "min >= max",
-			scalar:  &pb.Scalar{Label: "test", Min: 1.0, Max: 1.0},
-			data:    &pb.Data{},
-			wantErr: true,
-			errMsg:  "scalar min 1.000000 must be less than max 1.000000",
-		},
-		{
-			name:   "wrong data size",
-			scalar: &pb.Scalar{Label: "test", Min: 0.0, Max: 1.0},
-			data: &pb.Data{
-				Data: &pb.Data_Floats{
-					Floats: &pb.Floats{Values: []float64{0.5, 0.6}}, // should be 1
-				},
-			},
-			wantErr: true,
-			errMsg:  "scalar requires exactly 1 float value (got 2)",
-		},
-		{
-			name:   "value out of range low",
-			scalar: &pb.Scalar{Label: "test", Min: 0.0, Max: 1.0},
-			data: &pb.Data{
-				Data: &pb.Data_Floats{
-					Floats: &pb.Floats{Values: []float64{-0.1}},
-				},
-			},
-			wantErr: true,
-			errMsg:  "scalar value -0.100000 is outside range [0.000000, 1.000000]",
-		},
-		{
-			name:   "value out of range high",
-			scalar: &pb.Scalar{Label: "test", Min: 0.0, Max: 1.0},
-			data: &pb.Data{
-				Data: &pb.Data_Floats{
-					Floats: &pb.Floats{Values: []float64{1.1}},
-				},
-			},
-			wantErr: true,
-			errMsg:  "scalar value 1.100000 is outside range [0.000000, 1.000000]",
-		},
-		{
-			name:   "valid scalar",
-			scalar: &pb.Scalar{Label: "test", Min: 0.0, Max: 1.0, Unit: "ratio"},
-			data: &pb.Data{
-				Data: &pb.Data_Floats{
-					Floats: &pb.Floats{Values: []float64{0.5}},
-				},
-			},
-			wantErr: false,
-		},
+func TestSubQueues(t *testing.T) {
+	s := newTestServer()
+	s.timeout.Store(int64(100 * time.Millisecond))
+	s.queueLimits = map[string]int{"a": 1}
+	client, cleanup := startTestGRPCServer(t, s)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// collect into queue a, and wait for it to be enqueued there
+	req := newTestRequest()
+	req.QueueName = "a"
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := client.Collect(ctx, req)
+		errCh <- err
+	}()
+	qa, _ := s.queueFor("a")
+	deadline := time.Now().Add(time.Second)
+	for qa.Status().Total == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for collect to be enqueued")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if total := s.queue.Status().Total; total != 0 {
+		t.Errorf("expected default queue to be empty, got %d", total)
 	}
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			err := validateScalar(tt.scalar, tt.data)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("validateScalar() error = %v, wantErr %v", err, tt.wantErr)
-				return
-			}
-			if tt.wantErr && !strings.Contains(err.Error(), tt.errMsg) {
-				t.Errorf("expected error containing %q, got %v", tt.errMsg, err)
-			}
-		})
+	// queue a is at its own limit, which doesn't affect queue b
+	shortCtx, shortCancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer shortCancel()
+	if _, err := client.Collect(shortCtx, req); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("expected ResourceExhausted from full queue a, got %v", err)
+	}
+	other := newTestRequest()
+	other.QueueName = "b"
+	if _, err := client.Collect(shortCtx, other); status.Code(err) == codes.ResourceExhausted {
+		t.Errorf("expected queue b to have room, got %v", err)
 	}
-}
 
-func TestValidateVector2D(t *testing.T) {
-	tests := []struct {
-		name    string
-		vector  *pb.Vector2D
-		data    *pb.Data
-		wantErr bool
-		errMsg  string
-	}{
-		{
-			name:    "nil vector",
-			vector:  nil,
-			data:    &pb.Data{},
-			wantErr: true,
-			errMsg:  "vector cannot be nil",
-		},
-		{
-			name:    "empty label",
-			vector:  &pb.Vector2D{Label: "", MaxMagnitude: 1.0},
-			data:    &pb.Data{},
-			wantErr: true,
-			errMsg:  "vector label is required",
-		},
-		{
-			name:    "zero max magnitude",
-			vector:  &pb.Vector2D{Label: "test", MaxMagnitude: 0.0},
-			data:    &pb.Data{},
-			wantErr: true,
-			errMsg:  "vector max_magnitude must be positive (got 0.000000)",
-		},
-		{
-			name:   "wrong data size",
-			vector: &pb.Vector2D{Label: "test", MaxMagnitude: 1.0},
-			data: &pb.Data{
-				Data: &pb.Data_Floats{
-					Floats: &pb.Floats{Values: []float64{0.5}}, // should be 2
-				},
-			},
-			wantErr: true,
-			errMsg:  "vector requires exactly 2 float values (got 1)",
-		},
-		{
-			name:   "valid vector",
-			vector: &pb.Vector2D{Label: "velocity", MaxMagnitude: 10.0},
-			data: &pb.Data{
-				Data: &pb.Data_Floats{
-					Floats: &pb.Floats{Values: []float64{3.0, 4.0}}, // magnitude = 5.0 < 10.0
-				},
-			},
-			wantErr: false,
-		},
+	// the server removes b's item once it notices the cancellation, which can
+	// be after the client has already given up
+	qb, _ := s.queueFor("b")
+	for qb.Status().Total != 0 {
+		if time.Now().After(deadline.Add(time.Second)) {
+			t.Fatal("timed out waiting for queue b to drain")
+		}
+		time.Sleep(time.Millisecond)
 	}
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			err := validateVector2D(tt.vector, tt.data)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("validateVector2D() error = %v, wantErr %v", err, tt.wantErr)
-				return
-			}
-			if tt.wantErr && !strings.Contains(err.Error(), tt.errMsg) {
-				t.Errorf("expected error containing %q, got %v", tt.errMsg, err)
-			}
-		})
+	data := func(queue string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.handleData(w, httptest.NewRequest("GET", "/data.json?queue="+queue, nil))
+		return w
+	}
+
+	if w := data("b"); w.Code != http.StatusRequestTimeout {
+		t.Fatalf("expected queue b to have nothing for us, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w := data("a")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected item from queue a, got %d: %s", w.Code, w.Body.String())
+	}
+	var served map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &served)
+	if w := submitItem(t, s, served["uuid"].(string), newTestResponse()); w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("collect failed: %v", err)
+	}
+
+	if w := data("not%20a%20name"); w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for invalid queue name, got %d", w.Code)
 	}
 }
 
-func TestValidateTimeSeries(t *testing.T) {
-	tests := []struct {
-		name       string
-		timeSeries *pb.TimeSeries
-		data       *pb.Data
-		wantErr    bool
-		errMsg     string
-	}{
-		{
-			name:       "nil time series",
-			timeSeries: nil,
-			data:       &pb.Data{},
-			wantErr:    true,
-			errMsg:     "time series cannot be nil",
-		},
-		{
-			name:       "empty label",
-			timeSeries: &pb.TimeSeries{Label: "", Points: 100, MinValue: 0.0, MaxValue: 1.0},
-			data:       &pb.Data{},
-			wantErr:    true,
-			errMsg:     "time series label is required",
-		},
-		{
-			name:       "zero points",
-			timeSeries: &pb.TimeSeries{Label: "test", Points: 0, MinValue: 0.0, MaxValue: 1.0},
-			data:       &pb.Data{},
-			wantErr:    true,
-			errMsg:     "time series points must be positive (got 0)",
-		},
-		{
-			name:       "too many points",
-			timeSeries: &pb.TimeSeries{Label: "test", Points: 1001, MinValue: 0.0, MaxValue: 1.0},
-			data:       &pb.Data{},
-			wantErr:    true,
-			errMsg:     "time series has too many points (max 1000, got 1001)",
-		},
-		{
-			name:       "min >= max",
-			timeSeries: &pb.TimeSeries{Label: "test", Points: 100, MinValue: 1.0, MaxValue: 1.0},
-			data:       &pb.Data{},
-			wantErr:    true,
-			errMsg:     "time series min_value 1.000000 must be less than max_value 1.000000",
-		},
-		{
-			name:       "wrong data size",
-			timeSeries: &pb.TimeSeries{Label: "test", Points: 100, MinValue: 0.0, MaxValue: 1.0},
-			data: &pb.Data{
-				Data: &pb.Data_Floats{
-					Floats: &pb.Floats{Values: make([]float64, 50)}, // should be 100
-				},
-			},
-			wantErr: true,
-			errMsg:  "data size 50 doesn't match expected points 100",
-		},
-		{
-			name:       "value out of range",
-			timeSeries: &pb.TimeSeries{Label: "test", Points: 3, MinValue: 0.0, MaxValue: 1.0},
-			data: &pb.Data{
-				Data: &pb.Data_Floats{
-					Floats: &pb.Floats{Values: []float64{0.5, 1.5, 0.3}}, // 1.5 is out of range
-				},
-			},
-			wantErr: true,
-			errMsg:  "time series value at index 1 (1.500000) is outside range [0.000000, 1.000000]",
-		},
-		{
-			name:       "valid time series",
-			timeSeries: &pb.TimeSeries{Label: "temperature", Points: 3, MinValue: -10.0, MaxValue: 40.0},
-			data: &pb.Data{
-				Data: &pb.Data_Floats{
-					Floats: &pb.Floats{Values: []float64{20.5, 25.0, 18.3}},
-				},
-			},
-			wantErr: false,
-		},
+func TestAutoAnswerDefault(t *testing.T) {
+	s := newTestServer()
+	s.autoAnswerFraction = 0.5
+	client, cleanup := startTestGRPCServer(t, s)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 400*time.Millisecond)
+	defer cancel()
+
+	req := newTestRequest()
+	req.DefaultResponse = newTestResponse()
+
+	start := time.Now()
+	res, err := client.Collect(ctx, req)
+	if err != nil {
+		t.Fatalf("expected default response instead of an error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed >= 400*time.Millisecond {
+		t.Errorf("expected auto-answer before the deadline, took %v", elapsed)
+	}
+	if !res.GetMeta().GetAuto() {
+		t.Error("expected response to be flagged as auto")
+	}
+	if !proto.Equal(res.Output, req.DefaultResponse.Output) {
+		t.Errorf("expected default output %v, got %v", req.DefaultResponse.Output, res.Output)
+	}
+	if total := s.queue.Status().Total; total != 0 {
+		t.Errorf("expected auto-answered item to leave the queue, got total %d", total)
 	}
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			err := validateTimeSeries(tt.timeSeries, tt.data)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("validateTimeSeries() error = %v, wantErr %v", err, tt.wantErr)
-				return
-			}
-			if tt.wantErr && !strings.Contains(err.Error(), tt.errMsg) {
-				t.Errorf("expected error containing %q, got %v", tt.errMsg, err)
-			}
-		})
+	// the default must be a valid answer to the request
+	bad := newTestRequest()
+	bad.DefaultResponse = &pb.Response{
+		Output: &pb.Output{
+			Output: &pb.Output_OptionList{OptionList: &pb.OptionListOutput{Index: 99}},
+		},
 	}
-}
\ No newline at end of file
+	err = validate(bad, validationStrict)
+	if err == nil || !strings.Contains(err.Error(), "default response") {
+		t.Errorf("expected invalid default response to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// counters returns the cumulative counters of e by the names they're
+// persisted under. InFlight is a gauge, so it isn't included.
+func (e *ErrorStats) counters() map[string]*int64 {
+	return map[string]*int64{
+		"validation_errors":  &e.ValidationErrors,
+		"timeout_errors":     &e.TimeoutErrors,
+		"internal_errors":    &e.InternalErrors,
+		"resource_exhausted": &e.ResourceExhausted,
+		"total_requests":     &e.TotalRequests,
+		"queue_full":         &e.QueueFull,
+		"rate_limited":       &e.RateLimited,
+		"too_large":          &e.TooLarge,
+		"short_deadlines":    &e.ShortDeadlines,
+		"defer":              &e.DeferCount,
+		"skip":               &e.SkipCount,
+		"abstain":            &e.AbstainCount,
+	}
+}
+
+// saveMu stops a periodic save and the final one at shutdown from writing the
+// same temporary file at once.
+var saveMu sync.Mutex
+
+// saveStats writes a snapshot of the counters in e to path, as JSON. It
+// writes to a temporary file first, then renames it into place, so that a
+// crash mid-write leaves the previous snapshot intact.
+func saveStats(path string, e *ErrorStats) error {
+	saveMu.Lock()
+	defer saveMu.Unlock()
+
+	snapshot := map[string]int64{}
+	for name, c := range e.counters() {
+		snapshot[name] = atomic.LoadInt64(c)
+	}
+
+	b, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// loadStats adds the counters saved at path by saveStats to e. A missing file
+// isn't an error, since there's nothing to restore on the first run. A corrupt
+// one is, and leaves e unchanged.
+func loadStats(path string, e *ErrorStats) error {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var snapshot map[string]int64
+	if err := json.Unmarshal(b, &snapshot); err != nil {
+		return fmt.Errorf("corrupt metrics state: %w", err)
+	}
+
+	// names which are no longer counted are ignored
+	for name, c := range e.counters() {
+		atomic.AddInt64(c, snapshot[name])
+	}
+	return nil
+}
+
+// runStatsSaver saves stats to path every interval until ctx is done. main
+// saves once more at shutdown.
+func runStatsSaver(ctx context.Context, path string, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if err := saveStats(path, stats); err != nil {
+				slog.Warn("failed to save metrics state", "path", path, "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"compress/gzip"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// gzipResponseWriter compresses the response body lazily: the header is held
+// back until the first Write, so that responses without a body (e.g. 204s and
+// 304s) and images, which are already compressed, are sent as they are.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz *gzip.Writer
+
+	code        int
+	wroteHeader bool
+}
+
+func (w *gzipResponseWriter) WriteHeader(code int) {
+	if code < 200 {
+		// informational, so the real status is still to come
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if w.code != 0 {
+		return
+	}
+	w.code = code
+	if code == http.StatusNoContent || code == http.StatusNotModified {
+		w.writeHeader(false)
+	}
+}
+
+// writeHeader sends the held status, with the gzip headers if compress.
+func (w *gzipResponseWriter) writeHeader(compress bool) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	if compress {
+		h := w.Header()
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", "gzip")
+		w.gz = gzip.NewWriter(w.ResponseWriter)
+	}
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(w.code)
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		// sniff before compressing, as net/http would have from the plain body
+		h := w.Header()
+		if h.Get("Content-Type") == "" {
+			h.Set("Content-Type", http.DetectContentType(b))
+		}
+		w.writeHeader(!strings.HasPrefix(h.Get("Content-Type"), "image/"))
+	}
+	if w.gz == nil {
+		return w.ResponseWriter.Write(b)
+	}
+	return w.gz.Write(b)
+}
+
+// Flush pushes any buffered compressed data to the client, so that streaming
+// handlers still make progress when wrapped. If nothing has been written yet,
+// the header is sent uncompressed, since flushing commits it.
+func (w *gzipResponseWriter) Flush() {
+	if !w.wroteHeader {
+		w.writeHeader(false)
+	}
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *gzipResponseWriter) close() {
+	if !w.wroteHeader && w.code != 0 {
+		w.writeHeader(false)
+	}
+	if w.gz != nil {
+		w.gz.Close()
+	}
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		if strings.TrimSpace(strings.SplitN(enc, ";", 2)[0]) == "gzip" {
+			return true
+		}
+	}
+	return false
+}
+
+// withGzip compresses responses for clients which send Accept-Encoding: gzip.
+func withGzip(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if r.Method == http.MethodHead || !acceptsGzip(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.close()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// statusRecorder captures the status and body size of a response, for
+// withHTTPMetrics.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *statusRecorder) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *statusRecorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// withHTTPMetrics records the status, size, and latency of each request
+// against the mux pattern which served it. It must wrap the mux directly, since
+// that's what sets the pattern. Sizes are of the body before compression.
+func withHTTPMetrics(m *httpMetrics, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		m.record(route, status, rec.bytes, time.Since(start))
+	})
+}
+
+// requireAPIKey rejects requests which don't present the configured admin API
+// key, either as a bearer token or in the X-API-Key header. If no key is
+// configured, all requests are allowed.
+func requireAPIKey(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if config.AdminAPIKey == "" {
+			next(w, r)
+			return
+		}
+
+		key := r.Header.Get("X-API-Key")
+		if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+			key = strings.TrimPrefix(auth, "Bearer ")
+		}
+
+		if subtle.ConstantTimeCompare([]byte(key), []byte(config.AdminAPIKey)) != 1 {
+			writeJSONError(w, http.StatusUnauthorized,
+				"invalid or missing api key")
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// withCORS allows browsers on the given origins (or any origin, if the list
+// contains "*") to call the API, answering preflight requests directly. With
+// no origins configured, no CORS headers are set and browsers enforce
+// same-origin.
+func withCORS(origins []string, next http.Handler) http.Handler {
+	allowed := make(map[string]bool, len(origins))
+	for _, o := range origins {
+		allowed[o] = true
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || !(allowed[origin] || allowed["*"]) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Add("Vary", "Origin")
+		h.Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+		h.Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key, "+annotatorHeader)
+		h.Set("Access-Control-Expose-Headers", "X-Collector-UUID")
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -2,7 +2,18 @@ package main
 
 import (
 	"google.golang.org/grpc/codes"
+	"sort"
+	"strconv"
+	"sync"
 	"sync/atomic"
+	"time"
+)
+
+// reasons for ResourceExhausted rejections
+const (
+	rejectQueueFull   = "queue_full"
+	rejectRateLimited = "rate_limited"
+	rejectTooLarge    = "too_large"
 )
 
 type ErrorStats struct {
@@ -11,10 +22,390 @@ type ErrorStats struct {
 	InternalErrors    int64
 	ResourceExhausted int64
 	TotalRequests     int64
+
+	// breakdown of ResourceExhausted
+	QueueFull   int64
+	RateLimited int64
+	TooLarge    int64
+
+	// gauge of Collect calls currently blocked waiting for a response
+	InFlight int64
+
+	// Collect calls whose deadline was shorter than their estimated_seconds
+	ShortDeadlines int64
+
+	// annotator actions
+	DeferCount   int64
+	SkipCount    int64
+	AbstainCount int64
 }
 
 var stats = &ErrorStats{}
 
+// recentErrors keeps the last few errors returned to grpc clients, for
+// /debug/errors.
+var recentErrors = NewErrorLog(100)
+
+type ErrorEvent struct {
+	Code    string    `json:"code"`
+	Message string    `json:"message"`
+	Request string    `json:"request,omitempty"`
+	Time    time.Time `json:"time"`
+
+	err error
+}
+
+// ErrorLog is a bounded ring of recent errors. Once full, the oldest are
+// overwritten.
+type ErrorLog struct {
+	mu     sync.Mutex
+	events []ErrorEvent
+	next   int
+	full   bool
+}
+
+func NewErrorLog(size int) *ErrorLog {
+	return &ErrorLog{
+		events: make([]ErrorEvent, size),
+	}
+}
+
+func (l *ErrorLog) Append(code codes.Code, msg string, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.events[l.next] = ErrorEvent{Code: code.String(), Message: msg, Time: time.Now(), err: err}
+	l.next = (l.next + 1) % len(l.events)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// Annotate sets the request summary of the most recent entry for err, if it's
+// still retained.
+func (l *ErrorLog) Annotate(err error, summary string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for i := 1; i <= len(l.events); i++ {
+		e := &l.events[(l.next-i+len(l.events))%len(l.events)]
+		if e.err == err {
+			e.Request = summary
+			return
+		}
+	}
+}
+
+// Events returns the retained errors, oldest first.
+func (l *ErrorLog) Events() []ErrorEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	start, n := 0, l.next
+	if l.full {
+		start, n = l.next, len(l.events)
+	}
+
+	out := make([]ErrorEvent, 0, n)
+	for i := 0; i < n; i++ {
+		out = append(out, l.events[(start+i)%len(l.events)])
+	}
+	return out
+}
+
+// answered tracks the recent rate of submitted responses.
+var answered = newThroughput(5*time.Minute, 30)
+
+// throughput counts events in a ring of time buckets, so that the rate
+// reflects the recent window rather than the lifetime average.
+type throughput struct {
+	mu     sync.Mutex
+	width  time.Duration
+	counts []int64
+	ids    []int64 // which bucket (time since epoch / width) each slot holds
+}
+
+func newThroughput(window time.Duration, buckets int) *throughput {
+	return &throughput{
+		width:  window / time.Duration(buckets),
+		counts: make([]int64, buckets),
+		ids:    make([]int64, buckets),
+	}
+}
+
+func (t *throughput) record(now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	id := now.UnixNano() / int64(t.width)
+	slot := id % int64(len(t.counts))
+	if t.ids[slot] != id {
+		t.ids[slot] = id
+		t.counts[slot] = 0
+	}
+	t.counts[slot]++
+}
+
+// perMinute returns the average rate over the window ending at now.
+func (t *throughput) perMinute(now time.Time) float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	id := now.UnixNano() / int64(t.width)
+	n := int64(len(t.counts))
+
+	var total int64
+	for i, bid := range t.ids {
+		if bid > id-n && bid <= id {
+			total += t.counts[i]
+		}
+	}
+
+	window := t.width * time.Duration(n)
+	return float64(total) / window.Minutes()
+}
+
+// maxTrackedAnnotators bounds the memory used by annotatorCounts. Beyond it,
+// submits from new annotators are no longer counted.
+const maxTrackedAnnotators = 1000
+
+// annotatorHeader identifies the annotator making a submit, if the frontend or
+// a proxy in front of it sets one.
+const annotatorHeader = "X-Annotator-ID"
+
+// annotatorCounts counts submits per annotator id, for up to max distinct ids.
+type annotatorCounts struct {
+	mu     sync.Mutex
+	max    int
+	counts map[string]int64
+	capped bool
+}
+
+func newAnnotatorCounts(max int) *annotatorCounts {
+	return &annotatorCounts{
+		max:    max,
+		counts: make(map[string]int64),
+	}
+}
+
+func (a *annotatorCounts) record(id string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, ok := a.counts[id]; !ok && len(a.counts) >= a.max {
+		a.capped = true
+		return
+	}
+	a.counts[id]++
+}
+
+// snapshot returns the number of distinct annotators, a copy of the submit
+// counts, and whether any annotators went uncounted because the cap was hit.
+func (a *annotatorCounts) snapshot() (unique int, counts map[string]int64, capped bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	counts = make(map[string]int64, len(a.counts))
+	for id, n := range a.counts {
+		counts[id] = n
+	}
+	return len(a.counts), counts, a.capped
+}
+
+// httpLatencySamples is how many of its most recent latencies each route keeps,
+// to compute percentiles from.
+const httpLatencySamples = 1000
+
+// routeStats are the counters for one route. latencies is a ring, overwritten
+// once full.
+type routeStats struct {
+	requests  int64
+	bytes     int64
+	statuses  map[int]int64
+	latencies []time.Duration
+	next      int
+}
+
+// httpMetrics counts requests per mux pattern, for the "http" section of
+// /metrics. The patterns are fixed at startup, so the map stays small.
+type httpMetrics struct {
+	mu     sync.Mutex
+	routes map[string]*routeStats
+}
+
+func newHTTPMetrics() *httpMetrics {
+	return &httpMetrics{
+		routes: make(map[string]*routeStats),
+	}
+}
+
+func (m *httpMetrics) record(route string, status int, bytes int64, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rs, ok := m.routes[route]
+	if !ok {
+		rs = &routeStats{statuses: make(map[int]int64)}
+		m.routes[route] = rs
+	}
+
+	rs.requests++
+	rs.bytes += bytes
+	rs.statuses[status]++
+	if len(rs.latencies) < httpLatencySamples {
+		rs.latencies = append(rs.latencies, d)
+	} else {
+		rs.latencies[rs.next] = d
+		rs.next = (rs.next + 1) % httpLatencySamples
+	}
+}
+
+// httpRouteSnapshot is the JSON form of routeStats. Latencies are in
+// milliseconds, over the most recent httpLatencySamples requests.
+type httpRouteSnapshot struct {
+	Requests int64            `json:"requests"`
+	Bytes    int64            `json:"bytes"`
+	Statuses map[string]int64 `json:"statuses"`
+	P50Ms    float64          `json:"p50_ms"`
+	P95Ms    float64          `json:"p95_ms"`
+}
+
+func (m *httpMetrics) snapshot() map[string]httpRouteSnapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	out := make(map[string]httpRouteSnapshot, len(m.routes))
+	for route, rs := range m.routes {
+		statuses := make(map[string]int64, len(rs.statuses))
+		for code, n := range rs.statuses {
+			statuses[strconv.Itoa(code)] = n
+		}
+
+		sorted := append([]time.Duration(nil), rs.latencies...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+		out[route] = httpRouteSnapshot{
+			Requests: rs.requests,
+			Bytes:    rs.bytes,
+			Statuses: statuses,
+			P50Ms:    percentileMs(sorted, 0.50),
+			P95Ms:    percentileMs(sorted, 0.95),
+		}
+	}
+	return out
+}
+
+// percentileMs returns the p'th percentile (nearest rank) of sorted, in
+// milliseconds, or 0 if it's empty.
+func percentileMs(sorted []time.Duration, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(p*float64(len(sorted))+0.5) - 1
+	i = max(0, min(i, len(sorted)-1))
+	return float64(sorted[i]) / float64(time.Millisecond)
+}
+
+// metricsRetention is how far back /metrics/timeseries can look.
+const metricsRetention = 24 * time.Hour
+
+// metricsSample is a snapshot of the lifetime counters.
+type metricsSample struct {
+	time     time.Time
+	requests int64
+	errors   int64
+	defers   int64
+	skips    int64
+}
+
+// metricsBucket is the change in the counters between two samples.
+type metricsBucket struct {
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Requests int64     `json:"requests"`
+	Errors   int64     `json:"errors"`
+	Defers   int64     `json:"defers"`
+	Skips    int64     `json:"skips"`
+}
+
+// metricsSeries is a ring of periodic counter samples, from which per-interval
+// deltas are computed. Once full, the oldest are overwritten. A nil
+// *metricsSeries records nothing.
+type metricsSeries struct {
+	mu      sync.Mutex
+	samples []metricsSample
+	next    int
+	full    bool
+}
+
+func newMetricsSeries(size int) *metricsSeries {
+	if size <= 0 {
+		return nil
+	}
+
+	return &metricsSeries{
+		samples: make([]metricsSample, size),
+	}
+}
+
+func (m *metricsSeries) sample(now time.Time, s ErrorStats) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.samples[m.next] = metricsSample{
+		time:     now,
+		requests: s.TotalRequests,
+		errors:   s.ValidationErrors + s.TimeoutErrors + s.InternalErrors + s.ResourceExhausted,
+		defers:   s.DeferCount,
+		skips:    s.SkipCount,
+	}
+	m.next = (m.next + 1) % len(m.samples)
+	if m.next == 0 {
+		m.full = true
+	}
+}
+
+// deltas returns one bucket per pair of consecutive samples which ended within
+// the window before now, oldest first.
+func (m *metricsSeries) deltas(now time.Time, window time.Duration) []metricsBucket {
+	out := []metricsBucket{}
+	if m == nil {
+		return out
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	start, n := 0, m.next
+	if m.full {
+		start, n = m.next, len(m.samples)
+	}
+
+	since := now.Add(-window)
+	for i := 1; i < n; i++ {
+		prev := m.samples[(start+i-1)%len(m.samples)]
+		cur := m.samples[(start+i)%len(m.samples)]
+		if !cur.time.After(since) || cur.time.After(now) {
+			continue
+		}
+
+		out = append(out, metricsBucket{
+			Start:    prev.time,
+			End:      cur.time,
+			Requests: cur.requests - prev.requests,
+			Errors:   cur.errors - prev.errors,
+			Defers:   cur.defers - prev.defers,
+			Skips:    cur.skips - prev.skips,
+		})
+	}
+
+	return out
+}
+
 func recordError(code codes.Code) {
 	atomic.AddInt64(&stats.TotalRequests, 1)
 
@@ -30,6 +421,17 @@ func recordError(code codes.Code) {
 	}
 }
 
+func recordRejectReason(reason string) {
+	switch reason {
+	case rejectQueueFull:
+		atomic.AddInt64(&stats.QueueFull, 1)
+	case rejectRateLimited:
+		atomic.AddInt64(&stats.RateLimited, 1)
+	case rejectTooLarge:
+		atomic.AddInt64(&stats.TooLarge, 1)
+	}
+}
+
 func getStats() ErrorStats {
 	return ErrorStats{
 		ValidationErrors:  atomic.LoadInt64(&stats.ValidationErrors),
@@ -37,5 +439,13 @@ func getStats() ErrorStats {
 		InternalErrors:    atomic.LoadInt64(&stats.InternalErrors),
 		ResourceExhausted: atomic.LoadInt64(&stats.ResourceExhausted),
 		TotalRequests:     atomic.LoadInt64(&stats.TotalRequests),
+		QueueFull:         atomic.LoadInt64(&stats.QueueFull),
+		RateLimited:       atomic.LoadInt64(&stats.RateLimited),
+		TooLarge:          atomic.LoadInt64(&stats.TooLarge),
+		InFlight:          atomic.LoadInt64(&stats.InFlight),
+		ShortDeadlines:    atomic.LoadInt64(&stats.ShortDeadlines),
+		DeferCount:        atomic.LoadInt64(&stats.DeferCount),
+		SkipCount:         atomic.LoadInt64(&stats.SkipCount),
+		AbstainCount:      atomic.LoadInt64(&stats.AbstainCount),
 	}
 }
@@ -0,0 +1,97 @@
+package main
+
+import (
+	pb "github.com/adammck/collector/proto/gen"
+	"github.com/adammck/collector/validation"
+)
+
+// transpose converts column-major grid data to row-major. Each cell is
+// channels values wide, and the channels of a cell stay in order. The input
+// must already have been validated to be rows*cols*channels long.
+func transpose[T any](values []T, rows, cols, channels int) []T {
+	out := make([]T, len(values))
+	for r := 0; r < rows; r++ {
+		for c := 0; c < cols; c++ {
+			src := (c*rows + r) * channels
+			dst := (r*cols + c) * channels
+			copy(out[dst:dst+channels], values[src:src+channels])
+		}
+	}
+	return out
+}
+
+// transposeData converts data from column-major to row-major in place.
+func transposeData(data *pb.Data, rows, cols, channels int) {
+	switch d := data.GetData().(type) {
+	case *pb.Data_Ints:
+		d.Ints.Values = transpose(d.Ints.Values, rows, cols, channels)
+	case *pb.Data_Floats:
+		d.Floats.Values = transpose(d.Floats.Values, rows, cols, channels)
+	case *pb.Data_Bytes:
+		d.Bytes.Values = transpose(d.Bytes.Values, rows, cols, channels)
+	}
+}
+
+// toRowMajor rewrites a column-major grid input as row-major, which is the
+// only ordering the frontend understands. Other inputs are left alone.
+func toRowMajor(input *pb.Input) {
+	switch v := input.Visualization.(type) {
+	case *pb.Input_Grid:
+		if v.Grid.Ordering == pb.Ordering_COL_MAJOR {
+			transposeData(input.Data, int(v.Grid.Rows), int(v.Grid.Cols), 1)
+			if len(v.Grid.Mask) > 0 {
+				v.Grid.Mask = transpose(v.Grid.Mask, int(v.Grid.Rows), int(v.Grid.Cols), 1)
+			}
+			v.Grid.Ordering = pb.Ordering_ROW_MAJOR
+		}
+	case *pb.Input_MultiGrid:
+		if v.MultiGrid.Ordering == pb.Ordering_COL_MAJOR {
+			transposeData(input.Data, int(v.MultiGrid.Rows), int(v.MultiGrid.Cols), int(v.MultiGrid.Channels))
+			v.MultiGrid.Ordering = pb.Ordering_ROW_MAJOR
+		}
+	}
+}
+
+func hasColMajorGrid(req *pb.Request) bool {
+	for _, input := range req.GetInputs() {
+		if input.GetGrid().GetOrdering() == pb.Ordering_COL_MAJOR ||
+			input.GetMultiGrid().GetOrdering() == pb.Ordering_COL_MAJOR {
+			return true
+		}
+	}
+	return false
+}
+
+// editsToColMajor rewrites each edit in res of a column-major grid input back
+// to column-major, undoing toRowMajor, since the annotator edited the grid as
+// it was displayed. res must already have passed validateAnswer.
+func editsToColMajor(req *pb.Request, res *pb.Response) {
+	schemas, outputs := []*pb.OutputSchema{req.GetOutput()}, []*pb.Output{res.GetOutput()}
+	if len(req.GetOutputs()) > 0 {
+		schemas, outputs = req.GetOutputs(), res.GetOutputs()
+	}
+
+	for i, schema := range schemas {
+		edit := schema.GetEdit()
+		if edit == nil || i >= len(outputs) || int(edit.Input) >= len(req.GetInputs()) {
+			continue
+		}
+		data := outputs[i].GetEdit().GetData()
+		if c, ok := data.GetData().(*pb.Data_CompressedFloats); ok && c.CompressedFloats != nil {
+			values, err := validation.DecompressFloats(c.CompressedFloats)
+			if err != nil {
+				continue
+			}
+			data.Data = &pb.Data_Floats{Floats: &pb.Floats{Values: values}}
+		}
+
+		// transposing a row-major rows*cols grid as if it were a col-major
+		// cols*rows one is the inverse of transposeData
+		input := req.Inputs[edit.Input]
+		if g := input.GetGrid(); g.GetOrdering() == pb.Ordering_COL_MAJOR {
+			transposeData(data, int(g.Cols), int(g.Rows), 1)
+		} else if g := input.GetMultiGrid(); g.GetOrdering() == pb.Ordering_COL_MAJOR {
+			transposeData(data, int(g.Cols), int(g.Rows), int(g.Channels))
+		}
+	}
+}
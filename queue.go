@@ -3,7 +3,9 @@ package main
 import (
 	"container/list"
 	"context"
+	"errors"
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
@@ -17,20 +19,111 @@ type QueueItem struct {
 	AddedAt  time.Time
 	Deferred bool
 	Context  context.Context
+
+	// ServedAt is when the item was last handed to an annotator.
+	ServedAt time.Time
+
+	// DeferCount is how many times the item has been deferred.
+	DeferCount int
+
+	// Pinned items are served before all others, in the order they were
+	// pinned, whatever their priority or tag; see Queue.Pin.
+	Pinned bool
+
+	// Cancel, if set, aborts the Collect waiting on this item.
+	Cancel context.CancelCauseFunc
+
+	// Acked, if set, is closed by the Collect waiting on this item once it
+	// has taken the answer from Response, so that the server can warn about
+	// answers which nobody picked up.
+	Acked chan struct{}
+
+	// Replay marks an item re-served from history by /admin/replay. Nobody is
+	// waiting for it, so its Response is nil and answers only go to history.
+	Replay bool
+
+	// Permutations is the display order of each output schema's options, if
+	// any are shuffled; see shufflePermutations.
+	Permutations [][]int
+
+	// holders is how many annotators are currently shown the item, which is
+	// only ever more than one for RaceReplicas. Guarded by server.cmu.
+	holders int
+
+	// replicaQueued is set by server.claim if it took a race replica out of
+	// the queue, so that server.restore can put it back. Guarded by
+	// server.cmu.
+	replicaQueued bool
 }
 
 type QueueStatus struct {
 	Total    int `json:"total"`
 	Active   int `json:"active"`
 	Deferred int `json:"deferred"`
+
+	// set by DetailedStatus: items by tag ("" if untagged), and by the kind
+	// of visualization of their inputs. An item with several kinds counts
+	// once under each. At most maxStatusTags tags are counted; if there were
+	// more, TagsTruncated is set.
+	ByTag         map[string]int `json:"by_tag,omitempty"`
+	ByType        map[string]int `json:"by_type,omitempty"`
+	TagsTruncated bool           `json:"tags_truncated,omitempty"`
 }
 
+// maxStatusTags bounds QueueStatus.ByTag, since tags come from clients.
+const maxStatusTags = 100
+
+const (
+	// QueueModeFIFO serves the oldest active item first.
+	QueueModeFIFO = "fifo"
+
+	// QueueModeTagRoundRobin cycles through the distinct tags of active
+	// items, serving the oldest item of each in turn, so that a campaign with
+	// a large backlog doesn't starve the others.
+	QueueModeTagRoundRobin = "tag-round-robin"
+)
+
+// ErrQueuePaused is returned by Dequeue and GetNext while the queue is paused.
+var ErrQueuePaused = errors.New("queue paused")
+
+// ErrQueueEmpty is returned by Dequeue when there are no items at all, and
+// wrapped by GetNext when it times out waiting for one.
+var ErrQueueEmpty = errors.New("queue empty")
+
+// ErrAllDeferred is returned by Dequeue when there are items, but every one of
+// them is deferred, and by GetNext if that's still so when it times out.
+// Deferred items are never served again, so only new items will help.
+var ErrAllDeferred = errors.New("all items deferred")
+
+// ErrDefersExhausted is returned by Defer when an item has been deferred more
+// than maxDefers times. The item is removed, and its Collect is cancelled with
+// this as the cause.
+var ErrDefersExhausted = errors.New("exhausted defers")
+
 type Queue struct {
 	items    *list.List
 	itemsMap map[string]*list.Element
 	mu       sync.RWMutex
 
-	waiters map[chan struct{}]struct{}
+	mode    string
+	lastTag *string // rotation cursor for QueueModeTagRoundRobin
+	paused  bool
+	events  *EventLog
+
+	// agingThreshold, if positive, raises an item's effective priority by one
+	// for each threshold it has waited, so low priorities aren't starved.
+	agingThreshold time.Duration
+
+	// maxDefers, if positive, is how many times an item can be deferred before
+	// it's given up on.
+	maxDefers int
+
+	// notifier is told the queue length after every change.
+	notifier *notifier
+
+	// waiters are the channels of blocked GetNext calls, oldest first, so
+	// that they're served in the order they arrived.
+	waiters *list.List
 	wmu     sync.Mutex
 }
 
@@ -38,10 +131,25 @@ func NewQueue() *Queue {
 	return &Queue{
 		items:    list.New(),
 		itemsMap: make(map[string]*list.Element),
-		waiters:  make(map[chan struct{}]struct{}),
+		mode:     QueueModeFIFO,
+		waiters:  list.New(),
 	}
 }
 
+// SetMode changes the order in which Dequeue selects items.
+func (q *Queue) SetMode(mode string) error {
+	if mode != QueueModeFIFO && mode != QueueModeTagRoundRobin {
+		return fmt.Errorf("unknown queue mode: %s", mode)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.mode = mode
+	q.lastTag = nil
+	return nil
+}
+
 func (q *Queue) Enqueue(item *QueueItem) error {
 	q.mu.Lock()
 	defer q.mu.Unlock()
@@ -52,6 +160,61 @@ func (q *Queue) Enqueue(item *QueueItem) error {
 
 	elem := q.items.PushBack(item)
 	q.itemsMap[item.ID] = elem
+	q.events.Append(EventEnqueue, item.ID)
+	q.notifier.observe(q.items.Len())
+	q.notifyWaiters()
+
+	return nil
+}
+
+// EnqueueBatch adds all of items under a single lock, waking waiters once at
+// the end. If any ID is already in the queue, or appears twice in items,
+// nothing is added.
+func (q *Queue) EnqueueBatch(items []*QueueItem) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	seen := make(map[string]bool, len(items))
+	for _, item := range items {
+		if _, exists := q.itemsMap[item.ID]; exists || seen[item.ID] {
+			return fmt.Errorf("item already in queue: %s", item.ID)
+		}
+		seen[item.ID] = true
+	}
+
+	for _, item := range items {
+		q.itemsMap[item.ID] = q.items.PushBack(item)
+		q.events.Append(EventEnqueue, item.ID)
+	}
+	q.notifier.observe(q.items.Len())
+	q.notifyWaitersN(len(items))
+
+	return nil
+}
+
+// Requeue puts a previously dequeued item back at the front of the queue, so
+// that it's served next rather than waiting behind newer items. Pinned items
+// stay ahead of it, unless it was pinned itself.
+func (q *Queue) Requeue(item *QueueItem) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, exists := q.itemsMap[item.ID]; exists {
+		return fmt.Errorf("item already in queue: %s", item.ID)
+	}
+
+	var elem *list.Element
+	switch e := q.firstUnpinned(nil); {
+	case item.Pinned:
+		elem = q.items.PushFront(item)
+	case e != nil:
+		elem = q.items.InsertBefore(item, e)
+	default:
+		elem = q.items.PushBack(item)
+	}
+	q.itemsMap[item.ID] = elem
+	q.events.Append(EventRequeue, item.ID)
+	q.notifier.observe(q.items.Len())
 	q.notifyWaiters()
 
 	return nil
@@ -61,34 +224,259 @@ func (q *Queue) Dequeue() (*QueueItem, error) {
 	q.mu.Lock()
 	defer q.mu.Unlock()
 
+	if q.paused {
+		return nil, ErrQueuePaused
+	}
+
+	elem := q.nextPinned()
+	if elem == nil && q.mode == QueueModeTagRoundRobin {
+		elem = q.nextRoundRobin()
+	} else if elem == nil {
+		elem = q.nextByPriority(time.Now())
+	}
+
+	if elem == nil {
+		if q.items.Len() > 0 {
+			return nil, ErrAllDeferred
+		}
+		return nil, ErrQueueEmpty
+	}
+
+	item := elem.Value.(*QueueItem)
+	q.items.Remove(elem)
+	delete(q.itemsMap, item.ID)
+	q.events.Append(EventDequeue, item.ID)
+	q.notifier.observe(q.items.Len())
+	return item, nil
+}
+
+// nextPinned returns the frontmost active pinned element, or nil. Caller must
+// hold mu.
+func (q *Queue) nextPinned() *list.Element {
+	for e := q.items.Front(); e != nil; e = e.Next() {
+		if item := e.Value.(*QueueItem); item.Pinned && !item.Deferred {
+			return e
+		}
+	}
+	return nil
+}
+
+// firstUnpinned returns the frontmost element which isn't pinned, other than
+// skip, or nil. Caller must hold mu.
+func (q *Queue) firstUnpinned(skip *list.Element) *list.Element {
+	for e := q.items.Front(); e != nil; e = e.Next() {
+		if e != skip && !e.Value.(*QueueItem).Pinned {
+			return e
+		}
+	}
+	return nil
+}
+
+// Pin moves the item ahead of every unpinned item, so that it's served next,
+// and marks it so that it stays there: items requeued later go behind it, and
+// items pinned later go behind it too. A deferred item is made active again,
+// and pinning an item twice changes nothing. Deferring it unpins it.
+func (q *Queue) Pin(id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	elem, ok := q.itemsMap[id]
+	if !ok {
+		return fmt.Errorf("item not found: %s", id)
+	}
+
+	item := elem.Value.(*QueueItem)
+	if item.Pinned {
+		return nil
+	}
+
+	if e := q.firstUnpinned(elem); e != nil {
+		q.items.MoveBefore(elem, e)
+	} else {
+		q.items.MoveToBack(elem)
+	}
+	// a deferred item which becomes active may be what a waiter needs
+	wasDeferred := item.Deferred
+	item.Pinned = true
+	item.Deferred = false
+	q.events.Append(EventPin, id)
+	if wasDeferred {
+		q.notifyWaiters()
+	}
+
+	return nil
+}
+
+// nextByPriority returns the active element with the highest effective
+// priority, or nil. Among equals, the one nearest the front (i.e. the oldest,
+// unless deferred or requeued) wins, so without priorities this is FIFO.
+// Caller must hold mu.
+func (q *Queue) nextByPriority(now time.Time) *list.Element {
+	var best *list.Element
+	var bestPriority int64
+
 	for e := q.items.Front(); e != nil; e = e.Next() {
 		item := e.Value.(*QueueItem)
+		if item.Deferred {
+			continue
+		}
 
-		if !item.Deferred {
-			q.items.Remove(e)
-			delete(q.itemsMap, item.ID)
-			return item, nil
+		p := q.effectivePriority(item, now)
+		if best == nil || p > bestPriority {
+			best, bestPriority = e, p
+		}
+	}
+
+	return best
+}
+
+// effectivePriority is the item's requested priority, plus one for each
+// agingThreshold it has been waiting.
+func (q *Queue) effectivePriority(item *QueueItem, now time.Time) int64 {
+	p := int64(item.Request.GetPriority())
+	if q.agingThreshold > 0 {
+		p += int64(now.Sub(item.AddedAt) / q.agingThreshold)
+	}
+	return p
+}
+
+// nextRoundRobin returns the oldest active element of the tag following the
+// one served last (in sorted order, wrapping around), and advances the
+// cursor. The first call starts with the tag of the oldest active item.
+// Caller must hold mu.
+func (q *Queue) nextRoundRobin() *list.Element {
+	oldest := make(map[string]*list.Element)
+	var first *list.Element
+
+	for e := q.items.Front(); e != nil; e = e.Next() {
+		item := e.Value.(*QueueItem)
+		if item.Deferred {
+			continue
+		}
+		if first == nil {
+			first = e
+		}
+		tag := item.Request.GetTag()
+		if _, ok := oldest[tag]; !ok {
+			oldest[tag] = e
+		}
+	}
+
+	if first == nil {
+		return nil
+	}
+
+	tag := first.Value.(*QueueItem).Request.GetTag()
+	if q.lastTag != nil {
+		tags := make([]string, 0, len(oldest))
+		for t := range oldest {
+			tags = append(tags, t)
+		}
+		sort.Strings(tags)
+
+		// the first tag after the cursor, or wrap around to the start
+		i := sort.SearchStrings(tags, *q.lastTag)
+		if i < len(tags) && tags[i] == *q.lastTag {
+			i++
 		}
+		tag = tags[i%len(tags)]
 	}
 
-	return nil, fmt.Errorf("queue empty or all items deferred")
+	q.lastTag = &tag
+	return oldest[tag]
 }
 
-func (q *Queue) Defer(id string) error {
+// Defer moves the item to the back of the queue, and stops it being served.
+// Deferring an item which is already deferred changes nothing, and returns
+// true so that the caller can tell the difference.
+func (q *Queue) Defer(id string) (alreadyDeferred bool, err error) {
 	q.mu.Lock()
 	defer q.mu.Unlock()
 
+	return q.deferLocked(id)
+}
+
+// DeferResult is the outcome of deferring one item with DeferMany, as
+// returned by Defer.
+type DeferResult struct {
+	AlreadyDeferred bool
+	Err             error
+}
+
+// DeferMany defers each of ids under a single lock, returning one result per
+// id in the same order.
+func (q *Queue) DeferMany(ids []string) []DeferResult {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	results := make([]DeferResult, len(ids))
+	for i, id := range ids {
+		already, err := q.deferLocked(id)
+		results[i] = DeferResult{AlreadyDeferred: already, Err: err}
+	}
+	return results
+}
+
+// deferLocked is Defer, for callers which hold q.mu.
+func (q *Queue) deferLocked(id string) (alreadyDeferred bool, err error) {
 	elem, ok := q.itemsMap[id]
 	if !ok {
-		return fmt.Errorf("item not found: %s", id)
+		return false, fmt.Errorf("item not found: %s", id)
 	}
 
 	item := elem.Value.(*QueueItem)
+	if item.Deferred {
+		return true, nil
+	}
+
 	item.Deferred = true
+	item.Pinned = false
+	item.DeferCount++
+
+	if q.maxDefers > 0 && item.DeferCount > q.maxDefers {
+		q.items.Remove(elem)
+		delete(q.itemsMap, id)
+		q.events.Append(EventRemove, id)
+		q.notifier.observe(q.items.Len())
+		if item.Cancel != nil {
+			item.Cancel(ErrDefersExhausted)
+		}
+		return false, ErrDefersExhausted
+	}
 
 	q.items.MoveToBack(elem)
+	q.events.Append(EventDefer, id)
 
-	return nil
+	return false, nil
+}
+
+// RepeatedlyDeferred returns the ids of queued items which have been deferred
+// at least min times, which usually means they can't be answered.
+func (q *Queue) RepeatedlyDeferred(min int) []string {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	ids := []string{}
+	for e := q.items.Front(); e != nil; e = e.Next() {
+		if item := e.Value.(*QueueItem); item.DeferCount >= min {
+			ids = append(ids, item.ID)
+		}
+	}
+	return ids
+}
+
+// Active returns the items which aren't deferred, in queue order.
+func (q *Queue) Active() []*QueueItem {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	items := []*QueueItem{}
+	for e := q.items.Front(); e != nil; e = e.Next() {
+		if item := e.Value.(*QueueItem); !item.Deferred {
+			items = append(items, item)
+		}
+	}
+	return items
 }
 
 func (q *Queue) Remove(id string) error {
@@ -102,79 +490,244 @@ func (q *Queue) Remove(id string) error {
 
 	q.items.Remove(elem)
 	delete(q.itemsMap, id)
+	q.events.Append(EventRemove, id)
+	q.notifier.observe(q.items.Len())
 
 	return nil
 }
 
+// Contains returns true if the item is waiting in the queue.
+func (q *Queue) Contains(id string) bool {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	_, ok := q.itemsMap[id]
+	return ok
+}
+
+// Get returns the item if it's waiting in the queue, leaving it there.
+func (q *Queue) Get(id string) (*QueueItem, bool) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	elem, ok := q.itemsMap[id]
+	if !ok {
+		return nil, false
+	}
+	return elem.Value.(*QueueItem), true
+}
+
+// Take removes the item from the queue and returns it.
+func (q *Queue) Take(id string) (*QueueItem, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	elem, ok := q.itemsMap[id]
+	if !ok {
+		return nil, false
+	}
+
+	q.items.Remove(elem)
+	delete(q.itemsMap, id)
+	q.events.Append(EventRemove, id)
+	q.notifier.observe(q.items.Len())
+
+	return elem.Value.(*QueueItem), true
+}
+
 func (q *Queue) Status() QueueStatus {
+	return q.status(false)
+}
+
+// DetailedStatus is Status with the per-tag and per-type breakdowns, which
+// are too costly for the hot paths which only need the counts.
+func (q *Queue) DetailedStatus() QueueStatus {
+	return q.status(true)
+}
+
+func (q *Queue) status(detailed bool) QueueStatus {
 	q.mu.RLock()
 	defer q.mu.RUnlock()
 
-	active := 0
-	deferred := 0
+	st := QueueStatus{Total: q.items.Len()}
+	if detailed {
+		st.ByTag = map[string]int{}
+		st.ByType = map[string]int{}
+	}
 
 	for e := q.items.Front(); e != nil; e = e.Next() {
-		if e.Value.(*QueueItem).Deferred {
-			deferred++
+		item := e.Value.(*QueueItem)
+		if item.Deferred {
+			st.Deferred++
 		} else {
-			active++
+			st.Active++
+		}
+		if !detailed {
+			continue
 		}
-	}
 
-	return QueueStatus{
-		Total:    q.items.Len(),
-		Active:   active,
-		Deferred: deferred,
+		tag := item.Request.GetTag()
+		if _, ok := st.ByTag[tag]; ok || len(st.ByTag) < maxStatusTags {
+			st.ByTag[tag]++
+		} else {
+			st.TagsTruncated = true
+		}
+
+		seen := map[string]bool{}
+		for _, input := range item.Request.GetInputs() {
+			if input == nil {
+				continue
+			}
+			if kind := visualizationName(input); kind != "" && !seen[kind] {
+				seen[kind] = true
+				st.ByType[kind]++
+			}
+		}
 	}
+
+	return st
 }
 
+// GetNext waits up to timeout for an item. If none arrives, the error wraps
+// ErrQueueEmpty, or is ErrAllDeferred if there were items but all deferred.
 func (q *Queue) GetNext(timeout time.Duration) (*QueueItem, error) {
-	ch := make(chan struct{})
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	item, err := q.GetNextContext(ctx)
+	if err == context.DeadlineExceeded {
+		if st := q.Status(); st.Total > 0 && st.Active == 0 {
+			return nil, ErrAllDeferred
+		}
+		return nil, fmt.Errorf("timeout waiting for queue item: %w", ErrQueueEmpty)
+	}
+	return item, err
+}
+
+// GetNextContext is like GetNext, but waits until ctx is done rather than for
+// a fixed timeout, returning ctx.Err().
+func (q *Queue) GetNextContext(ctx context.Context) (*QueueItem, error) {
+	// buffered so that a notification sent between Dequeue and select isn't lost
+	ch := make(chan struct{}, 1)
 
 	q.wmu.Lock()
-	q.waiters[ch] = struct{}{}
+	first := q.waiters.Len() == 0
+	elem := q.waiters.PushBack(ch)
 	q.wmu.Unlock()
 
 	defer func() {
 		q.wmu.Lock()
-		delete(q.waiters, ch)
+		q.waiters.Remove(elem)
 		q.wmu.Unlock()
+
+		// pass the turn on, in case there are more items than were notified
+		// (e.g. after Resume) or this waiter was woken but gave up
+		q.notifyWaiters()
 	}()
 
-	timeoutCh := time.After(timeout)
+	// a caller arriving while others wait goes to the back of the line, rather
+	// than taking the item that the oldest waiter was woken for
+	if !first {
+		if q.Paused() {
+			return nil, ErrQueuePaused
+		}
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
 
 	for {
 		item, err := q.Dequeue()
 		if err == nil {
 			return item, nil
 		}
+		if err == ErrQueuePaused {
+			return nil, err
+		}
 
 		select {
 		case <-ch:
 			continue
-		case <-timeoutCh:
-			return nil, fmt.Errorf("timeout waiting for queue item")
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Pause stops Dequeue and GetNext from returning items, while still allowing
+// new items to be enqueued. Any blocked GetNext calls return ErrQueuePaused.
+func (q *Queue) Pause() {
+	q.mu.Lock()
+	q.paused = true
+	q.mu.Unlock()
+
+	q.wmu.Lock()
+	defer q.wmu.Unlock()
+	for e := q.waiters.Front(); e != nil; e = e.Next() {
+		select {
+		case e.Value.(chan struct{}) <- struct{}{}:
+		default:
 		}
 	}
 }
 
-func (q *Queue) Clear() {
+func (q *Queue) Resume() {
+	q.mu.Lock()
+	q.paused = false
+	q.mu.Unlock()
+
+	q.notifyWaiters()
+}
+
+func (q *Queue) Paused() bool {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	return q.paused
+}
+
+// Clear removes every item from the queue, closing each Response channel so
+// that the blocked Collect calls return promptly rather than waiting for
+// their deadlines. Returns the ids of the items removed. A race item may also
+// be held by annotators, so the caller must stop it being answered.
+func (q *Queue) Clear() []string {
 	q.mu.Lock()
 	defer q.mu.Unlock()
 
+	ids := make([]string, 0, q.items.Len())
+	for e := q.items.Front(); e != nil; e = e.Next() {
+		item := e.Value.(*QueueItem)
+		if item.Response != nil {
+			close(item.Response)
+		}
+		q.events.Append(EventRemove, item.ID)
+		ids = append(ids, item.ID)
+	}
+
 	q.items.Init()
 	q.itemsMap = make(map[string]*list.Element)
+	q.notifier.observe(0)
+
+	return ids
 }
 
 func (q *Queue) notifyWaiters() {
+	// wake only the oldest waiter which hasn't already been woken, since we
+	// only have one item available
+	q.notifyWaitersN(1)
+}
+
+// notifyWaitersN wakes up to n of the oldest waiters which haven't already
+// been woken, one per item made available.
+func (q *Queue) notifyWaitersN(n int) {
 	q.wmu.Lock()
 	defer q.wmu.Unlock()
 
-	// wake only one waiter since we only have one item available
-	for ch := range q.waiters {
+	for e := q.waiters.Front(); e != nil && n > 0; e = e.Next() {
 		select {
-		case ch <- struct{}{}:
-			return // only wake one waiter
+		case e.Value.(chan struct{}) <- struct{}{}:
+			n--
 		default:
 		}
 	}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"sync"
 	"testing"
@@ -99,7 +101,7 @@ func TestQueueDeferOperation(t *testing.T) {
 	}
 
 	// defer the first item
-	if err := q.Defer("first"); err != nil {
+	if _, err := q.Defer("first"); err != nil {
 		t.Fatalf("defer failed: %v", err)
 	}
 
@@ -196,6 +198,101 @@ func TestQueueConcurrentAccess(t *testing.T) {
 	}
 }
 
+func TestQueueDetailedStatus(t *testing.T) {
+	q := NewQueue()
+
+	scalar := &pb.Input{
+		Visualization: &pb.Input_Scalar{Scalar: &pb.Scalar{Label: "x"}},
+		Data:          &pb.Data{Data: &pb.Data_Floats{Floats: &pb.Floats{Values: []float64{1}}}},
+	}
+	for i, it := range []struct {
+		tag    string
+		scalar bool
+	}{
+		{"a", false},
+		{"a", true},
+		{"b", false},
+		{"", true},
+	} {
+		req := newTestRequest()
+		req.Tag = it.tag
+		if it.scalar {
+			req.Inputs = append(req.Inputs, scalar)
+		}
+		if err := q.Enqueue(&QueueItem{ID: fmt.Sprint(i), Request: req, AddedAt: time.Now()}); err != nil {
+			t.Fatalf("enqueue failed: %v", err)
+		}
+	}
+	q.Defer("0")
+
+	st := q.DetailedStatus()
+	if st.Total != 4 || st.Active != 3 || st.Deferred != 1 {
+		t.Errorf("expected 4 total, 3 active, 1 deferred, got %+v", st)
+	}
+	if fmt.Sprint(st.ByTag) != "map[:1 a:2 b:1]" {
+		t.Errorf("unexpected tag breakdown: %v", st.ByTag)
+	}
+	if fmt.Sprint(st.ByType) != "map[grid:4 scalar:2]" {
+		t.Errorf("unexpected type breakdown: %v", st.ByType)
+	}
+
+	if plain := q.Status(); plain.ByTag != nil || plain.ByType != nil {
+		t.Errorf("expected Status to leave out the breakdowns, got %+v", plain)
+	}
+}
+
+func TestQueueDetailedStatusTagCap(t *testing.T) {
+	q := NewQueue()
+	for i := 0; i < maxStatusTags+5; i++ {
+		req := newTestRequest()
+		req.Tag = fmt.Sprintf("tag-%d", i)
+		q.Enqueue(&QueueItem{ID: fmt.Sprint(i), Request: req, AddedAt: time.Now()})
+	}
+
+	st := q.DetailedStatus()
+	if len(st.ByTag) != maxStatusTags || !st.TagsTruncated {
+		t.Errorf("expected %d tags and truncation, got %d tags, truncated %v", maxStatusTags, len(st.ByTag), st.TagsTruncated)
+	}
+	if st.Total != maxStatusTags+5 {
+		t.Errorf("expected every item in the total, got %d", st.Total)
+	}
+}
+
+func TestQueueAllDeferred(t *testing.T) {
+	q := NewQueue()
+
+	if _, err := q.Dequeue(); err != ErrQueueEmpty {
+		t.Fatalf("expected ErrQueueEmpty from an empty queue, got %v", err)
+	}
+	if _, err := q.GetNext(10 * time.Millisecond); !errors.Is(err, ErrQueueEmpty) {
+		t.Fatalf("expected GetNext on an empty queue to wrap ErrQueueEmpty, got %v", err)
+	}
+
+	item := &QueueItem{ID: "test1", Request: newTestRequest(), AddedAt: time.Now()}
+	if err := q.Enqueue(item); err != nil {
+		t.Fatalf("enqueue failed: %v", err)
+	}
+	if _, err := q.Defer("test1"); err != nil {
+		t.Fatalf("defer failed: %v", err)
+	}
+
+	if _, err := q.Dequeue(); err != ErrAllDeferred {
+		t.Fatalf("expected ErrAllDeferred, got %v", err)
+	}
+	if _, err := q.GetNext(10 * time.Millisecond); err != ErrAllDeferred {
+		t.Fatalf("expected GetNext to return ErrAllDeferred, got %v", err)
+	}
+
+	// a new item is still served
+	if err := q.Enqueue(&QueueItem{ID: "test2", Request: newTestRequest(), AddedAt: time.Now()}); err != nil {
+		t.Fatalf("enqueue failed: %v", err)
+	}
+	got, err := q.GetNext(time.Second)
+	if err != nil || got.ID != "test2" {
+		t.Fatalf("expected test2, got %v, %v", got, err)
+	}
+}
+
 func TestQueueGetNextWithTimeout(t *testing.T) {
 	q := NewQueue()
 
@@ -233,3 +330,514 @@ func TestQueueGetNextWithTimeout(t *testing.T) {
 		t.Fatalf("expected test1, got %s", retrieved.ID)
 	}
 }
+
+func TestQueueTagRoundRobin(t *testing.T) {
+	q := NewQueue()
+	if err := q.SetMode(QueueModeTagRoundRobin); err != nil {
+		t.Fatalf("SetMode failed: %v", err)
+	}
+
+	// a big campaign enqueued ahead of a small one
+	for _, it := range []struct{ id, tag string }{
+		{"a1", "A"}, {"a2", "A"}, {"a3", "A"}, {"b1", "B"},
+	} {
+		req := newTestRequest()
+		req.Tag = it.tag
+		item := &QueueItem{
+			ID:       it.id,
+			Request:  req,
+			Response: make(chan *pb.Response, 1),
+			AddedAt:  time.Now(),
+		}
+		if err := q.Enqueue(item); err != nil {
+			t.Fatalf("enqueue %s failed: %v", it.id, err)
+		}
+	}
+
+	// b1 is served before a's backlog is drained
+	for _, expectedID := range []string{"a1", "b1", "a2", "a3"} {
+		item, err := q.Dequeue()
+		if err != nil {
+			t.Fatalf("dequeue failed: %v", err)
+		}
+		if item.ID != expectedID {
+			t.Fatalf("expected %s, got %s", expectedID, item.ID)
+		}
+	}
+
+	if _, err := q.Dequeue(); err == nil {
+		t.Fatal("expected error dequeuing from empty queue")
+	}
+}
+
+func TestQueueTagRoundRobinSkipsDeferred(t *testing.T) {
+	q := NewQueue()
+	q.SetMode(QueueModeTagRoundRobin)
+
+	for _, it := range []struct{ id, tag string }{
+		{"a1", "A"}, {"b1", "B"}, {"a2", "A"},
+	} {
+		req := newTestRequest()
+		req.Tag = it.tag
+		q.Enqueue(&QueueItem{
+			ID:       it.id,
+			Request:  req,
+			Response: make(chan *pb.Response, 1),
+			AddedAt:  time.Now(),
+		})
+	}
+
+	if _, err := q.Defer("b1"); err != nil {
+		t.Fatalf("defer failed: %v", err)
+	}
+
+	// with b's only item deferred, the rotation falls back to a
+	for _, expectedID := range []string{"a1", "a2"} {
+		item, err := q.Dequeue()
+		if err != nil {
+			t.Fatalf("dequeue failed: %v", err)
+		}
+		if item.ID != expectedID {
+			t.Fatalf("expected %s, got %s", expectedID, item.ID)
+		}
+	}
+}
+
+func TestQueueSetModeInvalid(t *testing.T) {
+	q := NewQueue()
+	if err := q.SetMode("lifo"); err == nil {
+		t.Fatal("expected error for unknown queue mode")
+	}
+}
+
+func TestQueuePauseWakesWaiters(t *testing.T) {
+	q := NewQueue()
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := q.GetNext(5 * time.Second)
+		errCh <- err
+	}()
+
+	// give the waiter time to register
+	time.Sleep(50 * time.Millisecond)
+	q.Pause()
+
+	select {
+	case err := <-errCh:
+		if err != ErrQueuePaused {
+			t.Fatalf("expected ErrQueuePaused, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected blocked GetNext to return on pause")
+	}
+
+	// enqueue still works while paused
+	item := &QueueItem{
+		ID:       "test1",
+		Request:  newTestRequest(),
+		Response: make(chan *pb.Response, 1),
+		AddedAt:  time.Now(),
+	}
+	if err := q.Enqueue(item); err != nil {
+		t.Fatalf("enqueue while paused failed: %v", err)
+	}
+	if _, err := q.Dequeue(); err != ErrQueuePaused {
+		t.Fatalf("expected ErrQueuePaused from Dequeue, got %v", err)
+	}
+
+	q.Resume()
+	retrieved, err := q.GetNext(time.Second)
+	if err != nil {
+		t.Fatalf("GetNext after resume failed: %v", err)
+	}
+	if retrieved.ID != "test1" {
+		t.Fatalf("expected test1, got %s", retrieved.ID)
+	}
+}
+
+func TestQueueWaitersFIFO(t *testing.T) {
+	q := NewQueue()
+
+	type result struct {
+		waiter string
+		id     string
+	}
+	results := make(chan result, 2)
+	wait := func(name string) {
+		item, err := q.GetNext(5 * time.Second)
+		if err != nil {
+			t.Errorf("%s: GetNext failed: %v", name, err)
+			return
+		}
+		results <- result{name, item.ID}
+	}
+
+	// stagger the waiters so that their order is known
+	go wait("first")
+	time.Sleep(50 * time.Millisecond)
+	go wait("second")
+	time.Sleep(50 * time.Millisecond)
+
+	for _, id := range []string{"item1", "item2"} {
+		item := &QueueItem{
+			ID:       id,
+			Request:  newTestRequest(),
+			Response: make(chan *pb.Response, 1),
+			AddedAt:  time.Now(),
+		}
+		if err := q.Enqueue(item); err != nil {
+			t.Fatalf("enqueue failed: %v", err)
+		}
+
+		select {
+		case r := <-results:
+			want := map[string]string{"item1": "first", "item2": "second"}[id]
+			if r.waiter != want || r.id != id {
+				t.Fatalf("expected %s to get %s, got %+v", want, id, r)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no waiter received %s", id)
+		}
+	}
+}
+
+func TestQueueRequeueGoesToFront(t *testing.T) {
+	q := NewQueue()
+
+	for _, id := range []string{"a", "b"} {
+		q.Enqueue(&QueueItem{ID: id, Request: newTestRequest(), AddedAt: time.Now()})
+	}
+
+	item, err := q.Dequeue()
+	if err != nil || item.ID != "a" {
+		t.Fatalf("expected a, got %v (err=%v)", item, err)
+	}
+
+	q.Enqueue(&QueueItem{ID: "c", Request: newTestRequest(), AddedAt: time.Now()})
+
+	if err := q.Requeue(item); err != nil {
+		t.Fatalf("requeue failed: %v", err)
+	}
+	if err := q.Requeue(item); err == nil {
+		t.Error("expected error requeueing an item already in the queue")
+	}
+
+	for _, want := range []string{"a", "b", "c"} {
+		got, err := q.Dequeue()
+		if err != nil {
+			t.Fatalf("dequeue failed: %v", err)
+		}
+		if got.ID != want {
+			t.Errorf("expected %s, got %s", want, got.ID)
+		}
+	}
+}
+
+func TestQueueEnqueueBatch(t *testing.T) {
+	q := NewQueue()
+	q.Enqueue(&QueueItem{ID: "a", Request: newTestRequest(), AddedAt: time.Now()})
+
+	batch := func(ids ...string) []*QueueItem {
+		items := []*QueueItem{}
+		for _, id := range ids {
+			items = append(items, &QueueItem{ID: id, Request: newTestRequest(), AddedAt: time.Now()})
+		}
+		return items
+	}
+
+	// one id already queued, and one repeated within the batch
+	for _, ids := range [][]string{{"b", "a", "c"}, {"b", "c", "b"}} {
+		if err := q.EnqueueBatch(batch(ids...)); err == nil {
+			t.Errorf("%v: expected error for duplicate id", ids)
+		}
+		if got := q.Status().Total; got != 1 {
+			t.Errorf("%v: expected nothing added, got %d items", ids, got)
+		}
+	}
+
+	if err := q.EnqueueBatch(batch("b", "c")); err != nil {
+		t.Fatalf("enqueue batch failed: %v", err)
+	}
+	for _, want := range []string{"a", "b", "c"} {
+		got, err := q.Dequeue()
+		if err != nil {
+			t.Fatalf("dequeue failed: %v", err)
+		}
+		if got.ID != want {
+			t.Errorf("expected %s, got %s", want, got.ID)
+		}
+	}
+}
+
+func TestQueueEnqueueBatchWakesWaiters(t *testing.T) {
+	q := NewQueue()
+
+	got := make(chan string, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			if item, err := q.GetNext(2 * time.Second); err == nil {
+				got <- item.ID
+			} else {
+				got <- ""
+			}
+		}()
+	}
+
+	// let the waiters register
+	time.Sleep(50 * time.Millisecond)
+
+	items := []*QueueItem{}
+	for _, id := range []string{"a", "b", "c"} {
+		items = append(items, &QueueItem{ID: id, Request: newTestRequest(), AddedAt: time.Now()})
+	}
+	if err := q.EnqueueBatch(items); err != nil {
+		t.Fatalf("enqueue batch failed: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		select {
+		case id := <-got:
+			if id == "" {
+				t.Error("expected every waiter to get an item")
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected every waiter to wake")
+		}
+	}
+}
+
+func TestQueuePriority(t *testing.T) {
+	q := NewQueue()
+	now := time.Now()
+
+	for i, p := range []int32{0, 5, 5, 1} {
+		req := newTestRequest()
+		req.Priority = p
+		q.Enqueue(&QueueItem{ID: fmt.Sprintf("item%d", i), Request: req, AddedAt: now})
+	}
+
+	for _, want := range []string{"item1", "item2", "item3", "item0"} {
+		item, err := q.Dequeue()
+		if err != nil {
+			t.Fatalf("dequeue failed: %v", err)
+		}
+		if item.ID != want {
+			t.Errorf("expected %s, got %s", want, item.ID)
+		}
+	}
+}
+
+func TestQueueAgingPreventsStarvation(t *testing.T) {
+	q := NewQueue()
+	q.agingThreshold = time.Minute
+	now := time.Now()
+
+	// a low priority item which has waited a long time
+	old := newTestRequest()
+	old.Priority = 0
+	q.Enqueue(&QueueItem{ID: "old", Request: old, AddedAt: now.Add(-10 * time.Minute)})
+
+	// and a steady stream of fresh high priority items
+	served := ""
+	for i := 0; i < 20 && served != "old"; i++ {
+		req := newTestRequest()
+		req.Priority = 5
+		q.Enqueue(&QueueItem{ID: fmt.Sprintf("high%d", i), Request: req, AddedAt: now})
+
+		item, err := q.Dequeue()
+		if err != nil {
+			t.Fatalf("dequeue failed: %v", err)
+		}
+		served = item.ID
+	}
+
+	if served != "old" {
+		t.Fatal("expected aged low priority item to eventually be served")
+	}
+
+	// without aging, it would starve
+	q = NewQueue()
+	q.Enqueue(&QueueItem{ID: "old", Request: old, AddedAt: now.Add(-10 * time.Minute)})
+	for i := 0; i < 20; i++ {
+		req := newTestRequest()
+		req.Priority = 5
+		q.Enqueue(&QueueItem{ID: fmt.Sprintf("high%d", i), Request: req, AddedAt: now})
+
+		item, _ := q.Dequeue()
+		if item.ID == "old" {
+			t.Fatal("expected old item to starve without aging")
+		}
+	}
+}
+
+func TestQueueMaxDefers(t *testing.T) {
+	q := NewQueue()
+	q.maxDefers = 2
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	q.Enqueue(&QueueItem{ID: "a", Request: &pb.Request{}, AddedAt: time.Now(), Context: ctx, Cancel: cancel})
+
+	// deferred items are only counted again once they've been re-served
+	redefer := func() error {
+		q.itemsMap["a"].Value.(*QueueItem).Deferred = false
+		_, err := q.Defer("a")
+		return err
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := redefer(); err != nil {
+			t.Fatalf("defer %d: unexpected error: %v", i, err)
+		}
+	}
+
+	if err := redefer(); !errors.Is(err, ErrDefersExhausted) {
+		t.Fatalf("expected ErrDefersExhausted, got %v", err)
+	}
+	if st := q.Status(); st.Total != 0 {
+		t.Errorf("expected item to be removed, got %+v", st)
+	}
+	if !errors.Is(context.Cause(ctx), ErrDefersExhausted) {
+		t.Errorf("expected item to be cancelled with ErrDefersExhausted, got %v", context.Cause(ctx))
+	}
+}
+
+func TestQueueDeferMany(t *testing.T) {
+	q := NewQueue()
+	for _, id := range []string{"a", "b", "c", "d", "e"} {
+		q.Enqueue(&QueueItem{ID: id, Request: newTestRequest(), AddedAt: time.Now()})
+	}
+	q.Defer("c")
+
+	results := q.DeferMany([]string{"a", "c", "e", "missing"})
+	if len(results) != 4 {
+		t.Fatalf("expected one result per id, got %d", len(results))
+	}
+	if results[0].Err != nil || results[0].AlreadyDeferred {
+		t.Errorf("expected a to be deferred, got %+v", results[0])
+	}
+	if !results[1].AlreadyDeferred {
+		t.Errorf("expected c to be already deferred, got %+v", results[1])
+	}
+	if results[3].Err == nil {
+		t.Error("expected an error for a missing id")
+	}
+
+	status := q.Status()
+	if status.Total != 5 || status.Active != 2 || status.Deferred != 3 {
+		t.Errorf("expected 5 items with 2 active and 3 deferred, got %+v", status)
+	}
+
+	// the deferred items go to the back, so the others are served first
+	for _, want := range []string{"b", "d"} {
+		got, err := q.Dequeue()
+		if err != nil || got.ID != want {
+			t.Fatalf("expected %s, got %v (err=%v)", want, got, err)
+		}
+	}
+}
+
+func TestQueueDeferIdempotent(t *testing.T) {
+	q := NewQueue()
+	for _, id := range []string{"a", "b", "c"} {
+		q.Enqueue(&QueueItem{ID: id, Request: &pb.Request{}, AddedAt: time.Now()})
+	}
+
+	if already, err := q.Defer("a"); err != nil || already {
+		t.Fatalf("expected first defer to succeed, got %v, %v", already, err)
+	}
+	if already, err := q.Defer("b"); err != nil || already {
+		t.Fatalf("expected defer of b to succeed, got %v, %v", already, err)
+	}
+
+	// a is now ahead of b, and deferring it again mustn't move it behind b
+	already, err := q.Defer("a")
+	if err != nil || !already {
+		t.Fatalf("expected second defer to report already deferred, got %v, %v", already, err)
+	}
+
+	order := []string{}
+	for e := q.items.Front(); e != nil; e = e.Next() {
+		order = append(order, e.Value.(*QueueItem).ID)
+	}
+	if got := fmt.Sprint(order); got != "[c a b]" {
+		t.Errorf("expected order [c a b], got %s", got)
+	}
+	if item := q.itemsMap["a"].Value.(*QueueItem); item.DeferCount != 1 {
+		t.Errorf("expected defer count 1, got %d", item.DeferCount)
+	}
+}
+
+func TestQueuePin(t *testing.T) {
+	q := NewQueue()
+
+	for _, id := range []string{"a", "b", "c"} {
+		q.Enqueue(&QueueItem{ID: id, Request: newTestRequest(), AddedAt: time.Now()})
+	}
+
+	if err := q.Pin("c"); err != nil {
+		t.Fatalf("pin failed: %v", err)
+	}
+	if err := q.Pin("missing"); err == nil {
+		t.Error("expected error pinning an item not in the queue")
+	}
+
+	item, err := q.Dequeue()
+	if err != nil || item.ID != "c" {
+		t.Fatalf("expected pinned c first, got %v (err=%v)", item, err)
+	}
+
+	// requeued and new items don't displace a pin
+	if err := q.Pin("b"); err != nil {
+		t.Fatalf("pin failed: %v", err)
+	}
+	if err := q.Requeue(&QueueItem{ID: "d", Request: newTestRequest(), AddedAt: time.Now()}); err != nil {
+		t.Fatalf("requeue failed: %v", err)
+	}
+	q.Enqueue(&QueueItem{ID: "e", Request: newTestRequest(), AddedAt: time.Now()})
+
+	for _, want := range []string{"b", "d", "a", "e"} {
+		got, err := q.Dequeue()
+		if err != nil {
+			t.Fatalf("dequeue failed: %v", err)
+		}
+		if got.ID != want {
+			t.Errorf("expected %s, got %s", want, got.ID)
+		}
+	}
+}
+
+func TestQueuePinOrder(t *testing.T) {
+	q := NewQueue()
+	q.SetMode(QueueModeTagRoundRobin)
+
+	for i := 0; i < 4; i++ {
+		req := newTestRequest()
+		req.Priority = int32(i)
+		req.Tag = fmt.Sprintf("tag%d", i)
+		q.Enqueue(&QueueItem{ID: fmt.Sprintf("item%d", i), Request: req, AddedAt: time.Now()})
+	}
+	q.Defer("item0")
+
+	// pins beat priority and tags, keep the order they were pinned in, and
+	// bring back deferred items
+	for _, id := range []string{"item2", "item0", "item1"} {
+		if err := q.Pin(id); err != nil {
+			t.Fatalf("pin failed: %v", err)
+		}
+	}
+	if err := q.Pin("item2"); err != nil {
+		t.Fatalf("repeated pin failed: %v", err)
+	}
+
+	for _, want := range []string{"item2", "item0", "item1", "item3"} {
+		got, err := q.Dequeue()
+		if err != nil {
+			t.Fatalf("dequeue failed: %v", err)
+		}
+		if got.ID != want {
+			t.Errorf("expected %s, got %s", want, got.ID)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	pb "github.com/adammck/collector/proto/gen"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// sessionPausedRetry is how often a Session checks whether a paused queue has
+// been resumed.
+const sessionPausedRetry = time.Second
+
+// Session streams pending items to the client, and completes the originating
+// Collect calls with the answers it streams back. Items are served via current
+// just like the web frontend, so the sweeper, Cancel, and /submit all still
+// apply to them.
+func (cs *collectorServer) Session(stream pb.Collector_SessionServer) error {
+	// like /data.json?queue=, a session serves one sub-queue
+	var name string
+	if md, ok := metadata.FromIncomingContext(stream.Context()); ok {
+		if vals := md.Get(queueMetadataKey); len(vals) > 0 {
+			name = vals[0]
+		}
+	}
+	if err := validateQueueName(name); err != nil {
+		return validationError("invalid queue: %v", err)
+	}
+	q, err := cs.s.queueFor(name)
+	if err != nil {
+		return resourceExhaustedError(rejectQueueFull, "queues")
+	}
+
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+
+	ss := &session{s: cs.s, sent: make(map[string]bool)}
+	defer ss.requeue()
+
+	recvErr := make(chan error, 1)
+	go func() {
+		recvErr <- ss.recv(stream)
+		cancel()
+	}()
+
+	for {
+		item, err := q.GetNextContext(ctx)
+		if err == ErrQueuePaused {
+			select {
+			case <-ctx.Done():
+			case <-time.After(sessionPausedRetry):
+			}
+			continue
+		}
+		if err != nil {
+			break
+		}
+
+		// a race item is requeued for other annotators, so don't send this
+		// one a second replica; wait for someone else to pick it up.
+		if ss.has(item.ID) {
+			if err := q.Requeue(item); err != nil {
+				slog.Warn("failed to requeue race item", "uuid", item.ID, "error", err)
+			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(sessionPausedRetry):
+			}
+			continue
+		}
+
+		// as in handleData, the limits or validators may have changed since it
+		// was accepted
+		if err := validate(item.Request, cs.s.validation); err != nil {
+			slog.Warn("dropping invalid session item", "uuid", item.ID, "error", err)
+			cs.s.rejectInvalid(item, err)
+			continue
+		}
+
+		// mark as sent before sending, so that the item is requeued if the
+		// send fails or the stream ends before it's answered.
+		if !cs.s.serve(item) {
+			continue
+		}
+		ss.markSent(item.ID)
+
+		// the same request handleData serves: shuffled, with hotkeys, etc.
+		if err := ss.send(stream, &pb.SessionItem{Uuid: item.ID, Request: cs.s.displayed(item)}); err != nil {
+			break
+		}
+	}
+
+	select {
+	case err := <-recvErr:
+		return err
+	default:
+		return nil
+	}
+}
+
+// session tracks the items sent on one Session stream.
+type session struct {
+	s *server
+
+	mu   sync.Mutex
+	sent map[string]bool
+
+	// recv resends items whose answers it rejects, so the stream has two
+	// senders.
+	sendMu sync.Mutex
+}
+
+func (ss *session) send(stream pb.Collector_SessionServer, item *pb.SessionItem) error {
+	ss.sendMu.Lock()
+	defer ss.sendMu.Unlock()
+	return stream.Send(item)
+}
+
+func (ss *session) markSent(id string) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.sent[id] = true
+}
+
+func (ss *session) has(id string) bool {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return ss.sent[id]
+}
+
+// answered removes id from the sent items, returning false if it was never
+// sent on this stream.
+func (ss *session) answered(id string) bool {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	ok := ss.sent[id]
+	delete(ss.sent, id)
+	return ok
+}
+
+// recv reads answers until the client closes its side of the stream, which
+// ends the session. An invalid answer doesn't end it: the item is sent again
+// with the error, for the client to correct.
+func (ss *session) recv(stream pb.Collector_SessionServer) error {
+	for {
+		ans, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if !ss.answered(ans.Uuid) {
+			slog.Warn("session answer for unknown item", "uuid", ans.Uuid)
+			continue
+		}
+
+		item, ok := ss.s.claim(ans.Uuid)
+		if !ok {
+			// answered elsewhere, cancelled, or swept
+			slog.Warn("session answer for item no longer pending", "uuid", ans.Uuid)
+			continue
+		}
+
+		if item.Context != nil && item.Context.Err() != nil {
+			continue
+		}
+
+		// the client chose from the options as displayed
+		unshuffle(ans.Response, item.Permutations)
+
+		if err := validateAnswer(item.Request, ans.Response); err != nil {
+			ss.s.restore(item)
+			ss.markSent(item.ID)
+
+			err = validationError("invalid response for %s: %v", ans.Uuid, err)
+			slog.Warn("session answer invalid", "uuid", ans.Uuid, "error", err)
+			if err := ss.send(stream, &pb.SessionItem{
+				Uuid:    item.ID,
+				Request: ss.s.displayed(item),
+				Error:   status.Convert(err).Message(),
+			}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// and edited any grid as displayed, i.e. row-major
+		editsToColMajor(item.Request, ans.Response)
+
+		if !ss.s.complete(item, ans.Response, "") {
+			slog.Warn("session answer could not be delivered", "uuid", ans.Uuid)
+		}
+	}
+}
+
+// requeue puts any items which were sent but not answered back in the queue,
+// so that another annotator can pick them up.
+func (ss *session) requeue() {
+	ss.mu.Lock()
+	ids := make([]string, 0, len(ss.sent))
+	for id := range ss.sent {
+		ids = append(ids, id)
+	}
+	ss.sent = make(map[string]bool)
+	ss.mu.Unlock()
+
+	for _, id := range ids {
+		item, last, ok := ss.s.release(id)
+		if !ok || !last {
+			continue
+		}
+		if item.Context != nil && item.Context.Err() != nil {
+			continue
+		}
+		if err := ss.s.queueOf(item).Requeue(item); err != nil {
+			slog.Warn("failed to requeue session item", "uuid", id, "error", err)
+		}
+	}
+}
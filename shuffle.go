@@ -0,0 +1,92 @@
+package main
+
+import (
+	"hash/fnv"
+	"math/rand"
+
+	pb "github.com/adammck/collector/proto/gen"
+	"google.golang.org/protobuf/proto"
+)
+
+// outputSchemas returns the request's output schemas, whether it uses the
+// singular output or outputs.
+func outputSchemas(req *pb.Request) []*pb.OutputSchema {
+	if len(req.GetOutputs()) > 0 {
+		return req.GetOutputs()
+	}
+	return []*pb.OutputSchema{req.GetOutput()}
+}
+
+// shufflePermutations returns, for each of the request's output schemas, the
+// order in which to display its options: the option shown at i is perm[i] of
+// the original. Schemas which aren't shuffled option lists get nil. It's
+// seeded by the item id, so the same item is always shown the same way.
+// Returns nil if nothing is shuffled.
+func shufflePermutations(req *pb.Request, id string) [][]int {
+	h := fnv.New64a()
+	h.Write([]byte(id))
+	rng := rand.New(rand.NewSource(int64(h.Sum64())))
+
+	var perms [][]int
+	schemas := outputSchemas(req)
+	for i, schema := range schemas {
+		ol := schema.GetOptionList()
+		if !ol.GetShuffle() {
+			continue
+		}
+		if perms == nil {
+			perms = make([][]int, len(schemas))
+		}
+		perms[i] = rng.Perm(len(ol.Options))
+	}
+
+	return perms
+}
+
+// shuffled returns a copy of the request with its options in display order.
+// The original is never modified; if perms is nil, it's returned as-is.
+func shuffled(req *pb.Request, perms [][]int) *pb.Request {
+	if perms == nil {
+		return req
+	}
+
+	out := proto.Clone(req).(*pb.Request)
+	for i, schema := range outputSchemas(out) {
+		if i >= len(perms) || perms[i] == nil {
+			continue
+		}
+
+		ol := schema.GetOptionList()
+		orig := ol.Options
+		ol.Options = make([]*pb.Option, len(orig))
+		for display, j := range perms[i] {
+			ol.Options[display] = orig[j]
+		}
+	}
+
+	return out
+}
+
+// unshuffle maps the option indexes of a response to the displayed request
+// back to the original order. Out of range indexes are left for validation to
+// reject.
+func unshuffle(res *pb.Response, perms [][]int) {
+	if perms == nil {
+		return
+	}
+
+	outputs := res.GetOutputs()
+	if res.GetOutput() != nil {
+		outputs = []*pb.Output{res.GetOutput()}
+	}
+
+	for i, output := range outputs {
+		ol := output.GetOptionList()
+		if ol == nil || i >= len(perms) || perms[i] == nil {
+			continue
+		}
+		if idx := int(ol.Index); idx >= 0 && idx < len(perms[i]) {
+			ol.Index = int32(perms[i][idx])
+		}
+	}
+}
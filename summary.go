@@ -0,0 +1,102 @@
+package main
+
+import (
+	"math"
+
+	pb "github.com/adammck/collector/proto/gen"
+)
+
+// itemSummary is a compact description of a pending item, for views which list
+// many items at once and can't afford to fetch each one's data.
+type itemSummary struct {
+	UUID   string         `json:"uuid"`
+	Inputs []inputSummary `json:"inputs"`
+}
+
+// inputSummary describes one input. Which of the optional fields are set
+// depends on the visualization: grids and series get min/max/mean, scalars
+// their value, and vectors their magnitude.
+type inputSummary struct {
+	Visualization string   `json:"visualization"`
+	Points        int      `json:"points"`
+	Min           *float64 `json:"min,omitempty"`
+	Max           *float64 `json:"max,omitempty"`
+	Mean          *float64 `json:"mean,omitempty"`
+	Value         *float64 `json:"value,omitempty"`
+	Magnitude     *float64 `json:"magnitude,omitempty"`
+
+	// Preview is true if the input has an image at /data/{uuid}/preview/{i}.png.
+	Preview bool `json:"preview,omitempty"`
+}
+
+func summarizeItem(item *QueueItem) itemSummary {
+	sum := itemSummary{UUID: item.ID, Inputs: []inputSummary{}}
+	for _, input := range item.Request.GetInputs() {
+		sum.Inputs = append(sum.Inputs, summarizeInput(input))
+	}
+	return sum
+}
+
+func summarizeInput(input *pb.Input) inputSummary {
+	values := dataValues(input.Data)
+	sum := inputSummary{
+		Visualization: visualizationName(input),
+		Points:        len(values),
+		Preview:       len(input.GetPreview()) > 0,
+	}
+
+	switch input.Visualization.(type) {
+	case *pb.Input_Grid, *pb.Input_GridPair, *pb.Input_MultiGrid, *pb.Input_Volume,
+		*pb.Input_TimeSeries, *pb.Input_Spectrogram:
+		summarizeRange(&sum, values)
+	case *pb.Input_Scalar:
+		if len(values) == 1 {
+			sum.Value = &values[0]
+		}
+	case *pb.Input_Vector:
+		if len(values) == 2 {
+			m := math.Hypot(values[0], values[1])
+			sum.Magnitude = &m
+		}
+	}
+
+	return sum
+}
+
+// summarizeRange sets the min, max, and mean of values, if there are any.
+func summarizeRange(sum *inputSummary, values []float64) {
+	if len(values) == 0 {
+		return
+	}
+
+	lo, hi, total := values[0], values[0], 0.0
+	for _, v := range values {
+		lo = math.Min(lo, v)
+		hi = math.Max(hi, v)
+		total += v
+	}
+
+	mean := total / float64(len(values))
+	sum.Min, sum.Max, sum.Mean = &lo, &hi, &mean
+}
+
+// dataValues returns the input data as floats, whichever type it was sent as.
+func dataValues(data *pb.Data) []float64 {
+	switch d := data.GetData().(type) {
+	case *pb.Data_Floats:
+		return d.Floats.GetValues()
+	case *pb.Data_Ints:
+		values := make([]float64, len(d.Ints.GetValues()))
+		for i, v := range d.Ints.GetValues() {
+			values[i] = float64(v)
+		}
+		return values
+	case *pb.Data_Bytes:
+		values := make([]float64, len(d.Bytes.GetValues()))
+		for i, v := range d.Bytes.GetValues() {
+			values[i] = float64(v)
+		}
+		return values
+	}
+	return nil
+}
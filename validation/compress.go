@@ -0,0 +1,48 @@
+package validation
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+
+	pb "github.com/adammck/collector/proto/gen"
+)
+
+// maxDecompressedFloats bounds how many values a CompressedFloats may expand
+// to, so that a small blob can't exhaust memory. It's well above the largest
+// input any visualization accepts.
+const maxDecompressedFloats = 1 << 20
+
+// DecompressFloats returns the values packed in c.
+func DecompressFloats(c *pb.CompressedFloats) ([]float64, error) {
+	if c.Algorithm != pb.Compression_GZIP {
+		return nil, fmt.Errorf("unsupported compression algorithm %s", c.Algorithm)
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(c.Data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid compressed data: %w", err)
+	}
+	defer zr.Close()
+
+	// read one byte more than the limit, to tell "exactly at" from "over"
+	b, err := io.ReadAll(io.LimitReader(zr, maxDecompressedFloats*8+1))
+	if err != nil {
+		return nil, fmt.Errorf("invalid compressed data: %w", err)
+	}
+	if len(b) > maxDecompressedFloats*8 {
+		return nil, fmt.Errorf("compressed data too large (max %d values)", maxDecompressedFloats)
+	}
+	if len(b)%8 != 0 {
+		return nil, fmt.Errorf("decompressed length %d is not a multiple of 8", len(b))
+	}
+
+	values := make([]float64, len(b)/8)
+	for i := range values {
+		values[i] = math.Float64frombits(binary.LittleEndian.Uint64(b[i*8:]))
+	}
+	return values, nil
+}
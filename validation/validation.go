@@ -0,0 +1,1293 @@
+// Package validation checks collector requests and responses. The server runs
+// these checks on everything it receives, and clients can run them too, to
+// catch a bad request before sending it.
+package validation
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+	"unicode"
+	"unicode/utf8"
+
+	pb "github.com/adammck/collector/proto/gen"
+)
+
+const (
+	maxScalarUnitLength  = 8
+	maxTitleLength       = 200
+	maxPromptLength      = 2000
+	maxCommentLength     = 1000
+	maxMarkdownLength    = 10000
+	maxOptionGroupLength = 40
+	maxOptionDescLength  = 500
+	maxGridLabelLength   = 40
+	maxOptions           = 100
+	maxHotkeyOptions     = 36
+	maxOutputsPerRequest = 10
+	maxRaceReplicas      = 10
+	maxEstimatedSeconds  = 24 * 60 * 60
+	maxQueueNameLength   = 64
+
+	// maxTimeSeriesPoints bounds the raw series a client may send. The server
+	// downsamples long series before serving them, so this is only a sanity
+	// check on size.
+	maxTimeSeriesPoints = 100000
+
+	// maxPreviewBytes bounds Input.preview, which is meant to be a thumbnail.
+	maxPreviewBytes = 64 << 10
+)
+
+// Limits are the configurable limits enforced by Request. They're swapped
+// atomically by SetLimits, so that the server can apply a config reload to the
+// next request without a restart. Zero means no limit.
+type Limits struct {
+	MaxInputsPerRequest int
+
+	// MaxVectorMagnitude caps Vector2D.max_magnitude, so that a client can't
+	// disable the magnitude check by setting it absurdly high.
+	MaxVectorMagnitude float64
+}
+
+var limits atomic.Pointer[Limits]
+
+// SetLimits replaces the limits used by Request. A client which doesn't call
+// it gets no limits, so the server may still reject a request which passes.
+func SetLimits(l Limits) {
+	limits.Store(&l)
+}
+
+// currentLimits returns the limits in effect, or no limits if none have been
+// loaded.
+func currentLimits() *Limits {
+	if l := limits.Load(); l != nil {
+		return l
+	}
+	return &Limits{}
+}
+
+// hook is a registered validator. It's a pointer, so that it can be found
+// again to unregister it.
+type hook struct {
+	fn func(*pb.Request) error
+}
+
+var (
+	validatorsMu sync.RWMutex
+	validators   []*hook
+)
+
+// Register adds a hook for deployment-specific rules (e.g. "grids must be
+// square"), which runs after the built-in checks in Request. Hooks run in
+// registration order, and the first error is returned. The returned func
+// removes the hook again.
+func Register(fn func(*pb.Request) error) func() {
+	h := &hook{fn}
+
+	validatorsMu.Lock()
+	defer validatorsMu.Unlock()
+	validators = append(validators, h)
+
+	return func() {
+		validatorsMu.Lock()
+		defer validatorsMu.Unlock()
+		for i, v := range validators {
+			if v == h {
+				validators = append(validators[:i:i], validators[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+func runValidators(req *pb.Request) error {
+	validatorsMu.RLock()
+	defer validatorsMu.RUnlock()
+
+	for _, h := range validators {
+		if err := h.fn(req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Mode selects how strictly requests are checked. Lenient mode skips
+// the checks that data values fall within their declared ranges, for clients
+// which ship pre-validated data, but keeps all structural checks (sizes, nils,
+// NaN/Inf).
+type Mode int
+
+const (
+	// Strict checks everything.
+	Strict Mode = iota
+
+	// Lenient skips the value range checks.
+	Lenient
+)
+
+// Request checks req in strict mode. It doesn't modify req.
+func Request(req *pb.Request) error {
+	return RequestWithMode(req, Strict)
+}
+
+// fieldError is a validation error attributed to one part of a request. Its
+// message is unchanged; path is only carried along for logging.
+type fieldError struct {
+	path string
+	err  error
+}
+
+func (e *fieldError) Error() string {
+	return e.err.Error()
+}
+
+func (e *fieldError) Unwrap() error {
+	return e.err
+}
+
+// FieldPath returns the path of the part of the request which failed
+// validation, e.g. "input 2 / grid", or "request" if it's not known.
+func FieldPath(err error) string {
+	var fe *fieldError
+	if errors.As(err, &fe) {
+		return fe.path
+	}
+	return "request"
+}
+
+// VisualizationName returns the proto name of the input's visualization, or ""
+// if it has none.
+func VisualizationName(input *pb.Input) string {
+	m := input.ProtoReflect()
+	if fd := m.WhichOneof(m.Descriptor().Oneofs().ByName("visualization")); fd != nil {
+		return string(fd.Name())
+	}
+	return ""
+}
+
+// RequestWithMode checks req, including its default response and any hooks
+// added with Register. Errors carry the part of the request which failed, for
+// FieldPath.
+func RequestWithMode(req *pb.Request, mode Mode) error {
+	if req == nil {
+		return fmt.Errorf("request cannot be nil")
+	}
+
+	if len(req.Inputs) == 0 {
+		return &fieldError{"inputs", fmt.Errorf("request must have at least one input")}
+	}
+
+	if max := currentLimits().MaxInputsPerRequest; max > 0 && len(req.Inputs) > max {
+		return &fieldError{"inputs", fmt.Errorf("request has too many inputs (max %d, got %d)", max, len(req.Inputs))}
+	}
+
+	if n := utf8.RuneCountInString(req.Title); n > maxTitleLength {
+		return &fieldError{"title", fmt.Errorf("title too long (max %d characters, got %d)", maxTitleLength, n)}
+	}
+
+	if n := utf8.RuneCountInString(req.Prompt); n > maxPromptLength {
+		return &fieldError{"prompt", fmt.Errorf("prompt too long (max %d characters, got %d)", maxPromptLength, n)}
+	}
+
+	if req.RaceReplicas < 0 || req.RaceReplicas > maxRaceReplicas {
+		return &fieldError{"race_replicas", fmt.Errorf("race_replicas must be between 0 and %d, got %d", maxRaceReplicas, req.RaceReplicas)}
+	}
+
+	if req.EstimatedSeconds < 0 || req.EstimatedSeconds > maxEstimatedSeconds {
+		return &fieldError{"estimated_seconds", fmt.Errorf("estimated_seconds must be between 0 and %d, got %d", maxEstimatedSeconds, req.EstimatedSeconds)}
+	}
+
+	if err := QueueName(req.QueueName); err != nil {
+		return &fieldError{"queue_name", err}
+	}
+
+	names := make(map[string]bool, len(req.Inputs))
+	for i, input := range req.Inputs {
+		if name := input.GetName(); name != "" {
+			if names[name] {
+				return &fieldError{fmt.Sprintf("input %d", i), fmt.Errorf("duplicate input name %q", name)}
+			}
+			names[name] = true
+		}
+
+		if err := Input(input, mode); err != nil {
+			path := fmt.Sprintf("input %d", i)
+			if input != nil {
+				if name := VisualizationName(input); name != "" {
+					path += " / " + name
+				}
+			}
+			return &fieldError{path, fmt.Errorf("input %d: %w", i, err)}
+		}
+	}
+
+	if len(req.Outputs) > 0 {
+		if req.Output != nil {
+			return &fieldError{"outputs", fmt.Errorf("set output or outputs, not both")}
+		}
+		if len(req.Outputs) > maxOutputsPerRequest {
+			return &fieldError{"outputs", fmt.Errorf("request has too many outputs (max %d, got %d)", maxOutputsPerRequest, len(req.Outputs))}
+		}
+		for i, schema := range req.Outputs {
+			if err := OutputSchema(schema); err != nil {
+				return &fieldError{fmt.Sprintf("outputs %d", i), fmt.Errorf("output schema %d: %w", i, err)}
+			}
+			if _, err := editTarget(req, schema); err != nil {
+				return &fieldError{fmt.Sprintf("outputs %d", i), fmt.Errorf("output schema %d: %w", i, err)}
+			}
+		}
+	} else if err := OutputSchema(req.Output); err != nil {
+		return &fieldError{"output", fmt.Errorf("output schema: %w", err)}
+	} else if _, err := editTarget(req, req.Output); err != nil {
+		return &fieldError{"output", fmt.Errorf("output schema: %w", err)}
+	}
+
+	if req.DefaultResponse != nil {
+		if err := Answer(req, req.DefaultResponse); err != nil {
+			return &fieldError{"default_response", fmt.Errorf("default response: %w", err)}
+		}
+	}
+
+	return runValidators(req)
+}
+
+// QueueName checks the name of a sub-queue. The empty name, which is the
+// default queue, is valid.
+func QueueName(name string) error {
+	if len(name) > maxQueueNameLength {
+		return fmt.Errorf("queue name too long (max %d characters, got %d)", maxQueueNameLength, len(name))
+	}
+	for _, r := range name {
+		if !(r == '-' || r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return fmt.Errorf("queue name %q may only contain letters, digits, '-' and '_'", name)
+		}
+	}
+	return nil
+}
+
+// Input checks one input's visualization and data. Compressed data is checked
+// as the floats it decodes to, but isn't replaced by them.
+func Input(input *pb.Input, mode Mode) error {
+	if input == nil {
+		return fmt.Errorf("input cannot be nil")
+	}
+
+	if _, ok := pb.LayoutHint_name[int32(input.LayoutHint)]; !ok {
+		return fmt.Errorf("unknown layout hint %d", input.LayoutHint)
+	}
+
+	if err := Preview(input.Preview); err != nil {
+		return err
+	}
+
+	// decode compressed data first, so that the visualization checks see the
+	// floats it decodes to
+	data, err := decompressed(input.Data)
+	if err != nil {
+		return err
+	}
+
+	switch v := input.Visualization.(type) {
+	case *pb.Input_Grid:
+		if err := Grid(v.Grid, data); err != nil {
+			return err
+		}
+	case *pb.Input_GridPair:
+		if err := GridPair(v.GridPair, data); err != nil {
+			return err
+		}
+	case *pb.Input_MultiGrid:
+		if err := MultiChannelGrid(v.MultiGrid, data); err != nil {
+			return err
+		}
+	case *pb.Input_Scalar:
+		if err := Scalar(v.Scalar, data, mode); err != nil {
+			return err
+		}
+	case *pb.Input_Vector:
+		if err := Vector2D(v.Vector, data, mode); err != nil {
+			return err
+		}
+	case *pb.Input_TimeSeries:
+		if err := TimeSeries(v.TimeSeries, data, mode); err != nil {
+			return err
+		}
+	case *pb.Input_Volume:
+		if err := VolumeGrid(v.Volume, data); err != nil {
+			return err
+		}
+	case *pb.Input_Spectrogram:
+		if err := Spectrogram(v.Spectrogram, data, mode); err != nil {
+			return err
+		}
+	case *pb.Input_Graph:
+		if err := Graph(v.Graph, data); err != nil {
+			return err
+		}
+	case *pb.Input_Geo:
+		if err := GeoPoints(v.Geo, data); err != nil {
+			return err
+		}
+	case *pb.Input_Markdown:
+		// display-only, so there's no data to check
+		return Markdown(v.Markdown, data)
+	case nil:
+		return fmt.Errorf("visualization is required")
+	default:
+		return fmt.Errorf("unsupported visualization type")
+	}
+
+	return Data(data)
+}
+
+// Grid checks that data fills the grid.
+func Grid(grid *pb.Grid, data *pb.Data) error {
+	if grid == nil {
+		return fmt.Errorf("grid cannot be nil")
+	}
+
+	if err := gridSize(grid.Rows, grid.Cols); err != nil {
+		return err
+	}
+
+	if _, ok := pb.Ordering_name[int32(grid.Ordering)]; !ok {
+		return fmt.Errorf("unknown ordering %d", grid.Ordering)
+	}
+
+	if data == nil {
+		return fmt.Errorf("data is required")
+	}
+
+	expectedSize := int(grid.Rows * grid.Cols)
+
+	if n := len(grid.Mask); n > 0 && n != expectedSize {
+		return fmt.Errorf("mask size %d doesn't match grid size %d", n, expectedSize)
+	}
+
+	n, err := gridDataLen(data)
+	if err != nil {
+		return err
+	}
+	if n != expectedSize {
+		return fmt.Errorf("data size %d doesn't match grid size %d", n, expectedSize)
+	}
+
+	return nil
+}
+
+// GridPair checks that data fills both grids.
+func GridPair(pair *pb.GridPair, data *pb.Data) error {
+	if pair == nil {
+		return fmt.Errorf("grid pair cannot be nil")
+	}
+
+	if err := gridSize(pair.Rows, pair.Cols); err != nil {
+		return err
+	}
+
+	for _, label := range []string{pair.LeftLabel, pair.RightLabel} {
+		if n := utf8.RuneCountInString(label); n > maxGridLabelLength {
+			return fmt.Errorf("grid pair label too long (max %d characters, got %d)", maxGridLabelLength, n)
+		}
+	}
+	if pair.LeftLabel != "" && pair.LeftLabel == pair.RightLabel {
+		return fmt.Errorf("grid pair labels must differ (both %q)", pair.LeftLabel)
+	}
+
+	if data == nil {
+		return fmt.Errorf("data is required")
+	}
+
+	n, err := gridDataLen(data)
+	if err != nil {
+		return err
+	}
+	if expectedSize := int(2 * pair.Rows * pair.Cols); n != expectedSize {
+		return fmt.Errorf("data size %d doesn't match grid pair size %d (2x%dx%d)", n, expectedSize, pair.Rows, pair.Cols)
+	}
+
+	return nil
+}
+
+// gridSize checks the dimensions of a single grid.
+func gridSize(rows, cols int32) error {
+	if rows <= 0 || cols <= 0 {
+		return fmt.Errorf("grid dimensions must be positive (got %dx%d)", rows, cols)
+	}
+
+	if rows > 100 || cols > 100 {
+		return fmt.Errorf("grid too large (max 100x100, got %dx%d)", rows, cols)
+	}
+
+	return nil
+}
+
+// gridDataLen returns the number of values in data, which must be one of the
+// types a grid can show.
+func gridDataLen(data *pb.Data) (int, error) {
+	switch d := data.Data.(type) {
+	case *pb.Data_Ints:
+		if d.Ints == nil {
+			return 0, fmt.Errorf("ints data cannot be nil")
+		}
+		return len(d.Ints.Values), nil
+	case *pb.Data_Floats:
+		if d.Floats == nil {
+			return 0, fmt.Errorf("floats data cannot be nil")
+		}
+		return len(d.Floats.Values), nil
+	case *pb.Data_Bytes:
+		if d.Bytes == nil {
+			return 0, fmt.Errorf("bytes data cannot be nil")
+		}
+		return len(d.Bytes.Values), nil
+	case nil:
+		return 0, fmt.Errorf("data type is required")
+	default:
+		return 0, fmt.Errorf("unsupported data type")
+	}
+}
+
+// MultiChannelGrid checks that data fills every channel of the grid.
+func MultiChannelGrid(grid *pb.MultiChannelGrid, data *pb.Data) error {
+	if grid == nil {
+		return fmt.Errorf("multi-channel grid cannot be nil")
+	}
+
+	if grid.Rows <= 0 || grid.Cols <= 0 {
+		return fmt.Errorf("grid dimensions must be positive (got %dx%d)", grid.Rows, grid.Cols)
+	}
+
+	if grid.Rows > 100 || grid.Cols > 100 {
+		return fmt.Errorf("grid too large (max 100x100, got %dx%d)", grid.Rows, grid.Cols)
+	}
+
+	if _, ok := pb.Ordering_name[int32(grid.Ordering)]; !ok {
+		return fmt.Errorf("unknown ordering %d", grid.Ordering)
+	}
+
+	if grid.Channels <= 0 {
+		return fmt.Errorf("channel count must be positive (got %d)", grid.Channels)
+	}
+
+	if grid.Channels > 10 {
+		return fmt.Errorf("too many channels (max 10, got %d)", grid.Channels)
+	}
+
+	if len(grid.ChannelNames) > 0 && len(grid.ChannelNames) != int(grid.Channels) {
+		return fmt.Errorf("channel names count %d doesn't match channel count %d",
+			len(grid.ChannelNames), grid.Channels)
+	}
+
+	// empty names are left alone, but named channels must be distinguishable
+	names := make(map[string]bool, len(grid.ChannelNames))
+	for _, name := range grid.ChannelNames {
+		if name == "" {
+			continue
+		}
+		if names[name] {
+			return fmt.Errorf("duplicate channel name %q", name)
+		}
+		names[name] = true
+	}
+
+	if data == nil {
+		return fmt.Errorf("data is required")
+	}
+
+	expectedSize := int(grid.Rows * grid.Cols * grid.Channels)
+
+	switch d := data.Data.(type) {
+	case *pb.Data_Ints:
+		if d.Ints == nil {
+			return fmt.Errorf("ints data cannot be nil")
+		}
+		if len(d.Ints.Values) != expectedSize {
+			return fmt.Errorf("data size %d doesn't match expected size %d (rows*cols*channels=%d*%d*%d)",
+				len(d.Ints.Values), expectedSize, grid.Rows, grid.Cols, grid.Channels)
+		}
+	case *pb.Data_Floats:
+		if d.Floats == nil {
+			return fmt.Errorf("floats data cannot be nil")
+		}
+		if len(d.Floats.Values) != expectedSize {
+			return fmt.Errorf("data size %d doesn't match expected size %d (rows*cols*channels=%d*%d*%d)",
+				len(d.Floats.Values), expectedSize, grid.Rows, grid.Cols, grid.Channels)
+		}
+	case *pb.Data_Bytes:
+		if d.Bytes == nil {
+			return fmt.Errorf("bytes data cannot be nil")
+		}
+		if len(d.Bytes.Values) != expectedSize {
+			return fmt.Errorf("data size %d doesn't match expected size %d (rows*cols*channels=%d*%d*%d)",
+				len(d.Bytes.Values), expectedSize, grid.Rows, grid.Cols, grid.Channels)
+		}
+	case nil:
+		return fmt.Errorf("data type is required")
+	default:
+		return fmt.Errorf("unsupported data type")
+	}
+
+	return nil
+}
+
+// VolumeGrid checks that data fills the volume.
+func VolumeGrid(volume *pb.VolumeGrid, data *pb.Data) error {
+	if volume == nil {
+		return fmt.Errorf("volume grid cannot be nil")
+	}
+
+	if volume.X <= 0 || volume.Y <= 0 || volume.Z <= 0 {
+		return fmt.Errorf("volume dimensions must be positive (got %dx%dx%d)", volume.X, volume.Y, volume.Z)
+	}
+
+	if volume.X > 64 || volume.Y > 64 || volume.Z > 64 {
+		return fmt.Errorf("volume too large (max 64x64x64, got %dx%dx%d)", volume.X, volume.Y, volume.Z)
+	}
+
+	if data == nil {
+		return fmt.Errorf("data is required")
+	}
+
+	expectedSize := int(volume.X * volume.Y * volume.Z)
+
+	switch d := data.Data.(type) {
+	case *pb.Data_Ints:
+		if d.Ints == nil {
+			return fmt.Errorf("ints data cannot be nil")
+		}
+		if len(d.Ints.Values) != expectedSize {
+			return fmt.Errorf("data size %d doesn't match expected size %d (x*y*z=%d*%d*%d)",
+				len(d.Ints.Values), expectedSize, volume.X, volume.Y, volume.Z)
+		}
+	case *pb.Data_Floats:
+		if d.Floats == nil {
+			return fmt.Errorf("floats data cannot be nil")
+		}
+		if len(d.Floats.Values) != expectedSize {
+			return fmt.Errorf("data size %d doesn't match expected size %d (x*y*z=%d*%d*%d)",
+				len(d.Floats.Values), expectedSize, volume.X, volume.Y, volume.Z)
+		}
+	case nil:
+		return fmt.Errorf("data type is required")
+	default:
+		return fmt.Errorf("unsupported data type")
+	}
+
+	return nil
+}
+
+// Scalar checks a single value, and in strict mode that it's within the
+// scalar's range.
+func Scalar(scalar *pb.Scalar, data *pb.Data, mode Mode) error {
+	if scalar == nil {
+		return fmt.Errorf("scalar cannot be nil")
+	}
+
+	if scalar.Label == "" {
+		return fmt.Errorf("scalar label is required")
+	}
+
+	if scalar.Min >= scalar.Max {
+		return fmt.Errorf("scalar min %f must be less than max %f", scalar.Min, scalar.Max)
+	}
+
+	// the unit is rendered inline next to the value, so keep it short
+	if n := utf8.RuneCountInString(scalar.Unit); n > maxScalarUnitLength {
+		return fmt.Errorf("scalar unit too long (max %d characters, got %d)", maxScalarUnitLength, n)
+	}
+	for _, r := range scalar.Unit {
+		if unicode.IsControl(r) {
+			return fmt.Errorf("scalar unit contains control character %q", r)
+		}
+	}
+
+	if data == nil {
+		return fmt.Errorf("data is required")
+	}
+
+	switch d := data.Data.(type) {
+	case *pb.Data_Floats:
+		if d.Floats == nil {
+			return fmt.Errorf("floats data cannot be nil")
+		}
+		if len(d.Floats.Values) != 1 {
+			return fmt.Errorf("scalar requires exactly 1 float value (got %d)", len(d.Floats.Values))
+		}
+		value := d.Floats.Values[0]
+		// NaN compares false against both bounds, so check it explicitly
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return fmt.Errorf("scalar value must be finite (got %f)", value)
+		}
+		if mode == Strict && (value < scalar.Min || value > scalar.Max) {
+			return fmt.Errorf("scalar value %f is outside range [%f, %f]", value, scalar.Min, scalar.Max)
+		}
+	default:
+		return fmt.Errorf("scalar visualization requires float data")
+	}
+
+	return nil
+}
+
+// Vector2D checks an x, y pair, and in strict mode that it's within the
+// vector's bounds.
+func Vector2D(vector *pb.Vector2D, data *pb.Data, mode Mode) error {
+	if vector == nil {
+		return fmt.Errorf("vector cannot be nil")
+	}
+
+	if vector.Label == "" {
+		return fmt.Errorf("vector label is required")
+	}
+
+	if vector.MaxMagnitude <= 0 {
+		return fmt.Errorf("vector max_magnitude must be positive (got %f)", vector.MaxMagnitude)
+	}
+
+	// written so that a NaN max_magnitude is rejected too
+	if max := currentLimits().MaxVectorMagnitude; max > 0 && !(vector.MaxMagnitude <= max) {
+		return fmt.Errorf("vector max_magnitude %f exceeds the server limit %f", vector.MaxMagnitude, max)
+	}
+
+	if data == nil {
+		return fmt.Errorf("data is required")
+	}
+
+	switch d := data.Data.(type) {
+	case *pb.Data_Floats:
+		if d.Floats == nil {
+			return fmt.Errorf("floats data cannot be nil")
+		}
+		if len(d.Floats.Values) != 2 {
+			return fmt.Errorf("vector requires exactly 2 float values (got %d)", len(d.Floats.Values))
+		}
+		x, y := d.Floats.Values[0], d.Floats.Values[1]
+		// a NaN magnitude would compare false against max_magnitude and slip through
+		if math.IsNaN(x) || math.IsInf(x, 0) || math.IsNaN(y) || math.IsInf(y, 0) {
+			return fmt.Errorf("vector components must be finite (got x=%f, y=%f)", x, y)
+		}
+		magnitude := math.Sqrt(x*x + y*y)
+		if mode == Strict && magnitude > vector.MaxMagnitude {
+			return fmt.Errorf("vector magnitude %f exceeds max_magnitude %f", magnitude, vector.MaxMagnitude)
+		}
+	default:
+		return fmt.Errorf("vector visualization requires float data")
+	}
+
+	return nil
+}
+
+// TimeSeries checks a series, and in strict mode that its values are within
+// the declared range.
+func TimeSeries(timeSeries *pb.TimeSeries, data *pb.Data, mode Mode) error {
+	if timeSeries == nil {
+		return fmt.Errorf("time series cannot be nil")
+	}
+
+	if timeSeries.Label == "" {
+		return fmt.Errorf("time series label is required")
+	}
+
+	if timeSeries.Points <= 0 {
+		return fmt.Errorf("time series points must be positive (got %d)", timeSeries.Points)
+	}
+
+	if timeSeries.Points > maxTimeSeriesPoints {
+		return fmt.Errorf("time series has too many points (max %d, got %d)", maxTimeSeriesPoints, timeSeries.Points)
+	}
+
+	if timeSeries.MinValue >= timeSeries.MaxValue {
+		return fmt.Errorf("time series min_value %f must be less than max_value %f",
+			timeSeries.MinValue, timeSeries.MaxValue)
+	}
+
+	if _, ok := pb.Monotonic_name[int32(timeSeries.Monotonic)]; !ok {
+		return fmt.Errorf("unknown monotonic %d", timeSeries.Monotonic)
+	}
+
+	if data == nil {
+		return fmt.Errorf("data is required")
+	}
+
+	switch d := data.Data.(type) {
+	case *pb.Data_Floats:
+		if d.Floats == nil {
+			return fmt.Errorf("floats data cannot be nil")
+		}
+		expectedSize := int(timeSeries.Points)
+		if len(d.Floats.Values) != expectedSize {
+			return fmt.Errorf("data size %d doesn't match expected points %d",
+				len(d.Floats.Values), expectedSize)
+		}
+		for i, v := range d.Floats.Values {
+			if mode == Strict && (v < timeSeries.MinValue || v > timeSeries.MaxValue) {
+				return fmt.Errorf("time series value at index %d (%f) is outside range [%f, %f]",
+					i, v, timeSeries.MinValue, timeSeries.MaxValue)
+			}
+		}
+		// unlike the range, this is about the data being wrong rather than
+		// unexpected, so it's checked in lenient mode too
+		if err := monotonic(d.Floats.Values, timeSeries.Monotonic); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("time series visualization requires float data")
+	}
+
+	return nil
+}
+
+// monotonic checks that values are in the order m requires.
+func monotonic(values []float64, m pb.Monotonic) error {
+	for i := 1; i < len(values); i++ {
+		prev, v := values[i-1], values[i]
+		switch {
+		case m == pb.Monotonic_NON_DECREASING && v < prev:
+			return fmt.Errorf("time series must be non-decreasing, but value at index %d (%f) is less than %f", i, v, prev)
+		case m == pb.Monotonic_NON_INCREASING && v > prev:
+			return fmt.Errorf("time series must be non-increasing, but value at index %d (%f) is greater than %f", i, v, prev)
+		}
+	}
+	return nil
+}
+
+// Spectrogram checks that data fills the time-frequency grid, and in strict
+// mode that its values are within the declared range.
+func Spectrogram(spec *pb.Spectrogram, data *pb.Data, mode Mode) error {
+	if spec == nil {
+		return fmt.Errorf("spectrogram cannot be nil")
+	}
+
+	if spec.Label == "" {
+		return fmt.Errorf("spectrogram label is required")
+	}
+
+	if spec.TimeBins <= 0 || spec.FreqBins <= 0 {
+		return fmt.Errorf("spectrogram bins must be positive (got %dx%d)", spec.TimeBins, spec.FreqBins)
+	}
+
+	if spec.TimeBins > 1000 || spec.FreqBins > 512 {
+		return fmt.Errorf("spectrogram too large (max 1000 time bins x 512 freq bins, got %dx%d)",
+			spec.TimeBins, spec.FreqBins)
+	}
+
+	if spec.Min >= spec.Max {
+		return fmt.Errorf("spectrogram min %f must be less than max %f", spec.Min, spec.Max)
+	}
+
+	if data == nil {
+		return fmt.Errorf("data is required")
+	}
+
+	switch d := data.Data.(type) {
+	case *pb.Data_Floats:
+		if d.Floats == nil {
+			return fmt.Errorf("floats data cannot be nil")
+		}
+		expectedSize := int(spec.TimeBins * spec.FreqBins)
+		if len(d.Floats.Values) != expectedSize {
+			return fmt.Errorf("data size %d doesn't match expected size %d (time_bins*freq_bins=%d*%d)",
+				len(d.Floats.Values), expectedSize, spec.TimeBins, spec.FreqBins)
+		}
+		for i, v := range d.Floats.Values {
+			if mode == Strict && (v < spec.Min || v > spec.Max) {
+				return fmt.Errorf("spectrogram value at index %d (%f) is outside range [%f, %f]",
+					i, v, spec.Min, spec.Max)
+			}
+		}
+	default:
+		return fmt.Errorf("spectrogram visualization requires float data")
+	}
+
+	return nil
+}
+
+// Graph checks a graph's nodes and edges.
+func Graph(graph *pb.Graph, data *pb.Data) error {
+	if graph == nil {
+		return fmt.Errorf("graph cannot be nil")
+	}
+
+	nodes := len(graph.NodeLabels)
+	if nodes == 0 {
+		return fmt.Errorf("graph requires at least one node label")
+	}
+
+	if nodes > 200 {
+		return fmt.Errorf("graph has too many nodes (max 200, got %d)", nodes)
+	}
+
+	for i, label := range graph.NodeLabels {
+		if label == "" {
+			return fmt.Errorf("graph node %d label cannot be empty", i)
+		}
+	}
+
+	if data == nil {
+		return fmt.Errorf("data is required")
+	}
+
+	switch d := data.Data.(type) {
+	case *pb.Data_Ints:
+		if d.Ints == nil {
+			return fmt.Errorf("ints data cannot be nil")
+		}
+		if len(d.Ints.Values)%2 != 0 {
+			return fmt.Errorf("graph edge data must have even length (got %d)", len(d.Ints.Values))
+		}
+		if edges := len(d.Ints.Values) / 2; edges > 1000 {
+			return fmt.Errorf("graph has too many edges (max 1000, got %d)", edges)
+		}
+		for i, v := range d.Ints.Values {
+			if v < 0 || v >= int64(nodes) {
+				return fmt.Errorf("graph edge %d references node %d outside range [0, %d)", i/2, v, nodes)
+			}
+		}
+	default:
+		return fmt.Errorf("graph visualization requires int edge data")
+	}
+
+	return nil
+}
+
+// validLatLng reports whether the coordinates are on the globe. It's written so
+// that NaN fails, rather than slipping past the comparisons.
+func validLatLng(lat, lng float64) bool {
+	return lat >= -90 && lat <= 90 && lng >= -180 && lng <= 180
+}
+
+// previewMagic are the leading bytes of the image formats accepted as
+// previews: png, jpeg, and gif.
+var previewMagic = [][]byte{
+	[]byte("\x89PNG\r\n\x1a\n"),
+	[]byte("\xff\xd8\xff"),
+	[]byte("GIF87a"),
+	[]byte("GIF89a"),
+}
+
+// Preview checks an input's optional preview image. An empty preview is fine;
+// otherwise it must be small, and start like a png, jpeg, or gif.
+func Preview(b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+
+	if len(b) > maxPreviewBytes {
+		return fmt.Errorf("preview too large (max %d bytes, got %d)", maxPreviewBytes, len(b))
+	}
+
+	for _, magic := range previewMagic {
+		if bytes.HasPrefix(b, magic) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("preview must be a png, jpeg, or gif image")
+}
+
+// Markdown checks a display-only markdown input, which takes no data.
+func Markdown(md *pb.Markdown, data *pb.Data) error {
+	if md == nil {
+		return fmt.Errorf("markdown cannot be nil")
+	}
+
+	if md.Content == "" {
+		return fmt.Errorf("markdown content is required")
+	}
+
+	if n := utf8.RuneCountInString(md.Content); n > maxMarkdownLength {
+		return fmt.Errorf("markdown content too long (max %d characters, got %d)", maxMarkdownLength, n)
+	}
+
+	if data != nil {
+		return fmt.Errorf("markdown input takes no data")
+	}
+
+	return nil
+}
+
+// GeoPoints checks a set of lat/lng points.
+func GeoPoints(geo *pb.GeoPoints, data *pb.Data) error {
+	if geo == nil {
+		return fmt.Errorf("geo cannot be nil")
+	}
+
+	if !validLatLng(geo.CenterLat, geo.CenterLng) {
+		return fmt.Errorf("geo center out of range (got lat=%f, lng=%f)", geo.CenterLat, geo.CenterLng)
+	}
+
+	if !(geo.ZoomHint >= 0) {
+		return fmt.Errorf("geo zoom_hint must be non-negative (got %f)", geo.ZoomHint)
+	}
+
+	if data == nil {
+		return fmt.Errorf("data is required")
+	}
+
+	switch d := data.Data.(type) {
+	case *pb.Data_Floats:
+		if d.Floats == nil {
+			return fmt.Errorf("floats data cannot be nil")
+		}
+		vals := d.Floats.Values
+		if len(vals) == 0 {
+			return fmt.Errorf("geo requires at least one point")
+		}
+		if len(vals)%2 != 0 {
+			return fmt.Errorf("geo data must be lat,lng pairs of even length (got %d)", len(vals))
+		}
+		if points := len(vals) / 2; points > 10000 {
+			return fmt.Errorf("geo has too many points (max 10000, got %d)", points)
+		}
+		for i := 0; i < len(vals); i += 2 {
+			if !validLatLng(vals[i], vals[i+1]) {
+				return fmt.Errorf("geo point %d out of range (got lat=%f, lng=%f)", i/2, vals[i], vals[i+1])
+			}
+		}
+	default:
+		return fmt.Errorf("geo visualization requires float data")
+	}
+
+	return nil
+}
+
+// Data checks that data is set and its floats are finite.
+func Data(data *pb.Data) error {
+	if data == nil {
+		return fmt.Errorf("data cannot be nil")
+	}
+
+	switch d := data.Data.(type) {
+	case *pb.Data_Ints:
+		return nil
+	case *pb.Data_Floats:
+		if d.Floats == nil {
+			return fmt.Errorf("floats data cannot be nil")
+		}
+		for i, v := range d.Floats.Values {
+			if math.IsNaN(v) {
+				return fmt.Errorf("float value at index %d is NaN", i)
+			}
+			if math.IsInf(v, 0) {
+				return fmt.Errorf("float value at index %d is infinite", i)
+			}
+		}
+		return nil
+	case *pb.Data_Bytes:
+		// every byte is in 0-255, so there's nothing to range check
+		if d.Bytes == nil {
+			return fmt.Errorf("bytes data cannot be nil")
+		}
+		return nil
+	case *pb.Data_CompressedFloats:
+		floats, err := decompressed(data)
+		if err != nil {
+			return err
+		}
+		return Data(floats)
+	case nil:
+		return fmt.Errorf("data type is required")
+	default:
+		return fmt.Errorf("unsupported data type")
+	}
+}
+
+// decompressed returns data, or if it's compressed, a copy holding the floats
+// it decodes to.
+func decompressed(data *pb.Data) (*pb.Data, error) {
+	c, ok := data.GetData().(*pb.Data_CompressedFloats)
+	if !ok {
+		return data, nil
+	}
+	if c.CompressedFloats == nil {
+		return nil, fmt.Errorf("compressed floats data cannot be nil")
+	}
+
+	values, err := DecompressFloats(c.CompressedFloats)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.Data{Data: &pb.Data_Floats{Floats: &pb.Floats{Values: values}}}, nil
+}
+
+// OutputSchema checks the schema of the answer a request asks for.
+func OutputSchema(schema *pb.OutputSchema) error {
+	if schema == nil {
+		return fmt.Errorf("output schema is required")
+	}
+
+	switch s := schema.Output.(type) {
+	case *pb.OutputSchema_OptionList:
+		if s.OptionList == nil {
+			return fmt.Errorf("option list cannot be nil")
+		}
+		n := len(s.OptionList.Options)
+		if n < 2 {
+			return fmt.Errorf("option list must have at least 2 options (got %d)", n)
+		}
+		if n > maxOptions {
+			return fmt.Errorf("option list has too many options (max %d, got %d)", maxOptions, n)
+		}
+
+		for i, opt := range s.OptionList.Options {
+			if opt == nil {
+				return fmt.Errorf("option %d cannot be nil", i)
+			}
+			if opt.Label == "" {
+				return fmt.Errorf("option %d label cannot be empty", i)
+			}
+			if n := utf8.RuneCountInString(opt.Group); n > maxOptionGroupLength {
+				return fmt.Errorf("option %d group too long (max %d characters, got %d)", i, maxOptionGroupLength, n)
+			}
+			if n := utf8.RuneCountInString(opt.Description); n > maxOptionDescLength {
+				return fmt.Errorf("option %d description too long (max %d characters, got %d)", i, maxOptionDescLength, n)
+			}
+		}
+
+		// the server assigns what hotkeys it can to lists which ask and set
+		// none, so only lists which set their own need one per option
+		auto := s.OptionList.AutoHotkeys
+		for _, opt := range s.OptionList.Options {
+			auto = auto && opt.Hotkey == ""
+		}
+		if auto {
+			return nil
+		}
+		return optionHotkeys(s.OptionList.Options)
+	case *pb.OutputSchema_Range:
+		if s.Range == nil {
+			return fmt.Errorf("range cannot be nil")
+		}
+		if math.IsNaN(s.Range.Min) || math.IsInf(s.Range.Min, 0) || math.IsNaN(s.Range.Max) || math.IsInf(s.Range.Max, 0) {
+			return fmt.Errorf("range bounds must be finite (got min=%f, max=%f)", s.Range.Min, s.Range.Max)
+		}
+		if s.Range.Min >= s.Range.Max {
+			return fmt.Errorf("range min must be less than max (got min=%f, max=%f)", s.Range.Min, s.Range.Max)
+		}
+		return nil
+	case *pb.OutputSchema_Classify:
+		if s.Classify == nil {
+			return fmt.Errorf("classify cannot be nil")
+		}
+		if len(s.Classify.ClassLabels) < 2 {
+			return fmt.Errorf("classify must have at least 2 classes (got %d)", len(s.Classify.ClassLabels))
+		}
+		for i, label := range s.Classify.ClassLabels {
+			if label == "" {
+				return fmt.Errorf("class %d label cannot be empty", i)
+			}
+		}
+		return nil
+	case *pb.OutputSchema_Edit:
+		if s.Edit == nil {
+			return fmt.Errorf("edit cannot be nil")
+		}
+		if !s.Edit.Editable {
+			return fmt.Errorf("edit schema must be editable")
+		}
+		if s.Edit.Input < 0 {
+			return fmt.Errorf("edit input index must not be negative (got %d)", s.Edit.Input)
+		}
+		return nil
+	case nil:
+		return fmt.Errorf("output type is required")
+	default:
+		return fmt.Errorf("unsupported output schema type")
+	}
+}
+
+// editTarget returns the (decompressed) data of the input which schema asks
+// to be edited, or nil if it's not an edit schema, checking that the input
+// exists and has data which can be edited.
+func editTarget(req *pb.Request, schema *pb.OutputSchema) (*pb.Data, error) {
+	s := schema.GetEdit()
+	if s == nil {
+		return nil, nil
+	}
+
+	if int(s.Input) >= len(req.GetInputs()) {
+		return nil, fmt.Errorf("edit input index %d out of range [0, %d)", s.Input, len(req.GetInputs()))
+	}
+	input := req.Inputs[s.Input]
+	if input.GetMarkdown() != nil {
+		return nil, fmt.Errorf("edit input %d is display-only and cannot be edited", s.Input)
+	}
+	data, err := decompressed(input.GetData())
+	if err != nil {
+		return nil, fmt.Errorf("edit input %d: %w", s.Input, err)
+	}
+	if data == nil {
+		return nil, fmt.Errorf("edit input %d has no data", s.Input)
+	}
+	if _, err := gridDataLen(data); err != nil {
+		return nil, fmt.Errorf("edit input %d must have ints, floats, or bytes: %w", s.Input, err)
+	}
+	return data, nil
+}
+
+// editShape checks that an edit answer has the same type and size of data as
+// the input it edits. Answers to other schemas are left to Output.
+func editShape(req *pb.Request, schema *pb.OutputSchema, output *pb.Output) error {
+	want, err := editTarget(req, schema)
+	if want == nil || err != nil {
+		return err
+	}
+
+	got, err := decompressed(output.GetEdit().GetData())
+	if err != nil {
+		return err
+	}
+	gotLen, err := gridDataLen(got)
+	if err != nil {
+		return err
+	}
+	wantLen, _ := gridDataLen(want)
+
+	if gotType, wantType := fmt.Sprintf("%T", got.Data), fmt.Sprintf("%T", want.Data); gotType != wantType {
+		return fmt.Errorf("edited data must be the same type as input %d's", schema.GetEdit().Input)
+	}
+	if gotLen != wantLen {
+		return fmt.Errorf("edited data must be the same size as input %d's (want %d values, got %d)", schema.GetEdit().Input, wantLen, gotLen)
+	}
+	return nil
+}
+
+// optionHotkeys checks that every option has its own single-character
+// hotkey. There are only so many keys to go round, so lists which need them
+// have at most maxHotkeyOptions options (0-9 and a-z).
+func optionHotkeys(options []*pb.Option) error {
+	if len(options) > maxHotkeyOptions {
+		return fmt.Errorf("too many options for each to have a unique hotkey (max %d, got %d); set auto_hotkeys to leave some without", maxHotkeyOptions, len(options))
+	}
+
+	hotkeys := make(map[string]bool)
+	for i, opt := range options {
+		if len(opt.Hotkey) != 1 {
+			return fmt.Errorf("option %d hotkey must be single character (got %q)", i, opt.Hotkey)
+		}
+		if hotkeys[opt.Hotkey] {
+			return fmt.Errorf("duplicate hotkey %q found at option %d", opt.Hotkey, i)
+		}
+		hotkeys[opt.Hotkey] = true
+	}
+
+	return nil
+}
+
+// Response checks a response submitted by an annotator against the
+// output schema of the request it answers.
+func Response(schema *pb.OutputSchema, res *pb.Response) error {
+	if res == nil {
+		return fmt.Errorf("response cannot be nil")
+	}
+
+	return Output(schema, res.Output)
+}
+
+// Answer checks a response against all of the output schemas of the
+// request it answers: either the single output, or one outputs entry per
+// schema, in order. Edits are also checked against the inputs they edit.
+func Answer(req *pb.Request, res *pb.Response) error {
+	if len(req.GetOutputs()) == 0 {
+		if err := Response(req.GetOutput(), res); err != nil {
+			return err
+		}
+		return editShape(req, req.GetOutput(), res.Output)
+	}
+
+	if res == nil {
+		return fmt.Errorf("response cannot be nil")
+	}
+
+	if res.Output != nil {
+		return fmt.Errorf("request has multiple outputs, so answer with outputs rather than output")
+	}
+
+	if len(res.Outputs) != len(req.Outputs) {
+		return fmt.Errorf("expected %d outputs (got %d)", len(req.Outputs), len(res.Outputs))
+	}
+
+	for i, schema := range req.Outputs {
+		if err := Output(schema, res.Outputs[i]); err != nil {
+			return fmt.Errorf("output %d: %w", i, err)
+		}
+		if err := editShape(req, schema, res.Outputs[i]); err != nil {
+			return fmt.Errorf("output %d: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+// Output checks a single answer against its schema.
+func Output(schema *pb.OutputSchema, output *pb.Output) error {
+	if output == nil {
+		return fmt.Errorf("output is required")
+	}
+
+	if n := utf8.RuneCountInString(output.Comment); n > maxCommentLength {
+		return fmt.Errorf("comment too long (max %d characters, got %d)", maxCommentLength, n)
+	}
+
+	switch s := schema.GetOutput().(type) {
+	case *pb.OutputSchema_OptionList:
+		out, ok := output.Output.(*pb.Output_OptionList)
+		if !ok || out.OptionList == nil {
+			return fmt.Errorf("expected option list output")
+		}
+		n := len(s.OptionList.GetOptions())
+		if out.OptionList.Index == -1 && s.OptionList.GetAllowNone() {
+			break
+		}
+		if out.OptionList.Index < 0 || int(out.OptionList.Index) >= n {
+			return fmt.Errorf("option index %d out of range [0, %d)", out.OptionList.Index, n)
+		}
+	case *pb.OutputSchema_Range:
+		out, ok := output.Output.(*pb.Output_Range)
+		if !ok || out.Range == nil {
+			return fmt.Errorf("expected range output")
+		}
+		start, end := out.Range.Start, out.Range.End
+		// written so that NaN fails every comparison and is rejected
+		if !(start < end) {
+			return fmt.Errorf("range start must be less than end (got start=%f, end=%f)", start, end)
+		}
+		if !(start >= s.Range.GetMin() && end <= s.Range.GetMax()) {
+			return fmt.Errorf("range [%f, %f] outside bounds [%f, %f]", start, end, s.Range.GetMin(), s.Range.GetMax())
+		}
+	case *pb.OutputSchema_Classify:
+		out, ok := output.Output.(*pb.Output_Classify)
+		if !ok || out.Classify == nil {
+			return fmt.Errorf("expected classify output")
+		}
+		n := len(s.Classify.GetClassLabels())
+		if out.Classify.ClassIndex < 0 || int(out.Classify.ClassIndex) >= n {
+			return fmt.Errorf("class index %d out of range [0, %d)", out.Classify.ClassIndex, n)
+		}
+		if out.Classify.Confidence == nil {
+			if s.Classify.GetAskConfidence() {
+				return fmt.Errorf("confidence is required")
+			}
+		} else if c := *out.Classify.Confidence; !(c >= 0 && c <= 1) {
+			return fmt.Errorf("confidence must be in [0, 1] (got %f)", c)
+		}
+	case *pb.OutputSchema_Edit:
+		out, ok := output.Output.(*pb.Output_Edit)
+		if !ok || out.Edit == nil {
+			return fmt.Errorf("expected edit output")
+		}
+		if err := Data(out.Edit.Data); err != nil {
+			return fmt.Errorf("edited data: %w", err)
+		}
+	case nil:
+		return fmt.Errorf("output schema is required")
+	default:
+		return fmt.Errorf("unsupported output schema type")
+	}
+
+	return nil
+}
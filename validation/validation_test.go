@@ -0,0 +1,2634 @@
+package validation
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+
+	pb "github.com/adammck/collector/proto/gen"
+	"google.golang.org/protobuf/proto"
+)
+
+// testRequest returns a valid request with one 10x10 grid input.
+func testRequest() *pb.Request {
+	return &pb.Request{
+		Inputs: []*pb.Input{
+			{
+				Visualization: &pb.Input_Grid{
+					Grid: &pb.Grid{Rows: 10, Cols: 10},
+				},
+				Data: &pb.Data{
+					Data: &pb.Data_Ints{
+						Ints: &pb.Ints{Values: make([]int64, 100)}, // 10x10 = 100
+					},
+				},
+			},
+		},
+		Output: &pb.OutputSchema{
+			Output: &pb.OutputSchema_OptionList{
+				OptionList: &pb.OptionListSchema{
+					Options: []*pb.Option{
+						{Label: "Option 1", Hotkey: "1"},
+						{Label: "Option 2", Hotkey: "2"},
+					},
+				},
+			},
+		},
+	}
+}
+
+// testResponse returns an answer to testRequest.
+func testResponse() *pb.Response {
+	return &pb.Response{
+		Output: &pb.Output{
+			Output: &pb.Output_OptionList{
+				OptionList: &pb.OptionListOutput{Index: 0},
+			},
+		},
+	}
+}
+
+// testMultiOutputRequest returns testRequest with a second, range, output.
+func testMultiOutputRequest() *pb.Request {
+	req := testRequest()
+	req.Outputs = []*pb.OutputSchema{
+		req.Output,
+		{Output: &pb.OutputSchema_Range{Range: &pb.RangeSchema{Min: 0, Max: 10, Label: "quality"}}},
+	}
+	req.Output = nil
+	return req
+}
+
+func TestValidateResponse(t *testing.T) {
+	schema := testRequest().Output
+
+	tests := []struct {
+		name    string
+		res     *pb.Response
+		wantErr bool
+		errMsg  string
+	}{
+		{
+			name:    "nil response",
+			res:     nil,
+			wantErr: true,
+			errMsg:  "response cannot be nil",
+		},
+		{
+			name:    "missing output",
+			res:     &pb.Response{},
+			wantErr: true,
+			errMsg:  "output is required",
+		},
+		{
+			name:    "wrong output type",
+			res:     &pb.Response{Output: &pb.Output{}},
+			wantErr: true,
+			errMsg:  "expected option list output",
+		},
+		{
+			name: "negative index",
+			res: &pb.Response{Output: &pb.Output{
+				Output: &pb.Output_OptionList{OptionList: &pb.OptionListOutput{Index: -1}},
+			}},
+			wantErr: true,
+			errMsg:  "option index -1 out of range [0, 2)",
+		},
+		{
+			name: "index past end",
+			res: &pb.Response{Output: &pb.Output{
+				Output: &pb.Output_OptionList{OptionList: &pb.OptionListOutput{Index: 2}},
+			}},
+			wantErr: true,
+			errMsg:  "option index 2 out of range [0, 2)",
+		},
+		{
+			name:    "valid response",
+			res:     testResponse(),
+			wantErr: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Response(schema, tt.res)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Response() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr && !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("expected error containing %q, got %v", tt.errMsg, err)
+			}
+		})
+	}
+}
+
+func TestValidateAbstain(t *testing.T) {
+	abstain := &pb.Response{Output: &pb.Output{
+		Output: &pb.Output_OptionList{OptionList: &pb.OptionListOutput{Index: -1}},
+	}}
+
+	tests := []struct {
+		name      string
+		allowNone bool
+		index     int32
+		wantErr   bool
+		errMsg    string
+	}{
+		{
+			name:      "abstain allowed",
+			allowNone: true,
+			index:     -1,
+			wantErr:   false,
+		},
+		{
+			name:      "abstain not allowed",
+			allowNone: false,
+			index:     -1,
+			wantErr:   true,
+			errMsg:    "option index -1 out of range [0, 2)",
+		},
+		{
+			name:      "other negative index with allow none",
+			allowNone: true,
+			index:     -2,
+			wantErr:   true,
+			errMsg:    "option index -2 out of range [0, 2)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schema := testRequest().Output
+			schema.GetOptionList().AllowNone = tt.allowNone
+			abstain.Output.GetOptionList().Index = tt.index
+
+			err := Response(schema, abstain)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Response() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr && !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("expected error containing %q, got %v", tt.errMsg, err)
+			}
+		})
+	}
+}
+
+// testEditRequest returns testRequest, asking for its grid to be edited.
+func testEditRequest() *pb.Request {
+	req := testRequest()
+	req.Output = &pb.OutputSchema{
+		Output: &pb.OutputSchema_Edit{Edit: &pb.EditSchema{Editable: true}},
+	}
+	return req
+}
+
+// editResponse returns an edit answer with data.
+func editResponse(data *pb.Data) *pb.Response {
+	return &pb.Response{Output: &pb.Output{
+		Output: &pb.Output_Edit{Edit: &pb.EditOutput{Data: data}},
+	}}
+}
+
+func TestValidateEditSchema(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(*pb.Request)
+		wantErr bool
+		errMsg  string
+	}{
+		{
+			name:    "valid",
+			modify:  func(req *pb.Request) {},
+			wantErr: false,
+		},
+		{
+			name:    "not editable",
+			modify:  func(req *pb.Request) { req.Output.GetEdit().Editable = false },
+			wantErr: true,
+			errMsg:  "edit schema must be editable",
+		},
+		{
+			name:    "input out of range",
+			modify:  func(req *pb.Request) { req.Output.GetEdit().Input = 1 },
+			wantErr: true,
+			errMsg:  "edit input index 1 out of range [0, 1)",
+		},
+		{
+			name:    "negative input",
+			modify:  func(req *pb.Request) { req.Output.GetEdit().Input = -1 },
+			wantErr: true,
+			errMsg:  "edit input index must not be negative (got -1)",
+		},
+		{
+			name: "markdown input",
+			modify: func(req *pb.Request) {
+				req.Inputs = append(req.Inputs, &pb.Input{
+					Visualization: &pb.Input_Markdown{Markdown: &pb.Markdown{Content: "notes"}},
+				})
+				req.Output.GetEdit().Input = 1
+			},
+			wantErr: true,
+			errMsg:  "edit input 1 is display-only and cannot be edited",
+		},
+		{
+			name: "one of several outputs",
+			modify: func(req *pb.Request) {
+				req.Outputs = []*pb.OutputSchema{testRequest().Output, req.Output}
+				req.Output = nil
+			},
+			wantErr: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := testEditRequest()
+			tt.modify(req)
+
+			err := Request(req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Request() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr && !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("expected error containing %q, got %v", tt.errMsg, err)
+			}
+		})
+	}
+}
+
+func TestValidateEditAnswer(t *testing.T) {
+	ints := func(n int) *pb.Data {
+		return &pb.Data{Data: &pb.Data_Ints{Ints: &pb.Ints{Values: make([]int64, n)}}}
+	}
+
+	tests := []struct {
+		name    string
+		res     *pb.Response
+		wantErr bool
+		errMsg  string
+	}{
+		{
+			name:    "correctly shaped edit",
+			res:     editResponse(ints(100)),
+			wantErr: false,
+		},
+		{
+			name:    "wrong size",
+			res:     editResponse(ints(99)),
+			wantErr: true,
+			errMsg:  "edited data must be the same size as input 0's (want 100 values, got 99)",
+		},
+		{
+			name:    "wrong type",
+			res:     editResponse(&pb.Data{Data: &pb.Data_Floats{Floats: &pb.Floats{Values: make([]float64, 100)}}}),
+			wantErr: true,
+			errMsg:  "edited data must be the same type as input 0's",
+		},
+		{
+			name:    "non-finite",
+			res:     editResponse(&pb.Data{Data: &pb.Data_Floats{Floats: &pb.Floats{Values: []float64{math.NaN()}}}}),
+			wantErr: true,
+			errMsg:  "edited data: float value at index 0 is NaN",
+		},
+		{
+			name:    "no data",
+			res:     editResponse(nil),
+			wantErr: true,
+			errMsg:  "edited data: data cannot be nil",
+		},
+		{
+			name:    "wrong output type",
+			res:     testResponse(),
+			wantErr: true,
+			errMsg:  "expected edit output",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Answer(testEditRequest(), tt.res)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Answer() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr && !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("expected error containing %q, got %v", tt.errMsg, err)
+			}
+		})
+	}
+
+	// each of several outputs is checked against its own input
+	req := testEditRequest()
+	req.Outputs = []*pb.OutputSchema{testRequest().Output, req.Output}
+	req.Output = nil
+	res := &pb.Response{Outputs: []*pb.Output{testResponse().Output, editResponse(ints(99)).Output}}
+	if err := Answer(req, res); err == nil || !strings.Contains(err.Error(), "output 1: edited data must be the same size") {
+		t.Errorf("expected the second output's edit to be rejected, got %v", err)
+	}
+}
+
+func TestValidateEstimatedSeconds(t *testing.T) {
+	tests := []struct {
+		name    string
+		seconds int32
+		wantErr bool
+		errMsg  string
+	}{
+		{
+			name:    "unset",
+			seconds: 0,
+			wantErr: false,
+		},
+		{
+			name:    "valid",
+			seconds: 30,
+			wantErr: false,
+		},
+		{
+			name:    "negative",
+			seconds: -1,
+			wantErr: true,
+			errMsg:  "estimated_seconds must be between 0 and 86400, got -1",
+		},
+		{
+			name:    "too long",
+			seconds: 86401,
+			wantErr: true,
+			errMsg:  "estimated_seconds must be between 0 and 86400, got 86401",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := testRequest()
+			req.EstimatedSeconds = tt.seconds
+
+			err := Request(req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Request() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr && !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("expected error containing %q, got %v", tt.errMsg, err)
+			}
+			if tt.wantErr && FieldPath(err) != "estimated_seconds" {
+				t.Errorf("expected field path estimated_seconds, got %q", FieldPath(err))
+			}
+		})
+	}
+}
+
+func TestValidateRangeResponse(t *testing.T) {
+	schema := &pb.OutputSchema{
+		Output: &pb.OutputSchema_Range{
+			Range: &pb.RangeSchema{Min: 0, Max: 60, Label: "anomaly window (s)"},
+		},
+	}
+
+	rng := func(start, end float64) *pb.Response {
+		return &pb.Response{Output: &pb.Output{
+			Output: &pb.Output_Range{Range: &pb.RangeOutput{Start: start, End: end}},
+		}}
+	}
+
+	tests := []struct {
+		name    string
+		res     *pb.Response
+		wantErr bool
+		errMsg  string
+	}{
+		{
+			name:    "wrong output type",
+			res:     testResponse(),
+			wantErr: true,
+			errMsg:  "expected range output",
+		},
+		{
+			name:    "inverted range",
+			res:     rng(30, 10),
+			wantErr: true,
+			errMsg:  "range start must be less than end",
+		},
+		{
+			name:    "empty range",
+			res:     rng(10, 10),
+			wantErr: true,
+			errMsg:  "range start must be less than end",
+		},
+		{
+			name:    "start below min",
+			res:     rng(-1, 10),
+			wantErr: true,
+			errMsg:  "outside bounds",
+		},
+		{
+			name:    "end above max",
+			res:     rng(50, 61),
+			wantErr: true,
+			errMsg:  "outside bounds",
+		},
+		{
+			name:    "nan start",
+			res:     rng(math.NaN(), 10),
+			wantErr: true,
+			errMsg:  "range start must be less than end",
+		},
+		{
+			name:    "full range",
+			res:     rng(0, 60),
+			wantErr: false,
+		},
+		{
+			name:    "sub range",
+			res:     rng(12.5, 14),
+			wantErr: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Response(schema, tt.res)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Response() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr && !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("expected error containing %q, got %v", tt.errMsg, err)
+			}
+		})
+	}
+}
+
+func TestValidateClassifyResponse(t *testing.T) {
+	schema := func(ask bool) *pb.OutputSchema {
+		return &pb.OutputSchema{
+			Output: &pb.OutputSchema_Classify{
+				Classify: &pb.ClassifySchema{ClassLabels: []string{"cat", "dog", "other"}, AskConfidence: ask},
+			},
+		}
+	}
+
+	classify := func(index int32, confidence *float64) *pb.Response {
+		return &pb.Response{Output: &pb.Output{
+			Output: &pb.Output_Classify{Classify: &pb.ClassifyOutput{ClassIndex: index, Confidence: confidence}},
+		}}
+	}
+
+	conf := func(c float64) *float64 { return &c }
+
+	tests := []struct {
+		name    string
+		schema  *pb.OutputSchema
+		res     *pb.Response
+		wantErr bool
+		errMsg  string
+	}{
+		{"wrong output type", schema(false), testResponse(), true, "expected classify output"},
+		{"index out of range", schema(false), classify(3, nil), true, "class index 3 out of range [0, 3)"},
+		{"negative index", schema(false), classify(-1, nil), true, "class index -1 out of range"},
+		{"missing confidence when required", schema(true), classify(1, nil), true, "confidence is required"},
+		{"confidence above one", schema(true), classify(1, conf(1.5)), true, "confidence must be in [0, 1]"},
+		{"nan confidence", schema(true), classify(1, conf(math.NaN())), true, "confidence must be in [0, 1]"},
+		{"zero confidence is present", schema(true), classify(1, conf(0)), false, ""},
+		{"confidence not required", schema(false), classify(2, nil), false, ""},
+		{"valid with confidence", schema(true), classify(0, conf(0.8)), false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Response(tt.schema, tt.res)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Response() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr && !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("expected error containing %q, got %v", tt.errMsg, err)
+			}
+		})
+	}
+}
+
+func TestValidateClassifySchema(t *testing.T) {
+	tests := []struct {
+		name    string
+		schema  *pb.ClassifySchema
+		wantErr bool
+		errMsg  string
+	}{
+		{"nil", nil, true, "classify cannot be nil"},
+		{"one class", &pb.ClassifySchema{ClassLabels: []string{"cat"}}, true, "classify must have at least 2 classes (got 1)"},
+		{"empty label", &pb.ClassifySchema{ClassLabels: []string{"cat", ""}}, true, "class 1 label cannot be empty"},
+		{"valid", &pb.ClassifySchema{ClassLabels: []string{"cat", "dog"}, AskConfidence: true}, false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := OutputSchema(&pb.OutputSchema{Output: &pb.OutputSchema_Classify{Classify: tt.schema}})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("OutputSchema() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr && !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("expected error containing %q, got %v", tt.errMsg, err)
+			}
+		})
+	}
+}
+
+func TestValidateRangeSchema(t *testing.T) {
+	tests := []struct {
+		name    string
+		schema  *pb.RangeSchema
+		wantErr bool
+		errMsg  string
+	}{
+		{"nil", nil, true, "range cannot be nil"},
+		{"inverted", &pb.RangeSchema{Min: 10, Max: 0}, true, "range min must be less than max"},
+		{"empty", &pb.RangeSchema{Min: 5, Max: 5}, true, "range min must be less than max"},
+		{"infinite", &pb.RangeSchema{Min: 0, Max: math.Inf(1)}, true, "range bounds must be finite"},
+		{"valid", &pb.RangeSchema{Min: -1, Max: 1}, false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := OutputSchema(&pb.OutputSchema{Output: &pb.OutputSchema_Range{Range: tt.schema}})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("OutputSchema() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr && !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("expected error containing %q, got %v", tt.errMsg, err)
+			}
+		})
+	}
+}
+
+func TestValidateInput(t *testing.T) {
+	validData := &pb.Data{
+		Data: &pb.Data_Ints{
+			Ints: &pb.Ints{Values: make([]int64, 100)}, // 10x10 = 100
+		},
+	}
+
+	tests := []struct {
+		name    string
+		input   *pb.Input
+		wantErr bool
+		errMsg  string
+	}{
+		{
+			name:    "nil input",
+			input:   nil,
+			wantErr: true,
+			errMsg:  "input cannot be nil",
+		},
+		{
+			name: "nil visualization",
+			input: &pb.Input{
+				Visualization: nil,
+				Data:          validData,
+			},
+			wantErr: true,
+			errMsg:  "visualization is required",
+		},
+		{
+			name: "unknown layout hint",
+			input: &pb.Input{
+				Visualization: &pb.Input_Grid{
+					Grid: &pb.Grid{Rows: 10, Cols: 10},
+				},
+				Data:       validData,
+				LayoutHint: pb.LayoutHint(42),
+			},
+			wantErr: true,
+			errMsg:  "unknown layout hint 42",
+		},
+		{
+			name: "known layout hint",
+			input: &pb.Input{
+				Visualization: &pb.Input_Grid{
+					Grid: &pb.Grid{Rows: 10, Cols: 10},
+				},
+				Data:       validData,
+				LayoutHint: pb.LayoutHint_SIDEBAR,
+			},
+			wantErr: false,
+		},
+		{
+			name: "valid input",
+			input: &pb.Input{
+				Visualization: &pb.Input_Grid{
+					Grid: &pb.Grid{Rows: 10, Cols: 10},
+				},
+				Data: validData,
+			},
+			wantErr: false,
+		},
+		{
+			name: "png preview",
+			input: &pb.Input{
+				Visualization: &pb.Input_Grid{
+					Grid: &pb.Grid{Rows: 10, Cols: 10},
+				},
+				Data:    validData,
+				Preview: []byte("\x89PNG\r\n\x1a\nrest"),
+			},
+			wantErr: false,
+		},
+		{
+			name: "jpeg preview",
+			input: &pb.Input{
+				Visualization: &pb.Input_Grid{
+					Grid: &pb.Grid{Rows: 10, Cols: 10},
+				},
+				Data:    validData,
+				Preview: []byte("\xff\xd8\xff\xe0rest"),
+			},
+			wantErr: false,
+		},
+		{
+			name: "preview not an image",
+			input: &pb.Input{
+				Visualization: &pb.Input_Grid{
+					Grid: &pb.Grid{Rows: 10, Cols: 10},
+				},
+				Data:    validData,
+				Preview: []byte("<svg></svg>"),
+			},
+			wantErr: true,
+			errMsg:  "preview must be a png, jpeg, or gif image",
+		},
+		{
+			name: "preview too large",
+			input: &pb.Input{
+				Visualization: &pb.Input_Grid{
+					Grid: &pb.Grid{Rows: 10, Cols: 10},
+				},
+				Data:    validData,
+				Preview: append([]byte("GIF89a"), make([]byte, maxPreviewBytes)...),
+			},
+			wantErr: true,
+			errMsg:  "preview too large (max 65536 bytes",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Input(tt.input, Strict)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Input() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr && !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("expected error containing %q, got %v", tt.errMsg, err)
+			}
+		})
+	}
+}
+
+func TestValidateGrid(t *testing.T) {
+	tests := []struct {
+		name    string
+		grid    *pb.Grid
+		data    *pb.Data
+		wantErr bool
+		errMsg  string
+	}{
+		{
+			name:    "nil grid",
+			grid:    nil,
+			data:    &pb.Data{},
+			wantErr: true,
+			errMsg:  "grid cannot be nil",
+		},
+		{
+			name:    "zero rows",
+			grid:    &pb.Grid{Rows: 0, Cols: 5},
+			data:    &pb.Data{},
+			wantErr: true,
+			errMsg:  "grid dimensions must be positive",
+		},
+		{
+			name:    "zero cols",
+			grid:    &pb.Grid{Rows: 5, Cols: 0},
+			data:    &pb.Data{},
+			wantErr: true,
+			errMsg:  "grid dimensions must be positive",
+		},
+		{
+			name:    "negative rows",
+			grid:    &pb.Grid{Rows: -1, Cols: 5},
+			data:    &pb.Data{},
+			wantErr: true,
+			errMsg:  "grid dimensions must be positive",
+		},
+		{
+			name:    "too large grid",
+			grid:    &pb.Grid{Rows: 101, Cols: 50},
+			data:    &pb.Data{},
+			wantErr: true,
+			errMsg:  "grid too large",
+		},
+		{
+			name:    "unknown ordering",
+			grid:    &pb.Grid{Rows: 2, Cols: 2, Ordering: pb.Ordering(7)},
+			data:    &pb.Data{},
+			wantErr: true,
+			errMsg:  "unknown ordering 7",
+		},
+		{
+			name: "col-major grid",
+			grid: &pb.Grid{Rows: 2, Cols: 3, Ordering: pb.Ordering_COL_MAJOR},
+			data: &pb.Data{
+				Data: &pb.Data_Ints{Ints: &pb.Ints{Values: []int64{1, 2, 3, 4, 5, 6}}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "masked grid",
+			grid: &pb.Grid{Rows: 2, Cols: 2, Mask: []bool{true, false, true, true}},
+			data: &pb.Data{
+				Data: &pb.Data_Floats{Floats: &pb.Floats{Values: []float64{1.5, 0, 0, 2}}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "mask size mismatch",
+			grid: &pb.Grid{Rows: 2, Cols: 2, Mask: []bool{true, false, true}},
+			data: &pb.Data{
+				Data: &pb.Data_Floats{Floats: &pb.Floats{Values: []float64{1.5, 0, 0, 2}}},
+			},
+			wantErr: true,
+			errMsg:  "mask size 3 doesn't match grid size 4",
+		},
+		{
+			name:    "nil data",
+			grid:    &pb.Grid{Rows: 2, Cols: 2},
+			data:    nil,
+			wantErr: true,
+			errMsg:  "data is required",
+		},
+		{
+			name:    "nil data type",
+			grid:    &pb.Grid{Rows: 2, Cols: 2},
+			data:    &pb.Data{Data: nil},
+			wantErr: true,
+			errMsg:  "data type is required",
+		},
+		{
+			name: "nil ints data",
+			grid: &pb.Grid{Rows: 2, Cols: 2},
+			data: &pb.Data{
+				Data: &pb.Data_Ints{Ints: nil},
+			},
+			wantErr: true,
+			errMsg:  "ints data cannot be nil",
+		},
+		{
+			name: "wrong ints size",
+			grid: &pb.Grid{Rows: 2, Cols: 2},
+			data: &pb.Data{
+				Data: &pb.Data_Ints{
+					Ints: &pb.Ints{Values: []int64{1, 2, 3}}, // should be 4
+				},
+			},
+			wantErr: true,
+			errMsg:  "data size 3 doesn't match grid size 4",
+		},
+		{
+			name: "wrong bytes size",
+			grid: &pb.Grid{Rows: 2, Cols: 2},
+			data: &pb.Data{
+				Data: &pb.Data_Bytes{
+					Bytes: &pb.Bytes{Values: []byte{1, 2, 3}},
+				},
+			},
+			wantErr: true,
+			errMsg:  "data size 3 doesn't match grid size 4",
+		},
+		{
+			name: "nil floats data",
+			grid: &pb.Grid{Rows: 2, Cols: 2},
+			data: &pb.Data{
+				Data: &pb.Data_Floats{Floats: nil},
+			},
+			wantErr: true,
+			errMsg:  "floats data cannot be nil",
+		},
+		{
+			name: "wrong floats size",
+			grid: &pb.Grid{Rows: 2, Cols: 2},
+			data: &pb.Data{
+				Data: &pb.Data_Floats{
+					Floats: &pb.Floats{Values: []float64{1.0, 2.0, 3.0}}, // should be 4
+				},
+			},
+			wantErr: true,
+			errMsg:  "data size 3 doesn't match grid size 4",
+		},
+		{
+			name: "valid ints",
+			grid: &pb.Grid{Rows: 2, Cols: 2},
+			data: &pb.Data{
+				Data: &pb.Data_Ints{
+					Ints: &pb.Ints{Values: []int64{1, 2, 3, 4}},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "valid floats",
+			grid: &pb.Grid{Rows: 2, Cols: 2},
+			data: &pb.Data{
+				Data: &pb.Data_Floats{
+					Floats: &pb.Floats{Values: []float64{1.0, 2.0, 3.0, 4.0}},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "1x1 grid valid",
+			grid: &pb.Grid{Rows: 1, Cols: 1},
+			data: &pb.Data{
+				Data: &pb.Data_Ints{
+					Ints: &pb.Ints{Values: []int64{42}},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "max size grid valid",
+			grid: &pb.Grid{Rows: 100, Cols: 100},
+			data: &pb.Data{
+				Data: &pb.Data_Ints{
+					Ints: &pb.Ints{Values: make([]int64, 10000)},
+				},
+			},
+			wantErr: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Grid(tt.grid, tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Grid() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr && !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("expected error containing %q, got %v", tt.errMsg, err)
+			}
+		})
+	}
+}
+
+func TestValidateGridPair(t *testing.T) {
+	floats := func(n int) *pb.Data {
+		return &pb.Data{Data: &pb.Data_Floats{Floats: &pb.Floats{Values: make([]float64, n)}}}
+	}
+
+	tests := []struct {
+		name    string
+		pair    *pb.GridPair
+		data    *pb.Data
+		wantErr bool
+		errMsg  string
+	}{
+		{
+			name:    "nil grid pair",
+			pair:    nil,
+			data:    floats(0),
+			wantErr: true,
+			errMsg:  "grid pair cannot be nil",
+		},
+		{
+			name:    "zero rows",
+			pair:    &pb.GridPair{Rows: 0, Cols: 3},
+			data:    floats(0),
+			wantErr: true,
+			errMsg:  "grid dimensions must be positive",
+		},
+		{
+			name:    "too large",
+			pair:    &pb.GridPair{Rows: 101, Cols: 3},
+			data:    floats(606),
+			wantErr: true,
+			errMsg:  "grid too large",
+		},
+		{
+			name:    "one grid of data",
+			pair:    &pb.GridPair{Rows: 2, Cols: 3},
+			data:    floats(6),
+			wantErr: true,
+			errMsg:  "data size 6 doesn't match grid pair size 12 (2x2x3)",
+		},
+		{
+			name:    "too much data",
+			pair:    &pb.GridPair{Rows: 2, Cols: 3},
+			data:    floats(13),
+			wantErr: true,
+			errMsg:  "data size 13 doesn't match grid pair size 12 (2x2x3)",
+		},
+		{
+			name:    "label too long",
+			pair:    &pb.GridPair{Rows: 2, Cols: 3, LeftLabel: strings.Repeat("l", 41)},
+			data:    floats(12),
+			wantErr: true,
+			errMsg:  "grid pair label too long (max 40 characters, got 41)",
+		},
+		{
+			name:    "same labels",
+			pair:    &pb.GridPair{Rows: 2, Cols: 3, LeftLabel: "before", RightLabel: "before"},
+			data:    floats(12),
+			wantErr: true,
+			errMsg:  "grid pair labels must differ",
+		},
+		{
+			name:    "valid floats",
+			pair:    &pb.GridPair{Rows: 2, Cols: 3, LeftLabel: "before", RightLabel: "after"},
+			data:    floats(12),
+			wantErr: false,
+		},
+		{
+			name: "valid ints without labels",
+			pair: &pb.GridPair{Rows: 2, Cols: 2},
+			data: &pb.Data{
+				Data: &pb.Data_Ints{Ints: &pb.Ints{Values: make([]int64, 8)}},
+			},
+			wantErr: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := GridPair(tt.pair, tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GridPair() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr && !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("expected error containing %q, got %v", tt.errMsg, err)
+			}
+		})
+	}
+}
+
+func TestValidateData(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    *pb.Data
+		wantErr bool
+		errMsg  string
+	}{
+		{
+			name:    "nil data",
+			data:    nil,
+			wantErr: true,
+			errMsg:  "data cannot be nil",
+		},
+		{
+			name:    "nil data type",
+			data:    &pb.Data{Data: nil},
+			wantErr: true,
+			errMsg:  "data type is required",
+		},
+		{
+			name: "valid ints",
+			data: &pb.Data{
+				Data: &pb.Data_Ints{
+					Ints: &pb.Ints{Values: []int64{1, 2, 3}},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "valid floats",
+			data: &pb.Data{
+				Data: &pb.Data_Floats{
+					Floats: &pb.Floats{Values: []float64{1.0, 2.0, 3.0}},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "nil floats data",
+			data: &pb.Data{
+				Data: &pb.Data_Floats{Floats: nil},
+			},
+			wantErr: true,
+			errMsg:  "floats data cannot be nil",
+		},
+		{
+			name: "valid bytes",
+			data: &pb.Data{
+				Data: &pb.Data_Bytes{
+					Bytes: &pb.Bytes{Values: []byte{0, 128, 255}},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "nan float",
+			data: &pb.Data{
+				Data: &pb.Data_Floats{
+					Floats: &pb.Floats{Values: []float64{1.0, math.NaN(), 3.0}},
+				},
+			},
+			wantErr: true,
+			errMsg:  "float value at index 1 is NaN",
+		},
+		{
+			name: "positive inf float",
+			data: &pb.Data{
+				Data: &pb.Data_Floats{
+					Floats: &pb.Floats{Values: []float64{1.0, math.Inf(1), 3.0}},
+				},
+			},
+			wantErr: true,
+			errMsg:  "float value at index 1 is infinite",
+		},
+		{
+			name: "negative inf float",
+			data: &pb.Data{
+				Data: &pb.Data_Floats{
+					Floats: &pb.Floats{Values: []float64{1.0, math.Inf(-1), 3.0}},
+				},
+			},
+			wantErr: true,
+			errMsg:  "float value at index 1 is infinite",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Data(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Data() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr && !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("expected error containing %q, got %v", tt.errMsg, err)
+			}
+		})
+	}
+}
+
+// manyOptions returns n labelled options. If hotkeys is true, each gets a
+// distinct one, which needn't be a single character.
+func manyOptions(n int, hotkeys bool) []*pb.Option {
+	opts := make([]*pb.Option, n)
+	for i := range opts {
+		opts[i] = &pb.Option{Label: fmt.Sprintf("Option %d", i)}
+		if hotkeys {
+			opts[i].Hotkey = fmt.Sprintf("%d", i)
+		}
+	}
+	return opts
+}
+
+func TestValidateOutputSchema(t *testing.T) {
+	tests := []struct {
+		name    string
+		schema  *pb.OutputSchema
+		wantErr bool
+		errMsg  string
+	}{
+		{
+			name:    "nil schema",
+			schema:  nil,
+			wantErr: true,
+			errMsg:  "output schema is required",
+		},
+		{
+			name:    "nil output type",
+			schema:  &pb.OutputSchema{Output: nil},
+			wantErr: true,
+			errMsg:  "output type is required",
+		},
+		{
+			name: "nil option list",
+			schema: &pb.OutputSchema{
+				Output: &pb.OutputSchema_OptionList{OptionList: nil},
+			},
+			wantErr: true,
+			errMsg:  "option list cannot be nil",
+		},
+		{
+			name: "empty option list",
+			schema: &pb.OutputSchema{
+				Output: &pb.OutputSchema_OptionList{
+					OptionList: &pb.OptionListSchema{Options: []*pb.Option{}},
+				},
+			},
+			wantErr: true,
+			errMsg:  "option list must have at least 2 options",
+		},
+		{
+			name: "one option only",
+			schema: &pb.OutputSchema{
+				Output: &pb.OutputSchema_OptionList{
+					OptionList: &pb.OptionListSchema{
+						Options: []*pb.Option{
+							{Label: "Option 1", Hotkey: "1"},
+						},
+					},
+				},
+			},
+			wantErr: true,
+			errMsg:  "option list must have at least 2 options",
+		},
+		{
+			name: "nil option",
+			schema: &pb.OutputSchema{
+				Output: &pb.OutputSchema_OptionList{
+					OptionList: &pb.OptionListSchema{
+						Options: []*pb.Option{
+							{Label: "Option 1", Hotkey: "1"},
+							nil,
+						},
+					},
+				},
+			},
+			wantErr: true,
+			errMsg:  "option 1 cannot be nil",
+		},
+		{
+			name: "empty label",
+			schema: &pb.OutputSchema{
+				Output: &pb.OutputSchema_OptionList{
+					OptionList: &pb.OptionListSchema{
+						Options: []*pb.Option{
+							{Label: "Option 1", Hotkey: "1"},
+							{Label: "", Hotkey: "2"},
+						},
+					},
+				},
+			},
+			wantErr: true,
+			errMsg:  "option 1 label cannot be empty",
+		},
+		{
+			name: "empty hotkey",
+			schema: &pb.OutputSchema{
+				Output: &pb.OutputSchema_OptionList{
+					OptionList: &pb.OptionListSchema{
+						Options: []*pb.Option{
+							{Label: "Option 1", Hotkey: "1"},
+							{Label: "Option 2", Hotkey: ""},
+						},
+					},
+				},
+			},
+			wantErr: true,
+			errMsg:  "option 1 hotkey must be single character",
+		},
+		{
+			name: "multi-char hotkey",
+			schema: &pb.OutputSchema{
+				Output: &pb.OutputSchema_OptionList{
+					OptionList: &pb.OptionListSchema{
+						Options: []*pb.Option{
+							{Label: "Option 1", Hotkey: "1"},
+							{Label: "Option 2", Hotkey: "ab"},
+						},
+					},
+				},
+			},
+			wantErr: true,
+			errMsg:  "option 1 hotkey must be single character",
+		},
+		{
+			name: "duplicate hotkey",
+			schema: &pb.OutputSchema{
+				Output: &pb.OutputSchema_OptionList{
+					OptionList: &pb.OptionListSchema{
+						Options: []*pb.Option{
+							{Label: "Option 1", Hotkey: "1"},
+							{Label: "Option 2", Hotkey: "1"},
+						},
+					},
+				},
+			},
+			wantErr: true,
+			errMsg:  "duplicate hotkey \"1\" found at option 1",
+		},
+		{
+			name: "duplicate hotkey across groups",
+			schema: &pb.OutputSchema{
+				Output: &pb.OutputSchema_OptionList{
+					OptionList: &pb.OptionListSchema{
+						Options: []*pb.Option{
+							{Label: "Left", Hotkey: "a", Group: "Movement"},
+							{Label: "Stop", Hotkey: "a", Group: "Safety"},
+						},
+					},
+				},
+			},
+			wantErr: true,
+			errMsg:  "duplicate hotkey \"a\" found at option 1",
+		},
+		{
+			name: "group too long",
+			schema: &pb.OutputSchema{
+				Output: &pb.OutputSchema_OptionList{
+					OptionList: &pb.OptionListSchema{
+						Options: []*pb.Option{
+							{Label: "Left", Hotkey: "a", Group: strings.Repeat("g", 41)},
+							{Label: "Stop", Hotkey: "s"},
+						},
+					},
+				},
+			},
+			wantErr: true,
+			errMsg:  "option 0 group too long (max 40 characters, got 41)",
+		},
+		{
+			name: "description too long",
+			schema: &pb.OutputSchema{
+				Output: &pb.OutputSchema_OptionList{
+					OptionList: &pb.OptionListSchema{
+						Options: []*pb.Option{
+							{Label: "Left", Hotkey: "a"},
+							{Label: "Stop", Hotkey: "s", Description: strings.Repeat("d", 501)},
+						},
+					},
+				},
+			},
+			wantErr: true,
+			errMsg:  "option 1 description too long (max 500 characters, got 501)",
+		},
+		{
+			name: "auto hotkeys",
+			schema: &pb.OutputSchema{
+				Output: &pb.OutputSchema_OptionList{
+					OptionList: &pb.OptionListSchema{
+						AutoHotkeys: true,
+						Options:     []*pb.Option{{Label: "Left"}, {Label: "Right"}},
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "auto hotkeys with some set",
+			schema: &pb.OutputSchema{
+				Output: &pb.OutputSchema_OptionList{
+					OptionList: &pb.OptionListSchema{
+						AutoHotkeys: true,
+						Options:     []*pb.Option{{Label: "Left", Hotkey: "a"}, {Label: "Right"}},
+					},
+				},
+			},
+			wantErr: true,
+			errMsg:  "option 1 hotkey must be single character",
+		},
+		{
+			name: "empty hotkeys without auto",
+			schema: &pb.OutputSchema{
+				Output: &pb.OutputSchema_OptionList{
+					OptionList: &pb.OptionListSchema{
+						Options: []*pb.Option{{Label: "Left"}, {Label: "Right"}},
+					},
+				},
+			},
+			wantErr: true,
+			errMsg:  "option 0 hotkey must be single character",
+		},
+		{
+			name: "many options with auto hotkeys",
+			schema: &pb.OutputSchema{
+				Output: &pb.OutputSchema_OptionList{
+					OptionList: &pb.OptionListSchema{
+						AutoHotkeys: true,
+						Options:     manyOptions(40, false),
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "many options with required hotkeys",
+			schema: &pb.OutputSchema{
+				Output: &pb.OutputSchema_OptionList{
+					OptionList: &pb.OptionListSchema{
+						Options: manyOptions(40, true),
+					},
+				},
+			},
+			wantErr: true,
+			errMsg:  "too many options for each to have a unique hotkey (max 36, got 40)",
+		},
+		{
+			name: "too many options",
+			schema: &pb.OutputSchema{
+				Output: &pb.OutputSchema_OptionList{
+					OptionList: &pb.OptionListSchema{
+						AutoHotkeys: true,
+						Options:     manyOptions(101, false),
+					},
+				},
+			},
+			wantErr: true,
+			errMsg:  "option list has too many options (max 100, got 101)",
+		},
+		{
+			name: "auto hotkeys still checks groups",
+			schema: &pb.OutputSchema{
+				Output: &pb.OutputSchema_OptionList{
+					OptionList: &pb.OptionListSchema{
+						AutoHotkeys: true,
+						Options:     []*pb.Option{{Label: "Left"}, {Label: "Right", Group: strings.Repeat("g", 41)}},
+					},
+				},
+			},
+			wantErr: true,
+			errMsg:  "option 1 group too long (max 40 characters, got 41)",
+		},
+		{
+			name: "valid description",
+			schema: &pb.OutputSchema{
+				Output: &pb.OutputSchema_OptionList{
+					OptionList: &pb.OptionListSchema{
+						Options: []*pb.Option{
+							{Label: "Left", Hotkey: "a"},
+							{Label: "Emergency Stop", Hotkey: "s", Description: "halts all motors immediately"},
+						},
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "valid grouped options",
+			schema: &pb.OutputSchema{
+				Output: &pb.OutputSchema_OptionList{
+					OptionList: &pb.OptionListSchema{
+						Options: []*pb.Option{
+							{Label: "Left", Hotkey: "a", Group: "Movement"},
+							{Label: "Right", Hotkey: "d", Group: "Movement"},
+							{Label: "Stop", Hotkey: "s", Group: "Safety"},
+							{Label: "Unsure", Hotkey: "u"},
+						},
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "valid option list",
+			schema: &pb.OutputSchema{
+				Output: &pb.OutputSchema_OptionList{
+					OptionList: &pb.OptionListSchema{
+						Options: []*pb.Option{
+							{Label: "Option 1", Hotkey: "1"},
+							{Label: "Option 2", Hotkey: "2"},
+						},
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "valid many options",
+			schema: &pb.OutputSchema{
+				Output: &pb.OutputSchema_OptionList{
+					OptionList: &pb.OptionListSchema{
+						Options: []*pb.Option{
+							{Label: "First", Hotkey: "a"},
+							{Label: "Second", Hotkey: "b"},
+							{Label: "Third", Hotkey: "c"},
+							{Label: "Fourth", Hotkey: "d"},
+						},
+					},
+				},
+			},
+			wantErr: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := OutputSchema(tt.schema)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("OutputSchema() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr && !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("expected error containing %q, got %v", tt.errMsg, err)
+			}
+		})
+	}
+}
+
+func TestValidateFieldPath(t *testing.T) {
+	tooLongTitle := testRequest()
+	tooLongTitle.Title = strings.Repeat("a", 201)
+
+	badOutput := testRequest()
+	badOutput.Output = nil
+
+	tests := []struct {
+		name string
+		req  *pb.Request
+		want string
+	}{
+		{"nil request", nil, "request"},
+		{"no inputs", &pb.Request{}, "inputs"},
+		{"title", tooLongTitle, "title"},
+		{"input without visualization", &pb.Request{Inputs: []*pb.Input{{}}}, "input 0"},
+		{"output", badOutput, "output"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FieldPath(RequestWithMode(tt.req, Strict)); got != tt.want {
+				t.Errorf("FieldPath() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateMultiChannelGrid(t *testing.T) {
+	tests := []struct {
+		name    string
+		grid    *pb.MultiChannelGrid
+		data    *pb.Data
+		wantErr bool
+		errMsg  string
+	}{
+		{
+			name:    "nil grid",
+			grid:    nil,
+			data:    &pb.Data{},
+			wantErr: true,
+			errMsg:  "multi-channel grid cannot be nil",
+		},
+		{
+			name:    "zero rows",
+			grid:    &pb.MultiChannelGrid{Rows: 0, Cols: 5, Channels: 3},
+			data:    &pb.Data{},
+			wantErr: true,
+			errMsg:  "grid dimensions must be positive",
+		},
+		{
+			name:    "zero channels",
+			grid:    &pb.MultiChannelGrid{Rows: 2, Cols: 2, Channels: 0},
+			data:    &pb.Data{},
+			wantErr: true,
+			errMsg:  "channel count must be positive",
+		},
+		{
+			name:    "too many channels",
+			grid:    &pb.MultiChannelGrid{Rows: 2, Cols: 2, Channels: 11},
+			data:    &pb.Data{},
+			wantErr: true,
+			errMsg:  "too many channels (max 10, got 11)",
+		},
+		{
+			name: "wrong channel names length",
+			grid: &pb.MultiChannelGrid{
+				Rows:         2,
+				Cols:         2,
+				Channels:     3,
+				ChannelNames: []string{"R", "G"}, // should be 3
+			},
+			data:    &pb.Data{},
+			wantErr: true,
+			errMsg:  "channel names count 2 doesn't match channel count 3",
+		},
+		{
+			name: "duplicate channel names",
+			grid: &pb.MultiChannelGrid{
+				Rows:         2,
+				Cols:         2,
+				Channels:     3,
+				ChannelNames: []string{"R", "R", "B"},
+			},
+			data:    &pb.Data{},
+			wantErr: true,
+			errMsg:  "duplicate channel name \"R\"",
+		},
+		{
+			name: "distinct channel names",
+			grid: &pb.MultiChannelGrid{
+				Rows:         2,
+				Cols:         2,
+				Channels:     3,
+				ChannelNames: []string{"R", "G", "B"},
+			},
+			data: &pb.Data{
+				Data: &pb.Data_Floats{
+					Floats: &pb.Floats{Values: make([]float64, 12)},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "wrong data size",
+			grid: &pb.MultiChannelGrid{Rows: 2, Cols: 2, Channels: 3},
+			data: &pb.Data{
+				Data: &pb.Data_Floats{
+					Floats: &pb.Floats{Values: make([]float64, 10)}, // should be 2*2*3=12
+				},
+			},
+			wantErr: true,
+			errMsg:  "data size 10 doesn't match expected size 12 (rows*cols*channels=2*2*3)",
+		},
+		{
+			name: "valid multi channel grid",
+			grid: &pb.MultiChannelGrid{Rows: 2, Cols: 2, Channels: 3},
+			data: &pb.Data{
+				Data: &pb.Data_Floats{
+					Floats: &pb.Floats{Values: make([]float64, 12)}, // 2*2*3=12
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "valid rgb image as bytes",
+			grid: &pb.MultiChannelGrid{Rows: 8, Cols: 8, Channels: 3},
+			data: &pb.Data{
+				Data: &pb.Data_Bytes{
+					Bytes: &pb.Bytes{Values: make([]byte, 192)}, // 8*8*3=192
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "rgb image as bytes with wrong size",
+			grid: &pb.MultiChannelGrid{Rows: 8, Cols: 8, Channels: 3},
+			data: &pb.Data{
+				Data: &pb.Data_Bytes{
+					Bytes: &pb.Bytes{Values: make([]byte, 64)},
+				},
+			},
+			wantErr: true,
+			errMsg:  "data size 64 doesn't match expected size 192 (rows*cols*channels=8*8*3)",
+		},
+		{
+			name:    "nil bytes data",
+			grid:    &pb.MultiChannelGrid{Rows: 8, Cols: 8, Channels: 3},
+			data:    &pb.Data{Data: &pb.Data_Bytes{}},
+			wantErr: true,
+			errMsg:  "bytes data cannot be nil",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := MultiChannelGrid(tt.grid, tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("MultiChannelGrid() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr && !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("expected error containing %q, got %v", tt.errMsg, err)
+			}
+		})
+	}
+}
+
+func TestValidateScalar(t *testing.T) {
+	tests := []struct {
+		name    string
+		scalar  *pb.Scalar
+		data    *pb.Data
+		wantErr bool
+		errMsg  string
+	}{
+		{
+			name:    "nil scalar",
+			scalar:  nil,
+			data:    &pb.Data{},
+			wantErr: true,
+			errMsg:  "scalar cannot be nil",
+		},
+		{
+			name:    "empty label",
+			scalar:  &pb.Scalar{Label: "", Min: 0.0, Max: 1.0},
+			data:    &pb.Data{},
+			wantErr: true,
+			errMsg:  "scalar label is required",
+		},
+		{
+			name:    "min >= max",
+			scalar:  &pb.Scalar{Label: "test", Min: 1.0, Max: 1.0},
+			data:    &pb.Data{},
+			wantErr: true,
+			errMsg:  "scalar min 1.000000 must be less than max 1.000000",
+		},
+		{
+			name:   "wrong data size",
+			scalar: &pb.Scalar{Label: "test", Min: 0.0, Max: 1.0},
+			data: &pb.Data{
+				Data: &pb.Data_Floats{
+					Floats: &pb.Floats{Values: []float64{0.5, 0.6}}, // should be 1
+				},
+			},
+			wantErr: true,
+			errMsg:  "scalar requires exactly 1 float value (got 2)",
+		},
+		{
+			name:   "value out of range low",
+			scalar: &pb.Scalar{Label: "test", Min: 0.0, Max: 1.0},
+			data: &pb.Data{
+				Data: &pb.Data_Floats{
+					Floats: &pb.Floats{Values: []float64{-0.1}},
+				},
+			},
+			wantErr: true,
+			errMsg:  "scalar value -0.100000 is outside range [0.000000, 1.000000]",
+		},
+		{
+			name:   "value out of range high",
+			scalar: &pb.Scalar{Label: "test", Min: 0.0, Max: 1.0},
+			data: &pb.Data{
+				Data: &pb.Data_Floats{
+					Floats: &pb.Floats{Values: []float64{1.1}},
+				},
+			},
+			wantErr: true,
+			errMsg:  "scalar value 1.100000 is outside range [0.000000, 1.000000]",
+		},
+		{
+			name:   "NaN value",
+			scalar: &pb.Scalar{Label: "test", Min: 0.0, Max: 1.0},
+			data: &pb.Data{
+				Data: &pb.Data_Floats{
+					Floats: &pb.Floats{Values: []float64{math.NaN()}},
+				},
+			},
+			wantErr: true,
+			errMsg:  "scalar value must be finite (got NaN)",
+		},
+		{
+			name:   "infinite value",
+			scalar: &pb.Scalar{Label: "test", Min: 0.0, Max: 1.0},
+			data: &pb.Data{
+				Data: &pb.Data_Floats{
+					Floats: &pb.Floats{Values: []float64{math.Inf(1)}},
+				},
+			},
+			wantErr: true,
+			errMsg:  "scalar value must be finite (got +Inf)",
+		},
+		{
+			name:   "unit too long",
+			scalar: &pb.Scalar{Label: "test", Min: 0.0, Max: 1.0, Unit: "kilometers"},
+			data: &pb.Data{
+				Data: &pb.Data_Floats{
+					Floats: &pb.Floats{Values: []float64{0.5}},
+				},
+			},
+			wantErr: true,
+			errMsg:  "scalar unit too long (max 8 characters, got 10)",
+		},
+		{
+			name:   "unit with newline",
+			scalar: &pb.Scalar{Label: "test", Min: 0.0, Max: 1.0, Unit: "m\ns"},
+			data: &pb.Data{
+				Data: &pb.Data_Floats{
+					Floats: &pb.Floats{Values: []float64{0.5}},
+				},
+			},
+			wantErr: true,
+			errMsg:  "scalar unit contains control character",
+		},
+		{
+			name:   "multibyte unit within limit",
+			scalar: &pb.Scalar{Label: "test", Min: 0.0, Max: 1.0, Unit: "°C/µs"},
+			data: &pb.Data{
+				Data: &pb.Data_Floats{
+					Floats: &pb.Floats{Values: []float64{0.5}},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name:   "valid scalar",
+			scalar: &pb.Scalar{Label: "test", Min: 0.0, Max: 1.0, Unit: "ratio"},
+			data: &pb.Data{
+				Data: &pb.Data_Floats{
+					Floats: &pb.Floats{Values: []float64{0.5}},
+				},
+			},
+			wantErr: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Scalar(tt.scalar, tt.data, Strict)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Scalar() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr && !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("expected error containing %q, got %v", tt.errMsg, err)
+			}
+		})
+	}
+}
+
+func TestValidateVector2D(t *testing.T) {
+	tests := []struct {
+		name    string
+		vector  *pb.Vector2D
+		data    *pb.Data
+		wantErr bool
+		errMsg  string
+	}{
+		{
+			name:    "nil vector",
+			vector:  nil,
+			data:    &pb.Data{},
+			wantErr: true,
+			errMsg:  "vector cannot be nil",
+		},
+		{
+			name:    "empty label",
+			vector:  &pb.Vector2D{Label: "", MaxMagnitude: 1.0},
+			data:    &pb.Data{},
+			wantErr: true,
+			errMsg:  "vector label is required",
+		},
+		{
+			name:    "zero max magnitude",
+			vector:  &pb.Vector2D{Label: "test", MaxMagnitude: 0.0},
+			data:    &pb.Data{},
+			wantErr: true,
+			errMsg:  "vector max_magnitude must be positive (got 0.000000)",
+		},
+		{
+			name:   "wrong data size",
+			vector: &pb.Vector2D{Label: "test", MaxMagnitude: 1.0},
+			data: &pb.Data{
+				Data: &pb.Data_Floats{
+					Floats: &pb.Floats{Values: []float64{0.5}}, // should be 2
+				},
+			},
+			wantErr: true,
+			errMsg:  "vector requires exactly 2 float values (got 1)",
+		},
+		{
+			name:   "NaN x component",
+			vector: &pb.Vector2D{Label: "test", MaxMagnitude: 1.0},
+			data: &pb.Data{
+				Data: &pb.Data_Floats{
+					Floats: &pb.Floats{Values: []float64{math.NaN(), 0.5}},
+				},
+			},
+			wantErr: true,
+			errMsg:  "vector components must be finite (got x=NaN, y=0.500000)",
+		},
+		{
+			name:   "NaN y component",
+			vector: &pb.Vector2D{Label: "test", MaxMagnitude: 1.0},
+			data: &pb.Data{
+				Data: &pb.Data_Floats{
+					Floats: &pb.Floats{Values: []float64{0.5, math.NaN()}},
+				},
+			},
+			wantErr: true,
+			errMsg:  "vector components must be finite",
+		},
+		{
+			name:   "infinite component",
+			vector: &pb.Vector2D{Label: "test", MaxMagnitude: 1.0},
+			data: &pb.Data{
+				Data: &pb.Data_Floats{
+					Floats: &pb.Floats{Values: []float64{math.Inf(-1), 0.0}},
+				},
+			},
+			wantErr: true,
+			errMsg:  "vector components must be finite (got x=-Inf",
+		},
+		{
+			name:   "valid vector",
+			vector: &pb.Vector2D{Label: "velocity", MaxMagnitude: 10.0},
+			data: &pb.Data{
+				Data: &pb.Data_Floats{
+					Floats: &pb.Floats{Values: []float64{3.0, 4.0}}, // magnitude = 5.0 < 10.0
+				},
+			},
+			wantErr: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Vector2D(tt.vector, tt.data, Strict)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Vector2D() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr && !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("expected error containing %q, got %v", tt.errMsg, err)
+			}
+		})
+	}
+}
+
+func TestValidateTimeSeries(t *testing.T) {
+	tests := []struct {
+		name       string
+		timeSeries *pb.TimeSeries
+		data       *pb.Data
+		wantErr    bool
+		errMsg     string
+	}{
+		{
+			name:       "nil time series",
+			timeSeries: nil,
+			data:       &pb.Data{},
+			wantErr:    true,
+			errMsg:     "time series cannot be nil",
+		},
+		{
+			name:       "empty label",
+			timeSeries: &pb.TimeSeries{Label: "", Points: 100, MinValue: 0.0, MaxValue: 1.0},
+			data:       &pb.Data{},
+			wantErr:    true,
+			errMsg:     "time series label is required",
+		},
+		{
+			name:       "zero points",
+			timeSeries: &pb.TimeSeries{Label: "test", Points: 0, MinValue: 0.0, MaxValue: 1.0},
+			data:       &pb.Data{},
+			wantErr:    true,
+			errMsg:     "time series points must be positive (got 0)",
+		},
+		{
+			name:       "too many points",
+			timeSeries: &pb.TimeSeries{Label: "test", Points: 100001, MinValue: 0.0, MaxValue: 1.0},
+			data:       &pb.Data{},
+			wantErr:    true,
+			errMsg:     "time series has too many points (max 100000, got 100001)",
+		},
+		{
+			name:       "min >= max",
+			timeSeries: &pb.TimeSeries{Label: "test", Points: 100, MinValue: 1.0, MaxValue: 1.0},
+			data:       &pb.Data{},
+			wantErr:    true,
+			errMsg:     "time series min_value 1.000000 must be less than max_value 1.000000",
+		},
+		{
+			name:       "wrong data size",
+			timeSeries: &pb.TimeSeries{Label: "test", Points: 100, MinValue: 0.0, MaxValue: 1.0},
+			data: &pb.Data{
+				Data: &pb.Data_Floats{
+					Floats: &pb.Floats{Values: make([]float64, 50)}, // should be 100
+				},
+			},
+			wantErr: true,
+			errMsg:  "data size 50 doesn't match expected points 100",
+		},
+		{
+			name:       "value out of range",
+			timeSeries: &pb.TimeSeries{Label: "test", Points: 3, MinValue: 0.0, MaxValue: 1.0},
+			data: &pb.Data{
+				Data: &pb.Data_Floats{
+					Floats: &pb.Floats{Values: []float64{0.5, 1.5, 0.3}}, // 1.5 is out of range
+				},
+			},
+			wantErr: true,
+			errMsg:  "time series value at index 1 (1.500000) is outside range [0.000000, 1.000000]",
+		},
+		{
+			name:       "valid time series",
+			timeSeries: &pb.TimeSeries{Label: "temperature", Points: 3, MinValue: -10.0, MaxValue: 40.0},
+			data: &pb.Data{
+				Data: &pb.Data_Floats{
+					Floats: &pb.Floats{Values: []float64{20.5, 25.0, 18.3}},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name:       "decreasing series with no constraint",
+			timeSeries: &pb.TimeSeries{Label: "count", Points: 3, MinValue: 0, MaxValue: 10, Monotonic: pb.Monotonic_NONE},
+			data: &pb.Data{
+				Data: &pb.Data_Floats{Floats: &pb.Floats{Values: []float64{1, 3, 2}}},
+			},
+			wantErr: false,
+		},
+		{
+			name:       "decreasing series must be non-decreasing",
+			timeSeries: &pb.TimeSeries{Label: "count", Points: 3, MinValue: 0, MaxValue: 10, Monotonic: pb.Monotonic_NON_DECREASING},
+			data: &pb.Data{
+				Data: &pb.Data_Floats{Floats: &pb.Floats{Values: []float64{1, 3, 2}}},
+			},
+			wantErr: true,
+			errMsg:  "time series must be non-decreasing, but value at index 2 (2.000000) is less than 3.000000",
+		},
+		{
+			name:       "flat series is non-decreasing",
+			timeSeries: &pb.TimeSeries{Label: "count", Points: 3, MinValue: 0, MaxValue: 10, Monotonic: pb.Monotonic_NON_DECREASING},
+			data: &pb.Data{
+				Data: &pb.Data_Floats{Floats: &pb.Floats{Values: []float64{1, 1, 2}}},
+			},
+			wantErr: false,
+		},
+		{
+			name:       "increasing series must be non-increasing",
+			timeSeries: &pb.TimeSeries{Label: "level", Points: 3, MinValue: 0, MaxValue: 10, Monotonic: pb.Monotonic_NON_INCREASING},
+			data: &pb.Data{
+				Data: &pb.Data_Floats{Floats: &pb.Floats{Values: []float64{5, 4, 6}}},
+			},
+			wantErr: true,
+			errMsg:  "time series must be non-increasing, but value at index 2 (6.000000) is greater than 4.000000",
+		},
+		{
+			name:       "unknown monotonic",
+			timeSeries: &pb.TimeSeries{Label: "count", Points: 1, MinValue: 0, MaxValue: 10, Monotonic: 7},
+			data: &pb.Data{
+				Data: &pb.Data_Floats{Floats: &pb.Floats{Values: []float64{1}}},
+			},
+			wantErr: true,
+			errMsg:  "unknown monotonic 7",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := TimeSeries(tt.timeSeries, tt.data, Strict)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("TimeSeries() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr && !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("expected error containing %q, got %v", tt.errMsg, err)
+			}
+		})
+	}
+}
+
+func TestValidateVolumeGrid(t *testing.T) {
+	tests := []struct {
+		name    string
+		volume  *pb.VolumeGrid
+		data    *pb.Data
+		wantErr bool
+		errMsg  string
+	}{
+		{
+			name:    "nil volume",
+			volume:  nil,
+			data:    &pb.Data{},
+			wantErr: true,
+			errMsg:  "volume grid cannot be nil",
+		},
+		{
+			name:    "zero dimension",
+			volume:  &pb.VolumeGrid{X: 4, Y: 0, Z: 4},
+			data:    &pb.Data{},
+			wantErr: true,
+			errMsg:  "volume dimensions must be positive (got 4x0x4)",
+		},
+		{
+			name:    "dimension over cap",
+			volume:  &pb.VolumeGrid{X: 4, Y: 4, Z: 65},
+			data:    &pb.Data{},
+			wantErr: true,
+			errMsg:  "volume too large (max 64x64x64, got 4x4x65)",
+		},
+		{
+			name:   "wrong data size",
+			volume: &pb.VolumeGrid{X: 2, Y: 3, Z: 4},
+			data: &pb.Data{
+				Data: &pb.Data_Ints{
+					Ints: &pb.Ints{Values: make([]int64, 23)}, // should be 2*3*4=24
+				},
+			},
+			wantErr: true,
+			errMsg:  "data size 23 doesn't match expected size 24 (x*y*z=2*3*4)",
+		},
+		{
+			name:    "missing data",
+			volume:  &pb.VolumeGrid{X: 2, Y: 2, Z: 2},
+			data:    nil,
+			wantErr: true,
+			errMsg:  "data is required",
+		},
+		{
+			name:   "valid volume at cap",
+			volume: &pb.VolumeGrid{X: 64, Y: 64, Z: 1},
+			data: &pb.Data{
+				Data: &pb.Data_Floats{
+					Floats: &pb.Floats{Values: make([]float64, 64*64)},
+				},
+			},
+			wantErr: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := VolumeGrid(tt.volume, tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("VolumeGrid() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr && !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("expected error containing %q, got %v", tt.errMsg, err)
+			}
+		})
+	}
+}
+
+func TestValidateSpectrogram(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    *pb.Spectrogram
+		data    *pb.Data
+		wantErr bool
+		errMsg  string
+	}{
+		{
+			name:    "nil spectrogram",
+			spec:    nil,
+			data:    &pb.Data{},
+			wantErr: true,
+			errMsg:  "spectrogram cannot be nil",
+		},
+		{
+			name:    "empty label",
+			spec:    &pb.Spectrogram{TimeBins: 2, FreqBins: 2, Min: 0, Max: 1},
+			data:    &pb.Data{},
+			wantErr: true,
+			errMsg:  "spectrogram label is required",
+		},
+		{
+			name:    "min >= max",
+			spec:    &pb.Spectrogram{Label: "audio", TimeBins: 2, FreqBins: 2, Min: 1, Max: 1},
+			data:    &pb.Data{},
+			wantErr: true,
+			errMsg:  "spectrogram min 1.000000 must be less than max 1.000000",
+		},
+		{
+			name:    "too many freq bins",
+			spec:    &pb.Spectrogram{Label: "audio", TimeBins: 2, FreqBins: 513, Min: 0, Max: 1},
+			data:    &pb.Data{},
+			wantErr: true,
+			errMsg:  "spectrogram too large",
+		},
+		{
+			name: "size mismatch",
+			spec: &pb.Spectrogram{Label: "audio", TimeBins: 3, FreqBins: 4, Min: 0, Max: 1},
+			data: &pb.Data{
+				Data: &pb.Data_Floats{
+					Floats: &pb.Floats{Values: make([]float64, 11)}, // should be 3*4=12
+				},
+			},
+			wantErr: true,
+			errMsg:  "data size 11 doesn't match expected size 12 (time_bins*freq_bins=3*4)",
+		},
+		{
+			name: "value out of range",
+			spec: &pb.Spectrogram{Label: "audio", TimeBins: 2, FreqBins: 2, Min: -80, Max: 0},
+			data: &pb.Data{
+				Data: &pb.Data_Floats{
+					Floats: &pb.Floats{Values: []float64{-10, -20, 3, -40}},
+				},
+			},
+			wantErr: true,
+			errMsg:  "spectrogram value at index 2 (3.000000) is outside range [-80.000000, 0.000000]",
+		},
+		{
+			name: "int data",
+			spec: &pb.Spectrogram{Label: "audio", TimeBins: 1, FreqBins: 1, Min: 0, Max: 1},
+			data: &pb.Data{
+				Data: &pb.Data_Ints{
+					Ints: &pb.Ints{Values: []int64{0}},
+				},
+			},
+			wantErr: true,
+			errMsg:  "spectrogram visualization requires float data",
+		},
+		{
+			name: "valid spectrogram",
+			spec: &pb.Spectrogram{Label: "audio", TimeBins: 2, FreqBins: 2, Min: -80, Max: 0},
+			data: &pb.Data{
+				Data: &pb.Data_Floats{
+					Floats: &pb.Floats{Values: []float64{-10, -20, -30, -80}},
+				},
+			},
+			wantErr: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Spectrogram(tt.spec, tt.data, Strict)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Spectrogram() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr && !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("expected error containing %q, got %v", tt.errMsg, err)
+			}
+		})
+	}
+}
+
+func TestValidateGraph(t *testing.T) {
+	edges := func(v ...int64) *pb.Data {
+		return &pb.Data{Data: &pb.Data_Ints{Ints: &pb.Ints{Values: v}}}
+	}
+
+	tests := []struct {
+		name    string
+		graph   *pb.Graph
+		data    *pb.Data
+		wantErr bool
+		errMsg  string
+	}{
+		{
+			name:    "nil graph",
+			graph:   nil,
+			data:    &pb.Data{},
+			wantErr: true,
+			errMsg:  "graph cannot be nil",
+		},
+		{
+			name:    "no nodes",
+			graph:   &pb.Graph{},
+			data:    edges(),
+			wantErr: true,
+			errMsg:  "graph requires at least one node label",
+		},
+		{
+			name:    "empty node label",
+			graph:   &pb.Graph{NodeLabels: []string{"a", ""}},
+			data:    edges(),
+			wantErr: true,
+			errMsg:  "graph node 1 label cannot be empty",
+		},
+		{
+			name:    "odd length edge data",
+			graph:   &pb.Graph{NodeLabels: []string{"a", "b", "c"}},
+			data:    edges(0, 1, 2),
+			wantErr: true,
+			errMsg:  "graph edge data must have even length (got 3)",
+		},
+		{
+			name:    "out of range node index",
+			graph:   &pb.Graph{NodeLabels: []string{"a", "b", "c"}},
+			data:    edges(0, 1, 1, 3),
+			wantErr: true,
+			errMsg:  "graph edge 1 references node 3 outside range [0, 3)",
+		},
+		{
+			name:    "negative node index",
+			graph:   &pb.Graph{NodeLabels: []string{"a", "b"}},
+			data:    edges(-1, 1),
+			wantErr: true,
+			errMsg:  "graph edge 0 references node -1",
+		},
+		{
+			name:    "float data",
+			graph:   &pb.Graph{NodeLabels: []string{"a", "b"}},
+			data:    &pb.Data{Data: &pb.Data_Floats{Floats: &pb.Floats{Values: []float64{0, 1}}}},
+			wantErr: true,
+			errMsg:  "graph visualization requires int edge data",
+		},
+		{
+			name:    "valid graph",
+			graph:   &pb.Graph{NodeLabels: []string{"a", "b", "c"}},
+			data:    edges(0, 1, 1, 2, 2, 0),
+			wantErr: false,
+		},
+		{
+			name:    "valid graph without edges",
+			graph:   &pb.Graph{NodeLabels: []string{"a"}},
+			data:    edges(),
+			wantErr: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Graph(tt.graph, tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Graph() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr && !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("expected error containing %q, got %v", tt.errMsg, err)
+			}
+		})
+	}
+}
+
+func TestValidateGeoPoints(t *testing.T) {
+	points := func(v ...float64) *pb.Data {
+		return &pb.Data{Data: &pb.Data_Floats{Floats: &pb.Floats{Values: v}}}
+	}
+
+	tests := []struct {
+		name    string
+		geo     *pb.GeoPoints
+		data    *pb.Data
+		wantErr bool
+		errMsg  string
+	}{
+		{
+			name:    "nil geo",
+			geo:     nil,
+			data:    &pb.Data{},
+			wantErr: true,
+			errMsg:  "geo cannot be nil",
+		},
+		{
+			name:    "center out of range",
+			geo:     &pb.GeoPoints{CenterLat: 91},
+			data:    points(0, 0),
+			wantErr: true,
+			errMsg:  "geo center out of range",
+		},
+		{
+			name:    "negative zoom",
+			geo:     &pb.GeoPoints{ZoomHint: -1},
+			data:    points(0, 0),
+			wantErr: true,
+			errMsg:  "geo zoom_hint must be non-negative",
+		},
+		{
+			name:    "no points",
+			geo:     &pb.GeoPoints{},
+			data:    points(),
+			wantErr: true,
+			errMsg:  "geo requires at least one point",
+		},
+		{
+			name:    "odd length data",
+			geo:     &pb.GeoPoints{},
+			data:    points(51.5, -0.1, 48.8),
+			wantErr: true,
+			errMsg:  "geo data must be lat,lng pairs of even length (got 3)",
+		},
+		{
+			name:    "latitude out of range",
+			geo:     &pb.GeoPoints{},
+			data:    points(51.5, -0.1, -90.5, 0),
+			wantErr: true,
+			errMsg:  "geo point 1 out of range",
+		},
+		{
+			name:    "longitude out of range",
+			geo:     &pb.GeoPoints{},
+			data:    points(0, 180.1),
+			wantErr: true,
+			errMsg:  "geo point 0 out of range",
+		},
+		{
+			name:    "nan coordinate",
+			geo:     &pb.GeoPoints{},
+			data:    points(math.NaN(), 0),
+			wantErr: true,
+			errMsg:  "geo point 0 out of range",
+		},
+		{
+			name:    "int data",
+			geo:     &pb.GeoPoints{},
+			data:    &pb.Data{Data: &pb.Data_Ints{Ints: &pb.Ints{Values: []int64{0, 0}}}},
+			wantErr: true,
+			errMsg:  "geo visualization requires float data",
+		},
+		{
+			name:    "valid points at the limits",
+			geo:     &pb.GeoPoints{CenterLat: 51.5, CenterLng: -0.1, ZoomHint: 12},
+			data:    points(90, 180, -90, -180, 51.5, -0.1),
+			wantErr: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := GeoPoints(tt.geo, tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GeoPoints() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr && !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("expected error containing %q, got %v", tt.errMsg, err)
+			}
+		})
+	}
+}
+
+func TestValidateMarkdown(t *testing.T) {
+	tests := []struct {
+		name    string
+		md      *pb.Markdown
+		data    *pb.Data
+		wantErr bool
+		errMsg  string
+	}{
+		{
+			name:    "nil markdown",
+			md:      nil,
+			wantErr: true,
+			errMsg:  "markdown cannot be nil",
+		},
+		{
+			name:    "empty content",
+			md:      &pb.Markdown{},
+			wantErr: true,
+			errMsg:  "markdown content is required",
+		},
+		{
+			name:    "content too long",
+			md:      &pb.Markdown{Content: strings.Repeat("x", 10001)},
+			wantErr: true,
+			errMsg:  "markdown content too long (max 10000 characters, got 10001)",
+		},
+		{
+			name:    "with data",
+			md:      &pb.Markdown{Content: "# Instructions"},
+			data:    &pb.Data{Data: &pb.Data_Floats{Floats: &pb.Floats{Values: []float64{1}}}},
+			wantErr: true,
+			errMsg:  "markdown input takes no data",
+		},
+		{
+			name:    "content at the limit",
+			md:      &pb.Markdown{Content: strings.Repeat("x", 10000)},
+			wantErr: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Markdown(tt.md, tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Markdown() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr && !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("expected error containing %q, got %v", tt.errMsg, err)
+			}
+		})
+	}
+
+	// a markdown input with nil data passes full request validation
+	req := testRequest()
+	req.Inputs = append(req.Inputs, &pb.Input{
+		Visualization: &pb.Input_Markdown{
+			Markdown: &pb.Markdown{Content: "Label the **brightest** cell. See [the guide](https://example.com)."},
+		},
+	})
+	if err := RequestWithMode(req, Strict); err != nil {
+		t.Errorf("expected markdown input without data to RequestWithMode, got %v", err)
+	}
+}
+
+func TestValidateLenientMode(t *testing.T) {
+	scalar := &pb.Scalar{Label: "temp", Min: 0, Max: 100}
+	outOfRange := &pb.Data{Data: &pb.Data_Floats{Floats: &pb.Floats{Values: []float64{150}}}}
+
+	if err := Scalar(scalar, outOfRange, Strict); err == nil {
+		t.Error("expected strict mode to reject out-of-range scalar")
+	}
+	if err := Scalar(scalar, outOfRange, Lenient); err != nil {
+		t.Errorf("expected lenient mode to accept out-of-range scalar, got: %v", err)
+	}
+
+	// structural checks still apply
+	nan := &pb.Data{Data: &pb.Data_Floats{Floats: &pb.Floats{Values: []float64{math.NaN()}}}}
+	if err := Scalar(scalar, nan, Lenient); err == nil {
+		t.Error("expected lenient mode to reject NaN scalar")
+	}
+	tooMany := &pb.Data{Data: &pb.Data_Floats{Floats: &pb.Floats{Values: []float64{1, 2}}}}
+	if err := Scalar(scalar, tooMany, Lenient); err == nil {
+		t.Error("expected lenient mode to reject wrong data size")
+	}
+
+	req := &pb.Request{
+		Inputs: []*pb.Input{{
+			Visualization: &pb.Input_Scalar{Scalar: scalar},
+			Data:          outOfRange,
+		}},
+		Output: testRequest().Output,
+	}
+	if err := RequestWithMode(req, Strict); err == nil {
+		t.Error("expected strict RequestWithMode to reject request")
+	}
+	if err := RequestWithMode(req, Lenient); err != nil {
+		t.Errorf("expected lenient RequestWithMode to accept request, got: %v", err)
+	}
+}
+
+func TestValidateMultipleOutputs(t *testing.T) {
+	both := testMultiOutputRequest()
+	both.Output = testRequest().Output
+
+	badRange := testMultiOutputRequest()
+	badRange.Outputs[1].GetRange().Max = -1
+
+	tests := []struct {
+		name    string
+		req     *pb.Request
+		wantErr bool
+		errMsg  string
+	}{
+		{
+			name: "option list and range",
+			req:  testMultiOutputRequest(),
+		},
+		{
+			name:    "output and outputs both set",
+			req:     both,
+			wantErr: true,
+			errMsg:  "set output or outputs, not both",
+		},
+		{
+			name:    "invalid second schema",
+			req:     badRange,
+			wantErr: true,
+			errMsg:  "output schema 1: range min must be less than max",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := RequestWithMode(tt.req, Strict)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RequestWithMode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("expected error containing %q, got %q", tt.errMsg, err.Error())
+			}
+		})
+	}
+
+	if got := FieldPath(RequestWithMode(badRange, Strict)); got != "outputs 1" {
+		t.Errorf("expected field path %q, got %q", "outputs 1", got)
+	}
+}
+
+func TestValidateInputNames(t *testing.T) {
+	tests := []struct {
+		name    string
+		names   []string
+		wantErr bool
+		errMsg  string
+	}{
+		{
+			name:    "distinct names",
+			names:   []string{"speed", "heading"},
+			wantErr: false,
+		},
+		{
+			name:    "empty names",
+			names:   []string{"", ""},
+			wantErr: false,
+		},
+		{
+			name:    "empty and named",
+			names:   []string{"", "speed", ""},
+			wantErr: false,
+		},
+		{
+			name:    "duplicate names",
+			names:   []string{"speed", "heading", "speed"},
+			wantErr: true,
+			errMsg:  `duplicate input name "speed"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := testRequest()
+			input := req.Inputs[0]
+			req.Inputs = nil
+			for _, name := range tt.names {
+				in := proto.Clone(input).(*pb.Input)
+				in.Name = name
+				req.Inputs = append(req.Inputs, in)
+			}
+
+			err := RequestWithMode(req, Strict)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("RequestWithMode() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr && !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("expected error containing %q, got %v", tt.errMsg, err)
+			}
+		})
+	}
+}
+
+func TestRequestLeavesCompressedData(t *testing.T) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	b := make([]byte, 8)
+	for _, v := range []float64{1, 2, 3, 4, 5, 6} {
+		binary.LittleEndian.PutUint64(b, math.Float64bits(v))
+		zw.Write(b)
+	}
+	zw.Close()
+
+	req := testRequest()
+	req.Inputs = []*pb.Input{{
+		Visualization: &pb.Input_Grid{Grid: &pb.Grid{Rows: 2, Cols: 3}},
+		Data: &pb.Data{Data: &pb.Data_CompressedFloats{CompressedFloats: &pb.CompressedFloats{
+			Algorithm: pb.Compression_GZIP,
+			Data:      buf.Bytes(),
+		}}},
+	}}
+
+	if err := Request(req); err != nil {
+		t.Fatalf("expected compressed grid to validate: %v", err)
+	}
+	if req.Inputs[0].GetData().GetCompressedFloats() == nil {
+		t.Errorf("expected data to still be compressed, got %v", req.Inputs[0].GetData())
+	}
+
+	// the decoded values are still checked against the grid
+	req.Inputs[0].Visualization = &pb.Input_Grid{Grid: &pb.Grid{Rows: 2, Cols: 2}}
+	if err := Request(req); err == nil || !strings.Contains(err.Error(), "doesn't match grid size 4") {
+		t.Errorf("expected grid size error, got %v", err)
+	}
+}
+
+func TestSetLimits(t *testing.T) {
+	t.Cleanup(func() { SetLimits(Limits{}) })
+
+	req := testRequest()
+	req.Inputs = append(req.Inputs, req.Inputs[0], req.Inputs[0])
+	if err := Request(req); err != nil {
+		t.Fatalf("expected no limit by default, got %v", err)
+	}
+
+	SetLimits(Limits{MaxInputsPerRequest: 2})
+	if err := Request(req); err == nil || !strings.Contains(err.Error(), "too many inputs (max 2, got 3)") {
+		t.Errorf("expected too many inputs error, got %v", err)
+	}
+}
+
+func TestMaxVectorMagnitude(t *testing.T) {
+	t.Cleanup(func() { SetLimits(Limits{}) })
+
+	data := &pb.Data{Data: &pb.Data_Floats{Floats: &pb.Floats{Values: []float64{3, 4}}}}
+	vector := func(max float64) *pb.Vector2D {
+		return &pb.Vector2D{Label: "velocity", MaxMagnitude: max}
+	}
+
+	if err := Vector2D(vector(1e18), data, Strict); err != nil {
+		t.Fatalf("expected no cap by default, got %v", err)
+	}
+
+	SetLimits(Limits{MaxVectorMagnitude: 100})
+
+	tests := []struct {
+		name    string
+		max     float64
+		wantErr bool
+		errMsg  string
+	}{
+		{name: "within cap", max: 50},
+		{name: "at cap", max: 100},
+		{name: "above cap", max: 1e18, wantErr: true, errMsg: "vector max_magnitude 1000000000000000000.000000 exceeds the server limit 100.000000"},
+		{name: "infinite", max: math.Inf(1), wantErr: true, errMsg: "exceeds the server limit"},
+		{name: "nan", max: math.NaN(), wantErr: true, errMsg: "exceeds the server limit"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Vector2D(vector(tt.max), data, Lenient)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Vector2D() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr && !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("expected error containing %q, got %v", tt.errMsg, err)
+			}
+		})
+	}
+}
+
+func TestRegisterRemove(t *testing.T) {
+	errRejected := errors.New("rejected")
+	remove := Register(func(*pb.Request) error { return errRejected })
+
+	if err := Request(testRequest()); !errors.Is(err, errRejected) {
+		t.Fatalf("expected hook error, got %v", err)
+	}
+
+	remove()
+	if err := Request(testRequest()); err != nil {
+		t.Errorf("expected removed hook not to run, got %v", err)
+	}
+
+	// removing twice is harmless
+	remove()
+}
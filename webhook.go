@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// WebhookNearlyFull is sent when the queue reaches the threshold fraction
+	// of MaxPendingRequests.
+	WebhookNearlyFull = "queue_nearly_full"
+
+	// WebhookDrained is sent when a queue which was nearly full empties.
+	WebhookDrained = "queue_drained"
+)
+
+// webhookInterval is the minimum time between webhook posts.
+const webhookInterval = time.Minute
+
+type webhookEvent struct {
+	Event string    `json:"event"`
+	Total int       `json:"total"`
+	Max   int       `json:"max"`
+	Time  time.Time `json:"time"`
+}
+
+// notifier posts a webhookEvent when the queue length crosses the threshold
+// fraction of max, and again when it drains to empty. It won't post more than
+// once per interval; events in between are coalesced, and the latest is sent
+// once the interval has passed. A nil *notifier does nothing.
+type notifier struct {
+	url       string
+	max       int
+	threshold float64
+	interval  time.Duration
+	client    *http.Client
+
+	mu       sync.Mutex
+	high     bool
+	lastSent time.Time
+	pending  *webhookEvent
+	timer    *time.Timer
+}
+
+func newNotifier(url string, max int, threshold float64) *notifier {
+	if url == "" {
+		return nil
+	}
+
+	return &notifier{
+		url:       url,
+		max:       max,
+		threshold: threshold,
+		interval:  webhookInterval,
+		client:    &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// observe is called with the queue length whenever it changes. It never
+// blocks on the webhook, so it's safe to call with the queue locked.
+func (n *notifier) observe(total int) {
+	if n == nil || n.max <= 0 {
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	var event string
+	if !n.high && float64(total) >= n.threshold*float64(n.max) {
+		n.high = true
+		event = WebhookNearlyFull
+	} else if n.high && total == 0 {
+		n.high = false
+		event = WebhookDrained
+	}
+	if event == "" {
+		return
+	}
+
+	now := time.Now()
+	ev := &webhookEvent{Event: event, Total: total, Max: n.max, Time: now}
+
+	if wait := n.interval - now.Sub(n.lastSent); wait > 0 {
+		n.pending = ev
+		if n.timer == nil {
+			n.timer = time.AfterFunc(wait, n.flush)
+		}
+		return
+	}
+
+	n.lastSent = now
+	go n.send(ev)
+}
+
+// flush sends the event which was held back by the interval, if any.
+func (n *notifier) flush() {
+	n.mu.Lock()
+	ev := n.pending
+	n.pending = nil
+	n.timer = nil
+	n.lastSent = time.Now()
+	n.mu.Unlock()
+
+	if ev != nil {
+		n.send(ev)
+	}
+}
+
+func (n *notifier) send(ev *webhookEvent) {
+	b, err := json.Marshal(ev)
+	if err != nil {
+		slog.Error("failed to marshal webhook event", "error", err)
+		return
+	}
+
+	res, err := n.client.Post(n.url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		slog.Warn("webhook failed", "event", ev.Event, "error", err)
+		return
+	}
+	res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		slog.Warn("webhook rejected", "event", ev.Event, "status", res.StatusCode)
+	}
+}